@@ -0,0 +1,103 @@
+package gosql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type Advice struct {
+	Table   string   `json:"table"`
+	Detail  string   `json:"detail"`
+	Columns []string `json:"columns"`
+	Index   string   `json:"index"`
+}
+
+var scanRegexp = regexp.MustCompile(`^SCAN (?:TABLE )?(\S+)(?: AS (\S+))?$`)
+
+func (db *DB) Analyze(query string, args ...interface{}) ([]Advice, error) {
+	steps := []planStep{}
+	if err := Query(db.DB, "EXPLAIN QUERY PLAN "+query, &steps, args...); err != nil {
+		return nil, err
+	}
+	advice := []Advice{}
+	for _, step := range steps {
+		m := scanRegexp.FindStringSubmatch(step.Detail)
+		if m == nil {
+			continue
+		}
+		columns := []string{}
+		if err := Query(db.DB, "SELECT name FROM pragma_table_info(?)", &columns, m[1]); err != nil {
+			return nil, err
+		} else if len(columns) == 0 {
+			continue
+		}
+		a := Advice{Table: m[1], Detail: step.Detail, Columns: indexCandidates(query, m[1], m[2], columns)}
+		if len(a.Columns) != 0 {
+			index, err := quoteIdentifier(strings.Join(append([]string{a.Table}, a.Columns...), "_") + "_idx")
+			if err != nil {
+				return nil, err
+			}
+			quoted := make([]string, len(a.Columns))
+			for i, c := range a.Columns {
+				quoted[i], _ = quoteIdentifier(c)
+			}
+			table, _ := quoteIdentifier(a.Table)
+			a.Index = fmt.Sprintf("CREATE INDEX %s ON %s (%s)", index, table, strings.Join(quoted, ", "))
+		}
+		advice = append(advice, a)
+	}
+	return advice, nil
+}
+
+// equality columns first, then range columns, then ORDER BY columns - the order a composite index can use them in
+func indexCandidates(query, table, alias string, columns []string) []string {
+	isColumn, words := map[string]bool{}, []string{}
+	for _, c := range columns {
+		isColumn[strings.ToLower(c)] = true
+	}
+	for _, t := range tokenize(query) {
+		if t.kind != "space" && t.kind != "comment" {
+			words = append(words, strings.Trim(t.text, "\"`[]"))
+		}
+	}
+	equal, other, order, seen, clause := []string{}, []string{}, []string{}, map[string]bool{}, ""
+	for i, w := range words {
+		switch upper := strings.ToUpper(w); {
+		case upper == "WHERE" || upper == "ON":
+			clause = "where"
+			continue
+		case upper == "ORDER" && i+1 < len(words) && strings.ToUpper(words[i+1]) == "BY":
+			clause = "order"
+			continue
+		case upper == "GROUP" || upper == "LIMIT" || upper == "HAVING" || upper == "UNION" || upper == "FROM":
+			clause = ""
+			continue
+		}
+		if clause == "" || !isColumn[strings.ToLower(w)] || seen[strings.ToLower(w)] {
+			continue
+		}
+		if i >= 2 && words[i-1] == "." && !strings.EqualFold(words[i-2], table) && !strings.EqualFold(words[i-2], alias) {
+			continue
+		}
+		prev, next := words[i-1], ""
+		if prev == "." && i >= 3 {
+			prev = words[i-3]
+		}
+		if i+1 < len(words) {
+			next = strings.ToUpper(words[i+1])
+		}
+		seen[strings.ToLower(w)] = true
+		switch {
+		case clause == "order":
+			order = append(order, w)
+		case next == "=" || next == "IN" || next == "IS" || prev == "=":
+			equal = append(equal, w)
+		case next == "<" || next == ">" || next == "!" || next == "BETWEEN" || next == "LIKE" || prev == "<" || prev == ">":
+			other = append(other, w)
+		default:
+			delete(seen, strings.ToLower(w))
+		}
+	}
+	return append(append(equal, other...), order...)
+}
@@ -0,0 +1,64 @@
+package gosql
+
+import (
+	"math"
+	"sort"
+)
+
+type floatValues []float64
+
+type medianAggregator struct{ floatValues }
+type percentileAggregator struct {
+	floatValues
+	p float64
+}
+type varianceAggregator struct{ floatValues }
+type stddevAggregator struct{ floatValues }
+
+func (xs *floatValues) Step(x interface{}) {
+	switch x := x.(type) {
+	case int64:
+		*xs = append(*xs, float64(x))
+	case float64:
+		*xs = append(*xs, x)
+	}
+}
+
+func (a *percentileAggregator) Step(x, p interface{}) {
+	a.floatValues.Step(x)
+	switch p := p.(type) {
+	case int64:
+		a.p = float64(p)
+	case float64:
+		a.p = p
+	}
+}
+
+func (a *medianAggregator) Done() float64     { return a.percentile(50) }
+func (a *percentileAggregator) Done() float64 { return a.percentile(a.p) }
+func (a *varianceAggregator) Done() float64   { return a.variance() }
+func (a *stddevAggregator) Done() float64     { return math.Sqrt(a.variance()) }
+
+func (xs floatValues) percentile(p float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	sort.Float64s(xs)
+	i := math.Max(0, math.Min(100, p)) / 100 * float64(len(xs)-1)
+	lower, upper := xs[int(math.Floor(i))], xs[int(math.Ceil(i))]
+	return lower + (upper-lower)*(i-math.Floor(i))
+}
+
+func (xs floatValues) variance() float64 {
+	if len(xs) < 2 {
+		return 0
+	}
+	mean, sum := 0.0, 0.0
+	for _, x := range xs {
+		mean += x / float64(len(xs))
+	}
+	for _, x := range xs {
+		sum += (x - mean) * (x - mean)
+	}
+	return sum / float64(len(xs)-1)
+}
@@ -0,0 +1,77 @@
+package gosql
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// attached databases are available on read-only connections too - the read-only authorizer
+// still denies ATTACH statements, so Attach is the only way to query across database files there
+func (db *DB) attach(c driverConn) error {
+	db.attachMutex.RLock()
+	defer db.attachMutex.RUnlock()
+	names := make([]string, 0, len(db.Attach))
+	for name := range db.Attach {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		quoted, err := quoteIdentifier(name)
+		if err != nil {
+			return err
+		}
+		if _, err := c.Exec("ATTACH DATABASE ? AS "+quoted, []driver.Value{db.Attach[name]}); err != nil {
+			return fmt.Errorf("attach %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// AttachDatabase attaches path as alias on all connections of both pools. Pooled connections
+// cannot be reached individually, so they are recycled - just like for RegisterFunc.
+// readOnly attaches the file with mode=ro so it cannot be written even through the read-write pool.
+func (db *DB) AttachDatabase(alias, path string, readOnly bool) error {
+	if _, err := quoteIdentifier(alias); err != nil {
+		return err
+	}
+	if readOnly {
+		path = (&url.URL{Scheme: "file", Opaque: url.PathEscape(path), RawQuery: "mode=ro"}).String()
+	}
+	return db.updateAttach(alias, path)
+}
+
+// DetachDatabase detaches an alias previously attached via Attach or AttachDatabase
+func (db *DB) DetachDatabase(alias string) error {
+	db.attachMutex.RLock()
+	_, ok := db.Attach[alias]
+	db.attachMutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("detach %s: not attached", alias)
+	}
+	return db.updateAttach(alias, "")
+}
+
+func (db *DB) updateAttach(alias, path string) error {
+	db.attachMutex.Lock()
+	previous := db.Attach
+	db.Attach = make(map[string]string, len(previous)+1)
+	for k, v := range previous {
+		db.Attach[k] = v
+	}
+	if path == "" {
+		delete(db.Attach, alias)
+	} else {
+		db.Attach[alias] = path
+	}
+	db.attachMutex.Unlock()
+	if err := db.recyclePools(); err != nil {
+		db.attachMutex.Lock()
+		db.Attach = previous
+		db.attachMutex.Unlock()
+		db.recyclePools()
+		return fmt.Errorf("attach %s: %w", alias, err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package gosql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type AuditEntry struct {
+	ID        int64     `db:"id" json:"id"`
+	Table     string    `db:"table_name" json:"table"`
+	RowID     int64     `db:"row_id" json:"rowid"`
+	Op        string    `db:"op" json:"op"`
+	Old       JSON      `db:"old" json:"old"`
+	New       JSON      `db:"new" json:"new"`
+	Actor     string    `db:"actor" json:"actor"`
+	Timestamp time.Time `db:"timestamp" json:"timestamp"`
+}
+
+// EnableAudit creates triggers that record each insert, update and delete on tables (as old and new row json)
+// into _audit. It must be called again after the columns of an audited table changed.
+// The triggers depend on functions registered by gosql - other clients cannot write to audited tables
+func (db *DB) EnableAudit(tables ...string) error {
+	return db.Transact(context.Background(), TxOptions{Immediate: true}, func(c Connection) error {
+		q := `CREATE TABLE IF NOT EXISTS _audit (
+		        id INTEGER PRIMARY KEY, table_name TEXT, row_id INTEGER, op TEXT, old TEXT, new TEXT, actor TEXT,
+		        timestamp TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')))`
+		if _, err := Exec(c, q); err != nil {
+			return err
+		} else if _, err := Exec(c, "CREATE INDEX IF NOT EXISTS _audit_row_idx ON _audit (table_name, row_id)"); err != nil {
+			return err
+		}
+		for _, table := range tables {
+			columns := []string{}
+			if err := Query(c, "SELECT name FROM pragma_table_info(?)", &columns, table); err != nil {
+				return err
+			} else if len(columns) == 0 {
+				return fmt.Errorf("audit: no such table %s", table)
+			}
+			queries, err := auditTriggerQueries(table, columns)
+			if err != nil {
+				return err
+			}
+			for _, query := range queries {
+				if _, err := Exec(c, query); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// SetAuditActor sets the actor recorded for changes until the end of the current transaction of c.
+// c must be a transaction (Transact, Batch or *sql.Tx) - the actor would outlive single statements on pooled connections
+func SetAuditActor(c Connection, actor string) error {
+	if _, isTx := c.(*sql.Tx); !isTx {
+		if pc, ok := pinnedOf(c); !ok || !pc.tx {
+			return fmt.Errorf("cannot set audit actor on %T: not a transaction", c)
+		}
+	}
+	_, err := Exec(c, "SELECT _audit_set_actor(?)", actor)
+	return err
+}
+
+// AuditHistory returns the recorded changes of a row, oldest first
+func (db *DB) AuditHistory(table string, rowID int64) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	q := "SELECT id, table_name, row_id, op, old, new, coalesce(actor, '') AS actor, timestamp FROM _audit WHERE table_name = ? AND row_id = ? ORDER BY id"
+	return entries, Query(db, q, &entries, table, rowID)
+}
+
+func (db *DB) registerAuditFuncs(c driverConn) error {
+	actor := ""
+	c.RegisterCommitHook(func() int { actor = ""; return 0 })
+	c.RegisterRollbackHook(func() { actor = "" })
+	setActor := func(s string) (string, error) {
+		if c.AutoCommit() {
+			return "", errors.New("audit actor must be set inside a transaction")
+		}
+		actor = s
+		return s, nil
+	}
+	if err := c.RegisterFunc("_audit_set_actor", setActor, false); err != nil {
+		return err
+	} else if err := c.RegisterFunc("_audit_actor", func() string { return actor }, false); err != nil {
+		return err
+	}
+	return c.RegisterFunc("_audit_json", auditJSON, true)
+}
+
+func auditJSON(kvs ...interface{}) (string, error) {
+	b := &bytes.Buffer{}
+	b.WriteByte('{')
+	for i := 0; i+1 < len(kvs); i += 2 {
+		v := kvs[i+1]
+		if bs, ok := v.([]byte); ok {
+			v = base64.StdEncoding.EncodeToString(bs)
+		}
+		k, err := json.Marshal(fmt.Sprint(kvs[i]))
+		if err != nil {
+			return "", err
+		}
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(bs)
+	}
+	b.WriteByte('}')
+	return b.String(), nil
+}
+
+func auditTriggerQueries(table string, columns []string) ([]string, error) {
+	t, err := quoteIdentifier(table)
+	if err != nil {
+		return nil, err
+	}
+	row := func(prefix string) (string, error) {
+		kvs := make([]string, len(columns))
+		for i, column := range columns {
+			c, err := quoteIdentifier(column)
+			if err != nil {
+				return "", err
+			}
+			kvs[i] = fmt.Sprintf("'%s', %s.%s", sqlString(column), prefix, c)
+		}
+		return "_audit_json(" + strings.Join(kvs, ", ") + ")", nil
+	}
+	oldRow, err := row("old")
+	if err != nil {
+		return nil, err
+	}
+	newRow, _ := row("new")
+	name := func(op string) string {
+		quoted, _ := quoteIdentifier("_audit_" + table + "_" + op)
+		return quoted
+	}
+	trigger := func(op, rowid, old, new string) string {
+		return fmt.Sprintf(`CREATE TRIGGER %s AFTER %s ON %s BEGIN
+		  INSERT INTO _audit (table_name, row_id, op, old, new, actor) VALUES ('%s', %s, '%s', %s, %s, nullif(_audit_actor(), ''));
+		END`, name(op), strings.ToUpper(op), t, sqlString(table), rowid, op, old, new)
+	}
+	return []string{
+		"DROP TRIGGER IF EXISTS " + name("insert"),
+		"DROP TRIGGER IF EXISTS " + name("update"),
+		"DROP TRIGGER IF EXISTS " + name("delete"),
+		trigger("insert", "new.rowid", "NULL", newRow),
+		trigger("update", "new.rowid", oldRow, newRow),
+		trigger("delete", "old.rowid", oldRow, "NULL"),
+	}, nil
+}
@@ -0,0 +1,69 @@
+package gosql
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+var backupPagesPerStep = 100
+
+func (db *DB) BackupTo(path string, progress func(remaining, total int)) error {
+	return db.backup(path, false, progress)
+}
+
+// Restore replaces the contents of db with the sqlite database at path. Unlike opening it, restoring from a
+// missing or non-database file is an error rather than silently restoring an empty database
+func (db *DB) Restore(path string, progress func(remaining, total int)) error {
+	if err := checkDatabaseFile(path); err != nil {
+		return err
+	}
+	return db.backup(path, true, progress)
+}
+
+func checkDatabaseFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil {
+		return err
+	} else if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s: not a regular file", path)
+	}
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(f, header); err != nil || !bytes.Equal(header, []byte("SQLite format 3\x00")) {
+		return fmt.Errorf("%s: not a sqlite database", path)
+	}
+	return nil
+}
+
+func (db *DB) backup(path string, restore bool, progress func(remaining, total int)) error {
+	ctx := context.Background()
+	other, err := openFile(path)
+	if err != nil {
+		return err
+	}
+	defer other.Close()
+	otherConn, err := other.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer otherConn.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(c interface{}) error {
+		return otherConn.Raw(func(o interface{}) error {
+			if restore {
+				return backupConn(c, o, progress)
+			}
+			return backupConn(o, c, progress)
+		})
+	})
+}
@@ -0,0 +1,56 @@
+package gosql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Batch runs statements in a single (immediate) transaction and prepares each distinct query only once.
+// Queries through the embedded Connection run in the same transaction. Like Transact, the limiter is held for the
+// whole batch and statements are recorded in the history inside the transaction
+type Batch struct {
+	Connection
+	db    *DB
+	ctx   context.Context
+	conn  *sql.Conn
+	stmts map[string]*sql.Stmt
+}
+
+func (db *DB) Batch(fn func(b *Batch) error) error {
+	return db.BatchContext(context.Background(), fn)
+}
+
+func (db *DB) BatchContext(ctx context.Context, fn func(b *Batch) error) error {
+	return db.transact(ctx, TxOptions{Immediate: true}, func(pc pinnedConn, conn *sql.Conn) error {
+		b := &Batch{pc, db, pc.ctx, conn, map[string]*sql.Stmt{}}
+		defer b.close()
+		return fn(b)
+	})
+}
+
+func (b *Batch) Exec(query string, args ...interface{}) (result sql.Result, err error) {
+	defer b.db.recordHistory(ctxConn{b.ctx, b.conn}, query, args, time.Now(), &err)
+	defer b.db.observeExec(query, args, time.Now(), &result, &err)
+	ctx, span := b.db.startSpan(b.ctx, "exec", query)
+	defer func() { span.End(rowsAffected(result), err) }()
+	stmt, ok := b.stmts[query]
+	if !ok {
+		if stmt, err = b.conn.PrepareContext(ctx, query); err != nil {
+			return nil, fmt.Errorf("%s: %w", query, err)
+		}
+		b.stmts[query] = stmt
+	}
+	args, err = convertArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+func (b *Batch) close() {
+	for _, stmt := range b.stmts {
+		stmt.Close()
+	}
+}
@@ -0,0 +1,104 @@
+package gosql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SelectQuery composes a SELECT from SQL fragments. Table, columns, conditions and order terms are
+// written into the query as is - values must be passed as args of Where.
+// Query and Paginate skip soft deleted rows if the result type has a softdelete field - see Unscoped
+type SelectQuery struct {
+	table    string
+	columns  []string
+	where    []string
+	args     []interface{}
+	orderBy  []string
+	limit    int
+	offset   int
+	unscoped bool
+}
+
+func Select(table string, columns ...string) *SelectQuery {
+	return &SelectQuery{table: table, columns: columns}
+}
+
+// Where conditions are combined with AND
+func (q *SelectQuery) Where(condition string, args ...interface{}) *SelectQuery {
+	q.where, q.args = append(q.where, "("+condition+")"), append(q.args, args...)
+	return q
+}
+
+func (q *SelectQuery) OrderBy(terms ...string) *SelectQuery {
+	q.orderBy = append(q.orderBy, terms...)
+	return q
+}
+
+func (q *SelectQuery) Limit(n int) *SelectQuery {
+	q.limit = n
+	return q
+}
+
+func (q *SelectQuery) Offset(n int) *SelectQuery {
+	q.offset = n
+	return q
+}
+
+// Unscoped makes Query and Paginate include soft deleted rows
+func (q *SelectQuery) Unscoped() *SelectQuery {
+	q.unscoped = true
+	return q
+}
+
+func (q *SelectQuery) SQL() (string, []interface{}) {
+	columns := "*"
+	if len(q.columns) != 0 {
+		columns = strings.Join(q.columns, ", ")
+	}
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "SELECT %s FROM %s", columns, q.table)
+	if len(q.where) != 0 {
+		fmt.Fprintf(b, " WHERE %s", strings.Join(q.where, " AND "))
+	}
+	if len(q.orderBy) != 0 {
+		fmt.Fprintf(b, " ORDER BY %s", strings.Join(q.orderBy, ", "))
+	}
+	if q.limit > 0 {
+		fmt.Fprintf(b, " LIMIT %d", q.limit)
+	}
+	if q.offset > 0 {
+		if q.limit <= 0 {
+			b.WriteString(" LIMIT -1")
+		}
+		fmt.Fprintf(b, " OFFSET %d", q.offset)
+	}
+	return b.String(), append([]interface{}{}, q.args...)
+}
+
+func (q *SelectQuery) Query(c Connection, result interface{}) error {
+	query, args, err := q.scopedSQL(c, result)
+	if err != nil {
+		return err
+	}
+	return Query(c, query, result, args...)
+}
+
+func (q *SelectQuery) Paginate(c Connection, page Page, result interface{}) (string, error) {
+	query, args, err := q.scopedSQL(c, result)
+	if err != nil {
+		return "", err
+	}
+	return Paginate(c, query, page, result, args...)
+}
+
+func (q *SelectQuery) scopedSQL(c Connection, result interface{}) (string, []interface{}, error) {
+	column, err := softDeleteColumn(c, result)
+	if err != nil || column == "" || q.unscoped {
+		query, args := q.SQL()
+		return query, args, err
+	}
+	scoped := *q
+	scoped.where = append(append([]string{}, q.where...), column+" IS NULL")
+	query, args := scoped.SQL()
+	return query, args, nil
+}
@@ -0,0 +1,167 @@
+package gosql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type QueryCache struct {
+	MaxEntries int
+	mutex      sync.Mutex
+	versions   dataVersion
+	version    int64
+	epoch      time.Time
+	entries    map[string]reflect.Value
+}
+
+type dataVersion struct {
+	mutex  sync.Mutex
+	conn   *sql.Conn
+	opened time.Time
+}
+
+func (db *DB) CachedQuery(queryString string, result interface{}, args ...interface{}) error {
+	return db.cachedQuery(db.RODB, queryString, result, args...)
+}
+
+func (db *DB) cachedQuery(c Connection, queryString string, result interface{}, args ...interface{}) error {
+	if db.Cache == nil {
+		return Query(c, queryString, result, args...)
+	}
+	xs := reflect.ValueOf(result)
+	if xs.Kind() != reflect.Ptr || xs.Type().Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("cannot unmarshal query results into %t (%v)", result, result)
+	}
+	key := fmt.Sprintf("%s\x00%#v\x00%s", queryString, args, xs.Type())
+	cached, version, err := db.Cache.get(db, key)
+	if err != nil {
+		return err
+	} else if cached.IsValid() {
+		xs.Elem().Set(copyValue(cached))
+		return nil
+	}
+	if err := Query(c, queryString, result, args...); err != nil {
+		return err
+	}
+	db.Cache.put(key, copyValue(xs.Elem()), version)
+	return nil
+}
+
+// get returns the cached value of key and the data version it is valid for - results of queries
+// started at that version can be put into the cache
+func (c *QueryCache) get(db *DB, key string) (reflect.Value, int64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	version, epoch, err := c.versions.getWithEpoch(db.RODB)
+	if err != nil {
+		return reflect.Value{}, 0, err
+	}
+	if version != c.version || epoch != c.epoch || c.entries == nil {
+		c.version, c.epoch, c.entries = version, epoch, map[string]reflect.Value{}
+	}
+	return c.entries[key], version, nil
+}
+
+// put drops results of queries that started before the data changed - they might be stale
+func (c *QueryCache) put(key string, v reflect.Value, version int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.entries == nil || version != c.version {
+		return
+	} else if c.MaxEntries > 0 && len(c.entries) >= c.MaxEntries {
+		c.entries = map[string]reflect.Value{}
+	}
+	c.entries[key] = v
+}
+
+func (c *QueryCache) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = nil
+	return c.versions.close()
+}
+
+func (v *dataVersion) get(db *sql.DB) (int64, error) {
+	version, _, err := v.getWithEpoch(db)
+	return version, err
+}
+
+func (v *dataVersion) getWithEpoch(db *sql.DB) (int64, time.Time, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if v.conn == nil {
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		v.conn, v.opened = conn, time.Now()
+	}
+	version := int64(0)
+	err := v.conn.QueryRowContext(context.Background(), "PRAGMA data_version").Scan(&version)
+	return version, v.opened, err
+}
+
+func (v *dataVersion) close() error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if v.conn == nil {
+		return nil
+	}
+	err := v.conn.Close()
+	v.conn = nil
+	return err
+}
+
+// copyValue returns a deep copy of v so callers can't modify cached results
+func copyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		if v.Kind() == reflect.Ptr {
+			c.Set(reflect.New(v.Type().Elem()))
+			c.Elem().Set(copyValue(v.Elem()))
+		} else {
+			c.Set(copyValue(v.Elem()))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+		switch v.Type().Elem().Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map, reflect.Struct:
+			for i := 0; i < v.Len(); i++ {
+				c.Index(i).Set(copyValue(v.Index(i)))
+			}
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for it := v.MapRange(); it.Next(); {
+			c.SetMapIndex(it.Key(), copyValue(it.Value()))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(copyValue(v.Field(i)))
+			}
+		}
+		return c
+	}
+	return v
+}
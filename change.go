@@ -0,0 +1,112 @@
+package gosql
+
+import (
+	"strings"
+	"sync"
+)
+
+type Change struct {
+	Op    string
+	Table string
+	RowID int64
+}
+
+type hookConn struct {
+	driverConn
+	commitHooks   []func() int
+	rollbackHooks []func()
+}
+
+type changeDispatcher struct {
+	mutex    sync.RWMutex
+	handlers []func(Change)
+	changes  chan []Change
+}
+
+func (c *hookConn) RegisterCommitHook(f func() int) { c.commitHooks = append(c.commitHooks, f) }
+func (c *hookConn) RegisterRollbackHook(f func())   { c.rollbackHooks = append(c.rollbackHooks, f) }
+
+func (c *hookConn) install() {
+	commitHooks, rollbackHooks := c.commitHooks, c.rollbackHooks
+	c.driverConn.RegisterCommitHook(func() int {
+		for _, f := range commitHooks {
+			if f() != 0 {
+				return 1
+			}
+		}
+		return 0
+	})
+	c.driverConn.RegisterRollbackHook(func() {
+		for _, f := range rollbackHooks {
+			f()
+		}
+	})
+}
+
+// OnChange calls f for each committed change to tables not starting with _. Changes are delivered once the
+// committing connection is returned to the pool - and dropped (with a warning) while handlers lag 1024 commits behind
+func (db *DB) OnChange(f func(Change)) {
+	d := &db.changes
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.changes == nil {
+		d.changes = make(chan []Change, 1024)
+		go func(changes chan []Change) {
+			for changes := range changes {
+				d.mutex.RLock()
+				handlers := d.handlers
+				d.mutex.RUnlock()
+				for _, change := range changes {
+					for _, handler := range handlers {
+						handler(change)
+					}
+				}
+			}
+		}(d.changes)
+	}
+	d.handlers = append(d.handlers, f)
+}
+
+// the commit hook runs before the commit completes - committed changes are only sent once the connection is released.
+// A rollback right after the commit hook means the commit failed
+func (db *DB) registerChangeHooks(c driverConn) {
+	pending, committed, lastCommit := []Change{}, []Change{}, 0
+	c.RegisterUpdateHook(func(op int, _, table string, rowid int64) {
+		if !strings.HasPrefix(table, "_") && db.hasChangeHandlers() {
+			if len(pending) == 0 {
+				lastCommit = 0
+			}
+			pending = append(pending, Change{changeOps[op], table, rowid})
+		}
+	})
+	c.RegisterCommitHook(func() int {
+		committed, lastCommit, pending = append(committed, pending...), len(pending), []Change{}
+		return 0
+	})
+	c.RegisterRollbackHook(func() {
+		committed, lastCommit, pending = committed[:len(committed)-lastCommit], 0, pending[:0]
+	})
+	c.RegisterReleaseHook(func() {
+		if len(committed) != 0 {
+			db.sendChanges(committed)
+			committed, lastCommit = []Change{}, 0
+		}
+	})
+}
+
+func (db *DB) sendChanges(changes []Change) {
+	d := &db.changes
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	select {
+	case d.changes <- changes:
+	default:
+		db.logger().Printf("WARNING: dropped %d changes: OnChange handlers are too slow", len(changes))
+	}
+}
+
+func (db *DB) hasChangeHandlers() bool {
+	db.changes.mutex.RLock()
+	defer db.changes.mutex.RUnlock()
+	return len(db.changes.handlers) != 0
+}
@@ -0,0 +1,169 @@
+package gosql
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+type Finding struct {
+	Check   string `json:"check"` // integrity or foreign_key
+	Table   string `json:"table,omitempty"`
+	RowID   *int64 `json:"rowid,omitempty"`
+	Parent  string `json:"parent,omitempty"`
+	Message string `json:"message"`
+}
+
+type RecoveredTable struct {
+	Name  string `json:"name"`
+	Rows  int64  `json:"rows"`
+	Error string `json:"error,omitempty"` // last read error - rows may have been lost
+}
+
+var maxRecoverAttempts = 32
+
+// Check runs PRAGMA integrity_check and foreign_key_check - a healthy database has no findings
+func (db *DB) Check() ([]Finding, error) {
+	findings, messages := []Finding{}, []string{}
+	if err := Query(db.DB, "PRAGMA integrity_check", &messages); isCorrupt(err) {
+		return append(findings, Finding{Check: "integrity", Message: err.Error()}), nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, m := range messages {
+		if m != "ok" {
+			findings = append(findings, Finding{Check: "integrity", Message: m})
+		}
+	}
+	violations := []struct {
+		Table  string `db:"table"`
+		RowID  *int64 `db:"rowid"`
+		Parent string `db:"parent"`
+		FKID   int    `db:"fkid"`
+	}{}
+	if err := Query(db.DB, "PRAGMA foreign_key_check", &violations); isCorrupt(err) {
+		return append(findings, Finding{Check: "foreign_key", Message: err.Error()}), nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, v := range violations {
+		message := fmt.Sprintf("%s references a missing row of %s (foreign key %d)", v.Table, v.Parent, v.FKID)
+		findings = append(findings, Finding{"foreign_key", v.Table, v.RowID, v.Parent, message})
+	}
+	return findings, nil
+}
+
+func isCorrupt(err error) bool {
+	code, _, ok := errorCodes(err)
+	return ok && (code == codeCorrupt || code == codeNotADB)
+}
+
+// Recover copies the schema and all readable rows into a new database at path.
+// Rows are read in rowid order; after a read error reading continues behind the last readable rowid
+func (db *DB) Recover(path string) ([]RecoveredTable, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("recover: %s already exists", path)
+	}
+	objects, err := masterObjects(db.DB, "", false)
+	if err != nil {
+		return nil, err
+	}
+	shadowTables := shadowTables(objects)
+	dst, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+	tx, err := dst.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	tables := []RecoveredTable{}
+	for _, o := range objects {
+		if o.Type == "table" && isVirtualTable(o.SQL) {
+			tables = append(tables, RecoveredTable{Name: o.Name, Error: "virtual tables are not recovered"})
+			continue
+		} else if shadowTables[o.Name] {
+			continue
+		} else if _, err := tx.Exec(o.SQL); err != nil {
+			return nil, fmt.Errorf("%s: %w", o.SQL, err)
+		}
+		if o.Type == "table" {
+			t, err := recoverRows(db.DB, tx, o.Name, !strings.Contains(strings.ToUpper(o.SQL), "WITHOUT ROWID"))
+			if err != nil {
+				return nil, err
+			}
+			tables = append(tables, t)
+		}
+	}
+	return tables, tx.Commit()
+}
+
+func recoverRows(src *sql.DB, tx *sql.Tx, table string, hasRowID bool) (RecoveredTable, error) {
+	t := RecoveredTable{Name: table}
+	quoted, err := quoteIdentifier(table)
+	if err != nil {
+		return t, err
+	}
+	query, last, skip, writeErr := "SELECT * FROM "+quoted, int64(math.MinInt64), int64(1), error(nil)
+	if hasRowID {
+		query = "SELECT rowid, * FROM " + quoted + " WHERE rowid > ? ORDER BY rowid"
+	}
+	for attempt := 0; attempt < maxRecoverAttempts; attempt++ {
+		args := []interface{}{}
+		if hasRowID {
+			args = append(args, last)
+		}
+		err := withRows(src, query, args, func(rows *resultRows) error {
+			columns, err := rows.Columns()
+			if err != nil {
+				return err
+			}
+			if hasRowID {
+				columns[0] = "rowid"
+			}
+			qs := make([]string, len(columns))
+			for i, column := range columns {
+				if columns[i], err = quoteIdentifier(column); err != nil {
+					return err
+				}
+				qs[i] = "?"
+			}
+			insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quoted, strings.Join(columns, ", "), strings.Join(qs, ", "))
+			for rows.Next() {
+				values, dsts := make([]interface{}, len(columns)), make([]interface{}, len(columns))
+				for i := range values {
+					dsts[i] = &values[i]
+				}
+				if err := rows.Scan(dsts...); err != nil {
+					return err
+				}
+				if _, writeErr = tx.Exec(insert, values...); writeErr != nil {
+					return writeErr
+				}
+				if hasRowID {
+					last = values[0].(int64)
+				}
+				t.Rows++
+			}
+			return rows.Err()
+		})
+		if writeErr != nil {
+			return t, fmt.Errorf("recover %s: %w", table, writeErr)
+		} else if err == nil || !hasRowID {
+			if err != nil {
+				t.Error = err.Error()
+			}
+			return t, nil
+		}
+		t.Error = err.Error()
+		if last > math.MaxInt64-skip {
+			break
+		}
+		last, skip = last+skip, skip*2
+	}
+	return t, nil
+}
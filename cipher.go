@@ -0,0 +1,34 @@
+package gosql
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+)
+
+var ErrNoSQLCipher = errors.New("encryption key set but the sqlite3 driver is not built with SQLCipher")
+
+func (db *DB) applyKey(c driverConn) error {
+	if db.Key == "" {
+		return nil
+	}
+	if _, err := c.Exec("PRAGMA key = '"+sqlString(db.Key)+"'", nil); err != nil {
+		return err
+	}
+	rows, err := c.Query("PRAGMA cipher_version", nil)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if err := rows.Next(make([]driver.Value, len(rows.Columns()))); err == io.EOF {
+		return ErrNoSQLCipher
+	} else if err != nil {
+		return err
+	}
+	for _, pragma := range db.CipherPragmas {
+		if _, err := c.Exec("PRAGMA "+pragma, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
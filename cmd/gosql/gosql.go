@@ -1,26 +1,550 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/niklasfasching/gosql"
 )
 
 var debug = flag.Bool("d", false, "print debug output (query plan & execution time)")
+var bail = flag.Bool("bail", false, "stop after the first failing statement when reading from stdin")
+var echo = flag.Bool("echo", false, "print statements before executing them when reading from stdin")
+var output = flag.String("o", "", "output format of QUERY results: "+strings.Join(gosql.OutputModes, "|")+" (default indented json objects)")
+var jsonParams = flag.String("json-params", "", "bind QUERY parameters from a JSON object (:name) or array (?) - use - to read it from stdin")
+var watch = flag.Bool("w", false, "re-run QUERY whenever the database changes")
+var queryFile = flag.String("f", "", "read QUERY from a file - QUERY - reads it from stdin")
+var params = &paramsFlag{}
+
+type paramsFlag []interface{}
+
+func (p *paramsFlag) String() string     { return fmt.Sprint(*p) }
+func (p *paramsFlag) Set(v string) error { *p = append(*p, v); return nil }
+
+var attach = attachFlag{}
+
+type attachFlag map[string]string
+
+func (a attachFlag) String() string { return fmt.Sprint(map[string]string(a)) }
+
+// FILE[:NAME] - NAME defaults to the file name without extension
+func (a attachFlag) Set(v string) error {
+	file, name := v, strings.TrimSuffix(filepath.Base(v), filepath.Ext(v))
+	if i := strings.LastIndexByte(v, ':'); i != -1 {
+		file, name = v[:i], v[i+1:]
+	}
+	if file == "" || name == "" {
+		return fmt.Errorf("expected FILE[:NAME], got %q", v)
+	}
+	a[name] = file
+	return nil
+}
+
+func init() {
+	flag.Var(params, "p", "bind the next ? parameter of QUERY (repeatable)")
+	flag.Var(attach, "attach", "attach FILE[:NAME] to the database (repeatable)")
+}
 
 func main() {
-	flag.Parse()
-	args, debug := flag.Args(), *debug
-	if len(args) < 2 {
-		log.Fatal("gosql DB_FILE [QUERY]")
+	args, err := parseFlags(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
 	}
-	db := &gosql.DB{DataSourceName: args[0]}
+	debug := *debug
+	if len(args) < 1 {
+		log.Fatal("gosql DB_FILE [QUERY|-] | gosql vet SQL_FILE... | gosql publish DB_FILE [ADDRESS] | gosql export DB_FILE TABLE OUT_FILE [csv|ndjson|sql] | gosql export DB_FILE QUERY|TABLE [-o csv|ndjson|sql] | gosql diff DB_FILE DB_FILE | gosql import DB_FILE TABLE IN_FILE|- [-o csv|ndjson] | gosql dump DB_FILE | gosql restore DB_FILE DUMP_FILE|- | gosql migrate DB_FILE DIR status|up|down [N]|create NAME | gosql gen DB_FILE STRUCT_NAME QUERY|-")
+	} else if args[0] == "vet" {
+		if !vet(args[1:]) {
+			os.Exit(1)
+		}
+		return
+	} else if args[0] == "publish" && len(args) > 1 {
+		log.Fatal(publish(args[1:]))
+	} else if args[0] == "diff" && len(args) == 3 {
+		if !diff(args[1], args[2]) {
+			os.Exit(1)
+		}
+		return
+	} else if args[0] == "import" && len(args) == 4 {
+		if err := importFile(args[1], args[2], args[3]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	} else if args[0] == "dump" && len(args) == 2 {
+		if err := dump(args[1]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	} else if args[0] == "restore" && len(args) == 3 {
+		if err := restore(args[1], args[2]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	} else if args[0] == "migrate" && len(args) > 3 {
+		if err := migrate(args[1], args[2], args[3], args[4:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	} else if args[0] == "gen" && len(args) > 2 {
+		if err := gen(args[1], args[2], args[3:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	} else if args[0] == "export" && len(args) == 3 {
+		if err := exportQuery(args[1], args[2]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	} else if args[0] == "export" && len(args) > 3 {
+		if err := export(args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	db := &gosql.DB{DataSourceName: args[0], Attach: attach}
 	if err := db.Open(nil); err != nil {
 		log.Fatal(err)
 	}
-	if err := gosql.Print(db, debug, strings.Join(args[1:], " ")); err != nil {
+	if fi, err := os.Stdin.Stat(); len(args) == 1 && *queryFile == "" && *jsonParams != "-" && err == nil && fi.Mode()&os.ModeCharDevice == 0 {
+		r := &gosql.REPL{DB: db, Out: os.Stdout, Bail: *bail, Echo: *echo}
+		if err := r.RunBatch(os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+		return
+	} else if len(args) == 1 && *queryFile == "" {
+		if err := db.REPL(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	query, err := readQuery(args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	queryArgs, err := parseParams()
+	if err != nil {
+		log.Fatal(err)
+	}
+	o := gosql.PrintOptions{Indent: "  ", Output: gosql.OutputOptions{Mode: *output}}
+	if debug {
+		o.Debug = os.Stderr
+	}
+	print := func() error { return gosql.Fprint(os.Stdout, db, o, query, queryArgs...) }
+	if *watch {
+		print = watchQuery(db, print)
+	}
+	if err := print(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func watchQuery(db *gosql.DB, print func() error) func() error {
+	return func() error {
+		return db.WatchChanges(context.Background(), 250*time.Millisecond, func() error {
+			fmt.Print("\033[H\033[2J")
+			if err := print(); err != nil {
+				return err
+			}
+			fmt.Printf("\n%s - watching %s for changes\n", time.Now().Format("15:04:05"), db.DataSourceName)
+			return nil
+		})
+	}
+}
+
+func readQuery(args []string) (string, error) {
+	query := strings.Join(args, " ")
+	if *queryFile != "" && query != "" {
+		return "", fmt.Errorf("-f and QUERY are mutually exclusive")
+	} else if *queryFile != "" {
+		bs, err := os.ReadFile(*queryFile)
+		return string(bs), err
+	} else if query == "-" {
+		if *jsonParams == "-" {
+			return "", fmt.Errorf("QUERY and -json-params cannot both be read from stdin")
+		}
+		bs, err := io.ReadAll(os.Stdin)
+		return string(bs), err
+	}
+	return query, nil
+}
+
+func parseParams() ([]interface{}, error) {
+	if *jsonParams == "" {
+		return *params, nil
+	} else if len(*params) != 0 {
+		return nil, fmt.Errorf("-p and -json-params are mutually exclusive")
+	}
+	bs := []byte(*jsonParams)
+	if *jsonParams == "-" {
+		var err error
+		if bs, err = io.ReadAll(os.Stdin); err != nil {
+			return nil, err
+		}
+	}
+	d := json.NewDecoder(bytes.NewReader(bs))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("-json-params: %w", err)
+	}
+	args := []interface{}{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, v := range v {
+			args = append(args, sql.Named(k, jsonParam(v)))
+		}
+	case []interface{}:
+		for _, v := range v {
+			args = append(args, jsonParam(v))
+		}
+	default:
+		return nil, fmt.Errorf("-json-params: expected object or array, got %s", bs)
+	}
+	return args, nil
+}
+
+// numbers are bound as integers where possible, nested objects and arrays as JSON text
+func jsonParam(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}, []interface{}:
+		bs, _ := json.Marshal(v)
+		return string(bs)
+	}
+	return v
+}
+
+// flags may also follow the positional arguments: gosql DB_FILE QUERY -o csv. Once the first positional argument
+// is seen, only defined flags are parsed - other arguments starting with - (e.g. the -1 of SELECT -1) are positional.
+// Everything after -- is positional
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for len(args) != 0 {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		for ; len(rest) != 0 && !isFlag(fs, rest[0]); rest = rest[1:] {
+			if rest[0] == "--" {
+				return append(positional, rest[1:]...), nil
+			}
+			positional = append(positional, rest[0])
+		}
+		args = rest
+	}
+	return positional, nil
+}
+
+func isFlag(fs *flag.FlagSet, arg string) bool {
+	if !strings.HasPrefix(arg, "-") || arg == "--" {
+		return false
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+	if i := strings.IndexByte(name, '='); i != -1 {
+		name = name[:i]
+	}
+	return name != "" && fs.Lookup(name) != nil
+}
+
+func publish(args []string) error {
+	address := ":8080"
+	if len(args) > 1 {
+		address = args[1]
+	}
+	_, handler, err := gosql.Publish(args[0])
+	if err != nil {
+		return err
+	}
+	log.Printf("publishing %s on %s", args[0], address)
+	return http.ListenAndServe(address, handler)
+}
+
+func gen(dbFile, name string, args []string) error {
+	db := &gosql.DB{DataSourceName: dbFile, Attach: attach, ReadOnly: true}
+	if err := db.Open(nil); err != nil {
+		return err
+	}
+	query, err := readQuery(args)
+	if err != nil {
+		return err
+	}
+	queryArgs, err := parseParams()
+	if err != nil {
+		return err
+	}
+	src, err := gosql.GenerateStruct(db.RODB, name, query, queryArgs...)
+	if err != nil {
+		return err
+	}
+	fmt.Print(src)
+	return nil
+}
+
+func dump(dbFile string) error {
+	db := &gosql.DB{DataSourceName: dbFile, ReadOnly: true}
+	if err := db.Open(nil); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	if err := db.Dump(w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func restore(dbFile, file string) error {
+	db := &gosql.DB{DataSourceName: dbFile}
+	if err := db.Open(nil); err != nil {
+		return err
+	}
+	f := os.Stdin
+	if file != "-" {
+		var err error
+		if f, err = os.Open(file); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	return db.RestoreDump(f)
+}
+
+func migrate(dbFile, dir, command string, args []string) error {
+	if command == "create" {
+		if len(args) != 1 {
+			return fmt.Errorf("usage: gosql migrate DB_FILE DIR create NAME")
+		}
+		name := strings.ToLower(strings.Join(strings.FieldsFunc(args[0], func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}), "_"))
+		path := filepath.Join(dir, time.Now().UTC().Format("20060102150405")+"_"+name+".sql")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		} else if err := os.WriteFile(path, []byte("\n-- +down\n"), 0644); err != nil {
+			return err
+		}
+		fmt.Println(path)
+		return nil
+	}
+	migrations, err := gosql.ReadMigrations(dir)
+	if err != nil {
+		return err
+	}
+	db := &gosql.DB{DataSourceName: dbFile}
+	if err := db.Open(nil); err != nil {
+		return err
+	} else if err := db.LoadMigrations(migrations); err != nil {
+		return err
+	}
+	switch command {
+	case "up":
+		return db.Migrate()
+	case "down":
+		n := 1
+		if len(args) != 0 {
+			if n, err = strconv.Atoi(args[0]); err != nil {
+				return fmt.Errorf("usage: gosql migrate DB_FILE DIR down [N]")
+			}
+		}
+		return db.Rollback(n)
+	case "status":
+		ms, err := db.Migrations()
+		if err != nil {
+			return err
+		}
+		for _, m := range ms {
+			switch {
+			case m.Unknown:
+				fmt.Printf("%s  applied %s (missing from %s)\n", m.Name, m.AppliedAt.Format(time.RFC3339), dir)
+			case m.Modified:
+				fmt.Printf("%s  applied %s (modified since)\n", m.Name, m.AppliedAt.Format(time.RFC3339))
+			case m.AppliedAt != nil:
+				fmt.Printf("%s  applied %s in %.1fms\n", m.Name, m.AppliedAt.Format(time.RFC3339), m.DurationMS)
+			default:
+				fmt.Printf("%s  pending\n", m.Name)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q (status, up, down, create)", command)
+}
+
+func export(args []string) error {
+	format, cursorFile := "csv", args[2]+".cursor"
+	if len(args) > 3 {
+		format = args[3]
+	}
+	db := &gosql.DB{DataSourceName: args[0]}
+	if err := db.Open(nil); err != nil {
+		return err
+	}
+	cursor := struct {
+		After  []interface{} `json:"after"`
+		Offset int64         `json:"offset"`
+	}{}
+	if bs, err := os.ReadFile(cursorFile); err == nil {
+		d := json.NewDecoder(bytes.NewReader(bs))
+		d.UseNumber()
+		if err := d.Decode(&cursor); err != nil {
+			return fmt.Errorf("invalid cursor file %s: %w", cursorFile, err)
+		}
+		for i, v := range cursor.After {
+			if n, ok := v.(json.Number); !ok {
+				continue
+			} else if x, err := n.Int64(); err == nil {
+				cursor.After[i] = x
+			} else if f, err := n.Float64(); err == nil {
+				cursor.After[i] = f
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := writeManifest(db, args[2]+".manifest.json"); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(args[2], os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(cursor.Offset); err != nil {
+		return err
+	} else if _, err := f.Seek(cursor.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = gosql.Export(db.RODB, args[1], f, gosql.ExportOptions{Format: format, After: cursor.After, Checkpoint: func(last []interface{}) error {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		cursor.After, cursor.Offset = last, offset
+		bs, err := json.Marshal(cursor)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(cursorFile, bs, 0644)
+	}})
+	if err != nil {
+		return err
+	}
+	return os.Remove(cursorFile)
+}
+
+func exportQuery(dbFile, query string) error {
+	format := *output
+	if format == "" {
+		format = "csv"
+	}
+	db := &gosql.DB{DataSourceName: dbFile, ReadOnly: true, Attach: attach}
+	if err := db.Open(nil); err != nil {
+		return err
+	}
+	queryArgs, err := parseParams()
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	if err := gosql.ExportQuery(db.RODB, w, query, format, queryArgs...); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// IN_FILE - reads stdin in the -o format (csv by default)
+func importFile(dbFile, table, file string) error {
+	db := &gosql.DB{DataSourceName: dbFile}
+	if err := db.Open(nil); err != nil {
+		return err
+	}
+	f, ext := os.Stdin, filepath.Ext(file)
+	if file == "-" {
+		ext = "." + *output
+	} else {
+		var err error
+		if f, err = os.Open(file); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	n, opts, err := 0, gosql.CSVOptions{}, error(nil)
+	switch ext {
+	case ".json", ".ndjson", ".jsonl":
+		n, err = gosql.ImportJSON(db, table, f)
+	case ".tsv":
+		opts.Comma = '\t'
+		fallthrough
+	default:
+		n, err = gosql.ImportCSV(db, table, f, opts)
+	}
+	log.Printf("imported %d rows into %s", n, table)
+	return err
+}
+
+func writeManifest(db *gosql.DB, path string) error {
+	m, err := gosql.ReadManifest(db.RODB)
+	if err != nil {
+		return err
+	}
+	bs, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, bs, 0644)
+}
+
+func diff(a, b string) bool {
+	dbs := [2]*gosql.DB{{DataSourceName: a, ReadOnly: true}, {DataSourceName: b, ReadOnly: true}}
+	for _, db := range dbs {
+		if err := db.Open(nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+	changes, err := gosql.DiffSchema(dbs[0].RODB, dbs[1].RODB)
+	if err != nil {
 		log.Fatal(err)
 	}
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	return len(changes) == 0
+}
+
+func vet(files []string) bool {
+	ok := true
+	for _, file := range files {
+		bs, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, w := range gosql.Lint(string(bs)) {
+			fmt.Printf("%s:%d: %s\n", file, w.Line, w.Message)
+			ok = false
+		}
+	}
+	return ok
 }
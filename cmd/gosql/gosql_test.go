@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv("GOSQL_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// run runs the gosql binary (i.e. this test binary calling main) and returns its stdout
+func run(t *testing.T, stdin string, args ...string) (string, error) {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env, cmd.Stderr = append(os.Environ(), "GOSQL_TEST_MAIN=1"), &strings.Builder{}
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		err = fmt.Errorf("%v: %s", err, cmd.Stderr)
+	}
+	return string(out), err
+}
+
+func TestParseFlags(t *testing.T) {
+	for _, x := range []struct {
+		args, positional []string
+		output           string
+		params           []interface{}
+		err              bool
+	}{
+		{[]string{"db", "SELECT 1"}, []string{"db", "SELECT 1"}, "", nil, false},
+		{[]string{"db", "SELECT 1", "-o", "csv"}, []string{"db", "SELECT 1"}, "csv", nil, false},
+		{[]string{"-o=csv", "db", "SELECT", "-1"}, []string{"db", "SELECT", "-1"}, "csv", nil, false},
+		{[]string{"db", "SELECT 1", "-- comment"}, []string{"db", "SELECT 1", "-- comment"}, "", nil, false},
+		{[]string{"db", "-p", "1", "SELECT ?", "--p=2"}, []string{"db", "SELECT ?"}, "", []interface{}{"1", "2"}, false},
+		{[]string{"db", "--", "SELECT", "-o", "csv"}, []string{"db", "SELECT", "-o", "csv"}, "", nil, false},
+		{[]string{"--", "-o", "csv"}, []string{"-o", "csv"}, "", nil, false},
+		{[]string{"-x", "db"}, nil, "", nil, true},
+	} {
+		fs := flag.NewFlagSet("gosql", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		output, params := fs.String("o", "", ""), &paramsFlag{}
+		fs.Var(params, "p", "")
+		positional, err := parseFlags(fs, x.args)
+		if (err != nil) != x.err {
+			t.Errorf("%q: unexpected error %v", x.args, err)
+		} else if fmt.Sprintf("%q %q %v", positional, *output, *params) != fmt.Sprintf("%q %q %v", x.positional, x.output, x.params) {
+			t.Errorf("%q: %q %q %v not %q %q %v", x.args, positional, *output, *params, x.positional, x.output, x.params)
+		}
+	}
+}
+
+func TestCLI(t *testing.T) {
+	dir := t.TempDir()
+	db, path := filepath.Join(dir, "db.sqlite"), func(name string) string { return filepath.Join(dir, name) }
+	if err := os.WriteFile(path("xs.csv"), []byte("x,s\n1,a\n2,b\n"), 0644); err != nil {
+		t.Fatal(err)
+	} else if err := os.WriteFile(path("query.sql"), []byte("SELECT count(*) AS n FROM xs"), 0644); err != nil {
+		t.Fatal(err)
+	} else if err := os.WriteFile(path("bad.sql"), []byte("SELECT * FROM xs WHERE s = NULL"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []struct {
+		stdin    string
+		args     []string
+		expected string
+	}{
+		{"CREATE TABLE xs (x INTEGER, s TEXT);", []string{db}, ""},
+		{"", []string{"import", db, "xs", path("xs.csv")}, ""},
+		{"", []string{db, "SELECT x, s FROM xs ORDER BY x", "-o", "csv"}, "x,s\n1,a\n2,b\n"},
+		{"", []string{db, "SELECT", "-1", "AS", "n", "-o", "csv"}, "n\n-1\n"},
+		{"", []string{db, "SELECT s FROM xs WHERE x = ?", "-p", "2", "-o", "csv"}, "s\nb\n"},
+		{`{"x": 1}`, []string{db, "SELECT s FROM xs WHERE x = :x", "-json-params", "-", "-o", "csv"}, "s\na\n"},
+		{"", []string{"-f", path("query.sql"), db, "-o", "csv"}, "n\n2\n"},
+		{"SELECT max(x) AS n FROM xs", []string{db, "-", "-o", "csv"}, "n\n2\n"},
+		{"", []string{"export", db, "SELECT s FROM xs ORDER BY x", "-o", "ndjson"}, `{"s":"a"}` + "\n" + `{"s":"b"}` + "\n"},
+		{"", []string{"gen", db, "X", "SELECT x FROM xs"}, "type X struct {\n\tX *int64 `db:\"x\"`\n}\n"},
+	} {
+		if actual, err := run(t, x.stdin, x.args...); err != nil {
+			t.Fatalf("%q: %v", x.args, err)
+		} else if actual != x.expected {
+			t.Errorf("%q: %q not %q", x.args, actual, x.expected)
+		}
+	}
+
+	if _, err := run(t, "", "export", db, "xs", path("xs.ndjson"), "ndjson"); err != nil {
+		t.Fatal(err)
+	} else if bs, err := os.ReadFile(path("xs.ndjson")); err != nil || string(bs) != `{"s":"a","x":1}`+"\n"+`{"s":"b","x":2}`+"\n" {
+		t.Errorf("%q %v", bs, err)
+	} else if _, err := os.Stat(path("xs.ndjson.cursor")); !os.IsNotExist(err) {
+		t.Errorf("expected cursor file to be removed: %v", err)
+	}
+
+	dump, err := run(t, "", "dump", db)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := os.WriteFile(path("dump.sql"), []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	} else if _, err := run(t, "", "restore", path("restored.sqlite"), path("dump.sql")); err != nil {
+		t.Fatal(err)
+	} else if out, err := run(t, "", path("restored.sqlite"), "SELECT s FROM xs ORDER BY x", "-o", "csv"); err != nil || out != "s\na\nb\n" {
+		t.Errorf("%q %v", out, err)
+	} else if out, err := run(t, "", "diff", db, path("restored.sqlite")); err != nil || out != "" {
+		t.Errorf("%q %v", out, err)
+	}
+
+	if out, err := run(t, "", "vet", path("bad.sql")); err == nil || !strings.HasPrefix(out, path("bad.sql")+":1: ") {
+		t.Errorf("expected vet to fail: %q %v", out, err)
+	}
+
+	migrations := path("migrations")
+	out, err := run(t, "", "migrate", db, migrations, "create", "add ys")
+	if err != nil || !strings.HasSuffix(out, "_add_ys.sql\n") {
+		t.Fatalf("%q %v", out, err)
+	} else if err := os.WriteFile(strings.TrimSpace(out), []byte("CREATE TABLE ys (y);\n-- +down\nDROP TABLE ys;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	name := strings.TrimSpace(out)
+	if out, err := run(t, "", "migrate", db, migrations, "status"); err != nil || out != name+"  pending\n" {
+		t.Errorf("%q %v", out, err)
+	} else if _, err := run(t, "", "migrate", db, migrations, "up"); err != nil {
+		t.Fatal(err)
+	} else if out, err := run(t, "", db, "SELECT count(*) AS n FROM ys", "-o", "csv"); err != nil || out != "n\n0\n" {
+		t.Errorf("%q %v", out, err)
+	} else if _, err := run(t, "", "migrate", db, migrations, "down", "1"); err != nil {
+		t.Fatal(err)
+	} else if out, err := run(t, "", "migrate", db, migrations, "status"); err != nil || out != name+"  pending\n" {
+		t.Errorf("%q %v", out, err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+	cmd := exec.Command(os.Args[0], "publish", db, address)
+	cmd.Env = append(os.Environ(), "GOSQL_TEST_MAIN=1")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		res, err := (&http.Client{Timeout: 5 * time.Second}).Get("http://" + address + "/schema")
+		if err == nil {
+			bs, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK || !strings.Contains(string(bs), `"xs"`) {
+				t.Errorf("%d %s", res.StatusCode, bs)
+			}
+			break
+		} else if time.Since(start) > 5*time.Second {
+			t.Fatal(err)
+		}
+	}
+}
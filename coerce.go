@@ -0,0 +1,66 @@
+package gosql
+
+import (
+	"crypto/cipher"
+	"database/sql"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+type resultRows struct {
+	*sql.Rows
+	warn        func(string)
+	strict      bool
+	columnTypes []*sql.ColumnType
+	count       int
+	cipher      cipher.AEAD
+	mapper      func(string) string
+	table       string // for the encryption context of encrypted fields
+}
+
+func (r *resultRows) Next() bool {
+	if !r.Rows.Next() {
+		return false
+	}
+	r.count++
+	return true
+}
+
+const maxExactFloatInt = 1 << 53
+
+func (rows *resultRows) checkCoercion(i int, src, dst interface{}) {
+	if rows.columnTypes == nil {
+		columnTypes, err := rows.ColumnTypes()
+		if err != nil {
+			return
+		}
+		rows.columnTypes = columnTypes
+	}
+	if i >= len(rows.columnTypes) {
+		return
+	}
+	column, declared := rows.columnTypes[i].Name(), strings.ToUpper(rows.columnTypes[i].DatabaseTypeName())
+	t := reflect.TypeOf(dst).Elem()
+	switch src := src.(type) {
+	case string:
+		if strings.Contains(declared, "INT") || strings.Contains(declared, "REAL") || strings.Contains(declared, "FLOA") || strings.Contains(declared, "DOUB") {
+			rows.warn(fmt.Sprintf("column %s: TEXT value %q stored in %s column", column, src, declared))
+		}
+	case []byte:
+		if t.Kind() == reflect.String {
+			rows.warn(fmt.Sprintf("column %s: BLOB value decoded as base64 into %s", column, t))
+		}
+	case int64:
+		if (t.Kind() == reflect.Interface || t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64) && (src > maxExactFloatInt || src < -maxExactFloatInt) {
+			rows.warn(fmt.Sprintf("column %s: INTEGER value %d loses precision as float", column, src))
+		}
+	case float64:
+		if t.Kind() == reflect.Float32 && float64(float32(src)) != src {
+			rows.warn(fmt.Sprintf("column %s: REAL value %v loses precision as float32", column, src))
+		} else if strings.Contains(declared, "INT") && src != math.Trunc(src) {
+			rows.warn(fmt.Sprintf("column %s: REAL value %v stored in %s column", column, src, declared))
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package gosql
+
+import (
+	"strings"
+	"unicode"
+)
+
+var defaultCollations = map[string]func(string, string) int{
+	"natural_sort":   naturalCompare,
+	"unicode_nocase": unicodeNoCaseCompare,
+}
+
+func naturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		aChunk, aRest, aIsDigit := naturalChunk(a)
+		bChunk, bRest, bIsDigit := naturalChunk(b)
+		if aIsDigit && bIsDigit {
+			aTrimmed, bTrimmed := strings.TrimLeft(aChunk, "0"), strings.TrimLeft(bChunk, "0")
+			if len(aTrimmed) != len(bTrimmed) {
+				return compareInts(len(aTrimmed), len(bTrimmed))
+			} else if c := strings.Compare(aTrimmed, bTrimmed); c != 0 {
+				return c
+			}
+		}
+		if c := strings.Compare(aChunk, bChunk); c != 0 {
+			return c
+		}
+		a, b = aRest, bRest
+	}
+	return compareInts(len(a), len(b))
+}
+
+func naturalChunk(s string) (string, string, bool) {
+	isDigit := s[0] >= '0' && s[0] <= '9'
+	i := 1
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9') == isDigit {
+		i++
+	}
+	return s[:i], s[i:], isDigit
+}
+
+func unicodeNoCaseCompare(a, b string) int {
+	return strings.Compare(strings.Map(unicode.ToLower, a), strings.Map(unicode.ToLower, b))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -1,27 +1,80 @@
 package gosql
 
 import (
+	"context"
+	"crypto/cipher"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-	"sort"
-
-	sqlite "github.com/mattn/go-sqlite3"
-	sqlite3 "github.com/mattn/go-sqlite3"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
 )
 
 type DB struct {
-	DataSourceName string
-	Funcs          map[string]interface{}
-	RODB           *sql.DB
+	DataSourceName      string
+	Funcs               map[string]interface{}
+	TableFuncs          map[string]TableFunc
+	Collations          map[string]func(string, string) int
+	Extensions          []string
+	Attach              map[string]string // schema name -> database file
+	Key                 string
+	CipherPragmas       []string
+	InitStatements      []string                   // run on each new connection of both pools - e.g. PRAGMA cache_size or mmap_size
+	ColumnMapper        func(field string) string  // maps names of fields without db tag name to columns - e.g. strings.ToUpper for legacy schemas
+	FieldMapper         func(column string) string // inverse of ColumnMapper - names the fields of GenerateStruct
+	ColumnKey           []byte                     // AES key of encrypted struct fields (`db:"name,encrypted"`)
+	ColumnFuncs         bool                       // register encrypt(value, ad) / decrypt(value, ad) on the read-write pool
+	columnCipher        cipher.AEAD
+	Logger              Logger
+	ReadOnly            bool
+	WarnCoercions       bool
+	QueryHook           func(query string, args []interface{}, d time.Duration, rows int, err error)
+	SlowQueryThreshold  time.Duration
+	OnSlowQuery         func(SlowQuery)
+	Tracer              Tracer
+	Metrics             *Metrics
+	StrictScan          bool
+	ReadOnlyAuthorizer  func(op int, arg1, arg2, arg3 string, result int) int
+	RODB                *sql.DB
+	migrations          map[string]interface{}
+	MigrationsTable     string // defaults to _migrations
+	Limit               *Limiter
+	RWLimit             *Limiter
+	ROLimit             *Limiter
+	History             HistoryMode
+	HistorySource       string
+	UndoTables          []string
+	UndoLimit           int
+	undoStep            int64
+	IDBlockSize         int
+	idBlocks            map[string]*idBlock
+	idMutex             sync.Mutex
+	Cache               *QueryCache
+	ListenInterval      time.Duration
+	HandlerTimeout      time.Duration
+	HandlerMaxRows      int
+	Encoder             *EncoderOptions // JSON encoding of Handler results and Print. nil keeps the defaults of the JSON type
+	HandlerTruncate     bool            // truncate results to HandlerMaxRows instead of failing. json responses become {"rows", "truncated", "total"}
+	HandlerCountTotal   bool            // count the rows of truncated json responses for "total" - runs the full query a second time
+	HandlerLimit        *Limiter
+	HandlerETag         bool
+	HandlerCacheControl string
+	HandlerQueries      map[string]NamedQuery
+	WriteAuth           func(*http.Request) bool
+	connectors          [2]*poolConnector
+	funcsMutex          sync.RWMutex
+	attachMutex         sync.RWMutex
+	changes             changeDispatcher
+	handlerVersion      dataVersion
+	wal                 walStats
 	*sql.DB
 }
 
-var driverIndex = 0
-
-func (db *DB) Open(migrations map[string]string) error {
+func (db *DB) Open(migrations interface{}) error {
 	if db.DB != nil {
 		return errors.New("already open")
 	}
@@ -29,110 +82,325 @@ func (db *DB) Open(migrations map[string]string) error {
 	for k, v := range defaultFuncs {
 		funcs[k] = v
 	}
+	if len(db.ColumnKey) != 0 {
+		aead, err := newColumnCipher(db.ColumnKey)
+		if err != nil {
+			return err
+		}
+		db.columnCipher = aead
+	}
 	for k, v := range db.Funcs {
 		funcs[k] = v
 	}
 	db.Funcs = funcs
-	rwDriver, roDriver := fmt.Sprintf("sqlite3-%d", driverIndex), fmt.Sprintf("sqlite3-read-only-%d", driverIndex)
-	driverIndex++
-	sql.Register(rwDriver, &sqlite3.SQLiteDriver{ConnectHook: db.connectHook})
-	sql.Register(roDriver, &sqlite3.SQLiteDriver{ConnectHook: db.readOnlyConnectHook})
-	if rwDB, err := sql.Open(rwDriver, db.DataSourceName); err != nil {
+	tableFuncs := map[string]TableFunc{}
+	for k, v := range defaultTableFuncs {
+		tableFuncs[k] = v
+	}
+	for k, v := range db.TableFuncs {
+		tableFuncs[k] = v
+	}
+	db.TableFuncs = tableFuncs
+	collations := map[string]func(string, string) int{}
+	for k, v := range defaultCollations {
+		collations[k] = v
+	}
+	for k, v := range db.Collations {
+		collations[k] = v
+	}
+	db.Collations = collations
+	db.DB, db.connectors[0] = openPool(db.DataSourceName, db.Extensions, db.connectHook)
+	db.RODB, db.connectors[1] = openPool(db.DataSourceName, db.Extensions, db.readOnlyConnectHook)
+	if db.ReadOnly {
+		if migrations != nil {
+			return errors.New("cannot migrate read-only database")
+		}
+		return nil
+	}
+	if err := db.createHistoryTable(); err != nil {
 		return err
-	} else {
-		db.DB = rwDB
 	}
-	if roDB, err := sql.Open(roDriver, db.DataSourceName); err != nil {
+	if err := db.migrate(migrations); err != nil {
 		return err
-	} else {
-		db.RODB = roDB
 	}
-	return db.migrate(migrations)
+	return db.createUndoTriggers()
 }
 
-func (db *DB) connectHook(c *sqlite.SQLiteConn) error {
-	for name, f := range db.Funcs {
-		_, isPure := f.(PureFunc)
-		if err := c.RegisterFunc(name, f, isPure); err != nil {
-			return err
-		}
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (result sql.Result, err error) {
+	release, err := db.acquire(ctx, false)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	defer release()
+	defer db.recordHistory(db.DB, query, args, time.Now(), &err)
+	defer db.observeExec(query, args, time.Now(), &result, &err)
+	ctx, span := db.startSpan(ctx, "exec", query)
+	defer func() { span.End(rowsAffected(result), err) }()
+	args, err = convertArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return db.DB.ExecContext(ctx, query, args...)
 }
 
-func (db *DB) readOnlyConnectHook(c *sqlite.SQLiteConn) error {
-	for name, f := range db.Funcs {
-		_, isPure := f.(PureFunc)
-		if err := c.RegisterFunc(name, f, isPure); err != nil {
-			return err
-		}
+func (db *DB) acquire(ctx context.Context, readOnly bool) (func(), error) {
+	limit := db.RWLimit
+	if readOnly {
+		limit = db.ROLimit
 	}
-	c.RegisterAuthorizer(func(op int, arg1, arg2, arg3 string) int {
-		switch op {
-		case sqlite.SQLITE_SELECT, sqlite.SQLITE_READ, sqlite.SQLITE_FUNCTION:
-			return sqlite.SQLITE_OK
-		case sqlite.SQLITE_PRAGMA:
-			switch arg1 {
-			case "table_info", "data_version":
-				return sqlite.SQLITE_OK
-			case "user_version":
-				if arg2 == "" && arg3 == "" {
-					return sqlite.SQLITE_OK
-				}
-			}
-		case sqlite.SQLITE_UPDATE: // necessary for fts5. see commit message
-			if arg1 == "sqlite_master" && arg3 == "main" {
-				return sqlite.SQLITE_OK
-			}
-		}
-		return sqlite.SQLITE_DENY
-	})
+	releasePool, err := limit.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	releaseGlobal, err := db.Limit.Acquire(ctx)
+	if err != nil {
+		releasePool()
+		return nil, err
+	}
+	return func() { releaseGlobal(); releasePool() }, nil
+}
+
+func (db *DB) connectHook(c driverConn) error {
+	if err := db.applyKey(c); err != nil {
+		return err
+	}
+	if err := db.attach(c); err != nil {
+		return err
+	}
+	if err := db.registerFuncs(c); err != nil {
+		return err
+	} else if err := db.registerColumnFuncs(c); err != nil {
+		return err
+	} else if err := db.execInitStatements(c); err != nil {
+		return err
+	}
+	hc := &hookConn{driverConn: c}
+	if err := db.registerUndoFuncs(hc); err != nil {
+		return err
+	}
+	if err := db.registerAuditFuncs(hc); err != nil {
+		return err
+	} else if err := db.registerReadOnlySwitch(hc); err != nil {
+		return err
+	}
+	db.registerChangeHooks(hc)
+	hc.install()
 	return nil
 }
 
-func (db *DB) migrate(migrations map[string]string) error {
-	q := "CREATE TABLE IF NOT EXISTS _migrations (name STRING, timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP)"
-	if _, err := db.Exec(q); err != nil {
+func (db *DB) readOnlyConnectHook(c driverConn) error {
+	if err := db.applyKey(c); err != nil {
 		return err
 	}
-	names, applied := []string{}, map[string]bool{}
-	if err := Query(db, "SELECT name FROM _migrations", &names); err != nil {
+	if err := db.attach(c); err != nil {
+		return err
+	}
+	if err := db.registerFuncs(c); err != nil {
+		return err
+	} else if err := db.execInitStatements(c); err != nil {
 		return err
 	}
-	for _, name := range names {
-		applied[name] = true
+	c.RegisterAuthorizer(db.authorizeReadOnly)
+	return nil
+}
+
+func (db *DB) execInitStatements(c driverConn) error {
+	for _, query := range db.InitStatements {
+		if _, err := c.Exec(query, nil); err != nil {
+			return fmt.Errorf("%s: %w", query, err)
+		}
 	}
-	keys := []string{}
-	for key := range migrations {
-		keys = append(keys, key)
+	return nil
+}
+
+func (db *DB) authorizeReadOnly(op int, arg1, arg2, arg3 string) int {
+	result := readOnlyAuthorizer(op, arg1, arg2, arg3)
+	if db.ReadOnlyAuthorizer != nil {
+		return db.ReadOnlyAuthorizer(op, arg1, arg2, arg3, result)
 	}
-	sort.Strings(keys)
+	return result
+}
 
-	for _, key := range keys {
-		if applied[key] {
-			continue
+func readOnlyAuthorizer(op int, arg1, arg2, arg3 string) int {
+	switch op {
+	case authSelect, authRead, authFunction, authRecursive:
+		return authOK
+	case authPragma:
+		switch arg1 {
+		case "table_info", "index_list", "index_info", "data_version":
+			return authOK
+		case "user_version":
+			if arg2 == "" && arg3 == "" {
+				return authOK
+			}
 		}
-		if _, err := db.Exec(migrations[key]); err != nil {
+	case authUpdate: // necessary for fts5. see commit message
+		if arg1 == "sqlite_master" && arg3 == "main" {
+			return authOK
+		}
+	}
+	return authDeny
+}
+
+func (db *DB) registerFuncs(c driverConn) error {
+	db.funcsMutex.RLock()
+	defer db.funcsMutex.RUnlock()
+	for name, f := range db.Funcs {
+		pure, isPure := f.(pureFunc)
+		if isPure {
+			f = pure.f
+		}
+		register := c.RegisterFunc
+		if isAggregator(f) {
+			register = c.RegisterAggregator
+		}
+		if err := register(name, f, isPure); err != nil {
 			return err
 		}
-		if _, err := db.Exec("INSERT INTO _migrations (name) VALUES (?)", key); err != nil {
+	}
+	for name, f := range db.Collations {
+		if err := c.RegisterCollation(name, f); err != nil {
 			return err
 		}
 	}
+	if err := db.registerModules(c); err != nil {
+		return err
+	}
+	return db.registerQueryStats(c)
+}
+
+func (db *DB) RegisterFunc(name string, f interface{}, pure bool) error {
+	if pure {
+		f = PureFunc(f)
+	}
+	db.funcsMutex.Lock()
+	previous, existed := db.Funcs[name]
+	db.Funcs[name] = f
+	db.funcsMutex.Unlock()
+	if err := db.recyclePools(); err != nil {
+		db.funcsMutex.Lock()
+		if existed {
+			db.Funcs[name] = previous
+		} else {
+			delete(db.Funcs, name)
+		}
+		db.funcsMutex.Unlock()
+		db.recyclePools()
+		return fmt.Errorf("register %s: %w", name, err)
+	}
 	return nil
 }
 
+// recyclePools replaces the connections of both pools - connect hooks only run for new connections.
+// The pings connect right away to report connect hook errors
+func (db *DB) recyclePools() error {
+	db.connectors[0].recycle()
+	db.connectors[1].recycle()
+	if err := db.DB.Ping(); err != nil {
+		return err
+	}
+	return db.RODB.Ping()
+}
+
+func isAggregator(f interface{}) bool {
+	t := reflect.TypeOf(f)
+	if t == nil || t.Kind() != reflect.Func || t.NumIn() != 0 || t.NumOut() != 1 {
+		return false
+	}
+	_, hasStep := t.Out(0).MethodByName("Step")
+	_, hasDone := t.Out(0).MethodByName("Done")
+	return hasStep && hasDone
+}
+
 func (db *DB) Handler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	query, args, results := r.URL.Query().Get("query"), []interface{}{}, []map[string]JSON{}
-	for _, arg := range r.URL.Query()["arg"] {
-		args = append(args, arg)
+	var results interface{} = &[]map[string]JSON{}
+	if db.Encoder != nil {
+		results = &[]rawRow{}
+	}
+	query, args, paginate, err := db.parseHandlerRequest(r)
+	if err == errUnknownQuery {
+		writeHandlerError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeHandlerError(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx, cancel := r.Context(), func() {}
+	if db.HandlerTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, db.HandlerTimeout)
+	}
+	defer cancel()
+	release, err := db.acquireHandler(ctx)
+	if err != nil {
+		writeHandlerError(w, handlerErrorStatus(ctx, err, http.StatusServiceUnavailable), err)
+		return
+	}
+	defer release()
+	cw := &cacheHeaderWriter{ResponseWriter: w, header: http.Header{}}
+	if db.HandlerCacheControl != "" {
+		cw.header.Set("Cache-Control", db.HandlerCacheControl)
 	}
-	if err := Query(db.RODB, query, &results, args...); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	if db.HandlerETag {
+		etag, err := db.handlerETag(r, query, args)
+		if err != nil {
+			writeHandlerError(w, http.StatusInternalServerError, err)
+			return
+		}
+		cw.header.Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			cw.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w = cw
+	c, max, limit := ctxConn{ctx, db.RODB}, db.HandlerMaxRows, ""
+	if paginate {
+		limit = r.URL.Query().Get("limit")
+	}
+	if format := responseFormat(r); format != "json" {
+		streamRows(ctx, w, c, format, maxRowsQuery(query, max), args, max, db.HandlerTruncate, db.Encoder)
+		return
+	}
+	truncated, total := false, []int64{}
+	if limit == "" {
+		err = db.cachedQuery(c, maxRowsQuery(query, max), results, args...)
+		xs := reflect.ValueOf(results).Elem()
+		if n := xs.Len(); err == nil && max > 0 && n > max && db.HandlerTruncate {
+			xs.SetLen(max)
+			if truncated = true; db.HandlerCountTotal && maxRowsQuery(query, max) == query {
+				total = append(total, int64(n)) // statements that cannot be limited are read completely
+			} else if db.HandlerCountTotal {
+				err = db.cachedQuery(c, countRowsQuery(query), &total, args...)
+			}
+		} else if err == nil && max > 0 && xs.Len() > max {
+			err = errTooManyRows(max)
+		}
+	} else if n, convErr := strconv.Atoi(limit); convErr != nil {
+		err = fmt.Errorf("invalid limit: %s", convErr)
+	} else {
+		if max > 0 && n > max {
+			n = max
+		}
+		page := Page{Limit: n, Cursor: r.URL.Query().Get("cursor"), Keys: r.URL.Query()["key"]}
+		next, pageErr := Paginate(c, query, page, results, args...)
+		w.Header().Set("X-Next-Cursor", next)
+		err = pageErr
+	}
+	if err != nil {
+		writeHandlerError(w, handlerErrorStatus(ctx, err, http.StatusBadRequest), err)
+	} else if db.HandlerTruncate {
+		response := truncatedResponse{Rows: db.Encoder.results(results), Truncated: truncated}
+		if len(total) != 0 {
+			response.Total = &total[0]
+		}
+		json.NewEncoder(w).Encode(response)
 	} else {
-		json.NewEncoder(w).Encode(results)
+		json.NewEncoder(w).Encode(db.Encoder.results(results))
 	}
 }
 
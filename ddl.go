@@ -0,0 +1,114 @@
+package gosql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExecIfNotExists runs a CREATE TABLE / INDEX / TRIGGER / VIEW statement unless an object of that type and name
+// already exists - so bootstrap code and hand-written migrations can be re-run. Returns whether the statement ran.
+func ExecIfNotExists(c Connection, query string, args ...interface{}) (bool, error) {
+	objectType, schema, name, err := parseCreateStatement(query)
+	if err != nil {
+		return false, err
+	}
+	quotedSchema, err := quoteIdentifier(schema)
+	if err != nil {
+		return false, err
+	}
+	master := quotedSchema + ".sqlite_master"
+	if schema == "temp" {
+		master = "sqlite_temp_master"
+	}
+	n := []int{}
+	if err := Query(c, "SELECT count(*) FROM "+master+" WHERE type = ? AND name = ? COLLATE NOCASE", &n, objectType, name); err != nil {
+		return false, err
+	} else if n[0] != 0 {
+		return false, nil
+	}
+	_, err = Exec(c, query, args...)
+	return err == nil, err
+}
+
+// AddColumnIfNotExists runs ALTER TABLE table ADD COLUMN column unless table already has a column of that name.
+// column is a column definition, e.g. "score INTEGER NOT NULL DEFAULT 0"
+func AddColumnIfNotExists(c Connection, table, column string) (bool, error) {
+	quotedTable, err := quoteTableName(table)
+	if err != nil {
+		return false, err
+	}
+	tokens := significantTokens(column)
+	if len(tokens) == 0 {
+		return false, fmt.Errorf("invalid column definition %q", column)
+	}
+	schema, name := "main", unquoteToken(tokens[0])
+	if parts := strings.SplitN(table, ".", 2); len(parts) == 2 {
+		schema, table = parts[0], parts[1]
+	}
+	n := []int{}
+	if err := Query(c, "SELECT count(*) FROM pragma_table_info(?, ?) WHERE name = ? COLLATE NOCASE", &n, table, schema, name); err != nil {
+		return false, err
+	} else if n[0] != 0 {
+		return false, nil
+	}
+	_, err = Exec(c, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", quotedTable, column))
+	return err == nil, err
+}
+
+// CREATE [TEMP|TEMPORARY] [UNIQUE|VIRTUAL] TABLE|INDEX|TRIGGER|VIEW [IF NOT EXISTS] [schema.]name
+func parseCreateStatement(query string) (objectType, schema, name string, err error) {
+	tokens, i := significantTokens(query), 1
+	keyword := func(i int) string {
+		if i < len(tokens) && tokens[i].kind == "word" {
+			return strings.ToUpper(tokens[i].text)
+		}
+		return ""
+	}
+	if keyword(0) != "CREATE" {
+		return "", "", "", fmt.Errorf("not a CREATE statement: %s", query)
+	}
+	schema = "main"
+	if k := keyword(i); k == "TEMP" || k == "TEMPORARY" {
+		schema, i = "temp", i+1
+	}
+	if k := keyword(i); k == "UNIQUE" || k == "VIRTUAL" {
+		i++
+	}
+	switch objectType = strings.ToLower(keyword(i)); objectType {
+	case "table", "index", "trigger", "view":
+	default:
+		return "", "", "", fmt.Errorf("unsupported CREATE statement: %s", query)
+	}
+	if i++; keyword(i) == "IF" && keyword(i+1) == "NOT" && keyword(i+2) == "EXISTS" {
+		i += 3
+	}
+	if i+2 < len(tokens) && tokens[i+1].text == "." {
+		schema, i = unquoteToken(tokens[i]), i+2
+	}
+	if i >= len(tokens) || (tokens[i].kind != "word" && tokens[i].kind != "quoted") {
+		return "", "", "", fmt.Errorf("missing name in CREATE statement: %s", query)
+	}
+	return objectType, schema, unquoteToken(tokens[i]), nil
+}
+
+func significantTokens(s string) []token {
+	tokens := []token{}
+	for _, t := range tokenize(s) {
+		if t.kind != "space" && t.kind != "comment" {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+func unquoteToken(t token) string {
+	if t.kind != "quoted" || len(t.text) < 2 {
+		return t.text
+	}
+	switch q := t.text[0]; q {
+	case '[':
+		return t.text[1 : len(t.text)-1]
+	default:
+		return strings.ReplaceAll(t.text[1:len(t.text)-1], string(q)+string(q), string(q))
+	}
+}
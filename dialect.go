@@ -0,0 +1,79 @@
+package gosql
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Dialect interface {
+	Placeholder(i int) string
+	QuoteIdentifier(s string) (string, error)
+	Upsert(keys, columns []string) string
+}
+
+type sqliteDialect struct{}
+type postgresDialect struct{ sqliteDialect }
+type mysqlDialect struct{}
+
+var SQLite, Postgres, MySQL Dialect = sqliteDialect{}, postgresDialect{}, mysqlDialect{}
+
+type dialectConnection struct {
+	Connection
+	dialect Dialect
+}
+
+func WithDialect(c Connection, d Dialect) Connection { return dialectConnection{c, d} }
+
+func (c dialectConnection) Dialect() Dialect { return c.dialect }
+
+func dialectOf(c Connection) Dialect {
+	if c, ok := c.(interface{ Dialect() Dialect }); ok {
+		return c.Dialect()
+	}
+	return SQLite
+}
+
+func (sqliteDialect) Placeholder(int) string                   { return "?" }
+func (sqliteDialect) QuoteIdentifier(s string) (string, error) { return quoteIdentifier(s) }
+func (sqliteDialect) Upsert(keys, columns []string) string {
+	if len(columns) == 0 {
+		return fmt.Sprintf("ON CONFLICT (%s) DO NOTHING", strings.Join(keys, ", "))
+	}
+	sets := make([]string, len(columns))
+	for i, column := range columns {
+		sets[i] = column + " = excluded." + column
+	}
+	return fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(keys, ", "), strings.Join(sets, ", "))
+}
+
+func (postgresDialect) Placeholder(i int) string { return fmt.Sprintf("$%d", i+1) }
+
+func (mysqlDialect) Placeholder(int) string { return "?" }
+func (mysqlDialect) QuoteIdentifier(s string) (string, error) {
+	if s == "" || strings.ContainsRune(s, 0) {
+		return "", fmt.Errorf("invalid identifier %q", s)
+	}
+	return "`" + strings.ReplaceAll(s, "`", "``") + "`", nil
+}
+func (mysqlDialect) Upsert(keys, columns []string) string {
+	if len(columns) == 0 {
+		columns = keys[:1]
+	}
+	sets := make([]string, len(columns))
+	for i, column := range columns {
+		sets[i] = column + " = VALUES(" + column + ")"
+	}
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+}
+
+func quoteDialectTableName(d Dialect, s string) (string, error) {
+	parts := strings.SplitN(s, ".", 2)
+	for i, part := range parts {
+		quoted, err := d.QuoteIdentifier(part)
+		if err != nil {
+			return "", fmt.Errorf("invalid table name %q", s)
+		}
+		parts[i] = quoted
+	}
+	return strings.Join(parts, "."), nil
+}
@@ -0,0 +1,119 @@
+package gosql
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+type SchemaChange struct {
+	Kind   string
+	Name   string
+	Change string
+	From   string
+	To     string
+}
+
+type schemaObjects map[string]map[string]string
+
+var diffIndex int64
+
+func (c SchemaChange) String() string {
+	s := fmt.Sprintf("%s %s %s", map[string]string{"added": "+", "removed": "-", "changed": "~"}[c.Change], c.Kind, c.Name)
+	switch {
+	case c.Change == "changed":
+		return s + ": " + c.From + " -> " + c.To
+	case c.From+c.To != "":
+		return s + ": " + c.From + c.To
+	}
+	return s
+}
+
+func DiffSchema(from, to Connection) ([]SchemaChange, error) {
+	a, err := readSchemaObjects(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := readSchemaObjects(to)
+	if err != nil {
+		return nil, err
+	}
+	changes := []SchemaChange{}
+	for _, kind := range []string{"table", "column", "index", "view", "trigger"} {
+		names := []string{}
+		for name := range a[kind] {
+			names = append(names, name)
+		}
+		for name := range b[kind] {
+			if _, ok := a[kind][name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			x, inA := a[kind][name]
+			y, inB := b[kind][name]
+			switch {
+			case !inB:
+				changes = append(changes, SchemaChange{kind, name, "removed", x, ""})
+			case !inA:
+				changes = append(changes, SchemaChange{kind, name, "added", "", y})
+			case x != y:
+				changes = append(changes, SchemaChange{kind, name, "changed", x, y})
+			}
+		}
+	}
+	return changes, nil
+}
+
+func (db *DB) DiffMigrations(migrations interface{}) ([]SchemaChange, error) {
+	migrated := &DB{DataSourceName: fmt.Sprintf("file:gosql_diff_%d?mode=memory&cache=shared", atomic.AddInt64(&diffIndex, 1))}
+	if err := migrated.Open(migrations); err != nil {
+		return nil, err
+	}
+	defer migrated.RODB.Close()
+	defer migrated.Close()
+	return DiffSchema(db.RODB, migrated.DB)
+}
+
+func readSchemaObjects(c Connection) (schemaObjects, error) {
+	entries := []schemaEntry{}
+	query := "SELECT type, name, tbl_name, sql FROM sqlite_master WHERE name NOT LIKE 'sqlite_%'"
+	if err := Query(c, query, &entries); err != nil {
+		return nil, err
+	}
+	objects := schemaObjects{"table": {}, "column": {}, "index": {}, "view": {}, "trigger": {}}
+	for _, e := range entries {
+		if e.Type != "table" {
+			if e.SQL != nil {
+				objects[e.Type][e.Name] = *e.SQL
+			}
+			continue
+		}
+		objects["table"][e.Name] = ""
+		columns := []struct {
+			Name    string  `db:"name"`
+			Type    string  `db:"type"`
+			NotNull int     `db:"notnull"`
+			Default *string `db:"dflt_value"`
+			PK      int     `db:"pk"`
+		}{}
+		if err := Query(c, "SELECT name, type, \"notnull\", dflt_value, pk FROM pragma_table_info(?)", &columns, e.Name); err != nil {
+			return nil, err
+		}
+		for _, column := range columns {
+			s := column.Type
+			if column.PK != 0 {
+				s += " PRIMARY KEY"
+			}
+			if column.NotNull != 0 {
+				s += " NOT NULL"
+			}
+			if column.Default != nil {
+				s += " DEFAULT " + *column.Default
+			}
+			objects["column"][e.Name+"."+column.Name] = s
+		}
+	}
+	return objects, nil
+}
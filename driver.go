@@ -0,0 +1,184 @@
+package gosql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync/atomic"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+type driverConn interface {
+	Exec(query string, args []driver.Value) (driver.Result, error)
+	Query(query string, args []driver.Value) (driver.Rows, error)
+	RegisterFunc(name string, impl interface{}, pure bool) error
+	RegisterAggregator(name string, impl interface{}, pure bool) error
+	RegisterCollation(name string, cmp func(string, string) int) error
+	RegisterAuthorizer(func(op int, arg1, arg2, arg3 string) int)
+	RegisterCommitHook(func() int)
+	RegisterRollbackHook(func())
+	RegisterUpdateHook(func(op int, db, table string, rowid int64))
+	AutoCommit() bool
+	RegisterReleaseHook(func())
+}
+
+// driver.go is the only file (besides the sqlite_vtable build) that depends on mattn/go-sqlite3 directly.
+// There is no CGO-free backend yet - one (e.g. modernc.org/sqlite) would provide this file's API behind a build tag
+
+var changeOps = map[int]string{
+	sqlite3.SQLITE_INSERT: "INSERT",
+	sqlite3.SQLITE_UPDATE: "UPDATE",
+	sqlite3.SQLITE_DELETE: "DELETE",
+}
+
+// authorizer actions and results
+const (
+	authOK        = sqlite3.SQLITE_OK
+	authDeny      = sqlite3.SQLITE_DENY
+	authSelect    = sqlite3.SQLITE_SELECT
+	authRead      = sqlite3.SQLITE_READ
+	authFunction  = sqlite3.SQLITE_FUNCTION
+	authPragma    = sqlite3.SQLITE_PRAGMA
+	authUpdate    = sqlite3.SQLITE_UPDATE
+	authRecursive = 33 // SQLITE_RECURSIVE - not exported by mattn/go-sqlite3
+)
+
+// result codes
+var (
+	codeConstraint           = int(sqlite3.ErrConstraint)
+	codeCorrupt              = int(sqlite3.ErrCorrupt)
+	codeNotADB               = int(sqlite3.ErrNotADB)
+	codeConstraintUnique     = int(sqlite3.ErrConstraintUnique)
+	codeConstraintPrimaryKey = int(sqlite3.ErrConstraintPrimaryKey)
+	codeConstraintForeignKey = int(sqlite3.ErrConstraintForeignKey)
+	codeConstraintNotNull    = int(sqlite3.ErrConstraintNotNull)
+)
+
+var timestampFormats = sqlite3.SQLiteTimestampFormats
+
+// SQLiteError returns the sqlite error wrapped in err, if any
+func SQLiteError(err error) (Error, bool) {
+	e := sqlite3.Error{}
+	if !errors.As(err, &e) {
+		return Error{}, false
+	}
+	return Error{int(e.Code), int(e.ExtendedCode), e.Error()}, true
+}
+
+func errorCodes(err error) (code, extendedCode int, ok bool) {
+	e, ok := SQLiteError(err)
+	return e.Code, e.ExtendedCode, ok
+}
+
+// openFile opens a plain pool without the hooks and funcs of a DB - e.g. for backups and recovery
+func openFile(dsn string) (*sql.DB, error) { return sql.Open("sqlite3", dsn) }
+
+// poolConnector connects the pools of a DB. The pools are never swapped: recycle makes them replace their connections
+// instead - idle ones before their next use, busy ones once they are returned - so connect hooks run again
+type poolConnector struct {
+	driver      *sqlite3.SQLiteDriver
+	dsn         string
+	generation  int64
+	connectHook func(driverConn) error
+}
+
+type pooledConn struct {
+	*sqlite3.SQLiteConn
+	generation   int64
+	current      *int64
+	releaseHooks []func()
+}
+
+func openPool(dsn string, extensions []string, connectHook func(driverConn) error) (*sql.DB, *poolConnector) {
+	c := &poolConnector{dsn: dsn, driver: &sqlite3.SQLiteDriver{Extensions: extensions}, connectHook: connectHook}
+	return sql.OpenDB(c), c
+}
+
+func (c *poolConnector) Connect(context.Context) (driver.Conn, error) {
+	generation := atomic.LoadInt64(&c.generation)
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	pc := &pooledConn{SQLiteConn: conn.(*sqlite3.SQLiteConn), generation: generation, current: &c.generation}
+	if err := c.connectHook(pc); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return pc, nil
+}
+
+func (c *poolConnector) Driver() driver.Driver { return c.driver }
+
+func (c *poolConnector) recycle() { atomic.AddInt64(&c.generation, 1) }
+
+// RegisterReleaseHook registers f to run whenever the connection is returned to the pool - i.e. once a statement,
+// transaction or *sql.Conn is done and its commit has completed
+func (c *pooledConn) RegisterReleaseHook(f func()) { c.releaseHooks = append(c.releaseHooks, f) }
+
+// IsValid is called by database/sql whenever the connection is returned to the pool
+func (c *pooledConn) IsValid() bool {
+	for _, f := range c.releaseHooks {
+		f()
+	}
+	return c.valid()
+}
+
+// CheckNamedValue converts JSON args so they can also be bound via database/sql directly (e.g. db.DB.Exec)
+func (c *pooledConn) CheckNamedValue(v *driver.NamedValue) (err error) {
+	switch j := v.Value.(type) {
+	case JSON:
+		v.Value, err = j.driverValue()
+	case *JSON:
+		v.Value, err = j.driverValue()
+	default:
+		return driver.ErrSkip
+	}
+	return err
+}
+
+func (c *pooledConn) valid() bool { return atomic.LoadInt64(c.current) == c.generation }
+
+func (c *pooledConn) ResetSession(context.Context) error {
+	if !c.valid() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func sqliteConn(c interface{}) (*sqlite3.SQLiteConn, bool) {
+	if pc, ok := c.(*pooledConn); ok {
+		return pc.SQLiteConn, true
+	}
+	sc, ok := c.(*sqlite3.SQLiteConn)
+	return sc, ok
+}
+
+func isBusyError(err error) bool {
+	sqliteErr := sqlite3.Error{}
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+func backupConn(dst, src interface{}, progress func(remaining, total int)) error {
+	dstConn, ok1 := sqliteConn(dst)
+	srcConn, ok2 := sqliteConn(src)
+	if !ok1 || !ok2 {
+		return errors.New("backup requires sqlite3 connections")
+	}
+	b, err := dstConn.Backup("main", srcConn, "main")
+	if err != nil {
+		return err
+	}
+	for done := false; !done; {
+		if done, err = b.Step(backupPagesPerStep); err != nil {
+			b.Close()
+			return err
+		}
+		if progress != nil {
+			progress(b.Remaining(), b.PageCount())
+		}
+	}
+	return b.Finish()
+}
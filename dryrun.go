@@ -0,0 +1,47 @@
+package gosql
+
+import (
+	"context"
+	"database/sql"
+)
+
+type Statement struct {
+	Query string
+	Args  []interface{}
+}
+
+type DryRun struct {
+	Connection
+	Execute    bool
+	Statements []Statement
+}
+
+type dryRunResult struct{}
+
+func (dryRunResult) LastInsertId() (int64, error) { return 0, nil }
+func (dryRunResult) RowsAffected() (int64, error) { return 0, nil }
+
+func (d *DryRun) Exec(query string, args ...interface{}) (sql.Result, error) {
+	d.Statements = append(d.Statements, Statement{query, args})
+	if d.Execute {
+		return d.Connection.Exec(query, args...)
+	}
+	return dryRunResult{}, nil
+}
+
+func (d *DryRun) Dialect() Dialect { return dialectOf(d.Connection) }
+
+func (db *DB) DryRun(ctx context.Context, fn func(Connection) error) ([]Statement, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(context.Background(), "ROLLBACK")
+	d := &DryRun{Connection: ctxConn{ctx, conn}, Execute: true}
+	err = fn(d)
+	return d.Statements, err
+}
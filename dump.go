@@ -0,0 +1,176 @@
+package gosql
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type dumpObject struct {
+	Type string `db:"type"`
+	Name string `db:"name"`
+	SQL  string `db:"sql"`
+}
+
+// Dump writes a consistent Dump of db from a Snapshot - writers are not blocked while it runs
+func (db *DB) Dump(w io.Writer) error {
+	return db.Snapshot(func(c Connection) error { return Dump(w, c) })
+}
+
+// Dump writes the schema and data of c as a script of sql statements that can be read by RestoreDump (or the sqlite3 shell).
+// Like sqlite3's .dump, virtual tables are written directly into sqlite_master and their shadow tables are dumped like any other table.
+// Tables are emptied before their rows are inserted, so restoring into a database that already has them (e.g. _migrations,
+// which is created by Open) replaces their rows rather than duplicating them
+func Dump(w io.Writer, c Connection) error {
+	objects, err := masterObjects(c, "", false)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n"); err != nil {
+		return err
+	}
+	writableSchema, sequence := false, false
+	for _, o := range objects {
+		if o.Type == "table" && isVirtualTable(o.SQL) {
+			if !writableSchema {
+				if _, err := io.WriteString(w, "PRAGMA writable_schema=ON;\n"); err != nil {
+					return err
+				}
+			}
+			writableSchema = true
+			_, err := fmt.Fprintf(w, "INSERT INTO sqlite_master (type, name, tbl_name, rootpage, sql) VALUES ('table', %s, %s, 0, %s);\n",
+				sqlLiteral(o.Name), sqlLiteral(o.Name), sqlLiteral(o.SQL))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if o.Type == "table" && strings.HasPrefix(o.SQL, "CREATE TABLE ") {
+			o.SQL = "CREATE TABLE IF NOT EXISTS " + o.SQL[len("CREATE TABLE "):] // e.g. _migrations is created by Open
+		}
+		if _, err := fmt.Fprintf(w, "%s;\n", o.SQL); err != nil {
+			return err
+		}
+		if o.Type == "table" {
+			sequence = sequence || strings.Contains(strings.ToUpper(o.SQL), "AUTOINCREMENT")
+			quotedTable, err := quoteIdentifier(o.Name)
+			if err != nil {
+				return err
+			} else if _, err := fmt.Fprintf(w, "DELETE FROM %s;\n", quotedTable); err != nil {
+				return err
+			} else if err := dumpRows(w, c, o.Name); err != nil {
+				return err
+			}
+		}
+	}
+	if sequence {
+		if _, err := io.WriteString(w, "DELETE FROM sqlite_sequence;\n"); err != nil {
+			return err
+		} else if err := dumpRows(w, c, "sqlite_sequence"); err != nil {
+			return err
+		}
+	}
+	if writableSchema {
+		if _, err := io.WriteString(w, "PRAGMA writable_schema=OFF;\n"); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "COMMIT;\n")
+	return err
+}
+
+func dumpRows(w io.Writer, c Connection, table string) error {
+	quotedTable, err := quoteIdentifier(table)
+	if err != nil {
+		return err
+	}
+	query := "SELECT * FROM " + quotedTable
+	err = withRows(c, query, nil, func(rows *resultRows) error {
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		quotedColumns := make([]string, len(columns))
+		for i, column := range columns {
+			quotedColumns[i], _ = quoteIdentifier(column)
+		}
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			for i := range values {
+				values[i] = new(interface{})
+			}
+			if err := rows.Scan(values...); err != nil {
+				return err
+			} else if err := writeInsert(w, quotedTable, quotedColumns, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", query, err)
+	}
+	return nil
+}
+
+// RestoreDump executes a script written by Dump in a single transaction; the BEGIN and COMMIT statements of the script are skipped.
+// Statements are read and executed one at a time, so the script does not have to fit into memory
+func (db *DB) RestoreDump(r io.Reader) error {
+	ctx := context.Background()
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("PRAGMA defer_foreign_keys = ON"); err != nil {
+		return err
+	}
+	br, statement, n, writableSchema := bufio.NewReader(r), "", 0, false
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		statements, rest := splitStatements(statement + line)
+		if err == io.EOF && strings.TrimSpace(rest) != "" {
+			statements = append(statements, strings.TrimSpace(rest))
+		}
+		for _, s := range statements {
+			n++
+			switch strings.ToUpper(strings.TrimSuffix(s, ";")) {
+			case "BEGIN", "BEGIN TRANSACTION", "COMMIT", "END", "END TRANSACTION":
+				continue
+			}
+			if _, err := tx.Exec(s); err != nil {
+				return fmt.Errorf("statement %d: %s: %w", n, s, err)
+			}
+			writableSchema = writableSchema || strings.Contains(strings.ToLower(s), "writable_schema")
+		}
+		if statement = rest; err == io.EOF {
+			break
+		}
+	}
+	if !writableSchema {
+		return tx.Commit()
+	}
+	// other connections only reload their schema once the schema cookie changes. conn itself keeps its stale schema
+	// (without the virtual tables written into sqlite_master) - so it is discarded rather than returned to the pool
+	version := 0
+	if err := tx.QueryRow("PRAGMA schema_version").Scan(&version); err != nil {
+		return err
+	} else if _, err := tx.Exec(fmt.Sprintf("PRAGMA schema_version = %d", version+1)); err != nil {
+		return err
+	} else if err := tx.Commit(); err != nil {
+		return err
+	}
+	conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	return nil
+}
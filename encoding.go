@@ -0,0 +1,80 @@
+package gosql
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// EncoderOptions control how query results are encoded as JSON by Fprint and the handlers.
+// Without them integers take the float64 round trip of the JSON type, NULLs are encoded as null
+// and timestamps as RFC3339.
+type EncoderOptions struct {
+	Integers   bool   // encode INTEGER values (including those nested in JSON text) as is - floats lose precision beyond 2^53
+	OmitNulls  bool   // leave NULL columns out of row objects. Positional rows keep them as null
+	TimeFormat string // time.Time layout - or "unix" / "unixmilli" for numeric timestamps
+}
+
+// rawRow keeps the driver values of a row so they can be encoded according to EncoderOptions
+type rawRow struct {
+	columns []string
+	values  []interface{}
+}
+
+func (r *rawRow) MapRow(columns []string, values []interface{}) error {
+	r.columns, r.values = columns, append([]interface{}(nil), values...)
+	return nil
+}
+
+func (o *EncoderOptions) object(r rawRow) map[string]interface{} {
+	m := make(map[string]interface{}, len(r.columns))
+	for i, column := range r.columns {
+		if r.values[i] != nil || !o.OmitNulls {
+			m[column] = o.value(r.values[i])
+		}
+	}
+	return m
+}
+
+// results returns the rows of a slice pointer in their encodable form - objects for rawRows
+func (o *EncoderOptions) results(xs interface{}) interface{} {
+	rows, ok := xs.(*[]rawRow)
+	if !ok {
+		return reflect.ValueOf(xs).Elem().Interface()
+	}
+	ms := make([]map[string]interface{}, len(*rows))
+	for i, r := range *rows {
+		ms[i] = o.object(r)
+	}
+	return ms
+}
+
+func (o *EncoderOptions) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		if !o.Integers {
+			return float64(v)
+		}
+	case time.Time:
+		switch o.TimeFormat {
+		case "":
+		case "unix":
+			return v.Unix()
+		case "unixmilli":
+			return v.UnixNano() / int64(time.Millisecond)
+		default:
+			return v.Format(o.TimeFormat)
+		}
+	case string:
+		if o.Integers && (isJSONArrayString(v) || isJSONObjectString(v)) {
+			d, x := json.NewDecoder(strings.NewReader(v)), interface{}(nil)
+			d.UseNumber()
+			if err := d.Decode(&x); err == nil && !d.More() {
+				return x
+			}
+		}
+		return JSON{v}
+	}
+	return v
+}
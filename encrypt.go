@@ -0,0 +1,158 @@
+package gosql
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+var ErrNoColumnKey = errors.New("encrypted column but DB.ColumnKey is not set")
+
+func newColumnCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("column key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// ciphertexts are nonce + sealed plaintext. ad (additional data) must match for decryption
+func encryptBytes(aead cipher.AEAD, ad, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+func decryptBytes(aead cipher.AEAD, ad, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("decrypt: ciphertext too short")
+	}
+	n := aead.NonceSize()
+	plaintext, err := aead.Open(nil, ciphertext[:n], ciphertext[n:], ad)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+// encrypt(value, ad) and decrypt(value, ad) are only registered on the read-write pool with DB.ColumnFuncs -
+// anyone able to call decrypt can read all encrypted columns
+func (db *DB) registerColumnFuncs(c driverConn) error {
+	if !db.ColumnFuncs || db.columnCipher == nil {
+		return nil
+	}
+	funcs := map[string]interface{}{
+		"encrypt": func(v interface{}, ad string) ([]byte, error) {
+			if bs, ok := v.([]byte); ok {
+				return encryptBytes(db.columnCipher, []byte(ad), bs)
+			} else if v == nil {
+				return nil, nil
+			}
+			return encryptBytes(db.columnCipher, []byte(ad), []byte(fmt.Sprint(v)))
+		},
+		"decrypt": func(bs []byte, ad string) (string, error) {
+			plaintext, err := decryptBytes(db.columnCipher, []byte(ad), bs)
+			return string(plaintext), err
+		},
+	}
+	for name, f := range funcs {
+		if err := c.RegisterFunc(name, f, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncryptionContext returns the additional data encrypted struct fields are bound to: table, column and the pk values
+// of the row as JSON array - so ciphertexts can't be copied to other columns or rows. The table is that of
+// Insert / Update / Upsert / Get or the one named by the tag (`db:"ssn,encrypted=people"`) - Query requires the latter
+func EncryptionContext(table, column string, pk []interface{}) (string, error) {
+	bs, err := json.Marshal([]interface{}{table, column, pk})
+	return string(bs), err
+}
+
+// encryptionContext of field f of struct v - pk fields must be set for encrypted fields to be bound to their row
+func encryptionContext(table string, v reflect.Value, f reflect.StructField, mapper func(string) string) ([]byte, error) {
+	column, options := mappedTag(mapper, f)
+	if options["encrypted"] != "encrypted" {
+		table = options["encrypted"]
+	} else if table == "" {
+		return nil, fmt.Errorf("encrypted field %s: unknown table - use Get or name it in the tag (encrypted=TABLE)", f.Name)
+	}
+	pk := []interface{}{}
+	for _, pkField := range columnFields(v.Type(), mapper) {
+		if _, options := parseTag(pkField); options["pk"] != "" {
+			if x := v.FieldByIndex(pkField.Index); x.IsZero() {
+				return nil, fmt.Errorf("encrypted field %s: pk %s must be set", f.Name, pkField.Name)
+			} else {
+				pk = append(pk, x.Interface())
+			}
+		}
+	}
+	ad, err := EncryptionContext(table, column, pk)
+	return []byte(ad), err
+}
+
+func columnCipherOf(c Connection) cipher.AEAD {
+	if db, _ := hookedDB(c); db != nil {
+		return db.columnCipher
+	}
+	return nil
+}
+
+// encryptField encrypts string and []byte fields (or pointers to them) - nil pointers stay NULL
+func encryptField(aead cipher.AEAD, ad []byte, f reflect.StructField, v reflect.Value) (interface{}, error) {
+	if aead == nil {
+		return nil, ErrNoColumnKey
+	} else if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	switch {
+	case v.Kind() == reflect.String:
+		return encryptBytes(aead, ad, []byte(v.String()))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		return encryptBytes(aead, ad, v.Bytes())
+	}
+	return nil, fmt.Errorf("encrypted field %s must be a string or []byte, not %s", f.Name, f.Type)
+}
+
+func decryptField(aead cipher.AEAD, ad []byte, v reflect.Value, ciphertext []byte) error {
+	if ciphertext == nil {
+		return nil
+	} else if aead == nil {
+		return ErrNoColumnKey
+	}
+	plaintext, err := decryptBytes(aead, ad, ciphertext)
+	if err != nil {
+		return err
+	}
+	if v.Kind() == reflect.Ptr {
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(string(plaintext))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(plaintext)
+	default:
+		return fmt.Errorf("cannot decrypt into %s", v.Type())
+	}
+	return nil
+}
+
+func isEncrypted(f reflect.StructField) bool {
+	_, options := parseTag(f)
+	_, ok := options["encrypted"]
+	return ok
+}
@@ -0,0 +1,47 @@
+package gosql
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrNoRows is returned by Get (and passed through by all helpers) - it is sql.ErrNoRows
+var ErrNoRows = sql.ErrNoRows
+
+// Error is the driver independent form of sqlite errors. Code and ExtendedCode are sqlite result codes (https://sqlite.org/rescode.html)
+type Error struct {
+	Code         int
+	ExtendedCode int
+	Message      string
+}
+
+func (e Error) Error() string { return e.Message }
+
+func IsUniqueViolation(err error) bool {
+	return hasExtendedCode(err, codeConstraintUnique, codeConstraintPrimaryKey)
+}
+
+func IsForeignKeyViolation(err error) bool {
+	return hasExtendedCode(err, codeConstraintForeignKey)
+}
+
+func IsNotNullViolation(err error) bool {
+	return hasExtendedCode(err, codeConstraintNotNull)
+}
+
+func IsConstraintViolation(err error) bool {
+	code, _, ok := errorCodes(err)
+	return ok && code == codeConstraint
+}
+
+func IsNoRows(err error) bool { return errors.Is(err, sql.ErrNoRows) }
+
+func hasExtendedCode(err error, codes ...int) bool {
+	_, extendedCode, ok := errorCodes(err)
+	for _, code := range codes {
+		if ok && extendedCode == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package gosql
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+type Explanation struct {
+	Plan     []string      `json:"plan"`
+	Advice   []Advice      `json:"advice"`
+	Duration time.Duration `json:"-"`
+	Rows     int           `json:"rows"`
+}
+
+// Explain returns the query plan and index advice for query and runs it to measure its duration and number of rows.
+// The query runs in a transaction that is rolled back, so writes are measured but not applied
+func (db *DB) Explain(query string, args ...interface{}) (*Explanation, error) {
+	e, err := explainPlan(db, db.DB, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	start := time.Now()
+	err = withRows(tx, query, args, func(rows *resultRows) error {
+		for rows.Next() {
+			e.Rows++
+		}
+		return nil
+	})
+	e.Duration = time.Since(start)
+	return e, err
+}
+
+type PlanStep struct {
+	ID       int        `json:"id"`
+	Parent   int        `json:"parent"`
+	Detail   string     `json:"detail"`
+	Children []PlanStep `json:"children"`
+}
+
+type Opcode struct {
+	Addr    int         `db:"addr" json:"addr"`
+	Opcode  string      `db:"opcode" json:"opcode"`
+	P1      int         `db:"p1" json:"p1"`
+	P2      int         `db:"p2" json:"p2"`
+	P3      int         `db:"p3" json:"p3"`
+	P4      interface{} `db:"p4" json:"p4"`
+	P5      int         `db:"p5" json:"p5"`
+	Comment *string     `db:"comment" json:"comment"`
+}
+
+// Plan returns the EXPLAIN QUERY PLAN of query as a tree of steps
+func (db *DB) Plan(query string, args ...interface{}) ([]PlanStep, error) {
+	steps := []planStep{}
+	if err := Query(db.DB, "EXPLAIN QUERY PLAN "+query, &steps, args...); err != nil {
+		return nil, err
+	}
+	children := map[int][]planStep{}
+	for _, step := range steps {
+		children[step.Parent] = append(children[step.Parent], step)
+	}
+	var build func(parent int) []PlanStep
+	build = func(parent int) []PlanStep {
+		tree := []PlanStep{}
+		for _, step := range children[parent] {
+			tree = append(tree, PlanStep{step.ID, step.Parent, step.Detail, build(step.ID)})
+		}
+		return tree
+	}
+	return build(0), nil
+}
+
+// Opcodes returns the bytecode program of query (EXPLAIN)
+func (db *DB) Opcodes(query string, args ...interface{}) ([]Opcode, error) {
+	opcodes := []Opcode{}
+	return opcodes, Query(db.DB, "EXPLAIN "+query, &opcodes, args...)
+}
+
+// FindPlanSteps returns the details of all steps (and their children) that contain s - e.g. "USING INDEX xs_x"
+func FindPlanSteps(steps []PlanStep, s string) []string {
+	details := []string{}
+	for _, step := range steps {
+		if strings.Contains(step.Detail, s) {
+			details = append(details, step.Detail)
+		}
+		details = append(details, FindPlanSteps(step.Children, s)...)
+	}
+	return details
+}
+
+func explainPlan(db *DB, c Connection, query string, args ...interface{}) (*Explanation, error) {
+	plan, err := QueryPlan(c, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	e := &Explanation{Plan: plan, Advice: []Advice{}}
+	if db != nil {
+		if e.Advice, err = db.Analyze(query, args...); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e Explanation) MarshalJSON() ([]byte, error) {
+	type explanation Explanation
+	return json.Marshal(struct {
+		explanation
+		DurationMS float64 `json:"duration_ms"`
+	}{explanation(e), float64(e.Duration) / float64(time.Millisecond)})
+}
@@ -0,0 +1,212 @@
+package gosql
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ExportOptions struct {
+	Format     string
+	ChunkSize  int
+	After      []interface{}
+	Checkpoint func(last []interface{}) error
+}
+
+// Export writes table in chunks ordered by rowid - or by primary key for WITHOUT ROWID tables.
+// The key of the last exported row is passed to Checkpoint and can be used as After to resume the export.
+func Export(c Connection, table string, w io.Writer, opts ExportOptions) ([]interface{}, error) {
+	quotedTable, err := quoteTableName(table)
+	if err != nil {
+		return opts.After, err
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = 10000
+	}
+	ew, err := newExportWriter(w, opts.Format, quotedTable)
+	if err != nil {
+		return opts.After, err
+	}
+	keys, withoutRowID, err := primaryKey(c, table)
+	if err != nil {
+		return opts.After, err
+	} else if !withoutRowID {
+		keys = []string{"rowid"}
+	}
+	if len(opts.After) != 0 && len(opts.After) != len(keys) {
+		return opts.After, fmt.Errorf("invalid cursor: %s is keyed by %d columns", table, len(keys))
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")
+	query := fmt.Sprintf("SELECT %[1]s, * FROM %[2]s ORDER BY %[1]s LIMIT ?", strings.Join(keys, ", "), quotedTable)
+	resumeQuery := fmt.Sprintf("SELECT %[1]s, * FROM %[2]s WHERE (%[1]s) > (%[3]s) ORDER BY %[1]s LIMIT ?", strings.Join(keys, ", "), quotedTable, placeholders)
+	last := opts.After
+	for header := len(opts.After) == 0; ; header = false {
+		n, q, args := 0, query, []interface{}{opts.ChunkSize}
+		if len(last) != 0 {
+			q, args = resumeQuery, append(append([]interface{}{}, last...), opts.ChunkSize)
+		}
+		err := withRows(c, q, args, func(rows *resultRows) error {
+			var err error
+			n, err = ew.writeRows(rows, len(keys), header, func(key []interface{}) { last = key })
+			return err
+		})
+		if err != nil {
+			return last, fmt.Errorf("%s: %w", q, err)
+		} else if n == 0 {
+			return last, nil
+		} else if opts.Checkpoint != nil {
+			if err := opts.Checkpoint(last); err != nil {
+				return last, err
+			}
+		}
+	}
+}
+
+// exportWriter writes rows as csv, ndjson or - if the table is known - sql INSERT statements
+type exportWriter struct {
+	w             io.Writer
+	format        string
+	quotedTable   string
+	columns       []string
+	quotedColumns []string
+	csv           *csv.Writer
+	json          *json.Encoder
+}
+
+func newExportWriter(w io.Writer, format, quotedTable string) (*exportWriter, error) {
+	if format == "sql" && quotedTable == "" {
+		return nil, fmt.Errorf("sql export requires a table name, not a query")
+	} else if format != "csv" && format != "ndjson" && format != "sql" {
+		return nil, fmt.Errorf("unhandled export format %q", format)
+	}
+	return &exportWriter{w: w, format: format, quotedTable: quotedTable, csv: csv.NewWriter(w), json: json.NewEncoder(w)}, nil
+}
+
+// writeRows writes all rows and returns their count. The first skip columns are not written but passed to skipped for each row
+func (e *exportWriter) writeRows(rows *resultRows, skip int, header bool, skipped func([]interface{})) (int, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	e.columns, e.quotedColumns = columns[skip:], make([]string, len(columns)-skip)
+	for i, column := range e.columns {
+		e.quotedColumns[i], _ = quoteIdentifier(column)
+	}
+	if header && e.format == "csv" {
+		if err := e.csv.Write(e.columns); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	for ; rows.Next(); n++ {
+		values := make([]interface{}, len(columns))
+		for i := range values {
+			values[i] = new(interface{})
+		}
+		if err := rows.Scan(values...); err != nil {
+			return n, err
+		} else if err := e.write(values[skip:]); err != nil {
+			return n, err
+		} else if n%1000 == 999 {
+			e.csv.Flush()
+		}
+		if skipped != nil {
+			key := make([]interface{}, skip)
+			for i := range key {
+				key[i] = *values[i].(*interface{})
+			}
+			skipped(key)
+		}
+	}
+	e.csv.Flush()
+	return n, e.csv.Error()
+}
+
+func (e *exportWriter) write(values []interface{}) error {
+	switch e.format {
+	case "ndjson":
+		m := map[string]interface{}{}
+		for i, column := range e.columns {
+			m[column] = *values[i].(*interface{})
+		}
+		return e.json.Encode(m)
+	case "sql":
+		return writeInsert(e.w, e.quotedTable, e.quotedColumns, values)
+	default:
+		return e.csv.Write(csvRecord(values))
+	}
+}
+
+func csvRecord(values []interface{}) []string {
+	record := make([]string, len(values))
+	for i, v := range values {
+		switch v := (*v.(*interface{})).(type) {
+		case nil:
+		case []byte:
+			record[i] = string(v)
+		case time.Time:
+			record[i] = v.Format(time.RFC3339Nano)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return record
+}
+
+func ExportQuery(c Connection, w io.Writer, query, format string, args ...interface{}) error {
+	quotedTable := ""
+	if !strings.ContainsAny(query, " \t\n(") {
+		table, err := quoteTableName(query)
+		if err != nil {
+			return err
+		}
+		quotedTable, query = table, "SELECT * FROM "+table
+	}
+	ew, err := newExportWriter(w, format, quotedTable)
+	if err != nil {
+		return err
+	}
+	err = withRows(c, query, args, func(rows *resultRows) error {
+		_, err := ew.writeRows(rows, 0, true, nil)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", query, err)
+	}
+	return nil
+}
+
+func writeInsert(w io.Writer, quotedTable string, quotedColumns []string, values []interface{}) error {
+	literals := make([]string, len(values))
+	for i, v := range values {
+		literals[i] = sqlLiteral(*v.(*interface{}))
+	}
+	_, err := fmt.Fprintf(w, "INSERT INTO %s (%s) VALUES (%s);\n", quotedTable, strings.Join(quotedColumns, ", "), strings.Join(literals, ", "))
+	return err
+}
+
+func sqlLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		return fmt.Sprintf("X'%x'", v)
+	case time.Time:
+		return "'" + v.Format(time.RFC3339Nano) + "'"
+	default:
+		return "'" + sqlString(fmt.Sprint(v)) + "'"
+	}
+}
@@ -0,0 +1,153 @@
+package gosql
+
+import (
+	"strings"
+	"unicode"
+)
+
+type token struct {
+	kind string
+	text string
+}
+
+var keywords = map[string]bool{}
+
+var clauseKeywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "GROUP": true, "HAVING": true, "ORDER": true, "LIMIT": true,
+	"OFFSET": true, "UNION": true, "EXCEPT": true, "INTERSECT": true, "VALUES": true, "SET": true, "INSERT": true,
+	"UPDATE": true, "DELETE": true, "WITH": true, "WINDOW": true, "RETURNING": true,
+}
+
+var joinKeywords = map[string]bool{
+	"JOIN": true, "LEFT": true, "RIGHT": true, "FULL": true, "INNER": true, "OUTER": true, "CROSS": true, "NATURAL": true,
+}
+
+func init() {
+	for _, k := range strings.Fields(`ABORT ACTION ADD AFTER ALL ALTER ANALYZE AND AS ASC ATTACH AUTOINCREMENT BEFORE
+		BEGIN BETWEEN BY CASCADE CASE CAST CHECK COLLATE COLUMN COMMIT CONFLICT CONSTRAINT CREATE CROSS CURRENT_DATE
+		CURRENT_TIME CURRENT_TIMESTAMP DEFAULT DEFERRABLE DEFERRED DELETE DESC DETACH DISTINCT DO DROP EACH ELSE END
+		ESCAPE EXCEPT EXCLUSIVE EXISTS EXPLAIN FAIL FILTER FOR FOREIGN FROM FULL GLOB GROUP HAVING IF IGNORE IMMEDIATE
+		IN INDEX INDEXED INITIALLY INNER INSERT INSTEAD INTERSECT INTO IS ISNULL JOIN KEY LEFT LIKE LIMIT MATCH NATURAL
+		NO NOT NOTHING NOTNULL NULL OF OFFSET ON OR ORDER OUTER OVER PARTITION PLAN PRAGMA PRIMARY QUERY RAISE RECURSIVE
+		REFERENCES REGEXP REINDEX RELEASE RENAME REPLACE RESTRICT RETURNING RIGHT ROLLBACK ROW ROWS SAVEPOINT SELECT SET
+		TABLE TEMP TEMPORARY THEN TO TRANSACTION TRIGGER UNION UNIQUE UPDATE USING VACUUM VALUES VIEW VIRTUAL WHEN WHERE
+		WINDOW WITH WITHOUT`) {
+		keywords[k] = true
+	}
+}
+
+func Format(sql string) string {
+	type paren struct {
+		isSubquery    bool
+		depth, indent int
+	}
+	tokens, b, depth, indent, parens := tokenize(sql), &strings.Builder{}, 0, 0, []paren{}
+	space, between, prev := false, false, ""
+	newline := func(i int) {
+		if b.Len() != 0 {
+			b.WriteString("\n" + strings.Repeat("  ", i))
+		}
+		space, indent = false, i
+	}
+	for i, t := range tokens {
+		text, upper := t.text, strings.ToUpper(t.text)
+		switch {
+		case t.kind == "space":
+			space = true
+			continue
+		case t.kind == "word" && keywords[upper]:
+			text = upper
+			if clauseKeywords[upper] || (joinKeywords[upper] && !joinKeywords[prev]) {
+				newline(depth)
+			} else if (upper == "AND" && !between) || upper == "OR" {
+				newline(depth + 1)
+			}
+			if upper == "BETWEEN" {
+				between = true
+			} else if upper == "AND" {
+				between = false
+			}
+		case text == ")" && len(parens) != 0:
+			if p := parens[len(parens)-1]; p.isSubquery {
+				depth = p.depth
+				newline(p.indent)
+			}
+			parens = parens[:len(parens)-1]
+		}
+		if space && b.Len() != 0 && text != "," && text != ")" && prev != "(" {
+			b.WriteString(" ")
+		}
+		b.WriteString(text)
+		space = false
+		if text == "(" {
+			isSubquery := false
+			for _, next := range tokens[i+1:] {
+				if next.kind != "space" {
+					isSubquery = strings.EqualFold(next.text, "SELECT") || strings.EqualFold(next.text, "WITH")
+					break
+				}
+			}
+			if parens = append(parens, paren{isSubquery, depth, indent}); isSubquery {
+				depth = indent + 1
+			}
+		} else if t.kind == "comment" && strings.HasPrefix(text, "--") {
+			newline(depth)
+		}
+		if t.kind == "word" {
+			prev = upper
+		} else {
+			prev = text
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func tokenize(s string) []token {
+	tokens := []token{}
+	for i := 0; i < len(s); {
+		j, kind := i+1, "punctuation"
+		switch c := s[i]; {
+		case unicode.IsSpace(rune(c)):
+			for j < len(s) && unicode.IsSpace(rune(s[j])) {
+				j++
+			}
+			kind = "space"
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			for ; j < len(s); j++ {
+				if s[j] == end && end != ']' && j+1 < len(s) && s[j+1] == end {
+					j++
+				} else if s[j] == end {
+					j++
+					break
+				}
+			}
+			kind = "quoted"
+		case strings.HasPrefix(s[i:], "--"):
+			if j = strings.IndexByte(s[i:], '\n'); j == -1 {
+				j = len(s)
+			} else {
+				j += i
+			}
+			kind = "comment"
+		case strings.HasPrefix(s[i:], "/*"):
+			if j = strings.Index(s[i+2:], "*/"); j == -1 {
+				j = len(s)
+			} else {
+				j += i + 4
+			}
+			kind = "comment"
+		case c == '_' || c == '$' || c == '@' || c == ':' || c == '?' || c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			for j < len(s) && (s[j] == '_' || s[j] == '$' || s[j] >= 0x80 || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			kind = "word"
+		}
+		tokens = append(tokens, token{kind, s[i:j]})
+		i = j
+	}
+	return tokens
+}
@@ -0,0 +1,75 @@
+package gosql
+
+import (
+	"fmt"
+	"strings"
+)
+
+func CreateFTS(c Connection, table string, columns ...string) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("fts %s: no columns", table)
+	}
+	quotedTable, err := quoteIdentifier(table)
+	if err != nil {
+		return err
+	}
+	fts, _ := quoteIdentifier(table + "_fts")
+	quoted, newValues, oldValues := make([]string, len(columns)), make([]string, len(columns)), make([]string, len(columns))
+	for i, column := range columns {
+		if quoted[i], err = quoteIdentifier(column); err != nil {
+			return err
+		}
+		newValues[i], oldValues[i] = "new."+quoted[i], "old."+quoted[i]
+	}
+	trigger := func(suffix string) string { s, _ := quoteIdentifier(table + "_fts_" + suffix); return s }
+	cs, news, olds := strings.Join(quoted, ", "), strings.Join(newValues, ", "), strings.Join(oldValues, ", ")
+	insert := fmt.Sprintf("INSERT INTO %s (rowid, %s) VALUES (new.rowid, %s);", fts, cs, news)
+	delete := fmt.Sprintf("INSERT INTO %s (%s, rowid, %s) VALUES ('delete', old.rowid, %s);", fts, fts, cs, olds)
+	return inTransaction(c, func(c Connection) error {
+		for _, query := range []string{
+			fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(%s, content=%s, content_rowid=rowid)", fts, cs, "'"+sqlString(table)+"'"),
+			fmt.Sprintf("CREATE TRIGGER %s AFTER INSERT ON %s BEGIN %s END", trigger("ai"), quotedTable, insert),
+			fmt.Sprintf("CREATE TRIGGER %s AFTER DELETE ON %s BEGIN %s END", trigger("ad"), quotedTable, delete),
+			fmt.Sprintf("CREATE TRIGGER %s AFTER UPDATE ON %s BEGIN %s %s END", trigger("au"), quotedTable, delete, insert),
+			fmt.Sprintf("INSERT INTO %s (%s) VALUES ('rebuild')", fts, fts),
+		} {
+			if _, err := c.Exec(query); err != nil {
+				return fmt.Errorf("%s: %w", query, err)
+			}
+		}
+		return nil
+	})
+}
+
+func DropFTS(c Connection, table string) error {
+	return inTransaction(c, func(c Connection) error {
+		for _, name := range []string{"_fts_ai", "_fts_ad", "_fts_au", "_fts"} {
+			quoted, err := quoteIdentifier(table + name)
+			if err != nil {
+				return err
+			}
+			kind := "TRIGGER"
+			if name == "_fts" {
+				kind = "TABLE"
+			}
+			query := fmt.Sprintf("DROP %s IF EXISTS %s", kind, quoted)
+			if _, err := c.Exec(query); err != nil {
+				return fmt.Errorf("%s: %w", query, err)
+			}
+		}
+		return nil
+	})
+}
+
+// results receive all columns of table plus rank (bm25, lower is better) and snippet (first matching column)
+func Search(c Connection, table, match string, results interface{}) error {
+	quotedTable, err := quoteIdentifier(table)
+	if err != nil {
+		return err
+	}
+	fts, _ := quoteIdentifier(table + "_fts")
+	query := fmt.Sprintf(`SELECT %s.*, bm25(%s) AS rank, snippet(%s, -1, '<b>', '</b>', '…', 16) AS snippet
+FROM %s JOIN %s ON %s.rowid = %s.rowid WHERE %s MATCH ? ORDER BY rank`,
+		quotedTable, fts, fts, fts, quotedTable, quotedTable, fts, fts)
+	return Query(c, query, results, match)
+}
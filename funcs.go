@@ -0,0 +1,411 @@
+package gosql
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var slugReplacer = strings.NewReplacer(
+	"ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss", "æ", "ae", "ø", "o", "å", "a", "œ", "oe",
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ç", "c", "è", "e", "é", "e", "ê", "e", "ë", "e",
+	"ì", "i", "í", "i", "î", "i", "ï", "i", "ñ", "n", "ò", "o", "ó", "o", "ô", "o", "õ", "o",
+	"ù", "u", "ú", "u", "û", "u", "ý", "y", "ÿ", "y",
+)
+
+func parseURL(s string) *url.URL {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return &url.URL{}
+	} else if u.Host == "" && u.Scheme == "" && !strings.HasPrefix(u.Path, "/") {
+		if v, err := url.Parse("//" + strings.TrimSpace(s)); err == nil {
+			return v
+		}
+	}
+	return u
+}
+
+func urlHost(s string) string { return strings.ToLower(parseURL(s).Hostname()) }
+
+func urlPath(s string) string { return parseURL(s).Path }
+
+func urlQueryParam(s, name string) string { return parseURL(s).Query().Get(name) }
+
+func slugify(s string) string {
+	b, dash := &strings.Builder{}, false
+	for _, r := range slugReplacer.Replace(strings.ToLower(s)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() != 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+func md5Hex(bs []byte) string {
+	sum := md5.Sum(bs)
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Hex(bs []byte) string {
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:])
+}
+
+func crc32Checksum(bs []byte) int64 { return int64(crc32.ChecksumIEEE(bs)) }
+
+func levenshtein(a, b string) int {
+	x, y := []rune(a), []rune(b)
+	previous, current := make([]int, len(y)+1), make([]int, len(y)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		current[0] = i
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(y)]
+}
+
+// optimal string alignment distance: levenshtein + transposition of adjacent characters (no substring is edited twice)
+func damerauLevenshtein(a, b string) int {
+	x, y := []rune(a), []rune(b)
+	d := make([][]int, len(x)+1)
+	for i := range d {
+		d[i] = make([]int, len(y)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(minInt(d[i-1][j]+1, d[i][j-1]+1), d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && x[i-1] == y[j-2] && x[i-2] == y[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(x)][len(y)]
+}
+
+// like postgres pg_trgm: words are lowercased and padded ("  word "); result is |shared| / |all| trigrams
+func trigramSimilarity(a, b string) float64 {
+	x, y := trigrams(a), trigrams(b)
+	shared := 0
+	for t := range x {
+		if y[t] {
+			shared++
+		}
+	}
+	if all := len(x) + len(y) - shared; all != 0 {
+		return float64(shared) / float64(all)
+	}
+	return 0
+}
+
+func trigrams(s string) map[string]bool {
+	m := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		rs := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(rs); i++ {
+			m[string(rs[i:i+3])] = true
+		}
+	}
+	return m
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// canonical output is RFC3339 in UTC, which sqlite's own date functions understand and which sorts lexicographically
+func strptimeGo(value, layout string) (string, error) {
+	t, err := time.Parse(goLayout(layout), value)
+	if err != nil {
+		return "", err
+	}
+	return t.UTC().Format(time.RFC3339Nano), nil
+}
+
+func strftimeGo(value interface{}, layout string) (string, error) {
+	t, err := parseTimeValue("strftime_go", value)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(goLayout(layout)), nil
+}
+
+// value is either unix seconds or a timestamp in one of the formats sqlite and the sqlite3 driver use
+func parseTimeValue(function string, value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC(), nil
+	case int64:
+		return time.Unix(v, 0).UTC(), nil
+	case float64:
+		return time.Unix(0, int64(v*float64(time.Second))).UTC(), nil
+	case []byte:
+		value = string(v)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%s: unhandled value %v", function, value)
+	}
+	s = strings.TrimSuffix(s, "Z")
+	for _, format := range timestampFormats {
+		if t, err := time.ParseInLocation(format, s, time.UTC); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s: cannot parse %q as time", function, s)
+}
+
+func goLayout(layout string) string {
+	switch layout {
+	case "RFC3339":
+		return time.RFC3339
+	case "RFC3339Nano":
+		return time.RFC3339Nano
+	case "RFC1123":
+		return time.RFC1123
+	case "RFC1123Z":
+		return time.RFC1123Z
+	case "RFC822":
+		return time.RFC822
+	case "Kitchen":
+		return time.Kitchen
+	}
+	return layout
+}
+
+func durationParse(s string) (float64, error) {
+	d, err := time.ParseDuration(s)
+	return d.Seconds(), err
+}
+
+// the two most significant units, e.g. 3d 4h, 5m 12s or 800ms
+func humanize(seconds interface{}) (string, error) {
+	d := time.Duration(0)
+	switch v := seconds.(type) {
+	case int64:
+		d = time.Duration(v) * time.Second
+	case float64:
+		d = time.Duration(v * float64(time.Second))
+	default:
+		return "", fmt.Errorf("humanize: %v is not a number of seconds", seconds)
+	}
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	if d < time.Second {
+		return sign + d.Round(time.Millisecond).String(), nil
+	}
+	units, parts := []struct {
+		name string
+		d    time.Duration
+	}{{"d", 24 * time.Hour}, {"h", time.Hour}, {"m", time.Minute}, {"s", time.Second}}, []string{}
+	d = d.Round(time.Second)
+	for _, u := range units {
+		if n := d / u.d; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d%s", n, u.name))
+			d -= n * u.d
+		} else if len(parts) != 0 {
+			break
+		}
+		if len(parts) == 2 {
+			break
+		}
+	}
+	return sign + strings.Join(parts, " "), nil
+}
+
+func decodeJSON(s string) (interface{}, error) {
+	d, v := json.NewDecoder(strings.NewReader(s)), interface{}(nil)
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	} else if d.More() {
+		return nil, fmt.Errorf("unexpected data after json value")
+	}
+	return v, nil
+}
+
+func encodeJSON(v interface{}) (string, error) {
+	bs, err := json.Marshal(v)
+	return string(bs), err
+}
+
+func jsonObject(s, function string) (map[string]interface{}, error) {
+	v, err := decodeJSON(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", function, err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: %s is not an object", function, s)
+	}
+	return m, nil
+}
+
+// RFC 7396 merge patch semantics: objects are merged recursively, null removes a key, everything else is replaced
+func jsonMerge(s string, patches ...string) (string, error) {
+	v, err := decodeJSON(s)
+	if err != nil {
+		return "", fmt.Errorf("json_merge: %w", err)
+	}
+	for _, patch := range patches {
+		p, err := decodeJSON(patch)
+		if err != nil {
+			return "", fmt.Errorf("json_merge: %w", err)
+		}
+		v = mergePatch(v, p)
+	}
+	return encodeJSON(v)
+}
+
+func mergePatch(v, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		m = map[string]interface{}{}
+	}
+	for k, pv := range p {
+		if pv == nil {
+			delete(m, k)
+		} else {
+			m[k] = mergePatch(m[k], pv)
+		}
+	}
+	return m
+}
+
+func jsonPick(s string, keys ...string) (string, error) {
+	m, err := jsonObject(s, "json_pick")
+	if err != nil {
+		return "", err
+	}
+	picked := map[string]interface{}{}
+	for _, k := range keys {
+		if v, ok := m[k]; ok {
+			picked[k] = v
+		}
+	}
+	return encodeJSON(picked)
+}
+
+func jsonOmit(s string, keys ...string) (string, error) {
+	m, err := jsonObject(s, "json_omit")
+	if err != nil {
+		return "", err
+	}
+	for _, k := range keys {
+		delete(m, k)
+	}
+	return encodeJSON(m)
+}
+
+// {"a": {"b": [1, 2]}} => {"a.b.0": 1, "a.b.1": 2}. empty objects and arrays are kept as leaves
+func jsonFlatten(s string) (string, error) {
+	v, err := decodeJSON(s)
+	if err != nil {
+		return "", fmt.Errorf("json_flatten: %w", err)
+	}
+	m := map[string]interface{}{}
+	var flatten func(prefix string, v interface{})
+	flatten = func(prefix string, v interface{}) {
+		join := func(k string) string {
+			if prefix == "" {
+				return k
+			}
+			return prefix + "." + k
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if len(v) == 0 && prefix != "" {
+				m[prefix] = v
+			}
+			for k, x := range v {
+				flatten(join(k), x)
+			}
+		case []interface{}:
+			if len(v) == 0 && prefix != "" {
+				m[prefix] = v
+			}
+			for i, x := range v {
+				flatten(join(strconv.Itoa(i)), x)
+			}
+		default:
+			m[prefix] = v
+		}
+	}
+	flatten("", v)
+	return encodeJSON(m)
+}
+
+// replaces every leaf with its type (null, boolean, number, string) - handy to compare document shapes
+func jsonTypeofDeep(s string) (string, error) {
+	v, err := decodeJSON(s)
+	if err != nil {
+		return "", fmt.Errorf("json_typeof_deep: %w", err)
+	}
+	var typeof func(v interface{}) interface{}
+	typeof = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, x := range v {
+				v[k] = typeof(x)
+			}
+			return v
+		case []interface{}:
+			for i, x := range v {
+				v[i] = typeof(x)
+			}
+			return v
+		case json.Number:
+			return "number"
+		case string:
+			return "string"
+		case bool:
+			return "boolean"
+		}
+		return "null"
+	}
+	return encodeJSON(typeof(v))
+}
+
+func jsonIsValid(s string) bool {
+	_, err := decodeJSON(s)
+	return err == nil
+}
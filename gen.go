@@ -0,0 +1,147 @@
+package gosql
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var initialisms = map[string]bool{"ID": true, "URL": true, "URI": true, "UUID": true, "API": true, "HTTP": true, "JSON": true, "SQL": true, "HTML": true, "IP": true}
+
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+var generateSampleRows = 1000
+
+// GenerateStruct runs query and returns the gofmt'ed definition of a struct named name with a field per result column.
+// Field types are derived from the declared column types - or from the first rows of the result for expressions
+// (interface{} if there are none or they differ). Columns become pointers unless they are declared NOT NULL -
+// expressions unless no NULLs are sampled.
+func GenerateStruct(c Connection, name, query string, args ...interface{}) (string, error) {
+	var columns []string
+	var types []reflect.Type
+	notNull, err := notNullColumns(c)
+	if err != nil {
+		return "", err
+	}
+	err = withRows(c, query, args, func(rows *resultRows) error {
+		columnTypes, err := rows.ColumnTypes()
+		if err != nil {
+			return err
+		}
+		types = make([]reflect.Type, len(columnTypes))
+		observed, nullable := make([]reflect.Type, len(columnTypes)), make([]bool, len(columnTypes))
+		values := make([]interface{}, len(columnTypes))
+		for i, ct := range columnTypes {
+			columns, types[i], values[i] = append(columns, ct.Name()), declaredGoType(ct.DatabaseTypeName()), new(interface{})
+			if isNullable, ok := ct.Nullable(); ok {
+				nullable[i] = isNullable
+			} else if ct.DatabaseTypeName() != "" {
+				nullable[i] = !notNull[ct.Name()]
+			}
+		}
+		for n := 0; n < generateSampleRows && rows.Next(); n++ {
+			if err := rows.Scan(values...); err != nil {
+				return err
+			}
+			for i, v := range values {
+				if v := *(v.(*interface{})); v == nil {
+					nullable[i] = true
+				} else if t := reflect.TypeOf(v); observed[i] == nil {
+					observed[i] = t
+				} else if observed[i] != t {
+					observed[i] = interfaceType
+				}
+			}
+		}
+		for i := range types {
+			if types[i] == nil {
+				types[i] = observed[i]
+			}
+			if types[i] == nil {
+				types[i] = interfaceType
+			} else if nullable[i] && types[i].Kind() != reflect.Interface && types[i].Kind() != reflect.Slice {
+				types[i] = reflect.PtrTo(types[i])
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", query, err)
+	}
+	w, fieldNames, fieldName := &bytes.Buffer{}, map[string]bool{}, goFieldName
+	if db, _ := hookedDB(c); db != nil && db.FieldMapper != nil {
+		fieldName = db.FieldMapper
+	}
+	fmt.Fprintf(w, "type %s struct {\n", name)
+	for i, column := range columns {
+		field := fieldName(column)
+		for n := 2; fieldNames[field]; n++ {
+			field = fmt.Sprintf("%s%d", fieldName(column), n)
+		}
+		fieldNames[field] = true
+		fmt.Fprintf(w, "%s %s `db:%q`\n", field, strings.ReplaceAll(types[i].String(), "uint8", "byte"), column)
+	}
+	fmt.Fprintf(w, "}\n")
+	bs, err := format.Source(w.Bytes())
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
+// notNullColumns returns the names of columns that are NOT NULL (or rowid aliases) in all tables that have them.
+// mattn/go-sqlite3 does not report the nullability or table of result columns - so this is a guess by name.
+// Columns of outer joins can be NULL regardless
+func notNullColumns(c Connection) (map[string]bool, error) {
+	columns := []string{}
+	query := `SELECT p.name FROM sqlite_master m, pragma_table_info(m.name) p WHERE m.type = 'table' GROUP BY p.name
+	          HAVING min(p."notnull" OR (p.pk = 1 AND upper(p.type) = 'INTEGER' AND (SELECT count(*) FROM pragma_table_info(m.name) WHERE pk > 0) = 1))`
+	if err := Query(c, query, &columns); err != nil {
+		return nil, err
+	}
+	m := map[string]bool{}
+	for _, column := range columns {
+		m[column] = true
+	}
+	return m, nil
+}
+
+// declaredGoType follows the sqlite type affinity rules - returns nil for NUMERIC affinity and missing declared types
+func declaredGoType(declared string) reflect.Type {
+	switch declared = strings.ToUpper(declared); {
+	case declared == "BOOLEAN" || declared == "BOOL":
+		return reflect.TypeOf(false)
+	case declared == "DATE" || declared == "DATETIME" || declared == "TIMESTAMP":
+		return reflect.TypeOf(time.Time{})
+	case strings.Contains(declared, "INT"):
+		return reflect.TypeOf(int64(0))
+	case strings.Contains(declared, "CHAR") || strings.Contains(declared, "CLOB") || strings.Contains(declared, "TEXT"):
+		return reflect.TypeOf("")
+	case strings.Contains(declared, "BLOB"):
+		return reflect.TypeOf([]byte{})
+	case strings.Contains(declared, "REAL") || strings.Contains(declared, "FLOA") || strings.Contains(declared, "DOUB"):
+		return reflect.TypeOf(float64(0))
+	}
+	return nil
+}
+
+func goFieldName(column string) string {
+	parts := strings.FieldsFunc(column, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	for i, part := range parts {
+		if upper := strings.ToUpper(part); initialisms[upper] {
+			parts[i] = upper
+		} else {
+			rs := []rune(part)
+			parts[i] = string(unicode.ToUpper(rs[0])) + string(rs[1:])
+		}
+	}
+	name := strings.Join(parts, "")
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "X" + name
+	}
+	return name
+}
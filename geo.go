@@ -0,0 +1,212 @@
+package gosql
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+type geoJSON struct {
+	Type        string
+	Coordinates json.RawMessage
+	Geometry    *geoJSON
+	Geometries  []geoJSON
+	Features    []geoJSON
+}
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+func CreateGeoIndex(c Connection, table, latColumn, lngColumn string) error {
+	quotedTable, err := quoteIdentifier(table)
+	if err != nil {
+		return err
+	}
+	lat, err := quoteIdentifier(latColumn)
+	if err != nil {
+		return err
+	}
+	lng, err := quoteIdentifier(lngColumn)
+	if err != nil {
+		return err
+	}
+	geo, _ := quoteIdentifier(table + "_geo")
+	trigger := func(suffix string) string { s, _ := quoteIdentifier(table + "_geo_" + suffix); return s }
+	insert := fmt.Sprintf("INSERT INTO %s SELECT new.rowid, new.%s, new.%s, new.%s, new.%s WHERE new.%s IS NOT NULL AND new.%s IS NOT NULL;",
+		geo, lat, lat, lng, lng, lat, lng)
+	delete := fmt.Sprintf("DELETE FROM %s WHERE id = old.rowid;", geo)
+	return inTransaction(c, func(c Connection) error {
+		for _, query := range []string{
+			fmt.Sprintf("CREATE VIRTUAL TABLE %s USING rtree(id, min_lat, max_lat, min_lng, max_lng)", geo),
+			fmt.Sprintf("CREATE TRIGGER %s AFTER INSERT ON %s BEGIN %s END", trigger("ai"), quotedTable, insert),
+			fmt.Sprintf("CREATE TRIGGER %s AFTER DELETE ON %s BEGIN %s END", trigger("ad"), quotedTable, delete),
+			fmt.Sprintf("CREATE TRIGGER %s AFTER UPDATE OF %s, %s ON %s BEGIN %s %s END", trigger("au"), lat, lng, quotedTable, delete, insert),
+			fmt.Sprintf("INSERT INTO %s SELECT rowid, %s, %s, %s, %s FROM %s WHERE %s IS NOT NULL AND %s IS NOT NULL",
+				geo, lat, lat, lng, lng, quotedTable, lat, lng),
+		} {
+			if _, err := c.Exec(query); err != nil {
+				return fmt.Errorf("%s: %w", query, err)
+			}
+		}
+		return nil
+	})
+}
+
+// results receive all columns of table plus distance (km), nearest first.
+// the rtree stores 32bit floats - distances are accurate to a few meters.
+// rtree prepares writes to its shadow tables on connect, so the readonly authorizer denies it - use the rw connection.
+func WithinRadius(c Connection, table string, lat, lng, km float64, results interface{}) error {
+	quotedTable, err := quoteIdentifier(table)
+	if err != nil {
+		return err
+	}
+	geo, _ := quoteIdentifier(table + "_geo")
+	minLat, maxLat, minLng, maxLng := boundingBox(lat, lng, km)
+	query := fmt.Sprintf(`SELECT %s.*, geo_haversine(?, ?, g.min_lat, g.min_lng) AS distance
+FROM %s g JOIN %s ON %s.rowid = g.id
+WHERE g.max_lat >= ? AND g.min_lat <= ? AND g.max_lng >= ? AND g.min_lng <= ? AND geo_haversine(?, ?, g.min_lat, g.min_lng) <= ?
+ORDER BY distance`, quotedTable, geo, quotedTable, quotedTable)
+	return Query(c, query, results, lat, lng, minLat, maxLat, minLng, maxLng, lat, lng, km)
+}
+
+func boundingBox(lat, lng, km float64) (minLat, maxLat, minLng, maxLng float64) {
+	d := km / earthRadiusKM * 180 / math.Pi
+	minLat, maxLat, minLng, maxLng = lat-d, lat+d, -180, 180
+	if minLat <= -90 || maxLat >= 90 {
+		return math.Max(minLat, -90), math.Min(maxLat, 90), minLng, maxLng
+	}
+	dLng := math.Asin(math.Sin(km/earthRadiusKM)/math.Cos(lat*math.Pi/180)) * 180 / math.Pi
+	if lng-dLng >= -180 && lng+dLng <= 180 {
+		minLng, maxLng = lng-dLng, lng+dLng
+	}
+	return minLat, maxLat, minLng, maxLng
+}
+
+func geoBBox(lat, lng, km float64, corner string) (float64, error) {
+	minLat, maxLat, minLng, maxLng := boundingBox(lat, lng, km)
+	switch corner {
+	case "min_lat":
+		return minLat, nil
+	case "max_lat":
+		return maxLat, nil
+	case "min_lng":
+		return minLng, nil
+	case "max_lng":
+		return maxLng, nil
+	}
+	return 0, fmt.Errorf("geo_bbox: unknown corner %q (min_lat, max_lat, min_lng, max_lng)", corner)
+}
+
+func geohash(lat, lng float64, precision int) (string, error) {
+	if precision < 1 || precision > 22 {
+		return "", fmt.Errorf("geohash: precision must be between 1 and 22")
+	}
+	latRange, lngRange, b := [2]float64{-90, 90}, [2]float64{-180, 180}, &strings.Builder{}
+	for i, bits, ch := 0, 0, 0; b.Len() < precision; i++ {
+		r, v := &lngRange, lng
+		if i%2 == 1 {
+			r, v = &latRange, lat
+		}
+		ch <<= 1
+		if mid := (r[0] + r[1]) / 2; v >= mid {
+			ch, r[0] = ch|1, mid
+		} else {
+			r[1] = mid
+		}
+		if bits++; bits == 5 {
+			b.WriteByte(geohashAlphabet[ch])
+			bits, ch = 0, 0
+		}
+	}
+	return b.String(), nil
+}
+
+func geohashDecode(hash, part string) (float64, error) {
+	latRange, lngRange, even := [2]float64{-90, 90}, [2]float64{-180, 180}, true
+	for _, c := range strings.ToLower(hash) {
+		ch := strings.IndexRune(geohashAlphabet, c)
+		if ch == -1 {
+			return 0, fmt.Errorf("geohash_decode: invalid geohash %q", hash)
+		}
+		for bit := 4; bit >= 0; bit-- {
+			r := &latRange
+			if even {
+				r = &lngRange
+			}
+			if mid := (r[0] + r[1]) / 2; ch&(1<<bit) != 0 {
+				r[0] = mid
+			} else {
+				r[1] = mid
+			}
+			even = !even
+		}
+	}
+	switch part {
+	case "lat":
+		return (latRange[0] + latRange[1]) / 2, nil
+	case "lng":
+		return (lngRange[0] + lngRange[1]) / 2, nil
+	}
+	return 0, fmt.Errorf("geohash_decode: unknown part %q (lat, lng)", part)
+}
+
+// polygons are GeoJSON Polygon / MultiPolygon geometries (or Features / FeatureCollections / GeometryCollections of them)
+func geoContains(polygon string, lat, lng float64) (bool, error) {
+	g := geoJSON{}
+	if err := json.Unmarshal([]byte(polygon), &g); err != nil {
+		return false, fmt.Errorf("geo_contains: %w", err)
+	}
+	return g.contains(lat, lng)
+}
+
+func (g geoJSON) contains(lat, lng float64) (bool, error) {
+	switch g.Type {
+	case "Feature":
+		if g.Geometry == nil {
+			return false, nil
+		}
+		return g.Geometry.contains(lat, lng)
+	case "FeatureCollection", "GeometryCollection":
+		for _, child := range append(g.Features, g.Geometries...) {
+			if ok, err := child.contains(lat, lng); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	case "Polygon":
+		rings := [][][2]float64{}
+		if err := json.Unmarshal(g.Coordinates, &rings); err != nil {
+			return false, fmt.Errorf("geo_contains: %w", err)
+		}
+		return polygonContains(rings, lat, lng), nil
+	case "MultiPolygon":
+		polygons := [][][][2]float64{}
+		if err := json.Unmarshal(g.Coordinates, &polygons); err != nil {
+			return false, fmt.Errorf("geo_contains: %w", err)
+		}
+		for _, rings := range polygons {
+			if polygonContains(rings, lat, lng) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("geo_contains: unhandled geometry type %q", g.Type)
+}
+
+// the first ring is the outline, all others are holes. positions are [lng, lat]
+func polygonContains(rings [][][2]float64, lat, lng float64) bool {
+	for i, ring := range rings {
+		inside := false
+		for j, k := 0, len(ring)-1; j < len(ring); k, j = j, j+1 {
+			a, b := ring[j], ring[k]
+			if (a[1] > lat) != (b[1] > lat) && lng < (b[0]-a[0])*(lat-a[1])/(b[1]-a[1])+a[0] {
+				inside = !inside
+			}
+		}
+		if inside != (i == 0) {
+			return false
+		}
+	}
+	return len(rings) != 0
+}
@@ -1,9 +1,35 @@
 package gosql
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/peterh/liner"
 )
 
 func TestUnmarshal(t *testing.T) {
@@ -29,3 +55,3875 @@ func TestUnmarshal(t *testing.T) {
 		t.Errorf("%#v not %#v", m, expected)
 	}
 }
+
+func TestInsertOmitEmpty(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE events (name TEXT, count INTEGER DEFAULT 42)")
+	type event struct {
+		Name  string `db:"name"`
+		Count int    `db:"count,omitempty"`
+	}
+	if _, err := Insert(db, "events", event{Name: "foo"}, ""); err != nil {
+		t.Error(err)
+		return
+	}
+	results := []event{}
+	if err := Query(db, "SELECT * FROM events", &results); err != nil {
+		t.Error(err)
+		return
+	}
+	expected := []event{{"foo", 42}}
+	if !reflect.DeepEqual(expected, results) {
+		t.Errorf("%#v not %#v", results, expected)
+	}
+}
+
+type validatedUser struct {
+	ID    int     `db:"id,pk"`
+	Email string  `db:"email,required,maxlen=10"`
+	Name  *string `db:"name,notnull"`
+}
+
+func (u *validatedUser) Validate() error {
+	if u.Email != "" && !strings.Contains(u.Email, "@") {
+		return ValidationError{{"Email", "email", "must contain @"}}
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, name TEXT)")
+	name := "foo"
+	_, err := Insert(db, "users", validatedUser{ID: 1}, "")
+	expected := ValidationError{{"Email", "email", "is required"}, {"Name", "name", "must not be null"}}
+	if fieldErrs := (ValidationError{}); !errors.As(err, &fieldErrs) || !reflect.DeepEqual(fieldErrs, expected) {
+		t.Errorf("%#v not %#v", err, expected)
+	}
+	_, err = Update(db, "users", &validatedUser{ID: 1, Email: "foobarbazqux", Name: &name})
+	expected = ValidationError{{"Email", "email", "must be at most 10 characters"}, {"Email", "email", "must contain @"}}
+	if fieldErrs := (ValidationError{}); !errors.As(err, &fieldErrs) || !reflect.DeepEqual(fieldErrs, expected) {
+		t.Errorf("%#v not %#v", err, expected)
+	}
+	if _, err := Upsert(db, "users", validatedUser{ID: 1, Email: "a@b", Name: &name}); err != nil {
+		t.Error(err)
+	}
+	users := []validatedUser{}
+	if err := Query(db, "SELECT * FROM users", &users); err != nil || len(users) != 1 {
+		t.Errorf("%#v %v", users, err)
+	}
+}
+
+type hookedNote struct {
+	ID   int64  `db:"id,pk,omitempty"`
+	Text string `db:"text"`
+}
+
+func (n *hookedNote) BeforeInsert() error {
+	n.Text = strings.TrimSpace(n.Text)
+	return nil
+}
+
+func (n *hookedNote) AfterInsert(r sql.Result) (err error) {
+	n.ID, err = r.LastInsertId()
+	return err
+}
+
+func (n *hookedNote) AfterScan() error {
+	n.Text = strings.ToUpper(n.Text)
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE notes (id INTEGER PRIMARY KEY, text TEXT)")
+	n := &hookedNote{Text: "  foo "}
+	if _, err := Insert(db, "notes", n, ""); err != nil || !reflect.DeepEqual(n, &hookedNote{1, "foo"}) {
+		t.Errorf("%#v %v", n, err)
+	}
+	if _, err := Insert(db, "notes", hookedNote{Text: " bar"}, ""); err != nil {
+		t.Error(err)
+	}
+	notes, texts := []*hookedNote{}, []string{}
+	if err := Query(db, "SELECT * FROM notes ORDER BY id", &notes); err != nil {
+		t.Error(err)
+	}
+	for _, n := range notes {
+		texts = append(texts, n.Text)
+	}
+	if expected := []string{"FOO", "BAR"}; !reflect.DeepEqual(texts, expected) {
+		t.Errorf("%#v not %#v", texts, expected)
+	}
+}
+
+func openTestDB(t *testing.T, migrations ...string) *DB {
+	m := map[string]string{}
+	for i, migration := range migrations {
+		m[fmt.Sprintf("%03d.sql", i)] = migration
+	}
+	db := &DB{DataSourceName: filepath.Join(t.TempDir(), "test.sqlite")}
+	if err := db.Open(m); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close(); db.RODB.Close() })
+	return db
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	for input, expected := range map[string]string{
+		"name":       `"name"`,
+		"first name": `"first name"`,
+		`a"; DROP`:   `"a""; DROP"`,
+		"":           "",
+		"a\x00b":     "",
+	} {
+		if quoted, err := quoteIdentifier(input); quoted != expected || (err != nil) != (expected == "") {
+			t.Errorf("%q: %q (%v) not %q", input, quoted, err, expected)
+		}
+	}
+}
+
+func TestLimiterReject(t *testing.T) {
+	l := &Limiter{Max: 1, Reject: true}
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := l.Acquire(context.Background()); err != ErrLimitExceeded {
+		t.Errorf("%v not %v", err, ErrLimitExceeded)
+	}
+	release()
+	if stats := l.Stats(); stats.Acquired != 1 || stats.Rejected != 1 || stats.InUse != 0 {
+		t.Errorf("unexpected stats: %#v", stats)
+	}
+	l = &Limiter{Max: 2}
+	release, _ = l.Acquire(context.Background())
+	l.Acquire(context.Background())
+	release()
+	release()
+	if stats := l.Stats(); stats.InUse != 1 {
+		t.Errorf("expected a repeated release to free only one slot: %#v", stats)
+	}
+	l, wg := &Limiter{Max: 2}, sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Stats()
+			if release, err := l.Acquire(context.Background()); err == nil {
+				time.Sleep(time.Millisecond)
+				release()
+			}
+		}()
+	}
+	wg.Wait()
+	if stats := l.Stats(); stats.Acquired != 10 || stats.InUse != 0 || stats.Waiting != 0 || stats.MaxWaitDuration == 0 {
+		t.Errorf("unexpected stats: %#v", stats)
+	}
+}
+
+func TestJSONArgAndScan(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE docs (body TEXT)")
+	if _, err := Exec(db, "INSERT INTO docs (body) VALUES (?)", JSON{map[string]interface{}{"a": 1}}); err != nil {
+		t.Error(err)
+		return
+	}
+	j := JSON{}
+	if err := db.QueryRow("SELECT body FROM docs").Scan(&j); err != nil {
+		t.Error(err)
+		return
+	}
+	expected := map[string]interface{}{"a": 1.0}
+	if !reflect.DeepEqual(expected, j.Value) {
+		t.Errorf("%#v not %#v", j.Value, expected)
+	}
+	if _, err := db.DB.Exec("INSERT INTO docs (body) VALUES (?), (?)", JSON{[]int{1}}, &JSON{"x"}); err != nil {
+		t.Fatal(err)
+	}
+	bodies := []string{}
+	if err := Query(db, "SELECT body FROM docs WHERE rowid > 1 ORDER BY rowid", &bodies); err != nil || !reflect.DeepEqual(bodies, []string{"[1]", `"x"`}) {
+		t.Errorf("%#v %v", bodies, err)
+	}
+}
+
+func TestQueryMap(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE users (id INTEGER, name TEXT); INSERT INTO users VALUES (1, 'a'), (2, 'b')")
+	m := map[int64]string{}
+	if err := QueryMap(db, "SELECT id, name FROM users", &m); err != nil {
+		t.Error(err)
+		return
+	}
+	expected := map[int64]string{1: "a", 2: "b"}
+	if !reflect.DeepEqual(expected, m) {
+		t.Errorf("%#v not %#v", m, expected)
+	}
+	if err := QueryMap(db, "SELECT id FROM users", &m); err == nil {
+		t.Error("expected error for single column query")
+	}
+}
+
+func TestCreateTableQuery(t *testing.T) {
+	type event struct {
+		ID        int64     `db:"id,pk"`
+		Name      string    `db:"name,notnull,unique"`
+		Score     *float64  `db:"score"`
+		CreatedAt time.Time `db:"created_at" default:"CURRENT_TIMESTAMP"`
+		Ignored   string    `db:"-"`
+	}
+	query, err := createTableQuery("events", event{}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := `CREATE TABLE IF NOT EXISTS "events" ("id" INTEGER PRIMARY KEY, "name" TEXT NOT NULL UNIQUE, ` +
+		`"score" REAL, "created_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`
+	if query != expected {
+		t.Errorf("%s not %s", query, expected)
+	}
+}
+
+func TestAutoMigrate(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE events (name TEXT)")
+	type event struct {
+		Name  string `db:"name,index"`
+		Count int    `db:"count"`
+	}
+	queries, err := db.AutoMigrate("events", event{}, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := []string{
+		`ALTER TABLE "events" ADD COLUMN "count" INTEGER`,
+		`CREATE INDEX IF NOT EXISTS "events_name_idx" ON "events" ("name")`,
+	}
+	if !reflect.DeepEqual(expected, queries) {
+		t.Errorf("%#v not %#v", queries, expected)
+	}
+	if queries, err := db.AutoMigrate("events", event{}, true); err != nil || len(queries) != 0 {
+		t.Errorf("expected no further queries: %#v %v", queries, err)
+	}
+
+	type uniqueEvent struct {
+		Code string `db:"code,unique"`
+	}
+	queries, err = db.AutoMigrate("events", uniqueEvent{}, false)
+	expected = []string{
+		`ALTER TABLE "events" ADD COLUMN "code" TEXT`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS "events_code_key" ON "events" ("code")`,
+	}
+	if err != nil || !reflect.DeepEqual(expected, queries) {
+		t.Errorf("%#v not %#v: %v", queries, expected, err)
+	}
+	type notNullEvent struct {
+		Level int `db:"level,notnull"`
+	}
+	type timestampEvent struct {
+		At time.Time `db:"at" default:"CURRENT_TIMESTAMP"`
+	}
+	for _, v := range []interface{}{notNullEvent{}, timestampEvent{}} {
+		if _, err := db.AutoMigrate("events", v, true); err == nil || !strings.Contains(err.Error(), "migrate it by hand") {
+			t.Errorf("%T: expected error: %v", v, err)
+		}
+	}
+}
+
+func TestQueryGrouped(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE comments (post_id INTEGER, body TEXT); INSERT INTO comments VALUES (1, 'a'), (2, 'b'), (1, 'c')")
+	type comment struct {
+		PostID int    `db:"post_id"`
+		Body   string `db:"body"`
+	}
+	m := map[int][]comment{}
+	if err := QueryGrouped(db, "SELECT * FROM comments ORDER BY body", "post_id", &m); err != nil {
+		t.Error(err)
+		return
+	}
+	expected := map[int][]comment{1: {{1, "a"}, {1, "c"}}, 2: {{2, "b"}}}
+	if !reflect.DeepEqual(expected, m) {
+		t.Errorf("%#v not %#v", m, expected)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	db := openTestDB(t,
+		"CREATE TABLE a (x TEXT)\n-- +down\nDROP TABLE a",
+		"CREATE TABLE b (x TEXT)\n-- +down\nDROP TABLE b")
+	tables := func() (names []string) {
+		if err := Query(db, "SELECT name FROM sqlite_master WHERE name IN ('a', 'b') ORDER BY name", &names); err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+	if err := db.Rollback(1); err != nil {
+		t.Error(err)
+		return
+	} else if names := tables(); !reflect.DeepEqual(names, []string{"a"}) {
+		t.Errorf("%#v not %#v", names, []string{"a"})
+	}
+	if err := db.MigrateTo(""); err != nil {
+		t.Error(err)
+		return
+	} else if names := tables(); len(names) != 0 {
+		t.Errorf("%#v not empty", names)
+	}
+	if err := db.MigrateTo("001.sql"); err != nil {
+		t.Error(err)
+	} else if names := tables(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("%#v not %#v", names, []string{"a", "b"})
+	}
+}
+
+func TestPreload(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE comments (post_id INTEGER, body TEXT); INSERT INTO comments VALUES (1, 'a'), (2, 'b'), (1, 'c')")
+	type comment struct {
+		PostID int    `db:"post_id"`
+		Body   string `db:"body"`
+	}
+	type post struct {
+		ID       int       `db:"id"`
+		Comments []comment `db:"-" preload:"post_id=id"`
+	}
+	posts := []*post{{ID: 1}, {ID: 2}, {ID: 3}}
+	if err := Preload(db, posts, "Comments", "SELECT * FROM comments WHERE post_id IN (?) ORDER BY body"); err != nil {
+		t.Error(err)
+		return
+	}
+	expected := []*post{{1, []comment{{1, "a"}, {1, "c"}}}, {2, []comment{{2, "b"}}}, {3, nil}}
+	if !reflect.DeepEqual(expected, posts) {
+		t.Errorf("%#v not %#v", posts, expected)
+	}
+	posts = []*post{{ID: 1}, {ID: 2}}
+	if err := Preload(db, posts, "Comments", "SELECT * FROM comments WHERE body != ? AND post_id IN (?) AND body != '?' ORDER BY body LIMIT ?", "a", 10); err != nil {
+		t.Error(err)
+		return
+	}
+	expected = []*post{{1, []comment{{1, "c"}}}, {2, []comment{{2, "b"}}}}
+	if !reflect.DeepEqual(expected, posts) {
+		t.Errorf("%#v not %#v", posts, expected)
+	}
+	if err := Preload(db, posts, "Comments", "SELECT * FROM comments WHERE post_id = ?", 1); err == nil {
+		t.Error("expected query without IN (?) to fail")
+	}
+}
+
+func TestTransact(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)")
+	err := db.Transact(context.Background(), TxOptions{Immediate: true}, func(c Connection) error {
+		if _, err := Exec(c, "INSERT INTO xs VALUES (1)"); err != nil {
+			return err
+		}
+		return errors.New("abort")
+	})
+	if err == nil || err.Error() != "abort" {
+		t.Errorf("expected abort error: %v", err)
+	}
+	err = db.Transact(context.Background(), TxOptions{}, func(c Connection) error {
+		_, err := Exec(c, "INSERT INTO xs VALUES (2)")
+		return err
+	})
+	xs := []int{}
+	if err != nil {
+		t.Error(err)
+	} else if err := Query(db, "SELECT x FROM xs", &xs); err != nil || !reflect.DeepEqual(xs, []int{2}) {
+		t.Errorf("%#v not %#v (%v)", xs, []int{2}, err)
+	}
+}
+
+func TestTransactHooks(t *testing.T) {
+	queries := []string{}
+	db := &DB{DataSourceName: filepath.Join(t.TempDir(), "test.sqlite"), History: HistoryWrites, RWLimit: &Limiter{Max: 1, Reject: true},
+		QueryHook: func(query string, args []interface{}, d time.Duration, rows int, err error) {
+			queries = append(queries, query)
+		}}
+	if err := db.Open(map[string]string{"001.sql": "CREATE TABLE xs (x INTEGER)"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close(); db.RODB.Close() })
+	queries = queries[:0]
+	for _, abort := range []bool{true, false} {
+		db.Transact(context.Background(), TxOptions{}, func(c Connection) error {
+			if _, err := Exec(c, "INSERT INTO xs VALUES (?)", abort); err != nil {
+				return err
+			} else if err := Query(c, "SELECT x FROM xs", &[]int{}); err != nil {
+				return err
+			} else if abort {
+				return errors.New("abort")
+			}
+			return nil
+		})
+	}
+	if expected := []string{"INSERT INTO xs VALUES (?)", "SELECT x FROM xs", "INSERT INTO xs VALUES (?)", "SELECT x FROM xs"}; !reflect.DeepEqual(queries, expected) {
+		t.Errorf("%#v not %#v", queries, expected)
+	}
+	history := []string{}
+	if err := Query(db, "SELECT args FROM _history WHERE query LIKE 'INSERT%'", &history); err != nil || !reflect.DeepEqual(history, []string{"[false]"}) {
+		t.Errorf("expected history of the rolled back transaction to be rolled back too: %#v %v", history, err)
+	}
+	err := db.Batch(func(b *Batch) error {
+		if _, err := db.Exec("INSERT INTO xs VALUES (1)"); err != ErrLimitExceeded {
+			t.Errorf("expected batch to hold the limiter: %v", err)
+		}
+		_, err := b.Exec("INSERT INTO xs VALUES (?)", 2)
+		return err
+	})
+	if history = []string{}; err != nil {
+		t.Fatal(err)
+	} else if err := Query(db, "SELECT args FROM _history WHERE query LIKE 'INSERT%' ORDER BY rowid", &history); err != nil || !reflect.DeepEqual(history, []string{"[false]", "[2]"}) {
+		t.Errorf("expected batch statements in history: %#v %v", history, err)
+	}
+}
+
+func TestMigrationTransaction(t *testing.T) {
+	db := openTestDB(t)
+	err := db.migrate(map[string]string{"001.sql": "CREATE TABLE a (x TEXT); INSERT INTO missing VALUES (1)"})
+	if err == nil {
+		t.Error("expected migration to fail")
+	}
+	tables := []string{}
+	if err := Query(db, "SELECT name FROM sqlite_master WHERE name = 'a'", &tables); err != nil || len(tables) != 0 {
+		t.Errorf("expected partial migration to be rolled back: %#v %v", tables, err)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE a (x TEXT)")
+	status := func() (names []string) {
+		ms, err := db.Migrations()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range ms {
+			names = append(names, fmt.Sprintf("%s %v %v", m.Name, m.AppliedAt != nil, m.Unknown))
+		}
+		return names
+	}
+	if err := db.LoadMigrations(map[string]string{"001.sql": "CREATE TABLE b (x TEXT)\n-- +down\nDROP TABLE b", "002.sql": "CREATE TABLE c (x TEXT)"}); err != nil {
+		t.Fatal(err)
+	}
+	if names, expected := status(), []string{"000.sql true true", "001.sql false false", "002.sql false false"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("%#v not %#v", names, expected)
+	}
+	if err := db.MigrateTo("001.sql"); err != nil {
+		t.Fatal(err)
+	}
+	if names, expected := status(), []string{"000.sql true true", "001.sql true false", "002.sql false false"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("%#v not %#v", names, expected)
+	}
+	if err := db.Rollback(1); err != nil {
+		t.Fatal(err)
+	} else if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if names, expected := status(), []string{"000.sql true true", "001.sql true false", "002.sql true false"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("%#v not %#v", names, expected)
+	}
+}
+
+func TestMigrationsTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	old := &DB{DataSourceName: path}
+	if err := old.Open(nil); err != nil {
+		t.Fatal(err)
+	} else if _, err := old.Exec(`CREATE TABLE "schema migrations" (name STRING, timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP);
+	                               INSERT INTO "schema migrations" (name) VALUES ('000.sql')`); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+	old.RODB.Close()
+	db := &DB{DataSourceName: path, MigrationsTable: "schema migrations"}
+	migrations := map[string]string{"000.sql": "CREATE TABLE a (x TEXT)", "001.sql": "CREATE TABLE b (x TEXT)"}
+	if err := db.Open(migrations); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close(); db.RODB.Close() })
+	migrations["001.sql"] += ";"
+	if err := db.LoadMigrations(migrations); err != nil {
+		t.Fatal(err)
+	}
+	ms, err := db.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ms) != 2 || ms[0].Checksum != "" || ms[0].Modified || ms[1].Checksum == "" || !ms[1].Modified || ms[1].DurationMS <= 0 {
+		t.Errorf("%#v", ms)
+	}
+	if m, err := ReadManifest(db); err != nil || !reflect.DeepEqual(m.Migrations, []string{"000.sql", "001.sql"}) {
+		t.Errorf("%#v %v", m, err)
+	}
+}
+
+type testLineReader struct {
+	lines   []string
+	history []string
+	prompts []string
+}
+
+func (r *testLineReader) Prompt(prompt string) (string, error) {
+	r.prompts = append(r.prompts, prompt)
+	if len(r.lines) == 0 {
+		return "", io.EOF
+	}
+	line := r.lines[0]
+	r.lines = r.lines[1:]
+	if line == "^C" {
+		return "", liner.ErrPromptAborted
+	}
+	return line, nil
+}
+
+func (r *testLineReader) PromptWithSuggestion(prompt, text string, pos int) (string, error) {
+	return r.Prompt(prompt + text)
+}
+
+func (r *testLineReader) AppendHistory(item string) { r.history = append(r.history, item) }
+
+func TestREPL(t *testing.T) {
+	db, out := openTestDB(t, "CREATE TABLE xs (x INTEGER); INSERT INTO xs VALUES (1), (2)"), &bytes.Buffer{}
+	reader := &testLineReader{lines: []string{".double 21", "SELECT x", "FROM xs;"}}
+	commands := map[string]Command{".double": {"", func(r *REPL, args []string) error {
+		return Table(r.Out, r.DB, "SELECT ? * 2", args[0])
+	}}}
+	if err := (&REPL{DB: db, Reader: reader, Out: out, Commands: commands}).Run(); err != nil {
+		t.Error(err)
+		return
+	}
+	if expected := "? * 2\n-----\n   42\nx\n-\n1\n2\n"; out.String() != expected {
+		t.Errorf("%q not %q", out.String(), expected)
+	}
+	if expected := []string{".double 21", "SELECT x FROM xs;"}; !reflect.DeepEqual(reader.history, expected) {
+		t.Errorf("%#v not %#v", reader.history, expected)
+	}
+}
+
+func TestREPLContinuation(t *testing.T) {
+	db, out := openTestDB(t), &bytes.Buffer{}
+	reader := &testLineReader{lines: []string{"SELECT 1", "AS x", "^C", "SELECT 2 AS x;", "SELECT", "^C", "^C", "SELECT 3 AS x", ";"}}
+	if err := (&REPL{DB: db, Reader: reader, Out: out}).Run(); err != nil {
+		t.Error(err)
+		return
+	}
+	if expected := "x\n-\n2\nx\n-\n3\n"; out.String() != expected {
+		t.Errorf("%q not %q", out.String(), expected)
+	}
+	if expected := []string{"> ", "...> ", "...> ", "> SELECT 1 AS x", "> ", "...> ", "> SELECT", "> ", "...> ", "> "}; !reflect.DeepEqual(reader.prompts, expected) {
+		t.Errorf("%#v not %#v", reader.prompts, expected)
+	}
+	if expected := []string{"SELECT 2 AS x;", "SELECT 3 AS x ;"}; !reflect.DeepEqual(reader.history, expected) {
+		t.Errorf("%#v not %#v", reader.history, expected)
+	}
+}
+
+func TestREPLDotCommands(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)", "CREATE INDEX xs_x ON xs (x)", "CREATE VIEW ys AS SELECT * FROM xs")
+	for _, c := range []struct{ input, expected string }{
+		{".tables", "_migrations\nxs\nys\n"},
+		{".tables x%", "xs\n"},
+		{".schema xs", "CREATE TABLE xs (x INTEGER);\nCREATE INDEX xs_x ON xs (x);\n"},
+		{".indexes xs", "xs_x\n"},
+		{".databases", "main  " + db.DataSourceName + "\n"},
+	} {
+		out := &bytes.Buffer{}
+		if err := (&REPL{DB: db, Out: out}).Eval(c.input); err != nil {
+			t.Errorf("%s: %s", c.input, err)
+		} else if out.String() != c.expected {
+			t.Errorf("%s: %q not %q", c.input, out.String(), c.expected)
+		}
+	}
+}
+
+func TestREPLComplete(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE users (id INTEGER, username TEXT)")
+	r := &REPL{DB: db, Out: ioutil.Discard}
+	for _, c := range []struct {
+		line     string
+		pos      int
+		expected []string
+	}{
+		{"sel", 3, []string{"select"}},
+		{"SELECT * FROM us", 16, []string{"username", "users", "using"}},
+		{"SELECT users.u FROM users", 14, []string{"users.username"}},
+		{"SELECT geo_of", 13, []string{"geo_offset_lat", "geo_offset_lng"}},
+		{".tab", 4, []string{".tables"}},
+		{"SELECT posts.", 13, nil},
+	} {
+		if _, completions, _ := r.Complete(c.line, c.pos); !reflect.DeepEqual(completions, c.expected) {
+			t.Errorf("%q: %#v not %#v", c.line, completions, c.expected)
+		}
+	}
+	if head, _, tail := r.Complete("SELECT users.u FROM users", 14); head != "SELECT " || tail != " FROM users" {
+		t.Errorf("%q %q", head, tail)
+	}
+	if err := r.Eval("CREATE TABLE posts (title TEXT)"); err != nil {
+		t.Fatal(err)
+	} else if _, completions, _ := r.Complete("SELECT * FROM pos", 17); !reflect.DeepEqual(completions, []string{"posts"}) {
+		t.Errorf("expected completions to be refreshed after DDL: %#v", completions)
+	}
+}
+
+func TestFprint(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x TEXT)", "INSERT INTO xs VALUES ('<a>')")
+	for _, c := range []struct {
+		o        PrintOptions
+		expected string
+	}{
+		{PrintOptions{}, `{"x":"<a>"}` + "\n"},
+		{PrintOptions{Indent: "\t", EscapeHTML: true}, "{\n\t\"x\": \"\\u003ca\\u003e\"\n}\n"},
+		{PrintOptions{Output: OutputOptions{Mode: "csv"}}, "x\n<a>\n"},
+	} {
+		out := &bytes.Buffer{}
+		if err := Fprint(out, db, c.o, "SELECT x FROM xs WHERE x = ?", "<a>"); err != nil {
+			t.Error(err)
+		} else if out.String() != c.expected {
+			t.Errorf("%q not %q", out.String(), c.expected)
+		}
+	}
+	out, debug := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := Fprint(out, db, PrintOptions{Debug: debug}, "SELECT x FROM xs WHERE x = ?", "<a>"); err != nil {
+		t.Error(err)
+	}
+	e := map[string]interface{}{}
+	if err := json.Unmarshal(debug.Bytes(), &e); err != nil {
+		t.Errorf("%q: %s", debug.String(), err)
+	} else if e["rows"] != 1.0 || len(e["plan"].([]interface{})) != 1 || len(e["advice"].([]interface{})) != 1 || e["duration_ms"] == nil {
+		t.Errorf("unexpected debug output: %q", debug.String())
+	}
+}
+
+func TestExplain(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)", "INSERT INTO xs VALUES (1), (2), (3)")
+	e, err := db.Explain("SELECT x FROM xs WHERE x > ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if e.Rows != 2 || !reflect.DeepEqual(e.Plan, []string{"SCAN TABLE xs"}) || len(e.Advice) != 1 || e.Duration <= 0 {
+		t.Errorf("%#v", e)
+	}
+	if _, err := db.Explain("DELETE FROM xs"); err != nil {
+		t.Fatal(err)
+	}
+	xs := []int{}
+	if err := Query(db, "SELECT x FROM xs", &xs); err != nil || len(xs) != 3 {
+		t.Errorf("expected Explain to roll back writes: %#v %v", xs, err)
+	}
+}
+
+func TestOutputModes(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (name TEXT, n INTEGER)", "INSERT INTO xs VALUES ('a|b', 1), ('c', NULL)")
+	for mode, expected := range map[string]string{
+		"table":    "name     n\n----  ----\na|b      1\nc     NULL\n",
+		"json":     "[\n" + `{"n":1,"name":"a|b"}` + ",\n" + `{"n":null,"name":"c"}` + "\n]\n",
+		"ndjson":   `{"n":1,"name":"a|b"}` + "\n" + `{"n":null,"name":"c"}` + "\n",
+		"csv":      "name,n\na|b,1\nc,\n",
+		"markdown": "| name |    n |\n| ---- | ---: |\n| a\\|b |    1 |\n| c    | NULL |\n",
+		"line":     "name = a|b\n   n = 1\n\nname = c\n   n = NULL\n",
+	} {
+		out := &bytes.Buffer{}
+		if err := Output(out, db, OutputOptions{Mode: mode}, "SELECT name, n FROM xs"); err != nil {
+			t.Errorf("%s: %s", mode, err)
+		} else if out.String() != expected {
+			t.Errorf("%s: %q not %q", mode, out.String(), expected)
+		}
+	}
+	out := &bytes.Buffer{}
+	if err := Output(out, db, OutputOptions{Mode: "json"}, "SELECT name FROM xs WHERE 0"); err != nil || out.String() != "[]\n" {
+		t.Errorf("%q %v", out.String(), err)
+	}
+	out.Reset()
+	r := &REPL{DB: db, Out: out}
+	if err := r.Eval(".mode csv"); err != nil {
+		t.Error(err)
+	} else if err := r.Eval("SELECT n FROM xs WHERE n IS NOT NULL"); err != nil || out.String() != "n\n1\n" {
+		t.Errorf("%q %v", out.String(), err)
+	} else if err := r.Eval(".mode xml"); err == nil {
+		t.Error("expected unknown mode error")
+	}
+	out.Reset()
+	if err := r.Eval(".nullvalue ∅"); err != nil {
+		t.Error(err)
+	} else if err := r.Eval("SELECT n FROM xs WHERE n IS NULL"); err != nil || out.String() != "n\n∅\n" {
+		t.Errorf("%q %v", out.String(), err)
+	}
+}
+
+func TestREPLEvalContextCancel(t *testing.T) {
+	db := openTestDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start, query := time.Now(), "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT count(*) FROM c"
+	if err := (&REPL{DB: db, Out: ioutil.Discard}).EvalContext(ctx, query); err == nil {
+		t.Error("expected interrupted query to fail")
+	} else if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("query was not interrupted: %s", d)
+	}
+	if err := (&REPL{DB: db, Out: ioutil.Discard}).Eval("SELECT 1"); err != nil {
+		t.Errorf("connection unusable after interrupt: %s", err)
+	}
+}
+
+func TestREPLTimerOutputRead(t *testing.T) {
+	db, out, dir := openTestDB(t, "CREATE TABLE xs (x INTEGER)"), &bytes.Buffer{}, t.TempDir()
+	db.Logger = log.New(ioutil.Discard, "", 0)
+	script, results := filepath.Join(dir, "script.sql"), filepath.Join(dir, "out.txt")
+	if err := os.WriteFile(script, []byte("INSERT INTO xs VALUES (1);\n.mode csv\nSELECT x FROM xs;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &REPL{DB: db, Out: out}
+	for _, input := range []string{".timer on", ".output " + results, ".read " + script, ".output", "SELECT 2"} {
+		if err := r.Eval(input); err != nil {
+			t.Errorf("%s: %s", input, err)
+		}
+	}
+	if bs, err := os.ReadFile(results); err != nil || string(bs) != "x\n1\n" {
+		t.Errorf("%q %v", bs, err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 5 || lines[3] != "2" ||
+		!strings.HasPrefix(lines[0], "Run Time: ") || !strings.HasPrefix(lines[1], "Run Time: ") || !strings.HasPrefix(lines[4], "Run Time: ") {
+		t.Errorf("%q", out.String())
+	}
+	loop := filepath.Join(dir, "loop.sql")
+	if err := os.WriteFile(loop, []byte(".read "+loop+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	} else if err := r.Eval(".read " + loop); err == nil || len(r.reading) != 0 {
+		t.Errorf("expected recursive .read to fail: %v %v", err, r.reading)
+	}
+	r = &REPL{DB: db, Out: out}
+	if err := r.RunBatch(strings.NewReader(".output " + results + "\nSELECT 3;\n")); err != nil || r.output != nil || r.Out != out {
+		t.Errorf("expected .output file to be closed after the batch: %v", err)
+	}
+}
+
+func TestREPLLimitPager(t *testing.T) {
+	db, out := openTestDB(t), &bytes.Buffer{}
+	r := &REPL{DB: db, Out: out}
+	for _, input := range []string{".mode csv", ".limit 2", ".pager tr a-z A-Z", "SELECT 'a' AS x UNION ALL SELECT 'b' UNION ALL SELECT 'c'"} {
+		if err := r.Eval(input); err != nil {
+			t.Errorf("%s: %s", input, err)
+		}
+	}
+	if expected := "X\nA\nB\n(showing the first 2 rows - see .limit)\n"; out.String() != expected {
+		t.Errorf("%q not %q", out.String(), expected)
+	}
+}
+
+func TestREPLParams(t *testing.T) {
+	db, out := openTestDB(t, "CREATE TABLE xs (x TEXT, n INTEGER)"), &bytes.Buffer{}
+	r := &REPL{DB: db, Out: out}
+	for _, input := range []string{".mode csv", ".set x 'a'' OR 1=1'", ".set :n 42", "INSERT INTO xs VALUES (:x, :n)", "SELECT x, n + 1 AS n FROM xs WHERE x = :x AND n = :n", ".set"} {
+		if err := r.Eval(input); err != nil {
+			t.Errorf("%s: %s", input, err)
+		}
+	}
+	if expected := "x,n\na' OR 1=1,43\n:n = 42\n:x = 'a'' OR 1=1'\n"; out.String() != expected {
+		t.Errorf("%q not %q", out.String(), expected)
+	}
+	if err := r.Eval(".unset x"); err != nil {
+		t.Error(err)
+	}
+	if err := r.Eval("SELECT :x"); err == nil || err.Error() != "parameter :x is not set (see .set)" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for s, expected := range map[string]interface{}{"1": int64(1), "1.5": 1.5, "null": nil, "'1'": "1", "abc": "abc"} {
+		if v := parseParam(s); v != expected {
+			t.Errorf("%s: %#v not %#v", s, v, expected)
+		}
+	}
+}
+
+func TestREPLKey(t *testing.T) {
+	a, b := replKey("/tmp/a/data.sqlite"), replKey("file:/tmp/b/data.sqlite?mode=ro")
+	if !strings.HasPrefix(a, "data.sqlite-") || !strings.HasPrefix(b, "data.sqlite-") || a == b {
+		t.Errorf("expected distinct keys per path: %s %s", a, b)
+	}
+	if a != replKey("file:/tmp/a/data.sqlite?_busy_timeout=100") {
+		t.Errorf("expected dsn params to be ignored")
+	}
+	if key := replKey(":memory:"); !strings.HasPrefix(key, "_memory_-") {
+		t.Errorf("unexpected key %s", key)
+	}
+}
+
+func TestReadMigrationsFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_a.up.sql":   {Data: []byte("CREATE TABLE a (x TEXT);")},
+		"migrations/001_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		"migrations/002_b.sql":      {Data: []byte("CREATE TABLE b (x TEXT);")},
+	}
+	m, err := ReadMigrationsFS(fsys, "migrations/*.sql")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := map[string]string{
+		"migrations/001_a.up.sql": "CREATE TABLE a (x TEXT);\n-- +down\nDROP TABLE a;",
+		"migrations/002_b.sql":    "CREATE TABLE b (x TEXT);",
+	}
+	if !reflect.DeepEqual(expected, m) {
+		t.Errorf("%#v not %#v", m, expected)
+	}
+}
+
+func TestREPLRunBatch(t *testing.T) {
+	db, out := openTestDB(t, "CREATE TABLE xs (x INTEGER)"), &bytes.Buffer{}
+	db.Logger = log.New(ioutil.Discard, "", 0)
+	script := "INSERT INTO xs VALUES (1);\nINSERT INTO missing VALUES (1);\nSELECT 'a;b', x\n  FROM xs;\n"
+	if err := (&REPL{DB: db, Out: out}).RunBatch(strings.NewReader(script)); err == nil {
+		t.Error("expected error for failed statement")
+	}
+	if expected := "'a;b'  x\n-----  -\na;b    1\n"; out.String() != expected {
+		t.Errorf("%q not %q", out.String(), expected)
+	}
+	out.Reset()
+	if err := (&REPL{DB: db, Out: out, Bail: true}).RunBatch(strings.NewReader(script)); err == nil {
+		t.Error("expected error for failed statement")
+	}
+	if out.String() != "" {
+		t.Errorf("expected bail before select: %q", out.String())
+	}
+}
+
+func TestFormat(t *testing.T) {
+	input := "select a, count(*) from t where x between 1 and 2 and y in (select id from u where z = 'and; or') group by a"
+	expected := strings.Join([]string{
+		"SELECT a, count(*)",
+		"FROM t",
+		"WHERE x BETWEEN 1 AND 2",
+		"  AND y IN (",
+		"    SELECT id",
+		"    FROM u",
+		"    WHERE z = 'and; or'",
+		"  )",
+		"GROUP BY a",
+	}, "\n")
+	if formatted := Format(input); formatted != expected {
+		t.Errorf("\n%s\nnot\n%s", formatted, expected)
+	}
+}
+
+func TestExecScript(t *testing.T) {
+	db := openTestDB(t)
+	script := `CREATE TABLE xs (x TEXT); -- a comment; with a semicolon
+CREATE TABLE log (x TEXT);
+CREATE TRIGGER xs_insert AFTER INSERT ON xs BEGIN
+  INSERT INTO log VALUES (CASE WHEN new.x = ';' THEN 'semicolon' ELSE new.x END);
+END;
+INSERT INTO xs VALUES (';');
+
+INSERT INTO missing VALUES (1)`
+	err, scriptErr := ExecScript(db, script), &ScriptError{}
+	if !errors.As(err, &scriptErr) || scriptErr.Statement != 5 || scriptErr.Line != 8 {
+		t.Errorf("unexpected error: %v", err)
+	}
+	xs := []string{}
+	if err := Query(db, "SELECT x FROM log", &xs); err != nil || !reflect.DeepEqual(xs, []string{"semicolon"}) {
+		t.Errorf("%#v not %#v (%v)", xs, []string{"semicolon"}, err)
+	}
+}
+
+func TestLint(t *testing.T) {
+	sql := `SELECT * FROM a, b WHERE a.name LIKE '%foo';
+SELECT x FROM a JOIN b ON a.id = b.id WHERE x IN (SELECT y FROM c, d);
+CREATE INDEX a_r ON a (random());`
+	messages := []string{}
+	for _, w := range Lint(sql) {
+		messages = append(messages, w.String())
+	}
+	expected := []string{
+		"1: SELECT * - list the columns explicitly",
+		"1: implicit cross join - use an explicit JOIN ... ON",
+		"1: LIKE with a leading wildcard cannot use an index",
+		"2: implicit cross join - use an explicit JOIN ... ON",
+		"3: non-deterministic function random in index expression",
+	}
+	if !reflect.DeepEqual(expected, messages) {
+		t.Errorf("%#v not %#v", messages, expected)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	db := &DB{DataSourceName: filepath.Join(t.TempDir(), "test.sqlite"), History: HistoryWrites, HistorySource: "test"}
+	if err := db.Open(map[string]string{"001.sql": "CREATE TABLE xs (x INTEGER)"}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer db.RODB.Close()
+	if _, err := Exec(db, "INSERT INTO xs VALUES (?)", 1); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := Query(db, "SELECT * FROM xs", &[]int{}); err != nil {
+		t.Error(err)
+		return
+	}
+	history := []map[string]interface{}{}
+	if err := Query(db, "SELECT query, args, source FROM _history WHERE query LIKE 'INSERT INTO xs%'", &history); err != nil {
+		t.Error(err)
+		return
+	}
+	expected := []map[string]interface{}{{"query": "INSERT INTO xs VALUES (?)", "args": "[1]", "source": "test"}}
+	if !reflect.DeepEqual(expected, history) {
+		t.Errorf("%#v not %#v", history, expected)
+	}
+	for query, expected := range map[string]bool{
+		"SELECT 1":                             true,
+		"WITH a AS (SELECT 1) SELECT * FROM a": true,
+		"WITH a(x) AS (SELECT 1) INSERT INTO xs SELECT x FROM a": false,
+		"WITH a AS (SELECT 1) DELETE FROM xs":                    false,
+		"PRAGMA user_version = 1":                                false,
+	} {
+		if actual := isReadQuery(query); actual != expected {
+			t.Errorf("%s: %v not %v", query, actual, expected)
+		}
+	}
+}
+
+func TestEach(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER); INSERT INTO xs VALUES (1), (2), (3)")
+	type row struct{ X int }
+	xs := []int{}
+	err := Each(db, "SELECT x AS X FROM xs ORDER BY x", func(r row) error {
+		if xs = append(xs, r.X); len(xs) == 2 {
+			return errors.New("stop")
+		}
+		return nil
+	})
+	if err == nil || !reflect.DeepEqual(xs, []int{1, 2}) {
+		t.Errorf("%#v not %#v (%v)", xs, []int{1, 2}, err)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER); INSERT INTO xs VALUES (1), (2), (3), (4), (5)")
+	for _, keys := range [][]string{nil, {"x"}} {
+		pages, page := [][]int{}, Page{Limit: 2, Keys: keys}
+		for {
+			xs := []int{}
+			next, err := Paginate(db, "SELECT x FROM xs ORDER BY x", page, &xs)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if pages = append(pages, xs); next == "" {
+				break
+			}
+			page.Cursor = next
+		}
+		if expected := [][]int{{1, 2}, {3, 4}, {5}}; !reflect.DeepEqual(expected, pages) {
+			t.Errorf("%v: %#v not %#v", keys, pages, expected)
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x TEXT, n INTEGER)")
+	if err := db.EnableAudit("xs"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Exec(db, "INSERT INTO xs VALUES ('a', 1)"); err != nil {
+		t.Fatal(err)
+	}
+	err := db.Transact(context.Background(), TxOptions{}, func(c Connection) error {
+		if err := SetAuditActor(c, "alice"); err != nil {
+			return err
+		} else if _, err := Exec(c, "UPDATE xs SET n = 2"); err != nil {
+			return err
+		}
+		_, err := Exec(c, "DELETE FROM xs")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetAuditActor(db, "mallory"); err == nil {
+		t.Error("expected setting the actor outside of a transaction to fail")
+	} else if _, err := Exec(db, "SELECT _audit_set_actor('mallory')"); err == nil {
+		t.Error("expected setting the actor in autocommit mode to fail")
+	}
+	entries, err := db.AuditHistory("xs", 1)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("%#v %v", entries, err)
+	}
+	actual := []string{}
+	for _, e := range entries {
+		bs, _ := json.Marshal([]interface{}{e.Op, e.Old, e.New, e.Actor, e.Timestamp.IsZero()})
+		actual = append(actual, string(bs))
+	}
+	expected := []string{
+		`["insert",null,{"n":1,"x":"a"},"",false]`,
+		`["update",{"n":1,"x":"a"},{"n":2,"x":"a"},"alice",false]`,
+		`["delete",{"n":2,"x":"a"},null,"alice",false]`,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%#v not %#v", actual, expected)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	db := &DB{DataSourceName: filepath.Join(t.TempDir(), "test.sqlite"), UndoTables: []string{"xs"}}
+	if err := db.Open(map[string]string{"001.sql": "CREATE TABLE xs (x TEXT)"}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer db.RODB.Close()
+	xs := func() []string {
+		xs := []string{}
+		if err := Query(db, "SELECT x FROM xs ORDER BY x", &xs); err != nil {
+			t.Fatal(err)
+		}
+		return xs
+	}
+	for _, query := range []string{"INSERT INTO xs VALUES ('a'), ('b')", "UPDATE xs SET x = 'c' WHERE x = 'a'", "DELETE FROM xs WHERE x = 'b'"} {
+		if _, err := Exec(db, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	steps := [][]string{{"b", "c"}, {"a", "b"}, {}, {"a", "b"}}
+	for i, expected := range steps {
+		undo := db.Undo
+		if i == len(steps)-1 {
+			undo = db.Redo
+		}
+		if ok, err := undo(); !ok || err != nil {
+			t.Errorf("%d: undo/redo failed: %v", i, err)
+		} else if actual := xs(); !reflect.DeepEqual(expected, actual) {
+			t.Errorf("%d: %#v not %#v", i, actual, expected)
+		}
+	}
+	if _, err := Exec(db, "INSERT INTO xs VALUES ('d')"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.Redo(); ok || err != nil {
+		t.Errorf("expected redo log to be cleared by new write: %v %v", ok, err)
+	}
+	path := db.path()
+	db.Close()
+	db.RODB.Close()
+	db = &DB{DataSourceName: path}
+	if err := db.Open(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer db.RODB.Close()
+	if _, err := Exec(db, "INSERT INTO xs VALUES ('e')"); err != nil {
+		t.Errorf("expected undo triggers to work without UndoTables: %v", err)
+	}
+	db = &DB{DataSourceName: filepath.Join(t.TempDir(), "test.sqlite"), UndoTables: []string{"ys"}}
+	if err := db.Open(map[string]string{"001.sql": "CREATE TABLE ys (y TEXT PRIMARY KEY) WITHOUT ROWID"}); err == nil || !strings.Contains(err.Error(), "WITHOUT ROWID") {
+		t.Errorf("expected WITHOUT ROWID table to be rejected: %v", err)
+	}
+	if db.DB != nil {
+		db.Close()
+		db.RODB.Close()
+	}
+}
+
+func TestIDs(t *testing.T) {
+	type tag struct {
+		Tenant int    `db:"tenant,pk,withoutrowid"`
+		Name   string `db:"name,pk"`
+	}
+	query, err := createTableQuery("tags", tag{}, nil)
+	if expected := `CREATE TABLE IF NOT EXISTS "tags" ("tenant" INTEGER, "name" TEXT, PRIMARY KEY ("tenant", "name")) WITHOUT ROWID`; err != nil || query != expected {
+		t.Errorf("%s not %s: %v", query, expected, err)
+		return
+	}
+	db := openTestDB(t, "CREATE TABLE xs (x TEXT)")
+	db.IDBlockSize = 2
+	ids := []int64{}
+	for i := 0; i < 3; i++ {
+		id, err := db.NextID("xs")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ids = append(ids, id)
+	}
+	db.idBlocks = nil
+	if id, err := db.NextID("xs"); err != nil {
+		t.Error(err)
+		return
+	} else if ids = append(ids, id); !reflect.DeepEqual(ids, []int64{1, 2, 3, 5}) {
+		t.Errorf("%#v not %#v", ids, []int64{1, 2, 3, 5})
+	}
+	result, err := Insert(db, "xs", map[string]interface{}{"x": "a"}, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var id uint8
+	if err := LastInsertID(result, &id); err != nil || id != 1 {
+		t.Errorf("%d not 1: %v", id, err)
+	}
+}
+
+func TestCachedQuery(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)")
+	db.Cache = &QueryCache{}
+	t.Cleanup(func() { db.Cache.Close() })
+	count := func() int {
+		counts := []int{}
+		if err := db.CachedQuery("SELECT count(*) FROM xs WHERE x > ?", &counts, 0); err != nil {
+			t.Fatal(err)
+		}
+		return counts[0]
+	}
+	if n := count(); n != 0 {
+		t.Errorf("%d not 0", n)
+	}
+	db.Cache.entries[fmt.Sprintf("%s\x00%#v\x00%s", "SELECT count(*) FROM xs WHERE x > ?", []interface{}{0}, reflect.TypeOf(&[]int{}))] = reflect.ValueOf([]int{42})
+	if n := count(); n != 42 {
+		t.Errorf("%d not 42 (cached)", n)
+	}
+	if _, err := db.Exec("INSERT INTO xs VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("%d not 1 (invalidated)", n)
+	}
+	rows := []map[string]interface{}{}
+	if err := db.CachedQuery("SELECT x FROM xs", &rows); err != nil {
+		t.Fatal(err)
+	}
+	rows[0]["x"] = "modified"
+	if rows = nil; db.CachedQuery("SELECT x FROM xs", &rows) != nil || rows[0]["x"] != 1.0 {
+		t.Errorf("expected cached results to be copied: %#v", rows)
+	}
+	_, version, err := db.Cache.get(db, "stale")
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec("INSERT INTO xs VALUES (2)"); err != nil {
+		t.Fatal(err)
+	} else if _, _, err := db.Cache.get(db, "stale"); err != nil {
+		t.Fatal(err)
+	}
+	db.Cache.put("stale", reflect.ValueOf([]int{1}), version)
+	if cached, _, _ := db.Cache.get(db, "stale"); cached.IsValid() {
+		t.Errorf("expected result of query started before the change to be dropped: %v", cached)
+	}
+}
+
+func TestCompositeKeys(t *testing.T) {
+	type member struct {
+		TenantID int    `db:"tenant_id,pk"`
+		ID       int    `db:"id,pk"`
+		Name     string `db:"name"`
+	}
+	db := openTestDB(t, "CREATE TABLE members (tenant_id INTEGER, id INTEGER, name TEXT, PRIMARY KEY (tenant_id, id))")
+	for _, m := range []member{{1, 1, "a"}, {2, 1, "b"}, {1, 1, "c"}} {
+		if _, err := Upsert(db, "members", m); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if _, err := Update(db, "members", member{2, 1, "d"}); err != nil {
+		t.Error(err)
+		return
+	}
+	m := member{TenantID: 2, ID: 1}
+	if err := Get(db, "members", &m); err != nil || m.Name != "d" {
+		t.Errorf("%#v: %v", m, err)
+		return
+	}
+	if _, err := Delete(db, "members", &m); err != nil {
+		t.Error(err)
+		return
+	}
+	members := []member{}
+	if err := Query(db, "SELECT * FROM members", &members); err != nil {
+		t.Error(err)
+	} else if expected := []member{{1, 1, "c"}}; !reflect.DeepEqual(members, expected) {
+		t.Errorf("%#v not %#v", members, expected)
+	}
+	if err := Get(db, "members", &m); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+type joinAggregator struct{ xs []string }
+
+func (a *joinAggregator) Step(x string) { a.xs = append(a.xs, x) }
+func (a *joinAggregator) Done() string  { return strings.Join(a.xs, "|") }
+
+func TestAggregateFunc(t *testing.T) {
+	db := &DB{
+		DataSourceName: filepath.Join(t.TempDir(), "test.sqlite"),
+		Funcs:          map[string]interface{}{"pipe_join": func() *joinAggregator { return &joinAggregator{} }},
+	}
+	if err := db.Open(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer db.RODB.Close()
+	for _, c := range []Connection{db, db.RODB} {
+		results := []string{}
+		if err := Query(c, "SELECT pipe_join(x) FROM (SELECT 'a' AS x UNION ALL SELECT 'b')", &results); err != nil {
+			t.Error(err)
+		} else if expected := []string{"a|b"}; !reflect.DeepEqual(results, expected) {
+			t.Errorf("%#v not %#v", results, expected)
+		}
+	}
+}
+
+func TestStatisticalAggregates(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x)", "INSERT INTO xs VALUES (1), (2.0), (3), (NULL), ('x'), (10)")
+	results := []map[string]float64{}
+	query := "SELECT median(x) AS median, percentile(x, 25) AS p25, round(variance(x), 4) AS variance, round(stddev(x), 4) AS stddev FROM xs"
+	if err := Query(db, query, &results); err != nil {
+		t.Error(err)
+		return
+	}
+	expected := []map[string]float64{{"median": 2.5, "p25": 1.75, "variance": 16.6667, "stddev": 4.0825}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v not %#v", results, expected)
+	}
+}
+
+func TestDumpRestore(t *testing.T) {
+	db := openTestDB(t, `CREATE TABLE "a b" (id INTEGER PRIMARY KEY AUTOINCREMENT, s TEXT, f REAL, bs BLOB)`,
+		`INSERT INTO "a b" (s, f, bs) VALUES ('it''s', 1.5, X'00ff'), (NULL, NULL, NULL)`,
+		"CREATE INDEX a_s ON \"a b\" (s)", "CREATE VIEW v AS SELECT s FROM \"a b\"",
+		"CREATE VIRTUAL TABLE r USING rtree(id, x0, x1)", "INSERT INTO r VALUES (1, 0.0, 2.0)",
+		"CREATE TRIGGER t AFTER INSERT ON \"a b\" BEGIN INSERT INTO r VALUES (new.id, 0.0, 1.0); END")
+	dump := &bytes.Buffer{}
+	if err := db.Dump(dump); err != nil {
+		t.Fatal(err)
+	}
+	restored := openTestDB(t)
+	if err := restored.RestoreDump(bytes.NewReader(dump.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []Connection{db, restored} {
+		if _, err := c.Exec(`INSERT INTO "a b" (s) VALUES ('x')`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, query := range []string{
+		`SELECT id || ':' || coalesce(s, '') || ':' || coalesce(f, '') || ':' || coalesce(hex(bs), '') FROM "a b" ORDER BY id`,
+		"SELECT id || ':' || x0 || ':' || x1 FROM r ORDER BY id",
+		"SELECT coalesce(s, '') FROM v ORDER BY s",
+		"SELECT type || ':' || name FROM sqlite_master ORDER BY name",
+	} {
+		expected, actual := []string{}, []string{}
+		if err := Query(db, query, &expected); err != nil {
+			t.Fatal(err)
+		} else if err := Query(restored, query, &actual); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: %#v not %#v", query, actual, expected)
+		}
+	}
+	db, dump = openTestDB(t, "CREATE TABLE xs (x)", "INSERT INTO xs VALUES (1)"), &bytes.Buffer{}
+	restored = openTestDB(t, "CREATE TABLE xs (x)")
+	if err := db.Dump(dump); err != nil {
+		t.Fatal(err)
+	} else if err := restored.RestoreDump(bytes.NewReader(dump.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	counts := []int{}
+	if err := Query(restored, "SELECT count(*) FROM _migrations UNION ALL SELECT count(*) FROM xs", &counts); err != nil {
+		t.Error(err)
+	} else if expected := []int{2, 1}; !reflect.DeepEqual(counts, expected) {
+		t.Errorf("%#v not %#v", counts, expected)
+	}
+}
+
+func TestSelectQuery(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE posts (id INTEGER, status TEXT)", "INSERT INTO posts VALUES (1, 'draft'), (2, 'public'), (3, 'public')")
+	for _, c := range []struct {
+		q     *SelectQuery
+		query string
+		ids   []int
+	}{
+		{Select("posts", "id"), "SELECT id FROM posts", []int{1, 2, 3}},
+		{Select("posts", "id").Where("status = ?", "public").Where("id > ? OR id < ?", 2, 0).OrderBy("id DESC").Limit(20),
+			"SELECT id FROM posts WHERE (status = ?) AND (id > ? OR id < ?) ORDER BY id DESC LIMIT 20", []int{3}},
+		{Select("posts", "id").OrderBy("id").Offset(1), "SELECT id FROM posts ORDER BY id LIMIT -1 OFFSET 1", []int{2, 3}},
+	} {
+		ids := []int{}
+		if query, _ := c.q.SQL(); query != c.query {
+			t.Errorf("%q not %q", query, c.query)
+		} else if err := c.q.Query(db, &ids); err != nil || !reflect.DeepEqual(ids, c.ids) {
+			t.Errorf("%s: %#v %v", query, ids, err)
+		}
+	}
+}
+
+func TestStructPlan(t *testing.T) {
+	type x struct {
+		A string
+		B int `db:"b"`
+	}
+	plan := structPlan(reflect.TypeOf(x{}), []string{"b", "c", "A"}, nil)
+	if expected := []int{1, -1, 0}; !reflect.DeepEqual(plan, expected) {
+		t.Errorf("%#v not %#v", plan, expected)
+	} else if cached := structPlan(reflect.TypeOf(x{}), []string{"b", "c", "A"}, nil); &cached[0] != &plan[0] {
+		t.Error("expected cached plan")
+	}
+	db, xs := openTestDB(t), []x{}
+	if err := Query(db, "SELECT 1 AS b, 'a' AS A, 2 AS c", &xs); err != nil || !reflect.DeepEqual(xs, []x{{"a", 1}}) {
+		t.Errorf("%#v %v", xs, err)
+	}
+}
+
+func TestJSONValue(t *testing.T) {
+	for _, v := range []interface{}{nil, true, int64(1 << 60), int64(-3), 1.5, "a\"b", "{\"a\": 1}", []byte{0, 255}, "\xff", math.Inf(1), time.Unix(0, 0).UTC()} {
+		expected := JSON{}
+		err := convert(&v, &expected)
+		if x, ok := jsonValue(v); ok && (err != nil || !reflect.DeepEqual(x, expected.Value)) {
+			t.Errorf("%#v: %#v not %#v (%v)", v, x, expected.Value, err)
+		}
+	}
+	db := openTestDB(t, "CREATE TABLE xs (i INTEGER, f REAL, s TEXT, b BLOB, n TEXT)", "INSERT INTO xs VALUES (1, 1.5, 'a', X'00', NULL)")
+	xs := []map[string]JSON{}
+	if err := Query(db, "SELECT * FROM xs", &xs); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []map[string]JSON{{"i": {1.0}, "f": {1.5}, "s": {"a"}, "b": {"AA=="}, "n": {nil}}}; !reflect.DeepEqual(xs, expected) {
+		t.Errorf("%#v not %#v", xs, expected)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)")
+	err := db.Batch(func(b *Batch) error {
+		for i := 0; i < 100; i++ {
+			if _, err := b.Exec("INSERT INTO xs VALUES (?)", i); err != nil {
+				return err
+			}
+		}
+		xs := []int{}
+		if err := Query(b, "SELECT count(*) FROM xs", &xs); err != nil || xs[0] != 100 {
+			t.Errorf("%#v %v", xs, err)
+		} else if len(b.stmts) != 1 {
+			t.Errorf("expected statement to be prepared once: %d", len(b.stmts))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Batch(func(b *Batch) error {
+		if _, err := b.Exec("INSERT INTO xs VALUES (?)", 100); err != nil {
+			return err
+		}
+		_, err := b.Exec("INSERT INTO missing VALUES (?)", 1)
+		return err
+	})
+	xs := []int{}
+	if err == nil || err.Error() != "INSERT INTO missing VALUES (?): no such table: missing" {
+		t.Errorf("unexpected error: %v", err)
+	} else if err := Query(db, "SELECT count(*) FROM xs", &xs); err != nil || xs[0] != 100 {
+		t.Errorf("expected failed batch to be rolled back: %#v %v", xs, err)
+	}
+}
+
+func TestQueryRO(t *testing.T) {
+	db, queries := openTestDB(t, "CREATE TABLE xs (x INTEGER)", "INSERT INTO xs VALUES (1)"), []string{}
+	db.QueryHook = func(query string, args []interface{}, d time.Duration, rows int, err error) {
+		queries = append(queries, query)
+	}
+	xs := []int{}
+	if err := db.QueryRO("SELECT x FROM xs", &xs); err != nil || !reflect.DeepEqual(xs, []int{1}) {
+		t.Errorf("%#v %v", xs, err)
+	}
+	if err := Query(ctxConn{context.Background(), roConn{db}}, "SELECT x FROM xs", &xs); err != nil || !reflect.DeepEqual(xs, []int{1, 1}) {
+		t.Errorf("%#v %v", xs, err)
+	}
+	if err := db.QueryRO("DELETE FROM xs", &xs); err == nil {
+		t.Error("expected write to fail")
+	} else if _, err := db.RO().Exec("DELETE FROM xs"); err == nil {
+		t.Error("expected write to fail")
+	}
+	if expected := []string{"SELECT x FROM xs", "SELECT x FROM xs", "DELETE FROM xs"}; !reflect.DeepEqual(queries, expected) {
+		t.Errorf("%#v not %#v", queries, expected)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	db := openTestDB(t, "-- +notransaction\nPRAGMA journal_mode = WAL", "CREATE TABLE xs (x INTEGER)", "INSERT INTO xs VALUES (1)")
+	counts, queries := []int{}, 0
+	db.ROLimit = &Limiter{Max: 1, Reject: true}
+	db.QueryHook = func(query string, args []interface{}, d time.Duration, rows int, err error) {
+		if query == "SELECT count(*) FROM xs" {
+			queries++
+		}
+	}
+	err := db.Snapshot(func(c Connection) error {
+		if err := db.QueryRO("SELECT 1", &[]int{}); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("expected snapshot to hold the limiter: %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := Query(c, "SELECT count(*) FROM xs", &counts); err != nil {
+				return err
+			} else if _, err := db.Exec("INSERT INTO xs VALUES (2)"); err != nil {
+				return err
+			}
+		}
+		if _, err := c.Exec("INSERT INTO xs VALUES (3)"); err == nil {
+			t.Error("expected snapshot to deny writes")
+		}
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(counts, []int{1, 1}) || queries != 2 {
+		t.Errorf("%#v %d %v", counts, queries, err)
+	}
+	if err := db.QueryRO("SELECT count(*) FROM xs", &counts); err != nil || !reflect.DeepEqual(counts, []int{1, 1, 3}) {
+		t.Errorf("%#v %v", counts, err)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	other := openTestDB(t, "CREATE TABLE ys (x INTEGER, y TEXT)", "INSERT INTO ys VALUES (1, 'one')")
+	db := &DB{DataSourceName: filepath.Join(t.TempDir(), "test.sqlite"), Attach: map[string]string{"other": other.path()}}
+	if err := db.Open(map[string]string{"000.sql": "CREATE TABLE xs (x INTEGER); INSERT INTO xs VALUES (1), (2)"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close(); db.RODB.Close() })
+	ys := []string{}
+	if err := Query(db.RODB, "SELECT coalesce(y, '-') FROM xs LEFT JOIN other.ys USING (x) ORDER BY x", &ys); err != nil || !reflect.DeepEqual(ys, []string{"one", "-"}) {
+		t.Errorf("%#v %v", ys, err)
+	}
+	if _, err := db.RODB.Exec("INSERT INTO other.ys VALUES (2, 'two')"); err == nil {
+		t.Error("expected read-only connection to deny writes to attached database")
+	}
+	if _, err := db.RODB.Exec("ATTACH DATABASE ? AS another", other.path()); err == nil {
+		t.Error("expected read-only connection to deny ATTACH")
+	}
+	if _, err := db.Exec("INSERT INTO other.ys VALUES (2, 'two')"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAttachDatabase(t *testing.T) {
+	other := openTestDB(t, "CREATE TABLE ys (x INTEGER, y TEXT)", "INSERT INTO ys VALUES (1, 'one')")
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)", "INSERT INTO xs VALUES (1), (2)")
+	query := "SELECT coalesce(y, '-') FROM xs LEFT JOIN other.ys USING (x) ORDER BY x"
+	if err := db.AttachDatabase("other", other.path(), true); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []Connection{db, db.RODB} {
+		ys := []string{}
+		if err := Query(c, query, &ys); err != nil || !reflect.DeepEqual(ys, []string{"one", "-"}) {
+			t.Errorf("%#v %v", ys, err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO other.ys VALUES (2, 'two')"); err == nil {
+		t.Error("expected read-only attached database to deny writes")
+	}
+	if err := db.AttachDatabase("other", other.path(), false); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec("INSERT INTO other.ys VALUES (2, 'two')"); err != nil {
+		t.Error(err)
+	}
+	err := db.Transact(context.Background(), TxOptions{}, func(c Connection) error {
+		if _, err := c.Exec("INSERT INTO xs VALUES (3)"); err != nil {
+			return err
+		} else if err := db.AttachDatabase("third", other.path(), true); err != nil {
+			return err
+		}
+		_, err := c.Exec("INSERT INTO xs VALUES (4)")
+		return err
+	})
+	if n := []int{}; err != nil || Query(db, "SELECT count(*) FROM xs JOIN third.ys USING (x)", &n) != nil || n[0] != 2 {
+		t.Errorf("expected transaction to survive attaching: %v %v", n, err)
+	}
+	if err := db.DetachDatabase("other"); err != nil {
+		t.Fatal(err)
+	} else if err := Query(db, query, &[]string{}); err == nil {
+		t.Error("expected query against detached database to fail")
+	}
+	if err := db.DetachDatabase("other"); err == nil {
+		t.Error("expected detaching unknown alias to fail")
+	}
+	if err := db.AttachDatabase("other", filepath.Join(t.TempDir(), "missing", "x.sqlite"), false); err == nil {
+		t.Error("expected attaching unopenable file to fail")
+	} else if err := Query(db, "SELECT x FROM xs", &[]int{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWatchChanges(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)")
+	ctx, cancel := context.WithCancel(context.Background())
+	counts, done := make(chan int, 10), make(chan error)
+	go func() {
+		done <- db.WatchChanges(ctx, time.Millisecond, func() error {
+			ns := []int{}
+			if err := Query(db, "SELECT count(*) FROM xs", &ns); err != nil {
+				return err
+			}
+			counts <- ns[0]
+			return nil
+		})
+	}()
+	for i, expected := range []int{0, 1, 2} {
+		if n := <-counts; n != expected {
+			t.Errorf("%d not %d", n, expected)
+		}
+		if i < 2 {
+			if _, err := db.Exec("INSERT INTO xs VALUES (?)", i); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x TEXT)", "INSERT INTO xs VALUES ('old')")
+	replacement := openTestDB(t, "CREATE TABLE xs (x TEXT)", "INSERT INTO xs VALUES ('new')")
+	reopened := make(chan error, 1)
+	stop, err := db.Watch(5*time.Millisecond, func(err error) { reopened <- err })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	if err := os.Rename(replacement.DataSourceName, db.DataSourceName); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-reopened:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("database was not reopened")
+	}
+	for _, c := range []Connection{db, db.RODB} {
+		xs := []string{}
+		if err := Query(c, "SELECT x FROM xs", &xs); err != nil {
+			t.Error(err)
+		} else if expected := []string{"new"}; !reflect.DeepEqual(xs, expected) {
+			t.Errorf("%#v not %#v", xs, expected)
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	db := openTestDB(t)
+	other := &DB{DataSourceName: db.DataSourceName, ListenInterval: time.Millisecond}
+	if err := other.Open(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	defer other.RODB.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := db.Notify("xs", "before"); err != nil {
+		t.Fatal(err)
+	}
+	payloads, err := other.Listen(ctx, "xs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := other.ListenMessages(ctx, "xs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range [][2]string{{"ys", "ignored"}, {"xs", "a"}, {"xs", "b"}} {
+		if err := db.Notify(n[0], n[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, expected := range []string{"a", "b"} {
+		select {
+		case payload := <-payloads:
+			if payload != expected {
+				t.Errorf("%s not %s", payload, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s", expected)
+		}
+		select {
+		case m := <-messages:
+			if m.Channel != "xs" || m.Payload != expected || m.ID == 0 || m.Timestamp.IsZero() {
+				t.Errorf("%#v not %s", m, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for message %s", expected)
+		}
+	}
+	if _, err := db.Exec("UPDATE _notify SET timestamp = datetime('now', '-2 hours')"); err != nil {
+		t.Fatal(err)
+	} else if err := db.Notify("xs", "after purge"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-messages:
+		if m.Payload != "after purge" || m.ID != 5 {
+			t.Errorf("%#v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message after purge")
+	}
+}
+
+func TestCollations(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE files (name TEXT)", "INSERT INTO files VALUES ('item10'), ('Item2'), ('item2'), ('item02b'), ('ÄRGER'), ('ärger')")
+	db.Close()
+	db.RODB.Close()
+	db.DB = nil
+	db.Collations = map[string]func(string, string) int{"reverse": func(a, b string) int { return strings.Compare(b, a) }}
+	if err := db.Open(nil); err != nil {
+		t.Fatal(err)
+	}
+	for collation, expected := range map[string][]string{
+		"natural_sort":   {"Item2", "item02b", "item2", "item10", "ÄRGER", "ärger"},
+		"unicode_nocase": {"item02b", "item10", "Item2", "item2", "ÄRGER", "ärger"},
+		"reverse":        {"ärger", "ÄRGER", "item2", "item10", "item02b", "Item2"},
+	} {
+		names := []string{}
+		if err := Query(db.RODB, "SELECT name FROM files ORDER BY name COLLATE "+collation+", rowid", &names); err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(names, expected) {
+			t.Errorf("%s: %#v not %#v", collation, names, expected)
+		}
+	}
+}
+
+func TestPureFunc(t *testing.T) {
+	db := &DB{
+		DataSourceName: filepath.Join(t.TempDir(), "test.sqlite"),
+		Funcs: map[string]interface{}{
+			"pure_upper":   PureFunc(strings.ToUpper),
+			"impure_upper": strings.ToUpper,
+		},
+	}
+	if err := db.Open(map[string]string{"001.sql": "CREATE TABLE xs (x TEXT)"}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer db.RODB.Close()
+	if _, err := db.Exec("CREATE INDEX xs_pure_upper ON xs (pure_upper(x))"); err != nil {
+		t.Error(err)
+	}
+	if _, err := db.Exec("CREATE INDEX xs_impure_upper ON xs (impure_upper(x))"); err == nil {
+		t.Error("expected non-deterministic function to be rejected in index")
+	}
+}
+
+func TestPublish(t *testing.T) {
+	rw := openTestDB(t, "CREATE TABLE xs (id INTEGER PRIMARY KEY, x TEXT NOT NULL DEFAULT '')", "CREATE INDEX xs_x ON xs (x)", "INSERT INTO xs (x) VALUES ('a')")
+	db, handler, err := Publish(rw.DataSourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer db.RODB.Close()
+	defer db.Cache.Close()
+	if _, err := db.Exec("INSERT INTO xs (x) VALUES ('b')"); err == nil {
+		t.Error("expected write to published database to fail")
+	}
+	for path, expected := range map[string]string{
+		"/?query=SELECT+x+FROM+xs": `[{"x":"a"}]`,
+		"/schema": `[{"type":"table","name":"xs","table":"xs","sql":"CREATE TABLE xs (id INTEGER PRIMARY KEY, x TEXT NOT NULL DEFAULT '')",` +
+			`"columns":[{"default":null,"name":"id","notnull":0,"pk":1,"type":"INTEGER"},{"default":"''","name":"x","notnull":1,"pk":0,"type":"TEXT"}],` +
+			`"indexes":[{"name":"xs_x","unique":0,"columns":["x"]}],"rows":1},` +
+			`{"type":"index","name":"xs_x","table":"xs","sql":"CREATE INDEX xs_x ON xs (x)"}]`,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if body := strings.TrimSpace(w.Body.String()); body != expected {
+			t.Errorf("%s: %s not %s", path, body, expected)
+		} else if w.Header().Get("Cache-Control") == "" {
+			t.Errorf("%s: missing Cache-Control header", path)
+		}
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/?query=SELECT+missing", nil))
+	if w.Code != http.StatusBadRequest || w.Header().Get("Cache-Control") != "" {
+		t.Errorf("expected errors not to be cached: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestWarnCoercions(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (i INTEGER, b BLOB, n)",
+		"INSERT INTO xs VALUES ('abc', x'01', NULL), (9007199254740993, NULL, 9007199254740993), (1.5, NULL, NULL)")
+	out := &bytes.Buffer{}
+	db.Logger, db.WarnCoercions = log.New(out, "", 0), true
+	results := []struct {
+		I interface{} `db:"i"`
+		B string      `db:"b"`
+		N float64     `db:"n"`
+	}{}
+	if err := Query(db, "SELECT i, b, n FROM xs", &results); err != nil {
+		t.Error(err)
+		return
+	}
+	expected := `WARNING: SELECT i, b, n FROM xs: column i: TEXT value "abc" stored in INTEGER column
+WARNING: SELECT i, b, n FROM xs: column b: BLOB value decoded as base64 into string
+WARNING: SELECT i, b, n FROM xs: column n: INTEGER value 9007199254740993 loses precision as float
+WARNING: SELECT i, b, n FROM xs: column i: REAL value 1.5 stored in INTEGER column
+`
+	if actual := out.String(); actual != expected {
+		t.Errorf("%s not %s", actual, expected)
+	} else if results[1].I != int64(9007199254740993) {
+		t.Errorf("%#v not %#v", results[1].I, int64(9007199254740993))
+	}
+}
+
+func TestRegexpCache(t *testing.T) {
+	c, wg := &regexpCache{max: 2}, sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := c.get(fmt.Sprintf("a{%d}", i%5)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	c.get("x")
+	c.get("y")
+	c.get("x")
+	c.get("z")
+	if _, ok := c.entries["y"]; ok || len(c.entries) != 2 || c.order.Len() != 2 {
+		t.Errorf("expected y to be evicted: %v", c.entries)
+	}
+	if _, err := c.get("("); err == nil {
+		t.Error("expected invalid pattern error")
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	db := openTestDB(t)
+	results := []string{}
+	if err := Query(db, "SELECT shout('a')", &results); err == nil {
+		t.Error("expected unknown function error")
+	}
+	if err := db.RegisterFunc("shout", func(s string) string { return strings.ToUpper(s) + "!" }, true); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, c := range []Connection{db, db.RODB} {
+		if err := Query(c, "SELECT shout('a')", &results); err != nil {
+			t.Error(err)
+		}
+	}
+	if expected := []string{"A!", "A!"}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v not %#v", results, expected)
+	}
+	if err := db.RegisterFunc("shout", func(c chan int) {}, false); err == nil || !strings.HasPrefix(err.Error(), "register shout: ") {
+		t.Errorf("expected registration error, got %v", err)
+	}
+	if err := Query(db, "SELECT shout('b')", &results); err != nil || results[2] != "B!" {
+		t.Errorf("expected previous function to be kept: %v %#v", err, results)
+	}
+}
+
+func TestRecyclePoolsConcurrently(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)")
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	done, errs := make(chan struct{}), make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func(c Connection) {
+			for {
+				select {
+				case <-done:
+					errs <- nil
+					return
+				default:
+				}
+				if _, err := Exec(db, "INSERT INTO xs VALUES (1)"); err != nil {
+					errs <- err
+					return
+				} else if err := Query(c, "SELECT count(*) FROM xs", &[]int{}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}([]Connection{db, db.RODB}[i%2])
+	}
+	for i := 0; i < 10; i++ {
+		n := i
+		if err := db.RegisterFunc(fmt.Sprintf("f%d", i), func() int { return n }, false); err != nil {
+			t.Error(err)
+		}
+	}
+	close(done)
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := conn.ExecContext(context.Background(), "INSERT INTO xs VALUES (2)"); err != nil {
+		t.Errorf("expected connection held across recycling to stay usable: %v", err)
+	}
+	n := []int{}
+	if err := Query(db, "SELECT f9()", &n); err != nil || n[0] != 9 {
+		t.Errorf("%#v %v", n, err)
+	}
+}
+
+func TestStrictScan(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (id INTEGER, name TEXT)", "INSERT INTO xs VALUES (1, 'a')")
+	type x struct {
+		ID      int `db:"id"`
+		Name    string
+		Ignored string `db:"-"`
+	}
+	db.StrictScan = true
+	for query, expected := range map[string]string{
+		"SELECT id, name AS Name FROM xs":    "",
+		"SELECT id, name AS nmae FROM xs":    "column nmae has no matching field in gosql.x",
+		"SELECT name AS Name FROM xs":        "field gosql.x.ID (id) is missing from result columns",
+		"SELECT id FROM xs":                  "",
+		"SELECT id, name AS Name, 1 FROM xs": "column 1 has no matching field in gosql.x",
+	} {
+		xs, actual := []x{}, ""
+		if err := Query(db, query, &xs); err != nil {
+			actual = strings.TrimPrefix(err.Error(), query+": ")
+		}
+		if actual != expected {
+			t.Errorf("%s: %q not %q", query, actual, expected)
+		}
+	}
+}
+
+func TestReadOnlyAuthorizer(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE users (name TEXT, password TEXT)", "INSERT INTO users VALUES ('a', 'secret')")
+	db.ReadOnlyAuthorizer = func(op int, arg1, arg2, arg3 string, result int) int {
+		if op == sqlite3.SQLITE_READ && arg1 == "users" && arg2 == "password" {
+			return sqlite3.SQLITE_IGNORE
+		} else if op == sqlite3.SQLITE_PRAGMA && arg1 == "page_size" {
+			return sqlite3.SQLITE_OK
+		}
+		return result
+	}
+	results := []map[string]interface{}{}
+	if err := Query(db.RODB, "SELECT name, password FROM users", &results); err != nil {
+		t.Error(err)
+	} else if expected := []map[string]interface{}{{"name": "a", "password": nil}}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v not %#v", results, expected)
+	}
+	if _, err := db.RODB.Exec("PRAGMA page_size"); err != nil {
+		t.Error(err)
+	}
+	if _, err := db.RODB.Exec("DELETE FROM users"); err == nil {
+		t.Error("expected default whitelist to deny writes")
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, deleted_at TIMESTAMP)")
+	type user struct {
+		ID        int        `db:"id,pk"`
+		Name      string     `db:"name"`
+		DeletedAt *time.Time `db:"deleted_at,softdelete"`
+	}
+	if _, err := Insert(db, "users", user{ID: 1, Name: "foo"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := Delete(db, "users", user{ID: 1}); err != nil {
+		t.Fatal(err)
+	} else if n, _ := r.RowsAffected(); n != 1 {
+		t.Errorf("soft delete affected %d rows", n)
+	}
+	if err := Get(db, "users", &user{ID: 1}); err != sql.ErrNoRows {
+		t.Errorf("expected soft deleted row to be hidden: %v", err)
+	}
+	if r, err := Update(db, "users", user{ID: 1, Name: "bar"}); err != nil {
+		t.Fatal(err)
+	} else if n, _ := r.RowsAffected(); n != 0 {
+		t.Errorf("update of soft deleted row affected %d rows", n)
+	}
+	if _, err := Insert(db, "users", user{ID: 2, Name: "baz"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []*SelectQuery{Select("users"), Select("users").Unscoped()} {
+		users, pageUsers := []user{}, []user{}
+		if err := q.Query(db, &users); err != nil {
+			t.Fatal(err)
+		} else if _, err := q.Paginate(db, Page{Limit: 10, Keys: []string{"id"}}, &pageUsers); err != nil {
+			t.Fatal(err)
+		} else if expected := map[bool]int{false: 1, true: 2}[q.unscoped]; len(users) != expected || len(pageUsers) != expected {
+			t.Errorf("unscoped=%v: %#v %#v", q.unscoped, users, pageUsers)
+		}
+	}
+	u := user{ID: 1}
+	if err := Get(db, "users", &u, Unscoped); err != nil || u.Name != "foo" || u.DeletedAt == nil {
+		t.Errorf("%#v %v", u, err)
+	}
+	if _, err := Delete(db, "users", u, Unscoped); err != nil {
+		t.Fatal(err)
+	}
+	if err := Get(db, "users", &user{ID: 1}, Unscoped); err != sql.ErrNoRows {
+		t.Errorf("expected row to be deleted: %v", err)
+	}
+}
+
+func TestKV(t *testing.T) {
+	db := openTestDB(t)
+	type settings struct {
+		Theme string
+		Size  int
+	}
+	kv := db.KV("settings")
+	if err := kv.Get("ui", &settings{}); err != sql.ErrNoRows {
+		t.Errorf("expected missing key: %v", err)
+	}
+	for _, s := range []settings{{"dark", 1}, {"light", 2}} {
+		if err := kv.Set("ui", s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.KV("settings").Set("version", 3); err != nil {
+		t.Fatal(err)
+	}
+	s := settings{}
+	if err := kv.Get("ui", &s); err != nil || s != (settings{"light", 2}) {
+		t.Errorf("%#v %v", s, err)
+	}
+	if err := kv.Delete("ui"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := kv.Keys(); err != nil || !reflect.DeepEqual(keys, []string{"version"}) {
+		t.Errorf("%#v %v", keys, err)
+	}
+}
+
+func TestTimeSeries(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE metrics (at TIMESTAMP, cpu REAL)",
+		"INSERT INTO metrics VALUES ('2021-03-04 10:05:00', 1), ('2021-03-04 10:55:00', 3), ('2021-03-04 12:30:00', 5)")
+	buckets := []string{}
+	q := `SELECT time_bucket('15m', '2021-03-04 10:20:30') || ' ' || time_bucket('1d', 1614853230) || ' ' || time_bucket('1w', '2021-03-04')`
+	if err := Query(db, q, &buckets); err != nil || buckets[0] != "2021-03-04T10:15:00Z 2021-03-04T00:00:00Z 2021-03-01T00:00:00Z" {
+		t.Errorf("%#v %v", buckets, err)
+	}
+	from := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+	points, err := Downsample(db, time.Hour, from, from.Add(4*time.Hour), "avg", "SELECT at, cpu, 'host' AS host FROM metrics WHERE at >= ?", "2021-03-04")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := []string{}
+	for _, p := range points {
+		if p.Value != nil {
+			actual = append(actual, fmt.Sprintf("%s %v", p.Time.Format("15:04"), *p.Value))
+		} else {
+			actual = append(actual, p.Time.Format("15:04")+" -")
+		}
+	}
+	if expected := []string{"10:00 2", "11:00 -", "12:00 5", "13:00 -"}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%#v not %#v", actual, expected)
+	}
+	if _, err := Downsample(db, time.Hour, from, from, "avg); DROP TABLE metrics; --", "SELECT at, cpu FROM metrics"); err == nil {
+		t.Errorf("expected unsupported aggregate to fail")
+	}
+}
+
+func TestEncryptedColumns(t *testing.T) {
+	db := &DB{DataSourceName: ":memory:", ColumnKey: bytes.Repeat([]byte("k"), 32), ColumnFuncs: true}
+	if err := db.Open(map[string]string{"001.sql": "CREATE TABLE people (id INTEGER PRIMARY KEY, ssn BLOB, note BLOB)"}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer db.RODB.Close()
+	type person struct {
+		ID   int     `db:"id,pk"`
+		SSN  string  `db:"ssn,encrypted"`
+		Note *string `db:"note,encrypted"`
+	}
+	if _, err := Insert(db, "people", person{ID: 1, SSN: "123-45-6789"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	note := "secret"
+	if _, err := Update(db, "people", person{ID: 1, SSN: "987-65-4321", Note: &note}); err != nil {
+		t.Fatal(err)
+	}
+	raw, decrypted := [][]byte{}, []string{}
+	if err := Query(db, "SELECT ssn FROM people", &raw); err != nil || bytes.Contains(raw[0], []byte("987")) {
+		t.Errorf("expected ssn to be encrypted: %q %v", raw, err)
+	}
+	ad, _ := EncryptionContext("people", "ssn", []interface{}{1})
+	if err := Query(db, "SELECT decrypt(ssn, ?) || ' ' || decrypt(encrypt('x', 'y'), 'y') FROM people", &decrypted, ad); err != nil || decrypted[0] != "987-65-4321 x" {
+		t.Errorf("%#v %v", decrypted, err)
+	}
+	if err := Query(db.RODB, "SELECT decrypt(ssn, ?) FROM people", &decrypted, ad); err == nil {
+		t.Errorf("expected decrypt to be unavailable on read-only pool")
+	}
+	p := person{ID: 1}
+	if err := Get(db, "people", &p); err != nil || p.SSN != "987-65-4321" || p.Note == nil || *p.Note != "secret" {
+		t.Errorf("%#v %v", p, err)
+	}
+	if err := Query(db, "SELECT * FROM people", &[]person{}); err == nil {
+		t.Errorf("expected error for unknown encryption table")
+	}
+	type taggedPerson struct {
+		ID  int    `db:"id,pk"`
+		SSN string `db:"ssn,encrypted=people"`
+	}
+	tagged := []taggedPerson{}
+	if err := Query(db, "SELECT id, ssn FROM people", &tagged); err != nil || tagged[0].SSN != "987-65-4321" {
+		t.Errorf("%#v %v", tagged, err)
+	}
+	err := db.Transact(context.Background(), TxOptions{}, func(c Connection) error {
+		if _, err := Insert(c, "people", person{ID: 2, SSN: "111-11-1111"}, ""); err != nil {
+			return err
+		}
+		p := person{ID: 2}
+		if err := Get(c, "people", &p); err != nil || p.SSN != "111-11-1111" {
+			return fmt.Errorf("%#v %v", p, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	err = db.Batch(func(b *Batch) error {
+		_, err := Insert(b, "people", person{ID: 3, SSN: "333-33-3333"}, "")
+		return err
+	})
+	if p := (person{ID: 3}); err != nil || Get(db, "people", &p) != nil || p.SSN != "333-33-3333" {
+		t.Errorf("%#v %v", p, err)
+	}
+	if _, err := Exec(db, "UPDATE people SET note = ssn WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	} else if err := Get(db, "people", &person{ID: 1}); err == nil {
+		t.Errorf("expected ciphertext copied to another column to fail decryption")
+	}
+	if _, err := Exec(db, "UPDATE people SET ssn = (SELECT ssn FROM people WHERE id = 1) WHERE id = 2"); err != nil {
+		t.Fatal(err)
+	} else if err := Get(db, "people", &person{ID: 2}); err == nil {
+		t.Errorf("expected ciphertext copied to another row to fail decryption")
+	}
+	if _, err := Insert(db, "people", person{SSN: "x"}, ""); err == nil {
+		t.Errorf("expected error for encrypted field without pk")
+	}
+	if _, err := Insert(openTestDB(t), "people", person{ID: 2}, ""); err != ErrNoColumnKey {
+		t.Errorf("expected missing column key error: %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (id INTEGER PRIMARY KEY, x TEXT NOT NULL UNIQUE)", "INSERT INTO xs VALUES (1, 'a')")
+	_, uniqueErr := Exec(db, "INSERT INTO xs VALUES (2, 'a')")
+	_, pkErr := Insert(db, "xs", map[string]interface{}{"id": 1, "x": "b"}, "")
+	_, notNullErr := Exec(db, "INSERT INTO xs VALUES (3, NULL)")
+	if !IsUniqueViolation(uniqueErr) || !IsUniqueViolation(pkErr) || IsUniqueViolation(notNullErr) {
+		t.Errorf("unexpected unique violations: %v %v %v", uniqueErr, pkErr, notNullErr)
+	}
+	if !IsNotNullViolation(notNullErr) || !IsConstraintViolation(notNullErr) || IsBusy(notNullErr) {
+		t.Errorf("unexpected not null violation: %v", notNullErr)
+	}
+	if e, ok := SQLiteError(uniqueErr); !ok || e.Code != 19 || e.ExtendedCode != 2067 || e.Message != "UNIQUE constraint failed: xs.x" || !strings.HasPrefix(uniqueErr.Error(), "INSERT INTO xs") {
+		t.Errorf("expected wrapped sqlite error: %#v %#v", uniqueErr, e)
+	}
+	type x struct {
+		ID int `db:"id,pk"`
+	}
+	if err := Get(db, "xs", &x{ID: 2}); !IsNoRows(err) || err != ErrNoRows {
+		t.Errorf("expected no rows: %v", err)
+	}
+}
+
+func TestScanRawFields(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (doc TEXT, n INTEGER, b BLOB)", `INSERT INTO xs VALUES ('{"a": [1, 2]}', 42, x'0102'), (NULL, NULL, NULL)`)
+	results := []struct {
+		Doc json.RawMessage `db:"doc"`
+		N   json.RawMessage `db:"n"`
+		X   interface{}     `db:"x"`
+		B   interface{}     `db:"b"`
+	}{}
+	if err := Query(db, "SELECT doc, n, n AS x, b FROM xs", &results); err != nil {
+		t.Fatal(err)
+	}
+	if r := results[0]; string(r.Doc) != `{"a": [1, 2]}` || string(r.N) != "42" || r.X != int64(42) || !reflect.DeepEqual(r.B, []byte{1, 2}) {
+		t.Errorf("%s %s %#v %#v", r.Doc, r.N, r.X, r.B)
+	}
+	if r := results[1]; r.Doc != nil || r.N != nil || r.X != nil || r.B != nil {
+		t.Errorf("%#v", r)
+	}
+}
+
+type mappedRow struct {
+	Name  string
+	Count int64
+}
+
+func (r *mappedRow) MapRow(columns []string, values []interface{}) error {
+	name, ok := values[0].(string)
+	if !ok {
+		return fmt.Errorf("unexpected name %#v", values[0])
+	}
+	r.Name, r.Count = strings.ToUpper(name), values[1].(int64)
+	return nil
+}
+
+func TestRowMapper(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (name TEXT, count INTEGER)", "INSERT INTO xs VALUES ('a', 1), ('b', 2)")
+	rows, ptrs := []mappedRow{}, []*mappedRow{}
+	if err := Query(db, "SELECT name, count FROM xs ORDER BY name", &rows); err != nil {
+		t.Fatal(err)
+	} else if expected := []mappedRow{{"A", 1}, {"B", 2}}; !reflect.DeepEqual(rows, expected) {
+		t.Errorf("%#v not %#v", rows, expected)
+	}
+	if err := Query(db, "SELECT name, count FROM xs ORDER BY name", &ptrs); err != nil || len(ptrs) != 2 || *ptrs[1] != (mappedRow{"B", 2}) {
+		t.Errorf("%#v %v", ptrs, err)
+	}
+	if err := Query(db, "SELECT count, name FROM xs", &rows); err == nil || !strings.Contains(err.Error(), "unexpected name") {
+		t.Errorf("expected MapRow error: %v", err)
+	}
+}
+
+func TestInitStatements(t *testing.T) {
+	db := &DB{DataSourceName: filepath.Join(t.TempDir(), "test.sqlite"), InitStatements: []string{"PRAGMA cache_size = -1234", "PRAGMA query_only = 0"}}
+	db.ReadOnlyAuthorizer = func(op int, arg1, arg2, arg3 string, result int) int {
+		if op == sqlite3.SQLITE_PRAGMA && arg1 == "cache_size" && arg2 == "" {
+			return sqlite3.SQLITE_OK
+		}
+		return result
+	}
+	if err := db.Open(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer db.RODB.Close()
+	for _, c := range []Connection{db, db.RODB} {
+		sizes := []int{}
+		if err := Query(c, "PRAGMA cache_size", &sizes); err != nil || sizes[0] != -1234 {
+			t.Errorf("%#v %v", sizes, err)
+		}
+	}
+	db = &DB{DataSourceName: ":memory:", InitStatements: []string{"PRAGMA nope("}}
+	if err := db.Open(nil); err == nil || !strings.HasPrefix(err.Error(), "PRAGMA nope(: ") {
+		t.Errorf("expected init statement error: %v", err)
+	}
+}
+
+func TestQueryReadOnly(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)", "INSERT INTO xs VALUES (1)")
+	db.SetMaxOpenConns(1)
+	xs, queries := []int{}, []string{}
+	db.QueryHook = func(query string, args []interface{}, d time.Duration, rows int, err error) {
+		queries = append(queries, query)
+	}
+	if err := QueryReadOnly(db, "SELECT x FROM xs", &xs); err != nil || !reflect.DeepEqual(xs, []int{1}) {
+		t.Errorf("%#v %v", xs, err)
+	} else if !reflect.DeepEqual(queries, []string{"SELECT x FROM xs"}) {
+		t.Errorf("expected hooks to apply: %#v", queries)
+	}
+	db.RWLimit = &Limiter{Max: 1, Reject: true}
+	if release, err := db.RWLimit.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if err := QueryReadOnly(db, "SELECT x FROM xs", &xs); err != ErrLimitExceeded {
+		t.Errorf("expected limiter to apply: %v", err)
+	} else {
+		release()
+	}
+	if err := QueryReadOnly(db, "DELETE FROM xs", &xs); err == nil || !strings.Contains(err.Error(), "not authorized") {
+		t.Errorf("expected write to be denied: %v", err)
+	}
+	if _, err := Exec(db, "INSERT INTO xs VALUES (2)"); err != nil {
+		t.Errorf("expected connection to be writable again: %v", err)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER, y INTEGER)", "CREATE INDEX xs_x ON xs (x)")
+	steps, err := db.Plan("SELECT * FROM xs WHERE x = ? AND y IN (SELECT y FROM xs WHERE y > 1)", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || len(steps[1].Children) != 1 || steps[1].Children[0].Parent != steps[1].ID {
+		t.Fatalf("unexpected plan tree: %#v", steps)
+	}
+	actual := []string{steps[0].Detail, steps[1].Detail, steps[1].Children[0].Detail}
+	if expected := []string{"SEARCH TABLE xs USING INDEX xs_x (x=?)", "LIST SUBQUERY 1", "SCAN TABLE xs"}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%#v not %#v", actual, expected)
+	}
+	if details := FindPlanSteps(steps, "USING INDEX xs_x"); len(details) != 1 {
+		t.Errorf("expected index usage: %#v", details)
+	}
+	opcodes, err := db.Opcodes("SELECT x FROM xs")
+	if err != nil || len(opcodes) == 0 || opcodes[0].Opcode != "Init" {
+		t.Errorf("%#v %v", opcodes, err)
+	}
+}
+
+func TestCheckRecover(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE ps (id INTEGER PRIMARY KEY)", "CREATE TABLE cs (id INTEGER PRIMARY KEY, p INTEGER REFERENCES ps (id), x TEXT)",
+		"CREATE INDEX cs_x ON cs (x)", "INSERT INTO ps VALUES (1)", "INSERT INTO cs VALUES (1, 1, 'a'), (2, 2, 'b')",
+		"CREATE VIRTUAL TABLE r USING rtree(id, x0, x1)", "CREATE TABLE r_tags (tag TEXT)", "INSERT INTO r_tags VALUES ('a')")
+	findings, err := db.Check()
+	if err != nil || len(findings) != 1 || findings[0].Check != "foreign_key" || findings[0].Table != "cs" || *findings[0].RowID != 2 {
+		t.Errorf("%#v %v", findings, err)
+	}
+	path := filepath.Join(t.TempDir(), "recovered.sqlite")
+	tables, err := db.Recover(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := []string{}
+	for _, t := range tables {
+		actual = append(actual, fmt.Sprintf("%s %d %q", t.Name, t.Rows, t.Error))
+	}
+	if expected := []string{`_migrations 8 ""`, `ps 1 ""`, `cs 2 ""`, `r 0 "virtual tables are not recovered"`, `r_tags 1 ""`}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%#v not %#v", actual, expected)
+	}
+	recovered := &DB{DataSourceName: path}
+	if err := recovered.Open(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	defer recovered.RODB.Close()
+	xs := []string{}
+	if err := Query(recovered, "SELECT x FROM cs INDEXED BY cs_x ORDER BY x", &xs); err != nil || !reflect.DeepEqual(xs, []string{"a", "b"}) {
+		t.Errorf("%#v %v", xs, err)
+	}
+	if _, err := db.Recover(path); err == nil {
+		t.Errorf("expected recover into existing file to fail")
+	}
+
+	db = openTestDB(t, "CREATE TABLE xs (id INTEGER PRIMARY KEY, x TEXT)",
+		"WITH RECURSIVE i(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM i WHERE n < 1000) INSERT INTO xs SELECT n, printf('%0200d', n) FROM i")
+	sizes := []int{}
+	if err := Query(db, "SELECT page_size FROM pragma_page_size UNION ALL SELECT page_count FROM pragma_page_count", &sizes); err != nil {
+		t.Fatal(err)
+	}
+	pageSize, pages := sizes[0], sizes[1]
+	db.Close()
+	db.RODB.Close()
+	f, err := os.OpenFile(db.DataSourceName, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := f.WriteAt(bytes.Repeat([]byte{0xff}, pageSize), int64(pageSize*(pages-5))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	db = &DB{DataSourceName: db.DataSourceName}
+	if err := db.Open(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer db.RODB.Close()
+	if findings, err := db.Check(); err != nil || len(findings) == 0 || findings[0].Check != "integrity" {
+		t.Errorf("expected integrity findings: %#v %v", findings, err)
+	}
+	tables, err = db.Recover(filepath.Join(t.TempDir(), "recovered.sqlite"))
+	if err != nil || len(tables) != 2 || tables[1].Name != "xs" || tables[1].Rows == 0 || tables[1].Rows >= 1000 || tables[1].Error == "" {
+		t.Errorf("expected partial recovery: %#v %v", tables, err)
+	}
+}
+
+func TestExecIfNotExists(t *testing.T) {
+	db := openTestDB(t)
+	for i, expected := range []bool{true, false} {
+		for _, query := range []string{
+			`CREATE TABLE "x""s" (x INTEGER)`,
+			"create unique index main.xs_x ON [x\"s] (x)",
+			`CREATE TEMP VIEW v AS SELECT x FROM "x""s"`,
+			`/* bootstrap */ CREATE TRIGGER IF NOT EXISTS t AFTER INSERT ON "x""s" BEGIN SELECT 1; END`,
+		} {
+			if ran, err := ExecIfNotExists(db, query); err != nil || ran != expected {
+				t.Errorf("%d %s: %v %v", i, query, ran, err)
+			}
+		}
+		if ran, err := AddColumnIfNotExists(db, `x"s`, "`y` TEXT DEFAULT ''"); err != nil || ran != expected {
+			t.Errorf("%d add column: %v %v", i, ran, err)
+		}
+	}
+	if _, err := ExecIfNotExists(db, "DROP TABLE xs"); err == nil {
+		t.Error("expected non-CREATE statement to fail")
+	}
+}
+
+func TestGenerateStruct(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE users (id INTEGER PRIMARY KEY, user_name TEXT NOT NULL, avatar_url VARCHAR(255), score REAL, created_at TIMESTAMP, data BLOB)",
+		"INSERT INTO users (user_name, score, created_at) VALUES ('a', 1.5, '2020-01-01T00:00:00Z')")
+	actual, err := GenerateStruct(db, "User", "SELECT *, count(*) OVER () AS \"user-count\", 1 AS \"1st\", NULL AS missing FROM users WHERE id > ?", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "type User struct {\n" +
+		"\tID        int64       `db:\"id\"`\n" +
+		"\tUserName  string      `db:\"user_name\"`\n" +
+		"\tAvatarURL *string     `db:\"avatar_url\"`\n" +
+		"\tScore     *float64    `db:\"score\"`\n" +
+		"\tCreatedAt *time.Time  `db:\"created_at\"`\n" +
+		"\tData      []byte      `db:\"data\"`\n" +
+		"\tUserCount int64       `db:\"user-count\"`\n" +
+		"\tX1st      int64       `db:\"1st\"`\n" +
+		"\tMissing   interface{} `db:\"missing\"`\n" +
+		"}\n"
+	if actual != expected {
+		t.Errorf("%s not %s", actual, expected)
+	}
+	defer func(n int) { generateSampleRows = n }(generateSampleRows)
+	generateSampleRows = 1
+	if src, err := GenerateStruct(db, "X", "SELECT 1 AS x UNION ALL SELECT 'a'"); err != nil || !strings.Contains(src, "X int64") {
+		t.Errorf("expected only the first row to be sampled: %s %v", src, err)
+	}
+}
+
+func TestSliceAndMapArgs(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (name TEXT, tags TEXT, meta TEXT)")
+	if _, err := db.Exec("INSERT INTO xs VALUES (?, ?, ?), (?, ?, ?)", "a", []string{"x", "y"}, map[string]int{"z": 1}, "b", []string{"z"}, map[string]int(nil)); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	if err := Query(db, "SELECT name FROM xs WHERE json_includes_all(tags, ?)", &names, []string{"y", "x"}); err != nil || !reflect.DeepEqual(names, []string{"a"}) {
+		t.Errorf("%#v %v", names, err)
+	}
+	if names = nil; Query(db, "SELECT name FROM xs WHERE json_includes(tags, ?)", &names, []string{"x", "y"}) != nil || len(names) != 0 {
+		t.Errorf("expected json_includes not to expand slice args: %#v", names)
+	}
+	ips := [][]byte{}
+	if err := Query(db, "SELECT ?", &ips, net.ParseIP("127.0.0.1").To4()); err != nil || !bytes.Equal(ips[0], []byte{127, 0, 0, 1}) {
+		t.Errorf("expected net.IP to be bound as blob: %#v %v", ips, err)
+	}
+	metas := []*string{}
+	if err := Query(db, "SELECT meta FROM xs WHERE tags = :tags OR name = 'a' ORDER BY name", &metas, sql.Named("tags", [1]string{"z"})); err != nil || len(metas) != 2 || *metas[0] != `{"z":1}` || metas[1] != nil {
+		t.Errorf("%#v %v", metas, err)
+	}
+	n := []int{}
+	if err := Query(db, "SELECT count(*) FROM xs WHERE ? IS NULL AND CAST(? AS BLOB) = X'01'", &n, []string(nil), []byte{1}); err != nil || !reflect.DeepEqual(n, []int{2}) {
+		t.Errorf("%#v %v", n, err)
+	}
+}
+
+func TestColumnMapper(t *testing.T) {
+	type legacyUser struct {
+		ID   int    `db:",pk"`
+		Name string `db:",required"`
+		Note string `db:"remark"`
+	}
+	db := openTestDB(t)
+	db.ColumnMapper = func(field string) string { return "USR_" + strings.ToUpper(field) }
+	db.FieldMapper = func(column string) string { return column[4:5] + strings.ToLower(column[5:]) }
+	if err := db.CreateTable("users", legacyUser{}); err != nil {
+		t.Fatal(err)
+	} else if _, err := Insert(db, "users", legacyUser{1, "a", "x"}, ""); err != nil {
+		t.Fatal(err)
+	} else if _, err := Update(db, "users", legacyUser{1, "b", "y"}); err != nil {
+		t.Fatal(err)
+	}
+	columns := []string{}
+	if err := Query(db, "SELECT name FROM pragma_table_info('users')", &columns); err != nil || !reflect.DeepEqual(columns, []string{"USR_ID", "USR_NAME", "remark"}) {
+		t.Errorf("%#v %v", columns, err)
+	}
+	users := []legacyUser{}
+	if err := Query(db, "SELECT * FROM users", &users); err != nil || !reflect.DeepEqual(users, []legacyUser{{1, "b", "y"}}) {
+		t.Errorf("%#v %v", users, err)
+	}
+	if src, err := GenerateStruct(db, "User", "SELECT USR_NAME FROM users"); err != nil || !strings.Contains(src, "Name *string `db:\"USR_NAME\"`") {
+		t.Errorf("%s %v", src, err)
+	}
+	if _, err := Insert(db, "users", legacyUser{ID: 2}, ""); err == nil || err.(ValidationError)[0].Column != "USR_NAME" {
+		t.Errorf("expected validation error for mapped column: %v", err)
+	}
+	err := db.Transact(context.Background(), TxOptions{}, func(c Connection) error {
+		if _, err := Insert(c, "users", legacyUser{2, "c", "z"}, ""); err != nil {
+			return err
+		}
+		u := legacyUser{ID: 2}
+		if err := Get(c, "users", &u); err != nil || u.Name != "c" {
+			return fmt.Errorf("%#v %v", u, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	db.StrictScan = true
+	if err := Query(db, "SELECT USR_ID, remark FROM users", &users); err == nil || !strings.Contains(err.Error(), "USR_NAME") {
+		t.Errorf("expected strict scan to require mapped column: %v", err)
+	}
+}
+
+func TestQueryScalars(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE events (kind TEXT, ts TIMESTAMP)",
+		"INSERT INTO events VALUES ('a', '2020-01-01T00:00:00Z'), ('a', '2020-01-02T00:00:00Z'), ('b', '2020-01-03T00:00:00Z')")
+	count, last := 0, time.Time{}
+	if err := QueryScalars(db, "SELECT count(*), max(ts) FROM events WHERE kind = ?", []interface{}{"a"}, &count, &last); err != nil {
+		t.Fatal(err)
+	} else if expected := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC); count != 2 || !last.Equal(expected) {
+		t.Errorf("%d %v", count, last)
+	}
+	stats := struct {
+		Count int    `db:"c"`
+		Kinds string `db:"k"`
+	}{}
+	if err := QueryScalars(db, "SELECT count(*) c, group_concat(DISTINCT kind) k FROM events", nil, &stats); err != nil || stats.Count != 3 || stats.Kinds != "a,b" {
+		t.Errorf("%#v %v", stats, err)
+	}
+	n, kind := sql.NullInt64{}, sql.NullString{}
+	if err := QueryScalars(db, "SELECT count(*), NULL FROM events", nil, &n, &kind); err != nil || n.Int64 != 3 || kind.Valid {
+		t.Errorf("%#v %#v %v", n, kind, err)
+	}
+	if err := QueryScalars(db, "SELECT count(*) FROM events", nil, &n); err != nil || !n.Valid || n.Int64 != 3 {
+		t.Errorf("%#v %v", n, err)
+	}
+	if err := QueryScalars(db, "SELECT kind FROM events WHERE kind = 'c'", nil, &stats.Kinds); !IsNoRows(err) {
+		t.Errorf("expected no rows: %v", err)
+	}
+	if err := QueryScalars(db, "SELECT kind FROM events", nil, &stats.Kinds); err == nil {
+		t.Error("expected multiple rows to fail")
+	}
+	if err := QueryScalars(db, "SELECT kind, ts FROM events LIMIT 1", nil, &stats.Kinds); err == nil {
+		t.Error("expected column count mismatch to fail")
+	}
+}
+
+func TestQueryChan(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)", "WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n WHERE x < 100) INSERT INTO xs SELECT x FROM n")
+	type row struct {
+		X int `db:"x"`
+	}
+	ch, sum := make(chan row, 10), 0
+	errs := QueryChan(context.Background(), db, "SELECT x FROM xs WHERE x > ?", ch, 50)
+	for r := range ch {
+		sum += r.X
+	}
+	if err := <-errs; err != nil || sum != 3775 {
+		t.Errorf("%d %v", sum, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ints := make(chan int)
+	errs = QueryChan(ctx, db.RO(), "SELECT x FROM xs", ints)
+	if x := <-ints; x != 1 {
+		t.Errorf("%d not 1", x)
+	}
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation: %v", err)
+	}
+	if _, ok := <-ints; ok {
+		t.Error("expected channel to be closed")
+	}
+	if err := <-QueryChan(ctx, db, "SELECT x FROM xs", []int{}); err == nil {
+		t.Error("expected non-channel to fail")
+	}
+}
+
+func TestTenants(t *testing.T) {
+	dir := t.TempDir()
+	tenants := &Tenants{
+		DSN:        filepath.Join(dir, "%s.sqlite"),
+		Migrations: map[string]string{"001.sql": "CREATE TABLE xs (x TEXT)"},
+		Funcs:      map[string]interface{}{"tenant_greeting": func() string { return "hi" }},
+		MaxOpen:    2,
+	}
+	defer tenants.Close()
+	releases := []func(){}
+	for _, tenant := range []string{"a", "b"} {
+		db, release, err := tenants.Acquire(tenant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+		if _, err := Exec(db, "INSERT INTO xs VALUES (tenant_greeting() || ?)", tenant); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := tenants.Acquire("c"); err != ErrLimitExceeded {
+		t.Errorf("expected limit error: %v", err)
+	}
+	releases[0]()
+	db, release, err := tenants.Acquire("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if open := tenants.Open(); len(open) != 2 || indexOf(open, "a") != -1 {
+		t.Errorf("expected a to be evicted: %#v", open)
+	}
+	xs := []string{}
+	if err := Query(db, "SELECT x FROM xs", &xs); err != nil || len(xs) != 0 {
+		t.Errorf("%#v %v", xs, err)
+	}
+	releases[1]()
+	db, release, err = tenants.Acquire("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if err := Query(db, "SELECT x FROM xs", &xs); err != nil || !reflect.DeepEqual(xs, []string{"hia"}) {
+		t.Errorf("%#v not %#v: %v", xs, []string{"hia"}, err)
+	}
+	for _, tenant := range []string{"../x", "x?mode=memory", "x y", ""} {
+		if _, _, err := tenants.Acquire(tenant); err == nil {
+			t.Errorf("expected invalid tenant error for %q", tenant)
+		}
+	}
+}
+
+func TestTenantsOpenConcurrently(t *testing.T) {
+	entered, unblock := make(chan struct{}), make(chan struct{})
+	tenants := &Tenants{
+		DSN:        filepath.Join(t.TempDir(), "%s.sqlite"),
+		Migrations: map[string]string{"001.sql": "CREATE TABLE xs (x TEXT)"},
+		Configure: func(tenant string, db *DB) {
+			if tenant == "slow" {
+				close(entered)
+				<-unblock
+			}
+		},
+	}
+	defer tenants.Close()
+	dbs := make(chan *DB, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			db, release, err := tenants.Acquire("slow")
+			if err != nil {
+				t.Error(err)
+			} else {
+				defer release()
+			}
+			dbs <- db
+		}()
+	}
+	<-entered
+	if _, release, err := tenants.Acquire("fast"); err != nil {
+		t.Errorf("expected other tenants to open while slow is migrating: %v", err)
+	} else {
+		release()
+	}
+	close(unblock)
+	if a, b := <-dbs, <-dbs; a == nil || a != b {
+		t.Errorf("expected both acquires to share one DB: %p %p", a, b)
+	}
+}
+
+func TestTables(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (id INTEGER PRIMARY KEY, x TEXT NOT NULL)", "CREATE INDEX xs_x ON xs (x)",
+		"CREATE TABLE ys (y TEXT)", "INSERT INTO xs (x) VALUES ('a'), ('b'), ('c')")
+	tables, err := db.Tables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := func() []string {
+		xs := []string{}
+		for _, t := range tables {
+			xs = append(xs, fmt.Sprintf("%s columns=%d indexes=%d rows=%d estimated=%v", t.Name, len(t.Columns), len(t.Indexes), t.Rows, t.RowsEstimated))
+		}
+		return xs
+	}
+	if expected := []string{"xs columns=2 indexes=1 rows=3 estimated=false", "ys columns=1 indexes=0 rows=0 estimated=false"}; !reflect.DeepEqual(summary(), expected) {
+		t.Errorf("%#v not %#v", summary(), expected)
+	}
+	if _, err := Exec(db, "ANALYZE"); err != nil {
+		t.Fatal(err)
+	}
+	if tables, err = db.Tables(); err != nil || !tables[0].RowsEstimated || tables[0].Rows != 3 {
+		t.Errorf("%#v %v", tables, err)
+	}
+}
+
+func TestUpdateFromJSON(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (id INTEGER PRIMARY KEY, n INTEGER, r REAL, tags TEXT, admin BOOLEAN)", "INSERT INTO xs (id) VALUES (1)")
+	allowed := []string{"n", "r", "tags"}
+	for patch, expected := range map[string]string{
+		`{"n": 2, "r": 3, "tags": ["a"]}`: "",
+		`{"admin": true}`:                 "invalid patch: column admin cannot be updated",
+		`{"missing": 1}`:                  "invalid patch: column missing cannot be updated",
+		`{"n": 1.5}`:                      `invalid patch: column n: strconv.ParseInt: parsing "1.5": invalid syntax`,
+		`{"n": "1"}`:                      "invalid patch: column n: cannot store string in INTEGER column",
+		`{}`:                              "invalid patch: no changes",
+	} {
+		actual := ""
+		if _, err := UpdateFromJSON(db, "xs", 1, []byte(patch), allowed); err != nil {
+			actual = err.Error()
+		}
+		if actual != expected {
+			t.Errorf("%s: %q not %q", patch, actual, expected)
+		}
+	}
+	results := []map[string]interface{}{}
+	if err := Query(db, "SELECT n, typeof(r) AS r, tags, admin FROM xs", &results); err != nil {
+		t.Error(err)
+	} else if expected := []map[string]interface{}{{"n": 2.0, "r": "real", "tags": `["a"]`, "admin": nil}}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v not %#v", results, expected)
+	}
+	if _, err := Exec(db, "CREATE TABLE ys (a TEXT, b INTEGER, n INTEGER, PRIMARY KEY (a, b)) WITHOUT ROWID; INSERT INTO ys VALUES ('x', 1, 0), ('x', 2, 0)"); err != nil {
+		t.Fatal(err)
+	} else if _, err := UpdateFromJSON(db, "ys", 1, []byte(`{"n": 1}`), []string{"n"}); err == nil || err.Error() != "invalid id: ys has a 2 column primary key" {
+		t.Errorf("unexpected error: %v", err)
+	} else if _, err := UpdateFromJSON(db, "ys", []interface{}{"x", 2}, []byte(`{"n": 1}`), []string{"n"}); err != nil {
+		t.Error(err)
+	}
+	ns := []int{}
+	if err := Query(db, "SELECT n FROM ys ORDER BY b", &ns); err != nil || !reflect.DeepEqual(ns, []int{0, 1}) {
+		t.Errorf("%v %v", ns, err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (name TEXT, n INTEGER)", "INSERT INTO xs VALUES ('a', 1), ('b,c', NULL), ('d', 3)")
+	out, checkpoints := &bytes.Buffer{}, [][]interface{}{}
+	opts := ExportOptions{Format: "csv", ChunkSize: 2, Checkpoint: func(last []interface{}) error {
+		checkpoints = append(checkpoints, last)
+		if len(checkpoints) == 1 {
+			return errors.New("interrupted")
+		}
+		return nil
+	}}
+	last, err := Export(db, "xs", out, opts)
+	if err == nil || !reflect.DeepEqual(last, []interface{}{int64(2)}) {
+		t.Errorf("expected interruption after first chunk: %v %v", last, err)
+		return
+	}
+	opts.After = last
+	if last, err := Export(db, "xs", out, opts); err != nil || !reflect.DeepEqual(last, []interface{}{int64(3)}) {
+		t.Errorf("%v %v", last, err)
+	}
+	if expected := "name,n\na,1\n\"b,c\",\nd,3\n"; out.String() != expected {
+		t.Errorf("%q not %q", out.String(), expected)
+	}
+	out.Reset()
+	if _, err := Export(db, "xs", out, ExportOptions{Format: "ndjson", After: []interface{}{2}}); err != nil {
+		t.Error(err)
+	} else if expected := `{"n":3,"name":"d"}` + "\n"; out.String() != expected {
+		t.Errorf("%q not %q", out.String(), expected)
+	}
+	out.Reset()
+	if _, err := Exec(db, "CREATE TABLE ys (a TEXT, b INTEGER, PRIMARY KEY (a, b)) WITHOUT ROWID; INSERT INTO ys VALUES ('y', 1), ('x', 2), ('x', 1)"); err != nil {
+		t.Fatal(err)
+	} else if last, err := Export(db, "ys", out, ExportOptions{Format: "csv", ChunkSize: 2, After: []interface{}{"x", 1}}); err != nil || !reflect.DeepEqual(last, []interface{}{"y", int64(1)}) {
+		t.Errorf("%v %v", last, err)
+	} else if expected := "x,2\ny,1\n"; out.String() != expected {
+		t.Errorf("%q not %q", out.String(), expected)
+	}
+	out.Reset()
+	if _, err := Export(db, "xs", out, ExportOptions{Format: "sql", After: []interface{}{2}}); err != nil {
+		t.Error(err)
+	} else if expected := `INSERT INTO "xs" ("name", "n") VALUES ('d', 3);` + "\n"; out.String() != expected {
+		t.Errorf("%q not %q", out.String(), expected)
+	}
+}
+
+func TestExportQuery(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (name TEXT, n INTEGER, b BLOB)", "INSERT INTO xs VALUES ('it''s', 1, x'0102'), ('b', NULL, NULL)")
+	out := &bytes.Buffer{}
+	if err := ExportQuery(db, out, "xs", "sql"); err != nil {
+		t.Error(err)
+	} else if expected := `INSERT INTO "xs" ("name", "n", "b") VALUES ('it''s', 1, X'0102');` + "\n" +
+		`INSERT INTO "xs" ("name", "n", "b") VALUES ('b', NULL, NULL);` + "\n"; out.String() != expected {
+		t.Errorf("%q not %q", out.String(), expected)
+	}
+	out.Reset()
+	if err := ExportQuery(db, out, "SELECT name, n FROM xs WHERE n > ?", "csv", 0); err != nil {
+		t.Error(err)
+	} else if expected := "name,n\nit's,1\n"; out.String() != expected {
+		t.Errorf("%q not %q", out.String(), expected)
+	}
+	out.Reset()
+	if err := ExportQuery(db, out, "SELECT name FROM xs", "ndjson"); err != nil {
+		t.Error(err)
+	} else if expected := `{"name":"it's"}` + "\n" + `{"name":"b"}` + "\n"; out.String() != expected {
+		t.Errorf("%q not %q", out.String(), expected)
+	}
+	if err := ExportQuery(db, out, "SELECT name FROM xs", "sql"); err == nil {
+		t.Error("expected sql export of a query to fail")
+	}
+}
+
+func TestCSVVirtualTable(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE ys (name TEXT, score INTEGER)", "INSERT INTO ys VALUES ('b', 10)")
+	path := filepath.Join(t.TempDir(), "xs.csv")
+	if err := os.WriteFile(path, []byte("name,\"n\"\"x\"\na,1\nb,2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(fmt.Sprintf("CREATE VIRTUAL TABLE temp.xs USING csv('%s')", path)); err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			t.Skip("built without the sqlite_vtable tag")
+		}
+		t.Fatal(err)
+	}
+	results := []map[string]interface{}{}
+	if err := Query(db, `SELECT xs.name, xs."n""x" AS n, ys.score FROM xs LEFT JOIN ys USING (name) ORDER BY xs.name`, &results); err != nil {
+		t.Error(err)
+	} else if expected := []map[string]interface{}{{"name": "a", "n": "1", "score": nil}, {"name": "b", "n": "2", "score": 10.0}}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v not %#v", results, expected)
+	}
+}
+
+func TestFTS(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE posts (title TEXT, body TEXT)", "INSERT INTO posts VALUES ('go', 'sqlite from go'), ('misc', 'nothing here')")
+	if err := CreateFTS(db, "posts", "title", "body"); err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			t.Skip("built without the sqlite_fts5 tag")
+		}
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO posts VALUES ('db', 'sqlite sqlite sqlite')"); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec("UPDATE posts SET body = 'gone' WHERE title = 'go'"); err != nil {
+		t.Fatal(err)
+	}
+	results := []struct {
+		Title   string  `db:"title"`
+		Rank    float64 `db:"rank"`
+		Snippet string  `db:"snippet"`
+	}{}
+	if err := Search(db.RODB, "posts", "sqlite", &results); err != nil {
+		t.Error(err)
+	} else if len(results) != 1 || results[0].Title != "db" || results[0].Rank >= 0 || results[0].Snippet != "<b>sqlite</b> <b>sqlite</b> <b>sqlite</b>" {
+		t.Errorf("%#v", results)
+	}
+	if err := DropFTS(db, "posts"); err != nil {
+		t.Error(err)
+	} else if _, err := db.Exec("INSERT INTO posts VALUES ('x', 'y')"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithinRadius(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE places (name TEXT, lat REAL, lng REAL)",
+		"INSERT INTO places VALUES ('berlin', 52.52, 13.405), ('hamburg', 53.55, 9.99), ('nowhere', NULL, NULL)")
+	if err := CreateGeoIndex(db, "places", "lat", "lng"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO places VALUES ('potsdam', 52.39, 13.06)"); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec("UPDATE places SET lat = 48.14, lng = 11.58 WHERE name = 'hamburg'"); err != nil {
+		t.Fatal(err)
+	}
+	results := []struct {
+		Name     string  `db:"name"`
+		Distance float64 `db:"distance"`
+	}{}
+	if err := WithinRadius(db, "places", 52.5, 13.4, 50, &results); err != nil {
+		t.Error(err)
+	} else if len(results) != 2 || results[0].Name != "berlin" || results[1].Name != "potsdam" || math.Abs(results[1].Distance-25.9) > 0.5 {
+		t.Errorf("%#v", results)
+	}
+	results = nil
+	if err := WithinRadius(db, "places", 48.1, 11.6, 10, &results); err != nil || len(results) != 1 || results[0].Name != "hamburg" {
+		t.Errorf("%#v %v", results, err)
+	}
+}
+
+func TestGeoFuncs(t *testing.T) {
+	db := openTestDB(t)
+	square := `{"type": "Polygon", "coordinates": [[[0, 0], [10, 0], [10, 10], [0, 10], [0, 0]], [[4, 4], [6, 4], [6, 6], [4, 6], [4, 4]]]}`
+	query := `SELECT geohash(57.64911, 10.40744, 11) AS hash,
+                     round(geohash_decode('u4pruydqqvj', 'lat'), 5) AS lat, round(geohash_decode('u4pruydqqvj', 'lng'), 5) AS lng,
+                     round(geo_bbox(0.0, 0.0, 111.19492664455873, 'max_lat'), 6) AS max_lat, round(geo_bbox(0.0, 0.0, 111.19492664455873, 'min_lng'), 6) AS min_lng,
+                     geo_contains(?, 2.0, 2.0) AS inside, geo_contains(?, 5.0, 5.0) AS hole, geo_contains(?, 20.0, 2.0) AS outside`
+	results := []map[string]interface{}{}
+	if err := Query(db, query, &results, square, square, square); err != nil {
+		t.Error(err)
+	} else if expected := []map[string]interface{}{{"hash": "u4pruydqqvj", "lat": 57.64911, "lng": 10.40744,
+		"max_lat": 1.0, "min_lng": -1.0, "inside": 1.0, "hole": 0.0, "outside": 0.0}}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v not %#v", results, expected)
+	}
+	if err := Query(db, "SELECT geo_bbox(0.0, 0.0, 1.0, 'middle')", &results); err == nil || !strings.Contains(err.Error(), "unknown corner") {
+		t.Errorf("expected unknown corner error: %v", err)
+	}
+}
+
+func TestURLFuncs(t *testing.T) {
+	db := openTestDB(t)
+	query := `SELECT url_host(?) AS host, url_host('example.com/a') AS bare_host, url_path(?) AS path,
+                     url_query_param(?, 'q') AS q, url_query_param(?, 'missing') AS missing, slugify(?) AS slug`
+	u := "https://Blog.Example.com:8080/posts/1?q=a%20b&x=1#top"
+	results := []map[string]interface{}{}
+	if err := Query(db, query, &results, u, u, u, u, "  Über Straße: ÉTÉ 2021!! "); err != nil {
+		t.Error(err)
+	} else if expected := []map[string]interface{}{{"host": "blog.example.com", "bare_host": "example.com", "path": "/posts/1",
+		"q": "a b", "missing": "", "slug": "ueber-strasse-ete-2021"}}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v not %#v", results, expected)
+	}
+}
+
+func TestHashAndIDFuncs(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (id TEXT, ulid TEXT, hash TEXT)")
+	results := []map[string]interface{}{}
+	if err := Query(db, "SELECT md5('abc') AS md5, sha256(x'616263') AS sha256, crc32('abc') AS crc32", &results); err != nil {
+		t.Error(err)
+	} else if expected := []map[string]interface{}{{"md5": "900150983cd24fb0d6963f7d28e17f72",
+		"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", "crc32": 891568578.0}}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v not %#v", results, expected)
+	}
+	if _, err := db.Exec("INSERT INTO xs SELECT uuid4(), ulid(), sha256(value) FROM (SELECT 'a' AS value UNION ALL SELECT 'b')"); err != nil {
+		t.Fatal(err)
+	}
+	rows := []struct {
+		ID   string `db:"id"`
+		ULID string `db:"ulid"`
+	}{}
+	uuidRegexp, ulidRegexp := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+	if err := Query(db, "SELECT id, ulid FROM xs", &rows); err != nil || len(rows) != 2 {
+		t.Errorf("%#v %v", rows, err)
+	} else if rows[0].ID == rows[1].ID || rows[0].ULID == rows[1].ULID || rows[0].ULID[:6] != rows[1].ULID[:6] {
+		t.Errorf("expected unique ids with shared time prefix: %#v", rows)
+	} else if !uuidRegexp.MatchString(rows[0].ID) || !ulidRegexp.MatchString(rows[0].ULID) {
+		t.Errorf("malformed ids: %#v", rows)
+	}
+}
+
+func TestFuzzyFuncs(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE names (name TEXT)", "INSERT INTO names VALUES ('Jonathan'), ('Johnathan'), ('Jon'), ('Mary')")
+	results := []map[string]interface{}{}
+	query := `SELECT levenshtein('kitten', 'sitting') AS l, levenshtein('', 'abc') AS empty, levenshtein('ab', 'ba') AS swap_l,
+                     damerau_levenshtein('ab', 'ba') AS swap_d, damerau_levenshtein('ca', 'abc') AS osa,
+                     round(trigram_similarity('word', 'words'), 6) AS trgm, trigram_similarity('', '') AS trgm_empty`
+	if err := Query(db, query, &results); err != nil {
+		t.Error(err)
+	} else if expected := []map[string]interface{}{{"l": 3.0, "empty": 3.0, "swap_l": 2.0, "swap_d": 1.0, "osa": 3.0, "trgm": 0.571429, "trgm_empty": 0.0}}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v not %#v", results, expected)
+	}
+	pairs := []string{}
+	query = "SELECT a.name || '/' || b.name FROM names a JOIN names b ON a.name < b.name WHERE levenshtein(a.name, b.name) <= 2"
+	if err := Query(db, query, &pairs); err != nil {
+		t.Error(err)
+	} else if expected := []string{"Johnathan/Jonathan"}; !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("%#v not %#v", pairs, expected)
+	}
+}
+
+func TestTimeFuncs(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE events (at TIMESTAMP)")
+	if _, err := db.Exec("INSERT INTO events VALUES (?)", time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	results := []map[string]interface{}{}
+	query := `SELECT strptime_go('04/03/2021 06:06 +0100', '02/01/2006 15:04 -0700') AS parsed,
+                     strftime_go('2021-03-04 05:06:07', 'Mon Jan 2 2006') AS formatted, strftime_go(0, 'RFC3339') AS unix,
+                     (SELECT strftime_go(at, 'Kitchen') FROM events) AS stored,
+                     duration_parse('1h30m') AS seconds, humanize(93784) AS long, humanize(7200) AS round, humanize(0.25) AS short`
+	if err := Query(db, query, &results); err != nil {
+		t.Error(err)
+	} else if expected := []map[string]interface{}{{"parsed": "2021-03-04T05:06:00Z", "formatted": "Thu Mar 4 2021", "unix": "1970-01-01T00:00:00Z",
+		"stored": "5:06AM", "seconds": 5400.0, "long": "1d 2h", "round": "2h", "short": "250ms"}}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v not %#v", results, expected)
+	}
+	if err := Query(db, "SELECT strptime_go('x', 'RFC3339')", &results); err == nil {
+		t.Error("expected parse error")
+	}
+}
+
+func TestJSONFuncs(t *testing.T) {
+	db := openTestDB(t)
+	doc := `{"a": {"b": [1, 2.5], "c": {}}, "d": "x", "e": null, "big": 12345678901234567890}`
+	query := `SELECT json_merge(?, '{"a": {"c": 1}, "d": null}', '{"f": true}') AS merged, json_pick(?, 'd', 'missing') AS picked,
+                     json_omit(?, 'a', 'big') AS omitted, json_flatten(?) AS flat, json_typeof_deep(?) AS types,
+                     json_is_valid(?) AS valid, json_is_valid('{"a": 1} x') AS invalid`
+	results := []map[string]interface{}{}
+	if err := Query(db, query, &results, doc, doc, doc, doc, doc, doc); err != nil {
+		t.Error(err)
+	} else if expected := []map[string]interface{}{{
+		"merged":  `{"a":{"b":[1,2.5],"c":1},"big":12345678901234567890,"e":null,"f":true}`,
+		"picked":  `{"d":"x"}`,
+		"omitted": `{"d":"x","e":null}`,
+		"flat":    `{"a.b.0":1,"a.b.1":2.5,"a.c":{},"big":12345678901234567890,"d":"x","e":null}`,
+		"types":   `{"a":{"b":["number","number"],"c":{}},"big":"number","d":"string","e":"null"}`,
+		"valid":   1.0, "invalid": 0.0,
+	}}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v not %#v", results, expected)
+	}
+	if err := Query(db, "SELECT json_pick('[1]', 'a')", &results); err == nil || !strings.Contains(err.Error(), "not an object") {
+		t.Errorf("expected not an object error: %v", err)
+	}
+}
+
+func TestTableFuncs(t *testing.T) {
+	words := TableFunc{Columns: []string{"word"}, Args: []string{"text"}, Rows: func(args ...interface{}) (func() ([]interface{}, error), error) {
+		words := strings.Fields(fmt.Sprint(args[0]))
+		return func() ([]interface{}, error) {
+			if len(words) == 0 {
+				return nil, nil
+			}
+			word := words[0]
+			words = words[1:]
+			return []interface{}{word}, nil
+		}, nil
+	}}
+	db := &DB{DataSourceName: filepath.Join(t.TempDir(), "test.sqlite"), TableFuncs: map[string]TableFunc{"words": words}}
+	if err := db.Open(map[string]string{"000.sql": "CREATE TABLE posts (id INTEGER, body TEXT)", "001.sql": "INSERT INTO posts VALUES (1, 'a b'), (2, 'c')"}); err != nil {
+		if strings.Contains(err.Error(), "requires the sqlite_vtable build tag") {
+			t.Skip("built without the sqlite_vtable tag")
+		}
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close(); db.RODB.Close() })
+	results := []string{}
+	query := `SELECT group_concat(value) FROM generate_series(1, 10, 3)
+              UNION ALL SELECT group_concat(value) FROM generate_series(3, 1, -1)
+              UNION ALL SELECT group_concat(posts.id || word) FROM posts, words(posts.body)
+              UNION ALL SELECT group_concat(match || ':' || start || ':' || groups, ' ') FROM regexp_matches('a1 b22 c', '([a-z])(\d+)?')`
+	if err := Query(db.RODB, query, &results); err != nil {
+		t.Error(err)
+	} else if expected := []string{"1,4,7,10", "3,2,1", "1a,1b,2c", `a1:0:["a","1"] b22:3:["b","22"] c:7:["c",null]`}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v not %#v", results, expected)
+	}
+	if err := Query(db, "SELECT * FROM generate_series(1, 2, 0)", &results); err == nil || !strings.Contains(err.Error(), "step must not be 0") {
+		t.Errorf("expected step error: %v", err)
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	db := &DB{DataSourceName: filepath.Join(t.TempDir(), "test.sqlite"), Extensions: []string{"./does-not-exist"}}
+	if err := db.Open(nil); err == nil || !strings.Contains(err.Error(), "does-not-exist") {
+		t.Errorf("expected extension load error, got %v", err)
+	}
+	if db.DB != nil {
+		db.Close()
+		db.RODB.Close()
+	}
+}
+
+func TestManifest(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (id INTEGER PRIMARY KEY, name TEXT NOT NULL DEFAULT 'x')", "CREATE INDEX xs_name ON xs (name)")
+	m, err := ReadManifest(db)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	bs, _ := json.Marshal(m.Tables)
+	expected := `[{"name":"xs","sql":"CREATE TABLE xs (id INTEGER PRIMARY KEY, name TEXT NOT NULL DEFAULT 'x')",` +
+		`"columns":[{"name":"id","type":"INTEGER","notnull":0,"default":null,"pk":1},{"name":"name","type":"TEXT","notnull":1,"default":"'x'","pk":0}],` +
+		`"indexes":["CREATE INDEX xs_name ON xs (name)"]}]`
+	if string(bs) != expected {
+		t.Errorf("%s not %s", bs, expected)
+	}
+	if expected := []string{"000.sql", "001.sql"}; !reflect.DeepEqual(m.Migrations, expected) {
+		t.Errorf("%#v not %#v", m.Migrations, expected)
+	}
+	fresh := openTestDB(t)
+	if err := m.Verify(fresh); err == nil || err.Error() != "table xs does not exist" {
+		t.Errorf("unexpected verify error: %v", err)
+	}
+	if err := m.Create(fresh); err != nil {
+		t.Error(err)
+	} else if err := m.Verify(fresh); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (tenant INTEGER, id INTEGER, name TEXT, tags TEXT)")
+	csvData := "tenant,id,name\n1,1,a\n1,2,b\n"
+	if result, err := Import(db, "xs", strings.NewReader(csvData), ImportOptions{Format: "csv"}); err != nil {
+		t.Error(err)
+		return
+	} else if expected := (ImportResult{Inserted: 2}); result != expected {
+		t.Errorf("%#v not %#v", result, expected)
+	}
+	ndjson := `{"tenant": 1, "id": 1, "name": "a"}
+{"tenant": 1, "id": 2, "name": "B", "tags": ["x"]}
+{"tenant": 2, "id": 1, "name": "c"}
+`
+	opts := ImportOptions{Format: "ndjson", Keys: []string{"tenant", "id"}}
+	if result, err := Import(db, "xs", strings.NewReader(ndjson), opts); err != nil {
+		t.Error(err)
+		return
+	} else if expected := (ImportResult{Inserted: 1, Updated: 1, Skipped: 1}); result != expected {
+		t.Errorf("%#v not %#v", result, expected)
+	}
+	results := []map[string]interface{}{}
+	if err := Query(db, "SELECT tenant, id, name, tags FROM xs ORDER BY tenant, id", &results); err != nil {
+		t.Error(err)
+	} else if expected := []map[string]interface{}{
+		{"tenant": 1.0, "id": 1.0, "name": "a", "tags": nil},
+		{"tenant": 1.0, "id": 2.0, "name": "B", "tags": `["x"]`},
+		{"tenant": 2.0, "id": 1.0, "name": "c", "tags": nil},
+	}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v not %#v", results, expected)
+	}
+	if _, err := Import(db, "xs", strings.NewReader(`{"id": 1}`), opts); err == nil || err.Error() != "record 1: missing key column tenant" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+type recordingConnection struct{ queries []string }
+
+func (c *recordingConnection) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	c.queries = append(c.queries, query)
+	return nil, errors.New("not implemented")
+}
+
+func (c *recordingConnection) Exec(query string, args ...interface{}) (sql.Result, error) {
+	c.queries = append(c.queries, fmt.Sprintf("%s %v", query, args))
+	return nil, nil
+}
+
+func TestDialect(t *testing.T) {
+	type member struct {
+		TenantID int    `db:"tenant_id,pk"`
+		ID       int    `db:"id,pk"`
+		Name     string `db:"name"`
+	}
+	m := member{1, 2, "a"}
+	for d, expected := range map[Dialect][]string{
+		Postgres: {
+			`INSERT  INTO "members" ("tenant_id", "id", "name") VALUES ($1, $2, $3) [1 2 a]`,
+			`UPDATE "members" SET "name" = $1 WHERE "tenant_id" = $2 AND "id" = $3 [a 1 2]`,
+			`INSERT INTO "members" ("tenant_id", "id", "name") VALUES ($1, $2, $3) ON CONFLICT ("tenant_id", "id") DO UPDATE SET "name" = excluded."name" [1 2 a]`,
+		},
+		MySQL: {
+			"INSERT  INTO `members` (`tenant_id`, `id`, `name`) VALUES (?, ?, ?) [1 2 a]",
+			"UPDATE `members` SET `name` = ? WHERE `tenant_id` = ? AND `id` = ? [a 1 2]",
+			"INSERT INTO `members` (`tenant_id`, `id`, `name`) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE `name` = VALUES(`name`) [1 2 a]",
+		},
+	} {
+		rc := &recordingConnection{}
+		c := WithDialect(rc, d)
+		Insert(c, "members", m, "")
+		Update(c, "members", m)
+		Upsert(c, "members", m)
+		if !reflect.DeepEqual(rc.queries, expected) {
+			t.Errorf("%#v not %#v", rc.queries, expected)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (id INTEGER PRIMARY KEY, x TEXT)")
+	d := &DryRun{Connection: db}
+	if _, err := Insert(d, "xs", map[string]interface{}{"x": "a"}, ""); err != nil {
+		t.Error(err)
+	}
+	expected := []Statement{{`INSERT  INTO "xs" ("x") VALUES (?)`, []interface{}{"a"}}}
+	if !reflect.DeepEqual(d.Statements, expected) {
+		t.Errorf("%#v not %#v", d.Statements, expected)
+	}
+	statements, err := db.DryRun(context.Background(), func(c Connection) error {
+		if _, err := Exec(c, "INSERT INTO xs (x) VALUES (?)", "b"); err != nil {
+			return err
+		}
+		counts := []int{}
+		if err := Query(c, "SELECT count(*) FROM xs", &counts); err != nil || counts[0] != 1 {
+			t.Errorf("expected insert to be visible inside dry run: %v %v", counts, err)
+		}
+		return nil
+	})
+	if err != nil || len(statements) != 1 {
+		t.Errorf("%#v %v", statements, err)
+	}
+	counts := []int{}
+	if err := Query(db, "SELECT count(*) FROM xs", &counts); err != nil || counts[0] != 0 {
+		t.Errorf("expected dry runs to leave no rows: %v %v", counts, err)
+	}
+}
+
+func TestKeyWithoutSQLCipher(t *testing.T) {
+	db := &DB{DataSourceName: filepath.Join(t.TempDir(), "test.sqlite"), Key: "secret"}
+	if err := db.Open(nil); !errors.Is(err, ErrNoSQLCipher) {
+		t.Errorf("expected ErrNoSQLCipher, got %v", err)
+	}
+	if db.DB != nil {
+		db.Close()
+		db.RODB.Close()
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x TEXT)", "INSERT INTO xs VALUES ('a')")
+	path, calls := filepath.Join(t.TempDir(), "backup.sqlite"), 0
+	if err := db.BackupTo(path, func(remaining, total int) { calls++ }); err != nil || calls == 0 {
+		t.Errorf("backup failed: %v (%d progress calls)", err, calls)
+		return
+	}
+	if _, err := db.Exec("DELETE FROM xs"); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "invalid.sqlite"), []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{filepath.Join(dir, "missing.sqlite"), filepath.Join(dir, "invalid.sqlite"), dir} {
+		if err := db.Restore(p, nil); err == nil {
+			t.Errorf("expected error restoring from %s", p)
+		}
+	}
+	if err := db.Restore(path, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	xs := []string{}
+	if err := Query(db.RODB, "SELECT x FROM xs", &xs); err != nil {
+		t.Error(err)
+	} else if expected := []string{"a"}; !reflect.DeepEqual(xs, expected) {
+		t.Errorf("%#v not %#v", xs, expected)
+	}
+}
+
+func TestWAL(t *testing.T) {
+	db := openTestDB(t, "-- +notransaction\nPRAGMA journal_mode = WAL", "CREATE TABLE xs (x TEXT)")
+	for i := 0; i < 10; i++ {
+		if _, err := db.Exec("INSERT INTO xs VALUES (?)", strings.Repeat("x", 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := db.WALInfo()
+	if err != nil || !info.Enabled || info.Size == 0 || info.Frames < 10 || info.LastCheckpoint != nil {
+		t.Errorf("%#v %v", info, err)
+	}
+	c, err := db.Checkpoint(CheckpointTruncate)
+	if err != nil || c.Busy || c.LogFrames != 0 || c.Mode != CheckpointTruncate {
+		t.Errorf("%#v %v", c, err)
+	}
+	info, err = db.WALInfo()
+	if err != nil || info.Size != 0 || info.Frames != 0 || info.Checkpoints != 1 || info.LastCheckpoint == nil {
+		t.Errorf("%#v %v", info, err)
+	}
+	if _, err := db.Exec("INSERT INTO xs VALUES ('x')"); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Checkpoint(CheckpointPassive); err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := db.walInfo(time.Hour); err != nil || cached.Size != 0 || cached.Checkpoints != 2 {
+		t.Errorf("expected cached wal size with current checkpoint stats: %#v %v", cached, err)
+	}
+	if _, err := db.Checkpoint("NOW"); err == nil {
+		t.Error("expected invalid mode to fail")
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	db := openTestDB(t, "-- +notransaction\nPRAGMA journal_mode = WAL", "CREATE TABLE xs (x TEXT)")
+	runs := make(chan string, 10)
+	stop := db.StartMaintenance(Maintenance{
+		CheckpointInterval: time.Millisecond,
+		OptimizeInterval:   time.Millisecond,
+		OnRun: func(task string, _ time.Duration, err error) {
+			if err != nil {
+				t.Errorf("%s: %s", task, err)
+			}
+			select {
+			case runs <- task:
+			default:
+			}
+		},
+	})
+	seen := map[string]bool{}
+	for timeout := time.After(time.Second); len(seen) < 2; {
+		select {
+		case task := <-runs:
+			seen[task] = true
+		case <-timeout:
+			t.Fatalf("maintenance did not run: %v", seen)
+		}
+	}
+	stop()
+}
+
+func TestReplicate(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x TEXT)", "INSERT INTO xs VALUES ('a'), ('b'), ('c')")
+	dest, snapshots := DirDestination(filepath.Join(t.TempDir(), "replica")), make(chan error, 10)
+	stop, err := db.Replicate(Replication{Destination: dest, Interval: time.Millisecond, OnSnapshot: func(name string, err error) { snapshots <- err }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-snapshots:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no snapshot taken")
+	}
+	stop()
+	if stop, err := db.Replicate(Replication{Destination: dest}); err != nil {
+		t.Fatal(err)
+	} else {
+		stop()
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := db.snapshot(Replication{Destination: dest, Retain: 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if names, err := snapshotNames(dest); err != nil || len(names) != 2 {
+		t.Errorf("expected 2 retained snapshots: %v %v", names, err)
+	}
+	path := filepath.Join(t.TempDir(), "restored.sqlite")
+	if _, err := RestoreLatest(dest, path); err != nil {
+		t.Fatal(err)
+	}
+	restored := &DB{DataSourceName: path, ReadOnly: true}
+	if err := restored.Open(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	defer restored.RODB.Close()
+	xs := []string{}
+	if err := Query(restored.RODB, "SELECT x FROM xs", &xs); err != nil {
+		t.Error(err)
+	} else if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(xs, expected) {
+		t.Errorf("%#v not %#v", xs, expected)
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x TEXT)")
+	changes, invisible := make(chan Change, 10), int64(0)
+	db.OnChange(func(c Change) {
+		if n := []int{}; c.Op == "INSERT" && (Query(db.RODB, "SELECT count(*) FROM xs WHERE rowid = ?", &n, c.RowID) != nil || n[0] != 1) {
+			atomic.AddInt64(&invisible, 1)
+		}
+	})
+	db.OnChange(func(c Change) { changes <- c })
+	if _, err := db.Exec("INSERT INTO xs VALUES ('a'), ('b')"); err != nil {
+		t.Fatal(err)
+	}
+	db.Transact(context.Background(), TxOptions{}, func(c Connection) error {
+		Exec(c, "DELETE FROM xs")
+		return errors.New("rollback")
+	})
+	if _, err := db.Exec("UPDATE xs SET x = 'c' WHERE rowid = 2"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{{"INSERT", "xs", 1}, {"INSERT", "xs", 2}, {"UPDATE", "xs", 2}}
+	for _, e := range expected {
+		select {
+		case c := <-changes:
+			if c != e {
+				t.Errorf("%#v not %#v", c, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %#v", e)
+		}
+	}
+	if n := atomic.LoadInt64(&invisible); n != 0 {
+		t.Errorf("expected changes to be delivered after commit: %d inserts not visible", n)
+	}
+}
+
+func TestLiveHandler(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x TEXT)")
+	db.ListenInterval = time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(db.LiveHandler))
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"?query=SELECT+x+FROM+xs", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	scanner := bufio.NewScanner(res.Body)
+	next := func() string {
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+				return strings.TrimPrefix(line, "data: ")
+			}
+		}
+		return ""
+	}
+	if data := next(); data != "[]" {
+		t.Errorf("%s not []", data)
+	}
+	if _, err := db.Exec("INSERT INTO xs VALUES ('a')"); err != nil {
+		t.Fatal(err)
+	}
+	if data := next(); data != `[{"x":"a"}]` {
+		t.Errorf(`%s not [{"x":"a"}]`, data)
+	}
+	db.HandlerLimit = &Limiter{Max: 1, Reject: true}
+	release, _ := db.HandlerLimit.Acquire(context.Background())
+	defer release()
+	w := httptest.NewRecorder()
+	db.LiveHandler(w, httptest.NewRequest("GET", "/?query=SELECT+1", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("%d not %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestHandlerPost(t *testing.T) {
+	db := openTestDB(t)
+	for body, expected := range map[string]string{
+		`{"query": "SELECT ? + 1 AS i, typeof(?) AS f, ? IS NULL AS n, ? AS b, ? AS o", "args": [1, 1.5, null, true, {"a": 2}]}`: `[{"b":1,"f":"real","i":2,"n":1,"o":{"a":2}}]`,
+		`{"args": []}`: `{"error":"invalid request body: missing query"}`,
+	} {
+		w := httptest.NewRecorder()
+		db.Handler(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		if actual := strings.TrimSpace(w.Body.String()); actual != expected {
+			t.Errorf("%s: %s not %s", body, actual, expected)
+		}
+	}
+}
+
+func TestHandlerNDJSON(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x TEXT, j TEXT)", `INSERT INTO xs VALUES ('a', '{"k":1}'), ('b', NULL)`)
+	for path, expected := range map[string]string{
+		"/?format=ndjson&query=SELECT+x,+j+FROM+xs": "{\"j\":{\"k\":1},\"x\":\"a\"}\n{\"j\":null,\"x\":\"b\"}\n",
+		"/?format=ndjson&query=SELECT+y+FROM+xs":    "{\"error\":\"SELECT y FROM xs: no such column: y\"}\n",
+	} {
+		w := httptest.NewRecorder()
+		db.Handler(w, httptest.NewRequest("GET", path, nil))
+		if actual := w.Body.String(); actual != expected {
+			t.Errorf("%s: %q not %q", path, actual, expected)
+		}
+	}
+}
+
+func TestHandlerCSV(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x TEXT, n INTEGER)", `INSERT INTO xs VALUES ('a,"b"', 1), ('c	d', NULL)`)
+	for path, expected := range map[string]string{
+		"/?format=csv&query=SELECT+x,+n+FROM+xs": "x,n\n\"a,\"\"b\"\"\",1\nc\td,\n",
+		"/?format=tsv&query=SELECT+x,+n+FROM+xs": "x\tn\n\"a,\"\"b\"\"\"\t1\n\"c\td\"\t\n",
+		"/?format=xml&query=SELECT+x+FROM+xs":    "{\"error\":\"SELECT x FROM xs: unhandled format \\\"xml\\\"\"}\n",
+	} {
+		w := httptest.NewRecorder()
+		db.Handler(w, httptest.NewRequest("GET", path, nil))
+		if actual := w.Body.String(); actual != expected {
+			t.Errorf("%s: %q not %q", path, actual, expected)
+		}
+	}
+}
+
+func TestHandlerLimits(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)", "INSERT INTO xs VALUES (1), (2), (3)")
+	db.HandlerMaxRows, db.HandlerTimeout = 2, 50*time.Millisecond
+	db.HandlerLimit = &Limiter{Max: 1, Reject: true}
+	slow := url.QueryEscape("SELECT count(*) FROM xs" + strings.Repeat(", xs", 20))
+	for path, expected := range map[string]int{
+		"/?query=SELECT+x+FROM+xs+WHERE+x+<+3": http.StatusOK,
+		"/?query=SELECT+x+FROM+xs":             http.StatusRequestEntityTooLarge,
+		"/?query=SELECT+x+FROM+xs&limit=5":     http.StatusOK,
+		"/?query=" + slow:                      http.StatusRequestTimeout,
+		"/?query=PRAGMA+table_info(xs)":        http.StatusOK,
+		"/?query=EXPLAIN+QUERY+PLAN+SELECT+1":  http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		db.Handler(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != expected {
+			t.Errorf("%s: %d not %d (%s)", path, w.Code, expected, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	db.Handler(w, httptest.NewRequest("GET", "/?format=ndjson&query=SELECT+x+FROM+xs", nil))
+	if expected := "{\"x\":1}\n{\"x\":2}\n{\"error\":\"SELECT * FROM (SELECT x FROM xs) LIMIT 3: result exceeds 2 rows\"}\n"; w.Body.String() != expected {
+		t.Errorf("%q not %q", w.Body.String(), expected)
+	}
+	release, _ := db.HandlerLimit.Acquire(context.Background())
+	defer release()
+	w = httptest.NewRecorder()
+	db.Handler(w, httptest.NewRequest("GET", "/?query=SELECT+1", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("%d not %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestHandlerTruncate(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)", "INSERT INTO xs VALUES (1), (2), (3)")
+	db.HandlerMaxRows, db.HandlerTruncate, db.HandlerCountTotal = 2, true, true
+	for path, expected := range map[string]string{
+		"/?query=SELECT+x+FROM+xs":                     `{"rows":[{"x":1},{"x":2}],"truncated":true,"total":3}`,
+		"/?query=SELECT+x+FROM+xs+WHERE+x+<+2":         `{"rows":[{"x":1}],"truncated":false}`,
+		"/?query=SELECT+x+FROM+xs&format=rows":         `{"columns":["x"],"rows":[[1],[2]],"truncated":true}`,
+		"/?query=SELECT+x+FROM+xs+LIMIT+1&format=rows": `{"columns":["x"],"rows":[[1]]}`,
+		"/?query=SELECT+x+FROM+xs&format=ndjson":       "{\"x\":1}\n{\"x\":2}",
+	} {
+		w := httptest.NewRecorder()
+		db.Handler(w, httptest.NewRequest("GET", path, nil))
+		if actual := strings.TrimSpace(w.Body.String()); w.Code != http.StatusOK || actual != expected {
+			t.Errorf("%s: %d %s not %s", path, w.Code, actual, expected)
+		}
+	}
+	w := httptest.NewRecorder()
+	db.Handler(w, httptest.NewRequest("GET", "/?query=PRAGMA+table_info(_migrations)", nil))
+	if body := strings.TrimSpace(w.Body.String()); w.Code != http.StatusOK || !strings.HasSuffix(body, `"truncated":true,"total":4}`) {
+		t.Errorf("expected total of statement that cannot be limited: %d %s", w.Code, body)
+	}
+	db.HandlerCountTotal = false
+	w = httptest.NewRecorder()
+	db.Handler(w, httptest.NewRequest("GET", "/?query=SELECT+x+FROM+xs", nil))
+	if actual, expected := strings.TrimSpace(w.Body.String()), `{"rows":[{"x":1},{"x":2}],"truncated":true}`; actual != expected {
+		t.Errorf("expected no total without HandlerCountTotal: %s not %s", actual, expected)
+	}
+}
+
+func TestEncoderOptions(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (id INTEGER, t TIMESTAMP, j TEXT, n TEXT)",
+		"INSERT INTO xs VALUES (9007199254740993, '2020-01-01T00:00:00Z', '{\"id\": 9007199254740993}', NULL)")
+	db.Encoder = &EncoderOptions{Integers: true, OmitNulls: true, TimeFormat: "unix"}
+	for path, expected := range map[string]string{
+		"/?query=SELECT+*+FROM+xs":               `[{"id":9007199254740993,"j":{"id":9007199254740993},"t":1577836800}]`,
+		"/?query=SELECT+*+FROM+xs&format=rows":   `{"columns":["id","t","j","n"],"rows":[[9007199254740993,1577836800,{"id":9007199254740993},null]]}`,
+		"/?query=SELECT+*+FROM+xs&format=ndjson": `{"id":9007199254740993,"j":{"id":9007199254740993},"t":1577836800}`,
+	} {
+		w := httptest.NewRecorder()
+		db.Handler(w, httptest.NewRequest("GET", path, nil))
+		if actual := strings.TrimSpace(w.Body.String()); w.Code != http.StatusOK || actual != expected {
+			t.Errorf("%s: %d %s not %s", path, w.Code, actual, expected)
+		}
+	}
+	b := &bytes.Buffer{}
+	o := PrintOptions{Encoder: &EncoderOptions{TimeFormat: "2006-01-02"}}
+	if err := Fprint(b, db, o, "SELECT id, t, n FROM xs"); err != nil {
+		t.Fatal(err)
+	} else if expected := `{"id":9007199254740992,"n":null,"t":"2020-01-01"}`; strings.TrimSpace(b.String()) != expected {
+		t.Errorf("%s not %s", b.String(), expected)
+	}
+}
+
+func TestHandlerETag(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)", "INSERT INTO xs VALUES (1)")
+	db.HandlerETag, db.HandlerCacheControl = true, "max-age=5"
+	get := func(etag string) *httptest.ResponseRecorder {
+		w, r := httptest.NewRecorder(), httptest.NewRequest("GET", "/?query=SELECT+x+FROM+xs", nil)
+		r.Header.Set("If-None-Match", etag)
+		db.Handler(w, r)
+		return w
+	}
+	w := get("")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Header().Get("Cache-Control") != "max-age=5" {
+		t.Errorf("%d %q %q", w.Code, etag, w.Header())
+	}
+	if w := get(etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("%d not %d (%s)", w.Code, http.StatusNotModified, w.Body.String())
+	}
+	if _, err := db.Exec("INSERT INTO xs VALUES (2)"); err != nil {
+		t.Fatal(err)
+	}
+	if w := get(etag); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("%d %q: expected new etag after write", w.Code, w.Header().Get("ETag"))
+	}
+	w = httptest.NewRecorder()
+	db.Handler(w, httptest.NewRequest("GET", "/?query=SELECT+missing", nil))
+	if w.Code != http.StatusBadRequest || w.Header().Get("ETag") != "" || w.Header().Get("Cache-Control") != "" {
+		t.Errorf("expected errors not to be cached: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestHandlerNamedQueries(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER, s TEXT)", "INSERT INTO xs VALUES (1, 'a'), (2, 'b'), (3, 'c')")
+	db.HandlerQueries = map[string]NamedQuery{
+		"top": {"SELECT s FROM xs WHERE x >= ? ORDER BY x DESC LIMIT ?", []QueryParam{{"min", "int"}, {"limit", "int"}}},
+		"all": {"SELECT s FROM xs ORDER BY x", nil},
+	}
+	for path, expected := range map[string]string{
+		"/query/top?min=2&limit=1":           `[{"s":"c"}]`,
+		"/query/top?limit=1":                 `{"error":"missing parameter min"}`,
+		"/query/top?min=x&limit=1":           `{"error":"invalid parameter min: strconv.ParseInt: parsing \"x\": invalid syntax"}`,
+		"/query/all?query=DELETE+FROM+xs":    `[{"s":"a"},{"s":"b"},{"s":"c"}]`,
+		"/query/all?limit=2":                 `[{"s":"a"},{"s":"b"}]`,
+		"/query/none?query=SELECT+*+FROM+xs": `{"error":"unknown query"}`,
+	} {
+		w := httptest.NewRecorder()
+		db.Handler(w, httptest.NewRequest("GET", path, nil))
+		if actual := strings.TrimSpace(w.Body.String()); actual != expected {
+			t.Errorf("%s: %s not %s", path, actual, expected)
+		}
+	}
+}
+
+func TestWriteHandler(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER PRIMARY KEY, s TEXT)")
+	post := func(auth, body string) string {
+		w, r := httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Authorization", auth)
+		db.WriteHandler(w, r)
+		return fmt.Sprintf("%d %s", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	insert := `{"query": "INSERT INTO xs (s) VALUES (?), (?)", "args": ["a", "b"]}`
+	if actual, expected := post("Bearer secret", insert), `401 {"error":"unauthorized"}`; actual != expected {
+		t.Errorf("%s not %s", actual, expected)
+	}
+	db.WriteAuth = TokenAuth("secret")
+	for _, x := range [][2]string{
+		{insert, `200 {"last_insert_id":2,"rows_affected":2}`},
+		{`{"query": "SELECT * FROM xs"}`, `400 {"error":"only INSERT, UPDATE, DELETE and REPLACE statements are allowed"}`},
+		{`{"query": "DELETE FROM xs; DROP TABLE xs"}`, `400 {"error":"expected a single statement"}`},
+		{`{"query": "UPDATE xs SET s = 'c' WHERE x = 1;"}`, `200 {"last_insert_id":2,"rows_affected":1}`},
+	} {
+		if actual := post("Bearer secret", x[0]); actual != x[1] {
+			t.Errorf("%s: %s not %s", x[0], actual, x[1])
+		}
+	}
+	if actual, expected := post("Bearer wrong", insert), `401 {"error":"unauthorized"}`; actual != expected {
+		t.Errorf("%s not %s", actual, expected)
+	}
+}
+
+func TestRESTHandler(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (id INTEGER PRIMARY KEY, s TEXT, n INTEGER)", "CREATE TABLE hidden (x)",
+		"CREATE TABLE ys (a TEXT, b INTEGER, n INTEGER, PRIMARY KEY (a, b)) WITHOUT ROWID", "CREATE TABLE zs (s TEXT)")
+	db.WriteAuth = TokenAuth("secret")
+	h := db.RESTHandler("xs", "ys", "zs")
+	do := func(method, path, body string) string {
+		w, r := httptest.NewRecorder(), httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		h.ServeHTTP(w, r)
+		return fmt.Sprintf("%d %s", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	for _, x := range [][4]string{
+		{"POST", "/xs", `{"s": "a", "n": 2}`, `201 {"id":1,"n":2,"s":"a"}`},
+		{"POST", "/xs", `{"s": "b", "n": 1}`, `201 {"id":2,"n":1,"s":"b"}`},
+		{"GET", "/xs?sort=-n&limit=1", "", `200 [{"id":1,"n":2,"s":"a"}]`},
+		{"GET", "/xs?s=b", "", `200 [{"id":2,"n":1,"s":"b"}]`},
+		{"GET", "/xs?foo=b", "", `400 {"error":"unknown column foo"}`},
+		{"PATCH", "/xs/2", `{"s": "c"}`, `200 {"id":2,"n":1,"s":"c"}`},
+		{"DELETE", "/xs/1", "", `204 `},
+		{"GET", "/xs/1", "", `404 {"error":"xs 1: not found"}`},
+		{"GET", "/hidden", "", `404 {"error":"unknown table hidden"}`},
+		{"POST", "/ys", `{"a": "x", "b": 1, "n": 1}`, `201 {"a":"x","b":1,"n":1}`},
+		{"POST", "/ys", `{"n": 1}`, `400 {"error":"INSERT INTO \"ys\" (\"n\") VALUES (?): NOT NULL constraint failed: ys.a"}`},
+		{"PATCH", "/ys/x/1", `{"b": 2}`, `200 {"a":"x","b":2,"n":1}`},
+		{"GET", "/ys/x", "", `404 {"error":"ys x: not found"}`},
+		{"DELETE", "/ys/x/2", "", `204 `},
+		{"POST", "/zs", `{"s": "a"}`, `201 {"s":"a"}`},
+		{"GET", "/zs/1", "", `200 {"s":"a"}`},
+	} {
+		if actual := do(x[0], x[1], x[2]); actual != x[3] {
+			t.Errorf("%s %s: %s not %s", x[0], x[1], actual, x[3])
+		}
+	}
+}
+
+func TestCORSAndCompress(t *testing.T) {
+	db := openTestDB(t)
+	h := CORS(Compress(http.HandlerFunc(db.Handler)), CORSOptions{Origins: []string{"http://example.com"}, MaxAge: time.Minute})
+	w, r := httptest.NewRecorder(), httptest.NewRequest("OPTIONS", "/", nil)
+	r.Header.Set("Origin", "http://example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "http://example.com" || w.Header().Get("Access-Control-Max-Age") != "60" {
+		t.Errorf("bad preflight response: %d %v", w.Code, w.Header())
+	}
+	w, r = httptest.NewRecorder(), httptest.NewRequest("GET", "/?query=SELECT+1+AS+x", nil)
+	r.Header.Set("Origin", "http://other.com")
+	r.Header.Set("Accept-Encoding", "deflate;q=0.5, gzip")
+	h.ServeHTTP(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("bad response headers: %v", w.Header())
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs, err := ioutil.ReadAll(gr); err != nil || strings.TrimSpace(string(bs)) != `[{"x":1}]` {
+		t.Errorf("%q %v", bs, err)
+	}
+
+	h = CORS(http.HandlerFunc(db.Handler), CORSOptions{Origins: []string{"*", "http://example.com"}, Credentials: true})
+	for origin, expected := range map[string][2]string{"http://other.com": {"*", ""}, "http://example.com": {"http://example.com", "true"}} {
+		w, r = httptest.NewRecorder(), httptest.NewRequest("GET", "/?query=SELECT+1", nil)
+		r.Header.Set("Origin", origin)
+		h.ServeHTTP(w, r)
+		if actual := [2]string{w.Header().Get("Access-Control-Allow-Origin"), w.Header().Get("Access-Control-Allow-Credentials")}; actual != expected {
+			t.Errorf("%s: %v not %v", origin, actual, expected)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (id INTEGER PRIMARY KEY, s TEXT, f REAL, b BLOB)")
+	db.WriteAuth = TokenAuth("secret")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/query", db.Handler)
+	mux.HandleFunc("/write", db.WriteHandler)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	c := &Client{URL: server.URL + "/query", WriteURL: server.URL + "/write", Header: http.Header{"Authorization": {"Bearer secret"}}}
+	if err := c.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if result, err := Exec(c, "INSERT INTO xs (s, f) VALUES (?, ?), (?, NULL)", "a", 1.5, "b"); err != nil {
+		t.Fatal(err)
+	} else if id, _ := result.LastInsertId(); id != 2 {
+		t.Errorf("%d not 2", id)
+	}
+	results := []struct {
+		ID int64    `db:"id"`
+		S  string   `db:"s"`
+		F  *float64 `db:"f"`
+	}{}
+	if err := Query(c, "SELECT id, s, f FROM xs WHERE id >= ? ORDER BY id", &results, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].S != "a" || *results[0].F != 1.5 || results[1].F != nil {
+		t.Errorf("unexpected results %#v", results)
+	}
+	if err := Query(c, "SELECT y FROM xs", &results); err == nil || !strings.Contains(err.Error(), "no such column: y") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := Exec(c, "UPDATE xs SET b = CASE id WHEN 1 THEN x'0102' END, s = CASE id WHEN 2 THEN 'AQI=' ELSE s END"); err != nil {
+		t.Fatal(err)
+	}
+	blobs := []struct {
+		S string `db:"s"`
+		B []byte `db:"b"`
+	}{}
+	if err := Query(c, "SELECT s, b FROM xs ORDER BY id", &blobs); err != nil {
+		t.Fatal(err)
+	} else if len(blobs) != 2 || !bytes.Equal(blobs[0].B, []byte{1, 2}) || blobs[1].S != "AQI=" || blobs[1].B != nil {
+		t.Errorf("unexpected results %#v", blobs)
+	}
+	b, s := interface{}(nil), interface{}(nil)
+	if err := c.QueryRow("SELECT (SELECT b FROM xs WHERE id = 1), (SELECT s FROM xs WHERE id = 2)").Scan(&b, &s); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(b, []byte{1, 2}) || s != "AQI=" {
+		t.Errorf("unexpected values %#v %#v", b, s)
+	}
+	if _, err := Exec(c, "UPDATE xs SET b = ?", []byte{1}); err == nil || !strings.HasSuffix(err.Error(), "blob args are not supported by remote connections: arg 1") {
+		t.Errorf("unexpected error %v", err)
+	}
+	c.Header = nil
+	if _, err := Exec(c, "DELETE FROM xs"); err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestQueryHook(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)")
+	calls := []string{}
+	db.QueryHook = func(query string, args []interface{}, d time.Duration, rows int, err error) {
+		calls = append(calls, fmt.Sprintf("%s %v %d %v", query, args, rows, err != nil))
+	}
+	if _, err := Insert(db, "xs", map[string]interface{}{"x": 1}, ""); err != nil {
+		t.Fatal(err)
+	}
+	Exec(db, "INSERT INTO xs VALUES (?), (?)", 2, 3)
+	Query(db, "SELECT x FROM xs WHERE x > ?", &[]int{}, 1)
+	Query(db, "SELECT y FROM xs", &[]int{})
+	expected := []string{
+		"INSERT  INTO \"xs\" (\"x\") VALUES (?) [1] 1 false",
+		"INSERT INTO xs VALUES (?), (?) [2 3] 2 false",
+		"SELECT x FROM xs WHERE x > ? [1] 2 false",
+		"SELECT y FROM xs [] 0 true",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("%#v not %#v", calls, expected)
+	}
+}
+
+func TestSlowQuery(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)", "CREATE TABLE ys (y INTEGER PRIMARY KEY)")
+	slow := []SlowQuery{}
+	db.SlowQueryThreshold, db.OnSlowQuery = time.Nanosecond, func(q SlowQuery) { slow = append(slow, q) }
+	Query(db, "SELECT x FROM xs JOIN ys ON y = x WHERE x = ?", &[]int{}, 1)
+	if plan := []string{"SEARCH TABLE ys USING INTEGER PRIMARY KEY (rowid=?)", "SCAN TABLE xs"}; len(slow) != 1 || !reflect.DeepEqual(slow[0].Plan, plan) {
+		t.Errorf("unexpected slow queries: %#v", slow)
+	}
+	out := &bytes.Buffer{}
+	db.Logger, db.OnSlowQuery = log.New(out, "", 0), nil
+	Exec(db, "INSERT INTO xs VALUES (1)")
+	if !strings.Contains(out.String(), "SLOW QUERY") || !strings.Contains(out.String(), "INSERT INTO xs VALUES (1)") {
+		t.Errorf("unexpected log output: %s", out.String())
+	}
+}
+
+type testTracer struct{ spans []string }
+
+type testSpan struct {
+	t    *testTracer
+	name string
+}
+
+func (t *testTracer) Start(ctx context.Context, operation, query string) (context.Context, Span) {
+	return ctx, testSpan{t, operation + " " + query}
+}
+
+func (s testSpan) End(rows int, err error) {
+	s.t.spans = append(s.t.spans, fmt.Sprintf("%s: %d %v", s.name, rows, err != nil))
+}
+
+func TestTracer(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER)")
+	tracer := &testTracer{}
+	db.Tracer = tracer
+	Exec(db, "INSERT INTO xs VALUES (1), (2)")
+	Query(db, "SELECT x FROM xs", &[]int{})
+	db.Transact(context.Background(), TxOptions{}, func(c Connection) error { return errors.New("rollback") })
+	expected := []string{"exec INSERT INTO xs VALUES (1), (2): 2 false", "query SELECT x FROM xs: 2 false", "transaction : 0 true"}
+	if !reflect.DeepEqual(tracer.spans, expected) {
+		t.Errorf("%#v not %#v", tracer.spans, expected)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (x INTEGER, s TEXT)")
+	db.Metrics = &Metrics{}
+	Exec(db, "INSERT INTO xs VALUES (1, 'a')")
+	Exec(db, "INSERT INTO xs VALUES (2.5, 'b') -- comment")
+	Query(db, "SELECT y FROM xs", &[]int{})
+	queries, errs, _, fingerprints := db.Metrics.Snapshot()
+	if queries != 3 || errs != 1 || fingerprints["INSERT INTO xs VALUES (?, ?)"].Count != 2 || fingerprints["SELECT y FROM xs"].Errors != 1 {
+		t.Errorf("unexpected metrics: %d %d %#v", queries, errs, fingerprints)
+	}
+	v := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(db.MetricsVar().String()), &v); err != nil || v["queries"] != 3.0 || v["ro_pool"] == nil {
+		t.Errorf("unexpected expvar: %v %v", v, err)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE xs (id INTEGER PRIMARY KEY, a TEXT, b INTEGER, c INTEGER)", "CREATE INDEX xs_c ON xs (c)")
+	for query, expected := range map[string][]Advice{
+		"SELECT * FROM xs WHERE b > 1 AND a = 'x' ORDER BY c": {},
+		"SELECT * FROM xs WHERE b > 1 AND a = 'x'":            {{"xs", "SCAN TABLE xs", []string{"a", "b"}, `CREATE INDEX "xs_a_b_idx" ON "xs" ("a", "b")`}},
+		"SELECT * FROM xs AS x WHERE x.c = 1":                 {},
+		"SELECT * FROM xs x WHERE 2 < x.b":                    {{"xs", "SCAN TABLE xs AS x", []string{"b"}, `CREATE INDEX "xs_b_idx" ON "xs" ("b")`}},
+	} {
+		advice, err := db.Analyze(query)
+		if err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(advice, expected) {
+			t.Errorf("%s: %#v not %#v", query, advice, expected)
+		}
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	db := &DB{DataSourceName: filepath.Join(t.TempDir(), "test.sqlite"), Metrics: &Metrics{}}
+	if err := db.Open(map[string]string{"001.sql": "CREATE TABLE xs (x INTEGER)"}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer db.RODB.Close()
+	for i := 0; i < 3; i++ {
+		Exec(db, fmt.Sprintf("INSERT INTO xs VALUES (%d)", i))
+	}
+	Query(db, "SELECT x FROM xs WHERE x = 'a'", &[]int{})
+	calls := map[string]int64{}
+	for _, s := range db.QueryStats() {
+		calls[s.Fingerprint] = s.Count
+	}
+	if calls["INSERT INTO xs VALUES (?)"] != 3 || calls["SELECT x FROM xs WHERE x = ?"] != 1 {
+		t.Errorf("unexpected stats %#v", db.QueryStats())
+	}
+	results := []struct {
+		Fingerprint string `db:"fingerprint"`
+		Calls       int    `db:"calls"`
+	}{}
+	if err := Query(db.RODB, "SELECT fingerprint, calls FROM _query_stats WHERE fingerprint LIKE '% xs %' ORDER BY calls DESC", &results); err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		Fingerprint string `db:"fingerprint"`
+		Calls       int    `db:"calls"`
+	}{{"INSERT INTO xs VALUES (?)", 3}, {"SELECT x FROM xs WHERE x = ?", 1}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v not %#v", results, expected)
+	}
+	db.Metrics.MaxFingerprints = 20000
+	for i := 0; i < 10001; i++ {
+		db.Metrics.record(fmt.Sprintf("SELECT %d FROM t%d", i, i), time.Millisecond, nil)
+	}
+	n := []int{}
+	if err := Query(db.RODB, "SELECT count(*) FROM _query_stats", &n); err != nil || n[0] < 10001 {
+		t.Errorf("expected all query stats: %v %v", n, err)
+	}
+}
+
+func TestSchemaDump(t *testing.T) {
+	db := openTestDB(t,
+		"CREATE TABLE ys (y TEXT UNIQUE)",
+		"CREATE VIEW v AS SELECT y FROM ys",
+		"CREATE TRIGGER t AFTER INSERT ON ys BEGIN SELECT 1; END",
+		"CREATE INDEX ys_y ON ys (y)",
+		"CREATE TABLE xs (x INTEGER)",
+		"CREATE VIRTUAL TABLE r USING rtree(id, x0, x1)",
+		"CREATE VIRTUAL TABLE posts USING fts4(body)",
+		"CREATE TABLE posts_tags (tag TEXT)")
+	out := &bytes.Buffer{}
+	if err := db.SchemaDump(out); err != nil {
+		t.Fatal(err)
+	}
+	expected := "CREATE TABLE _migrations (name STRING, timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP, duration_ms REAL, checksum TEXT);\n" +
+		"CREATE VIRTUAL TABLE posts USING fts4(body);\n" +
+		"CREATE TABLE posts_tags (tag TEXT);\n" +
+		"CREATE VIRTUAL TABLE r USING rtree(id, x0, x1);\n" +
+		"CREATE TABLE xs (x INTEGER);\n" +
+		"CREATE TABLE ys (y TEXT UNIQUE);\n" +
+		"CREATE INDEX ys_y ON ys (y);\n" +
+		"CREATE VIEW v AS SELECT y FROM ys;\n" +
+		"CREATE TRIGGER t AFTER INSERT ON ys BEGIN SELECT 1; END;\n"
+	if out.String() != expected {
+		t.Errorf("%q not %q", out.String(), expected)
+	}
+}
+
+func TestDiffMigrations(t *testing.T) {
+	db := openTestDB(t,
+		"CREATE TABLE xs (id INTEGER PRIMARY KEY, a TEXT, b INTEGER)",
+		"CREATE INDEX xs_a ON xs (a)",
+		"CREATE TABLE old (x)")
+	changes, err := db.DiffMigrations(map[string]string{
+		"000.sql": "CREATE TABLE xs (id INTEGER PRIMARY KEY, a TEXT NOT NULL DEFAULT '', c REAL)",
+		"001.sql": "CREATE INDEX xs_a ON xs (a, c)",
+		"002.sql": "CREATE TABLE new (y)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := []string{}
+	for _, c := range changes {
+		actual = append(actual, c.String())
+	}
+	expected := []string{
+		"+ table new",
+		"- table old",
+		"+ column new.y",
+		"- column old.x",
+		"~ column xs.a: TEXT -> TEXT NOT NULL DEFAULT ''",
+		"- column xs.b: INTEGER",
+		"+ column xs.c: REAL",
+		"~ index xs_a: CREATE INDEX xs_a ON xs (a) -> CREATE INDEX xs_a ON xs (a, c)",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%#v not %#v", actual, expected)
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	db := openTestDB(t)
+	in := "id;price;name;note\n1;1.5;a;\n2;2;b;NULL\n3;;\"c;d\";x\n"
+	if n, err := ImportCSV(db, "xs", strings.NewReader(in), CSVOptions{Comma: ';', Null: "", BatchSize: 2}); err != nil || n != 3 {
+		t.Fatalf("%d %v", n, err)
+	}
+	columns := []string{}
+	if err := Query(db, "SELECT name || ' ' || type FROM pragma_table_info('xs')", &columns); err != nil {
+		t.Fatal(err)
+	} else if expected := []string{"id INTEGER", "price REAL", "name TEXT", "note TEXT"}; !reflect.DeepEqual(columns, expected) {
+		t.Errorf("%#v not %#v", columns, expected)
+	}
+	rows := []string{}
+	if err := Query(db, "SELECT quote(id) || ',' || quote(price) || ',' || quote(name) || ',' || quote(note) FROM xs", &rows); err != nil {
+		t.Fatal(err)
+	} else if expected := []string{"1,1.5,'a',NULL", "2,2.0,'b','NULL'", "3,NULL,'c;d','x'"}; !reflect.DeepEqual(rows, expected) {
+		t.Errorf("%#v not %#v", rows, expected)
+	}
+	if _, err := ImportCSV(db, "ys", strings.NewReader("1,2\n3\n"), CSVOptions{NoHeader: true}); err == nil || err.Error() != "record 2: expected 2 fields, got 1" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := Exec(db, "CREATE TABLE zs (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	n, err := ImportCSV(db, "zs", strings.NewReader("id\n1\n2\n3\n3\n"), CSVOptions{BatchSize: 2})
+	if ids := []int{}; err == nil || !strings.HasPrefix(err.Error(), "record 4: ") || n != 2 || Query(db, "SELECT id FROM zs", &ids) != nil || len(ids) != 2 {
+		t.Errorf("expected only the committed batch to be counted: %d %v %v", n, ids, err)
+	}
+}
+
+func TestImportJSON(t *testing.T) {
+	db := openTestDB(t, "CREATE TABLE ys (id INTEGER)")
+	for table, in := range map[string]string{
+		"xs": "\n {\"id\": 1, \"s\": \"a\"}\n{\"id\": 2, \"f\": 1.5, \"o\": {\"k\": [1]}}\n",
+		"ys": `[{"id": 1}, {"id": 2, "s": "b"}]`,
+	} {
+		if n, err := ImportJSON(db, table, strings.NewReader(in)); err != nil || n != 2 {
+			t.Fatalf("%s: %d %v", table, n, err)
+		}
+	}
+	q := "SELECT group_concat(name || ' ' || type, ', ') FROM pragma_table_info(?)"
+	for table, expected := range map[string]string{"xs": "id INTEGER, s TEXT, f REAL, o TEXT", "ys": "id INTEGER, s TEXT"} {
+		rows := []string{}
+		if err := Query(db, q, &rows, table); err != nil {
+			t.Fatal(err)
+		} else if rows[0] != expected {
+			t.Errorf("%s: %s not %s", table, rows[0], expected)
+		}
+	}
+	rows := []string{}
+	if err := Query(db, "SELECT quote(id) || ',' || quote(s) || ',' || quote(f) || ',' || quote(o) FROM xs", &rows); err != nil {
+		t.Fatal(err)
+	} else if expected := []string{"1,'a',NULL,NULL", `2,NULL,1.5,'{"k":[1]}'`}; !reflect.DeepEqual(rows, expected) {
+		t.Errorf("%#v not %#v", rows, expected)
+	}
+	result, err := Import(db, "zs", strings.NewReader(`{"id": 1, "s": "a"}`), ImportOptions{Format: "ndjson", AddColumns: true})
+	if err != nil || result.Inserted != 1 {
+		t.Errorf("%#v %v", result, err)
+	}
+}
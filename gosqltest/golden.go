@@ -0,0 +1,46 @@
+package gosqltest
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/niklasfasching/gosql"
+)
+
+// updateGolden reports whether golden files should be rewritten: GOSQL_UPDATE_GOLDEN=1 or an -update flag defined by
+// the test package. gosqltest does not define the flag itself - that would panic for packages defining their own
+func updateGolden() bool {
+	if os.Getenv("GOSQL_UPDATE_GOLDEN") == "1" {
+		return true
+	}
+	f := flag.Lookup("update")
+	return f != nil && f.Value.String() == "true"
+}
+
+func Golden(t testing.TB, c gosql.Connection, file, query string, args ...interface{}) {
+	t.Helper()
+	actual := &bytes.Buffer{}
+	if err := gosql.Fprint(actual, c, gosql.PrintOptions{Indent: "  "}, query, args...); err != nil {
+		t.Fatal(err)
+	}
+	if updateGolden() {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(file, actual.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		t.Fatalf("%s does not exist (run with GOSQL_UPDATE_GOLDEN=1 to create it)", file)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual.Bytes(), expected) {
+		t.Errorf("%s: query results differ from golden file (run with GOSQL_UPDATE_GOLDEN=1 to accept)\n%s", file, actual.String())
+	}
+}
@@ -0,0 +1,107 @@
+package gosqltest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/niklasfasching/gosql"
+)
+
+var dbIndex int64
+
+func NewTestDB(t testing.TB, migrations interface{}, fixtures ...interface{}) *gosql.DB {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db := &gosql.DB{DataSourceName: fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", name, atomic.AddInt64(&dbIndex, 1))}
+	if err := db.Open(migrations); err != nil {
+		t.Fatal(err)
+	}
+	// shared in-memory databases are dropped once their last connection is closed
+	conn, err := db.RODB.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		db.Close()
+		db.RODB.Close()
+	})
+	for _, fixture := range fixtures {
+		if err := Load(db, fixture); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func Load(c gosql.Connection, fixture interface{}) error {
+	switch f := fixture.(type) {
+	case string:
+		return loadFile(c, f)
+	case map[string]interface{}:
+		tables := []string{}
+		for table := range f {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+		for _, table := range tables {
+			if err := insertRows(c, table, f[table]); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unhandled fixture type %T", fixture)
+	}
+}
+
+func loadFile(c gosql.Connection, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rows := []map[string]interface{}{}
+	d := json.NewDecoder(f)
+	d.UseNumber()
+	if err := d.Decode(&rows); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, row := range rows {
+		for k, v := range row {
+			switch v := v.(type) {
+			case json.Number:
+				if i, err := v.Int64(); err == nil {
+					row[k] = i
+				} else {
+					row[k], _ = v.Float64()
+				}
+			case map[string]interface{}, []interface{}:
+				row[k] = gosql.JSON{Value: v}
+			}
+		}
+	}
+	table := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return insertRows(c, table, rows)
+}
+
+func insertRows(c gosql.Connection, table string, rows interface{}) error {
+	rv := reflect.ValueOf(rows)
+	if rv.Kind() != reflect.Slice {
+		return fmt.Errorf("fixture %s: rows must be a slice, not %T", table, rows)
+	}
+	for i := 0; i < rv.Len(); i++ {
+		if _, err := gosql.Insert(c, table, rv.Index(i).Interface(), ""); err != nil {
+			return fmt.Errorf("fixture %s: row %d: %w", table, i, err)
+		}
+	}
+	return nil
+}
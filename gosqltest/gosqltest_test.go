@@ -0,0 +1,62 @@
+package gosqltest
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/niklasfasching/gosql"
+)
+
+// test packages may define their own -update flag - Golden honors it
+var update = flag.Bool("update", false, "update golden files")
+
+type x struct {
+	ID int    `db:"id"`
+	S  string `db:"s"`
+}
+
+func TestNewTestDB(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ys.json")
+	if err := os.WriteFile(file, []byte(`[{"y": 1, "j": {"a": [1]}}, {"y": 2.5}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	migrations := map[string]string{"001.sql": "CREATE TABLE xs (id INTEGER PRIMARY KEY, s TEXT); CREATE TABLE ys (y, j)"}
+	db := NewTestDB(t, migrations, map[string]interface{}{"xs": []x{{1, "a"}, {2, "b"}}}, file)
+	xs, ys := []x{}, []map[string]interface{}{}
+	if err := gosql.Query(db.RODB, "SELECT id, s FROM xs ORDER BY id", &xs); err != nil {
+		t.Fatal(err)
+	} else if expected := []x{{1, "a"}, {2, "b"}}; !reflect.DeepEqual(xs, expected) {
+		t.Errorf("%#v not %#v", xs, expected)
+	}
+	if err := gosql.Query(db, "SELECT y, j FROM ys ORDER BY y", &ys); err != nil {
+		t.Fatal(err)
+	} else if expected := []map[string]interface{}{{"y": 1.0, "j": `{"a":[1]}`}, {"y": 2.5, "j": nil}}; !reflect.DeepEqual(ys, expected) {
+		t.Errorf("%#v not %#v", ys, expected)
+	}
+	if other := NewTestDB(t, migrations); other.DataSourceName == db.DataSourceName {
+		t.Errorf("expected unique database names")
+	}
+}
+
+func TestGolden(t *testing.T) {
+	db := NewTestDB(t, map[string]string{"001.sql": "CREATE TABLE xs (id INTEGER PRIMARY KEY, s TEXT)"}, map[string]interface{}{"xs": []x{{1, "a"}, {2, "<b>"}}})
+	file := filepath.Join(t.TempDir(), "testdata", "xs.golden")
+	*update = true
+	Golden(t, db, file, "SELECT * FROM xs WHERE id > ?", 0)
+	*update = false
+	if bs, err := os.ReadFile(file); err != nil {
+		t.Fatal(err)
+	} else if expected := "{\n  \"id\": 1,\n  \"s\": \"a\"\n}\n{\n  \"id\": 2,\n  \"s\": \"<b>\"\n}\n"; string(bs) != expected {
+		t.Errorf("%q not %q", bs, expected)
+	}
+	Golden(t, db, file, "SELECT * FROM xs WHERE id > ?", 0)
+	os.Setenv("GOSQL_UPDATE_GOLDEN", "1")
+	defer os.Unsetenv("GOSQL_UPDATE_GOLDEN")
+	Golden(t, db, file, "SELECT * FROM xs WHERE id = ?", 1)
+	if bs, err := os.ReadFile(file); err != nil || string(bs) != "{\n  \"id\": 1,\n  \"s\": \"a\"\n}\n" {
+		t.Errorf("expected GOSQL_UPDATE_GOLDEN to update golden file: %q %v", bs, err)
+	}
+}
@@ -0,0 +1,451 @@
+package gosql
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type errTooManyRows int
+
+func (e errTooManyRows) Error() string { return fmt.Sprintf("result exceeds %d rows", int(e)) }
+
+type truncatedResponse struct {
+	Rows      interface{} `json:"rows"`
+	Truncated bool        `json:"truncated"`
+	Total     *int64      `json:"total,omitempty"`
+}
+
+type NamedQuery struct {
+	Query  string
+	Params []QueryParam
+}
+
+type QueryParam struct {
+	Name string
+	Type string
+}
+
+var errUnknownQuery = errors.New("unknown query")
+
+type handlerRequest struct {
+	Query string            `json:"query"`
+	Args  []json.RawMessage `json:"args"`
+}
+
+func (db *DB) parseHandlerRequest(r *http.Request) (string, []interface{}, bool, error) {
+	if db.HandlerQueries == nil {
+		query, args, err := parseHandlerRequest(r)
+		return query, args, true, err
+	}
+	q, ok := db.HandlerQueries[path.Base(r.URL.Path)]
+	if !ok {
+		return "", nil, false, errUnknownQuery
+	}
+	args, paginate, values := []interface{}{}, true, r.URL.Query()
+	for _, p := range q.Params {
+		if _, ok := values[p.Name]; !ok {
+			return "", nil, false, fmt.Errorf("missing parameter %s", p.Name)
+		}
+		arg, err := p.parse(values.Get(p.Name))
+		if err != nil {
+			return "", nil, false, fmt.Errorf("invalid parameter %s: %w", p.Name, err)
+		}
+		args, paginate = append(args, arg), paginate && p.Name != "limit"
+	}
+	return q.Query, args, paginate, nil
+}
+
+func (p QueryParam) parse(s string) (interface{}, error) {
+	switch p.Type {
+	case "", "string":
+		return s, nil
+	case "int":
+		return strconv.ParseInt(s, 10, 64)
+	case "float":
+		return strconv.ParseFloat(s, 64)
+	case "bool":
+		return strconv.ParseBool(s)
+	default:
+		return nil, fmt.Errorf("unknown type %q", p.Type)
+	}
+}
+
+func parseHandlerRequest(r *http.Request) (string, []interface{}, error) {
+	args := []interface{}{}
+	if r.Method != http.MethodPost {
+		for _, arg := range r.URL.Query()["arg"] {
+			args = append(args, arg)
+		}
+		return r.URL.Query().Get("query"), args, nil
+	}
+	body := handlerRequest{}
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20)).Decode(&body); err != nil {
+		return "", nil, fmt.Errorf("invalid request body: %w", err)
+	} else if body.Query == "" {
+		return "", nil, errors.New("invalid request body: missing query")
+	}
+	for i, raw := range body.Args {
+		arg, err := decodeJSONArg(raw)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid request body: arg %d: %w", i, err)
+		}
+		args = append(args, arg)
+	}
+	return body.Query, args, nil
+}
+
+func decodeJSONArg(raw json.RawMessage) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case float64:
+		i := int64(0)
+		if err := json.Unmarshal(raw, &i); err == nil {
+			return i, nil
+		}
+		return v, nil
+	case map[string]interface{}, []interface{}:
+		return string(raw), nil
+	default:
+		return v, nil
+	}
+}
+
+func responseFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	} else if strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+		return "ndjson"
+	}
+	return "json"
+}
+
+func (db *DB) WriteHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if db.WriteAuth == nil || !db.WriteAuth(r) {
+		writeHandlerError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	} else if r.Method != http.MethodPost {
+		writeHandlerError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	query, args, err := parseHandlerRequest(r)
+	if err == nil {
+		err = checkWriteStatement(query)
+	}
+	if err != nil {
+		writeHandlerError(w, http.StatusBadRequest, err)
+		return
+	}
+	result, err := db.ExecContext(r.Context(), query, args...)
+	if err != nil {
+		writeHandlerError(w, handlerErrorStatus(r.Context(), err, http.StatusBadRequest), fmt.Errorf("%s: %w", query, err))
+		return
+	}
+	rowsAffected, _ := result.RowsAffected()
+	lastInsertID, _ := result.LastInsertId()
+	json.NewEncoder(w).Encode(map[string]int64{"rows_affected": rowsAffected, "last_insert_id": lastInsertID})
+}
+
+func TokenAuth(token string) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		actual := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return token != "" && subtle.ConstantTimeCompare([]byte(actual), []byte(token)) == 1
+	}
+}
+
+func BasicAuth(user, password string) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		u, p, ok := r.BasicAuth()
+		return ok && subtle.ConstantTimeCompare([]byte(u), []byte(user))&subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+	}
+}
+
+func checkWriteStatement(query string) error {
+	statements, rest := splitScript(query)
+	if n := len(statements); n > 1 || (n == 1 && lineOf(rest) != 0) {
+		return errors.New("expected a single statement")
+	}
+	for _, t := range tokenize(query) {
+		if t.kind == "space" || t.kind == "comment" {
+			continue
+		}
+		switch strings.ToUpper(t.text) {
+		case "INSERT", "UPDATE", "DELETE", "REPLACE":
+			return nil
+		}
+		break
+	}
+	return errors.New("only INSERT, UPDATE, DELETE and REPLACE statements are allowed")
+}
+
+func (db *DB) acquireHandler(ctx context.Context) (func(), error) {
+	releaseHandler, err := db.HandlerLimit.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	release, err := db.acquire(ctx, true)
+	if err != nil {
+		releaseHandler()
+		return nil, err
+	}
+	return func() { release(); releaseHandler() }, nil
+}
+
+func (db *DB) handlerETag(r *http.Request, query string, args []interface{}) (string, error) {
+	version, epoch, err := db.handlerVersion.getWithEpoch(db.RODB)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%d\x00%s\x00%s\x00%#v\x00%s", epoch.UnixNano(), version, r.URL.RawQuery, query, args, r.Header.Get("Accept"))
+	return fmt.Sprintf(`"%x"`, h.Sum(nil)[:16]), nil
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		if candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/"); candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// maxRowsQuery limits SELECT queries to max+1 rows - other statements (e.g. PRAGMA or EXPLAIN) cannot be wrapped;
+// their rows are counted while reading instead
+func maxRowsQuery(query string, max int) string {
+	if verb := statementVerb(query); max <= 0 || (verb != "SELECT" && verb != "VALUES") {
+		return query
+	}
+	return fmt.Sprintf("SELECT * FROM (%s) LIMIT %d", strings.TrimRight(strings.TrimSpace(query), ";"), max+1)
+}
+
+func countRowsQuery(query string) string {
+	return fmt.Sprintf("SELECT count(*) FROM (%s)", strings.TrimRight(strings.TrimSpace(query), ";"))
+}
+
+func handlerErrorStatus(ctx context.Context, err error, status int) int {
+	if errors.Is(err, ErrLimitExceeded) {
+		return http.StatusTooManyRequests
+	} else if errors.As(err, new(errTooManyRows)) {
+		return http.StatusRequestEntityTooLarge
+	} else if ctx.Err() == context.DeadlineExceeded {
+		return http.StatusRequestTimeout
+	}
+	return status
+}
+
+// cacheHeaderWriter only sends its caching headers (Cache-Control, ETag) with 200 and 304 responses -
+// errors such as the 429 of a limiter must not be cached
+type cacheHeaderWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+}
+
+func (w *cacheHeaderWriter) WriteHeader(status int) {
+	if !w.wroteHeader && (status == http.StatusOK || status == http.StatusNotModified) {
+		for k, vs := range w.header {
+			w.ResponseWriter.Header()[k] = vs
+		}
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheHeaderWriter) Write(bs []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(bs)
+}
+
+func (w *cacheHeaderWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wroteHeader {
+			w.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+func writeHandlerError(w http.ResponseWriter, status int, err error) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// with truncate, results end after max rows - only the rows format marks them as truncated
+func streamRows(ctx context.Context, w http.ResponseWriter, c Connection, format, query string, args []interface{}, max int, truncate bool, o *EncoderOptions) {
+	started, e := false, json.NewEncoder(w)
+	err := withRows(c, query, args, func(rows *resultRows) error {
+		switch format {
+		case "ndjson":
+			return streamNDJSON(w, rows, max, o, &started)
+		case "csv", "tsv":
+			return streamCSV(w, rows, format, max, &started)
+		case "rows":
+			return streamJSONRows(w, rows, max, truncate, o, &started)
+		default:
+			return fmt.Errorf("unhandled format %q", format)
+		}
+	})
+	if err == nil || (truncate && errors.As(err, new(errTooManyRows))) {
+		return
+	} else if !started {
+		writeHandlerError(w, handlerErrorStatus(ctx, err, http.StatusBadRequest), fmt.Errorf("%s: %w", query, err))
+	} else if format == "ndjson" {
+		e.Encode(map[string]string{"error": fmt.Sprintf("%s: %s", query, err)})
+	}
+}
+
+func streamNDJSON(w http.ResponseWriter, rows *resultRows, max int, o *EncoderOptions, started *bool) error {
+	t := reflect.TypeOf(map[string]JSON{})
+	if o != nil {
+		t = reflect.TypeOf(rawRow{})
+	}
+	decode, err := decoder(rows, t)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, e := w.(http.Flusher), json.NewEncoder(w)
+	for i := 0; rows.Next(); i++ {
+		if max > 0 && i >= max {
+			return errTooManyRows(max)
+		}
+		row, err := decode()
+		if err != nil {
+			return err
+		}
+		*started = true
+		if r, ok := row.Interface().(rawRow); ok {
+			row = reflect.ValueOf(o.object(r))
+		}
+		if err := e.Encode(row.Interface()); err != nil {
+			return err
+		}
+		if flusher != nil && i%1000 == 999 {
+			flusher.Flush()
+		}
+	}
+	return nil
+}
+
+func streamJSONRows(w http.ResponseWriter, rows *resultRows, max int, truncate bool, o *EncoderOptions, started *bool) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	bs, err := json.Marshal(columns)
+	if err != nil {
+		return err
+	}
+	*started = true
+	fmt.Fprintf(w, `{"columns":%s,"rows":[`, bs)
+	blobs, err := writeJSONRows(w, rows, len(columns), max, o)
+	if len(blobs) != 0 {
+		bs, _ := json.Marshal(blobs)
+		fmt.Fprintf(w, `],"blobs":%s`, bs)
+	} else {
+		w.Write([]byte("]"))
+	}
+	if truncate && errors.As(err, new(errTooManyRows)) {
+		w.Write([]byte(`,"truncated":true}` + "\n"))
+		return err
+	} else if err != nil {
+		bs, _ := json.Marshal(err.Error())
+		fmt.Fprintf(w, `,"error":%s}`+"\n", bs)
+		return err
+	}
+	_, err = w.Write([]byte("}\n"))
+	return err
+}
+
+// writeJSONRows returns the [row, column] positions of blobs - they are base64 encoded and would be indistinguishable from text otherwise
+func writeJSONRows(w http.ResponseWriter, rows *resultRows, n, max int, o *EncoderOptions) ([][2]int, error) {
+	blobs := [][2]int{}
+	for i := 0; rows.Next(); i++ {
+		if max > 0 && i >= max {
+			return blobs, errTooManyRows(max)
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			values[i] = new(interface{})
+		}
+		if err := rows.Scan(values...); err != nil {
+			return blobs, err
+		}
+		for j, v := range values {
+			if _, ok := (*v.(*interface{})).([]byte); ok {
+				blobs = append(blobs, [2]int{i, j})
+			}
+		}
+		if o != nil {
+			for i := range values {
+				values[i] = o.value(*values[i].(*interface{}))
+			}
+		}
+		bs, err := json.Marshal(values)
+		if err != nil {
+			return blobs, err
+		}
+		if i != 0 {
+			bs = append([]byte(","), bs...)
+		}
+		if _, err := w.Write(bs); err != nil {
+			return blobs, err
+		}
+	}
+	return blobs, rows.Err()
+}
+
+func streamCSV(w http.ResponseWriter, rows *resultRows, format string, max int, started *bool) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if format == "tsv" {
+		cw.Comma = '\t'
+		w.Header().Set("Content-Type", "text/tab-separated-values")
+	} else {
+		w.Header().Set("Content-Type", "text/csv")
+	}
+	*started = true
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	for i := 0; rows.Next(); i++ {
+		if max > 0 && i >= max {
+			cw.Flush()
+			return errTooManyRows(max)
+		}
+		values := make([]interface{}, len(columns))
+		for i := range values {
+			values[i] = new(interface{})
+		}
+		if err := rows.Scan(values...); err != nil {
+			return err
+		}
+		if err := cw.Write(csvRecord(values)); err != nil {
+			return err
+		}
+		if i%1000 == 999 {
+			cw.Flush()
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
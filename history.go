@@ -0,0 +1,68 @@
+package gosql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type HistoryMode int
+
+const (
+	HistoryOff HistoryMode = iota
+	HistoryWrites
+	HistoryAll
+)
+
+func (db *DB) createHistoryTable() error {
+	if db.History == HistoryOff {
+		return nil
+	}
+	_, err := db.DB.Exec(`CREATE TABLE IF NOT EXISTS _history (
+		query TEXT, args TEXT, source TEXT, duration REAL, error TEXT, timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+	return err
+}
+
+// history is inserted via c - the statement's own connection inside transactions, db.DB otherwise
+func (db *DB) recordHistory(c Connection, query string, args []interface{}, start time.Time, err *error) {
+	if db.History == HistoryOff || (db.History == HistoryWrites && isReadQuery(query)) {
+		return
+	}
+	duration, errString, source := time.Since(start).Seconds(), interface{}(nil), db.HistorySource
+	if *err != nil {
+		errString = (*err).Error()
+	}
+	if source == "" {
+		source = filepath.Base(os.Args[0])
+	}
+	bs, jsonErr := json.Marshal(args)
+	if jsonErr != nil {
+		bs = []byte("null")
+	}
+	q := "INSERT INTO _history (query, args, source, duration, error) VALUES (?, ?, ?, ?, ?)"
+	if _, err := c.Exec(q, query, string(bs), source, duration, errString); err != nil {
+		db.logger().Printf("WARNING: recording history: %s", err)
+	}
+}
+
+func (db *DB) observeQuery(query string, args []interface{}, start time.Time, rows *int, err *error) {
+	db.observe(query, args, time.Since(start), *rows, *err)
+}
+
+func (db *DB) observeExec(query string, args []interface{}, start time.Time, result *sql.Result, err *error) {
+	db.observe(query, args, time.Since(start), rowsAffected(*result), *err)
+}
+
+func (db *DB) observe(query string, args []interface{}, d time.Duration, rows int, err error) {
+	if db.QueryHook != nil {
+		db.QueryHook(query, args, d, rows, err)
+	}
+	if db.Metrics != nil {
+		db.Metrics.record(query, d, err)
+	}
+	if db.SlowQueryThreshold > 0 && d >= db.SlowQueryThreshold {
+		db.logSlowQuery(SlowQuery{Query: query, Args: args, Duration: d, Rows: rows, Err: err})
+	}
+}
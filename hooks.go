@@ -0,0 +1,50 @@
+package gosql
+
+import (
+	"database/sql"
+	"reflect"
+)
+
+// BeforeInserter is called by Insert before the row is validated and written - e.g. to set CreatedAt.
+// Insert works on a copy unless it is passed a pointer
+type BeforeInserter interface {
+	BeforeInsert() error
+}
+
+type AfterInserter interface {
+	AfterInsert(sql.Result) error
+}
+
+// AfterScanner is called for each struct (or struct pointer) result row after its columns have been scanned
+type AfterScanner interface {
+	AfterScan() error
+}
+
+// RowMapper is used instead of reflection to decode result rows into (pointers to) its type.
+// values are the driver values of the row and only valid during the call
+type RowMapper interface {
+	MapRow(columns []string, values []interface{}) error
+}
+
+var afterScannerType = reflect.TypeOf((*AfterScanner)(nil)).Elem()
+var rowMapperType = reflect.TypeOf((*RowMapper)(nil)).Elem()
+
+func rowMapperDecoder(rows *resultRows, columns []string, t reflect.Type, isPtr bool) func() (reflect.Value, error) {
+	values, dsts := make([]interface{}, len(columns)), make([]interface{}, len(columns))
+	for i := range values {
+		dsts[i] = &values[i]
+	}
+	return func() (reflect.Value, error) {
+		if err := rows.Scan(dsts...); err != nil {
+			return reflect.Value{}, err
+		}
+		x := reflect.New(t)
+		if err := x.Interface().(RowMapper).MapRow(columns, values); err != nil {
+			return reflect.Value{}, err
+		}
+		if isPtr {
+			return x, nil
+		}
+		return x.Elem(), nil
+	}
+}
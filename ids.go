@@ -0,0 +1,101 @@
+package gosql
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+type idBlock struct{ next, max int64 }
+
+var defaultIDBlockSize = 100
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func LastInsertID(result sql.Result, id interface{}) error {
+	v := reflect.ValueOf(id)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("cannot store id into %T", id)
+	}
+	lastID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	switch v := v.Elem(); v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(lastID) {
+			return fmt.Errorf("id %d overflows %s", lastID, v.Type())
+		}
+		v.SetInt(lastID)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if lastID < 0 || v.OverflowUint(uint64(lastID)) {
+			return fmt.Errorf("id %d overflows %s", lastID, v.Type())
+		}
+		v.SetUint(uint64(lastID))
+	default:
+		return fmt.Errorf("cannot store id into %T", id)
+	}
+	return nil
+}
+
+func (db *DB) NextID(table string) (int64, error) {
+	db.idMutex.Lock()
+	defer db.idMutex.Unlock()
+	if db.idBlocks == nil {
+		db.idBlocks = map[string]*idBlock{}
+	}
+	if b := db.idBlocks[table]; b != nil && b.next <= b.max {
+		b.next++
+		return b.next - 1, nil
+	}
+	size := int64(db.IDBlockSize)
+	if size <= 0 {
+		size = int64(defaultIDBlockSize)
+	}
+	his := []int64{}
+	err := db.Transact(context.Background(), TxOptions{Immediate: true}, func(c Connection) error {
+		if _, err := Exec(c, "CREATE TABLE IF NOT EXISTS _ids (name TEXT PRIMARY KEY, hi INTEGER NOT NULL)"); err != nil {
+			return err
+		}
+		if _, err := Exec(c, "INSERT INTO _ids (name, hi) VALUES (?, 1) ON CONFLICT (name) DO UPDATE SET hi = hi + 1", table); err != nil {
+			return err
+		}
+		return Query(c, "SELECT hi FROM _ids WHERE name = ?", &his, table)
+	})
+	if err != nil {
+		return 0, err
+	}
+	b := &idBlock{next: (his[0]-1)*size + 1, max: his[0] * size}
+	db.idBlocks[table] = b
+	b.next++
+	return b.next - 1, nil
+}
+
+func uuid4() (string, error) {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	bs[6], bs[8] = bs[6]&0x0f|0x40, bs[8]&0x3f|0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", bs[0:4], bs[4:6], bs[6:8], bs[8:10], bs[10:]), nil
+}
+
+// 48 bit unix ms timestamp + 80 random bits, crockford base32 encoded - sorts lexicographically by creation time
+func ulid() (string, error) {
+	bs := make([]byte, 16)
+	binary.BigEndian.PutUint64(bs[:8], uint64(time.Now().UnixNano()/int64(time.Millisecond))<<16)
+	if _, err := rand.Read(bs[6:]); err != nil {
+		return "", err
+	}
+	hi, lo, s := binary.BigEndian.Uint64(bs[:8]), binary.BigEndian.Uint64(bs[8:]), make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		s[i] = crockfordAlphabet[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s), nil
+}
@@ -0,0 +1,435 @@
+package gosql
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type ImportOptions struct {
+	Format     string
+	Keys       []string
+	BatchSize  int  // rows per transaction (if c is a *DB) - defaults to 1000
+	AddColumns bool // create the table and add missing columns, typed by the value of their first row
+}
+
+type ImportResult struct {
+	Inserted, Updated, Skipped int
+}
+
+func Import(c Connection, table string, r io.Reader, opts ImportOptions) (ImportResult, error) {
+	next, err := importReader(r, opts.Format)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return importRows(c, table, next, opts)
+}
+
+// importRows imports the rows returned by next (until io.EOF) in batches - the result only counts committed batches
+func importRows(c Connection, table string, next func() (map[string]interface{}, error), opts ImportOptions) (ImportResult, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	result, batch, line := ImportResult{}, []map[string]interface{}{}, 0
+	flush := func() error {
+		batchResult, offset := ImportResult{}, line-len(batch)
+		err := inTransaction(c, func(c Connection) error {
+			columns := map[string]bool{}
+			if opts.AddColumns {
+				var err error
+				if columns, err = tableColumns(c, table); err != nil {
+					return err
+				}
+			}
+			for i, row := range batch {
+				if opts.AddColumns {
+					if err := addColumns(c, table, row, columns); err != nil {
+						return fmt.Errorf("record %d: %w", offset+i+1, err)
+					}
+				}
+				if err := importRow(c, table, row, opts.Keys, &batchResult); err != nil {
+					return fmt.Errorf("record %d: %w", offset+i+1, err)
+				}
+			}
+			return nil
+		})
+		batch = batch[:0]
+		if err != nil {
+			return err
+		}
+		result.Inserted += batchResult.Inserted
+		result.Updated += batchResult.Updated
+		result.Skipped += batchResult.Skipped
+		return nil
+	}
+	for {
+		row, err := next()
+		if err == io.EOF {
+			return result, flush()
+		} else if err != nil {
+			return result, fmt.Errorf("record %d: %w", line+1, err)
+		}
+		line++
+		if batch = append(batch, row); len(batch) >= opts.BatchSize {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+}
+
+func importReader(r io.Reader, format string) (func() (map[string]interface{}, error), error) {
+	switch format {
+	case "csv":
+		csvReader := csv.NewReader(r)
+		header, err := csvReader.Read()
+		if err != nil {
+			return nil, err
+		}
+		return func() (map[string]interface{}, error) {
+			record, err := csvReader.Read()
+			if err != nil {
+				return nil, err
+			}
+			row := map[string]interface{}{}
+			for i, column := range header {
+				if record[i] == "" {
+					row[column] = nil
+				} else {
+					row[column] = record[i]
+				}
+			}
+			return row, nil
+		}, nil
+	case "ndjson":
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 64*1024*1024)
+		return func() (map[string]interface{}, error) {
+			for scanner.Scan() {
+				if line := bytes.TrimSpace(scanner.Bytes()); len(line) != 0 {
+					row, d := map[string]interface{}{}, json.NewDecoder(bytes.NewReader(line))
+					d.UseNumber()
+					return row, d.Decode(&row)
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}, nil
+	default:
+		return nil, fmt.Errorf("unhandled import format %q", format)
+	}
+}
+
+func importRow(c Connection, table string, row map[string]interface{}, keys []string, result *ImportResult) error {
+	if len(keys) == 0 {
+		if _, err := Insert(c, table, row, ""); err != nil {
+			return err
+		}
+		result.Inserted++
+		return nil
+	}
+	quotedTable, err := quoteTableName(table)
+	if err != nil {
+		return err
+	}
+	keyConditions, keyValues, sets, setValues, changed := []string{}, []interface{}{}, []string{}, []interface{}{}, []string{}
+	for _, key := range keys {
+		quoted, err := quoteIdentifier(key)
+		if err != nil {
+			return err
+		}
+		v, ok := row[key]
+		if !ok {
+			return fmt.Errorf("missing key column %s", key)
+		}
+		keyConditions, keyValues = append(keyConditions, quoted+" IS ?"), append(keyValues, importValue(v))
+	}
+	columns := []string{}
+	for column := range row {
+		if indexOf(keys, column) == -1 {
+			columns = append(columns, column)
+		}
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		quoted, err := quoteIdentifier(column)
+		if err != nil {
+			return err
+		}
+		sets, setValues = append(sets, quoted+" = ?"), append(setValues, importValue(row[column]))
+		changed = append(changed, quoted+" IS NOT ?")
+	}
+	where := strings.Join(keyConditions, " AND ")
+	counts := []int{}
+	if err := Query(c, fmt.Sprintf("SELECT count(*) FROM %s WHERE %s", quotedTable, where), &counts, keyValues...); err != nil {
+		return err
+	} else if counts[0] == 0 {
+		if _, err := Insert(c, table, row, ""); err != nil {
+			return err
+		}
+		result.Inserted++
+		return nil
+	} else if len(sets) == 0 {
+		result.Skipped++
+		return nil
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s AND (%s)", quotedTable, strings.Join(sets, ", "), where, strings.Join(changed, " OR "))
+	res, err := Exec(c, query, append(append(setValues, keyValues...), setValues...)...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		result.Skipped++
+	} else {
+		result.Updated++
+	}
+	return nil
+}
+
+func importValue(v interface{}) interface{} {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		bs, _ := json.Marshal(v)
+		return string(bs)
+	default:
+		return v
+	}
+}
+
+type CSVOptions struct {
+	Comma     rune
+	NoHeader  bool
+	Null      string
+	BatchSize int
+	InferRows int
+}
+
+// ImportCSV creates table with column types inferred from the first InferRows records and imports all records
+func ImportCSV(c Connection, table string, r io.Reader, opts CSVOptions) (int, error) {
+	if opts.InferRows <= 0 {
+		opts.InferRows = 1000
+	}
+	csvReader := csv.NewReader(r)
+	csvReader.FieldsPerRecord, csvReader.ReuseRecord = -1, false
+	if opts.Comma != 0 {
+		csvReader.Comma = opts.Comma
+	}
+	records, header := [][]string{}, []string(nil)
+	for len(records) < opts.InferRows {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		if header == nil && !opts.NoHeader {
+			header = record
+		} else {
+			records = append(records, record)
+		}
+	}
+	if header == nil {
+		if len(records) == 0 {
+			return 0, fmt.Errorf("%s: empty csv", table)
+		}
+		for i := range records[0] {
+			header = append(header, fmt.Sprintf("c%d", i+1))
+		}
+	}
+	types := inferColumnTypes(records, len(header), opts.Null)
+	if err := csvTable(c, table, header, types); err != nil {
+		return 0, err
+	}
+	next := func() (map[string]interface{}, error) {
+		record := []string(nil)
+		if len(records) != 0 {
+			record, records = records[0], records[1:]
+		} else if r, err := csvReader.Read(); err != nil {
+			return nil, err
+		} else {
+			record = r
+		}
+		if len(record) != len(header) {
+			return nil, fmt.Errorf("expected %d fields, got %d", len(header), len(record))
+		}
+		row := make(map[string]interface{}, len(record))
+		for j, field := range record {
+			row[header[j]] = csvValue(field, types[j], opts.Null)
+		}
+		return row, nil
+	}
+	result, err := importRows(c, table, next, ImportOptions{BatchSize: opts.BatchSize})
+	return result.Inserted, err
+}
+
+func inferColumnTypes(records [][]string, n int, null string) []string {
+	types := make([]string, n)
+	for i := range types {
+		types[i] = "INTEGER"
+		for _, record := range records {
+			if i >= len(record) || record[i] == null {
+				continue
+			} else if _, err := strconv.ParseInt(record[i], 10, 64); err == nil {
+				continue
+			} else if _, err := strconv.ParseFloat(record[i], 64); err == nil {
+				types[i] = "REAL"
+			} else {
+				types[i] = "TEXT"
+				break
+			}
+		}
+	}
+	return types
+}
+
+func csvTable(c Connection, table string, header, types []string) error {
+	quotedTable, err := quoteTableName(table)
+	if err != nil {
+		return err
+	}
+	definitions := make([]string, len(header))
+	for i, name := range header {
+		column, err := quoteIdentifier(name)
+		if err != nil {
+			return err
+		}
+		definitions[i] = column + " " + types[i]
+	}
+	_, err = Exec(c, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", quotedTable, strings.Join(definitions, ", ")))
+	return err
+}
+
+func csvValue(field, columnType, null string) interface{} {
+	if field == null {
+		return nil
+	} else if columnType == "INTEGER" {
+		if i, err := strconv.ParseInt(field, 10, 64); err == nil {
+			return i
+		}
+	} else if columnType == "REAL" {
+		if f, err := strconv.ParseFloat(field, 64); err == nil {
+			return f
+		}
+	}
+	return field
+}
+
+func inTransaction(c Connection, f func(Connection) error) error {
+	if db, ok := c.(*DB); ok {
+		return db.Transact(context.Background(), TxOptions{Immediate: true}, f)
+	}
+	return f(c)
+}
+
+// ImportJSON imports a JSON array or stream of objects - creating table and adding missing columns as needed
+func ImportJSON(c Connection, table string, r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	for {
+		if b, err := br.ReadByte(); err != nil || !unicode.IsSpace(rune(b)) {
+			br.UnreadByte()
+			break
+		}
+	}
+	d := json.NewDecoder(br)
+	d.UseNumber()
+	if bs, err := br.Peek(1); err == nil && bs[0] == '[' {
+		if _, err := d.Token(); err != nil {
+			return 0, err
+		}
+	}
+	next := func() (map[string]interface{}, error) {
+		if !d.More() {
+			return nil, io.EOF
+		}
+		row := map[string]interface{}{}
+		if err := d.Decode(&row); err != nil {
+			return nil, err
+		}
+		for k, v := range row {
+			row[k] = jsonImportValue(v)
+		}
+		return row, nil
+	}
+	result, err := importRows(c, table, next, ImportOptions{AddColumns: true})
+	return result.Inserted, err
+}
+
+func tableColumns(c Connection, table string) (map[string]bool, error) {
+	names, columns := []string{}, map[string]bool{}
+	if err := Query(c, "SELECT name FROM pragma_table_info(?)", &names, table); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		columns[name] = true
+	}
+	return columns, nil
+}
+
+func addColumns(c Connection, table string, row map[string]interface{}, columns map[string]bool) error {
+	quotedTable, err := quoteTableName(table)
+	if err != nil {
+		return err
+	}
+	names, definitions := []string{}, []string{}
+	for name := range row {
+		if !columns[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		quoted, err := quoteIdentifier(name)
+		if err != nil {
+			return err
+		}
+		switch row[name].(type) {
+		case int64:
+			quoted += " INTEGER"
+		case float64:
+			quoted += " REAL"
+		case string:
+			quoted += " TEXT"
+		}
+		definitions = append(definitions, quoted)
+	}
+	if len(names) == 0 {
+		return nil
+	} else if len(columns) == 0 {
+		if _, err := Exec(c, fmt.Sprintf("CREATE TABLE %s (%s)", quotedTable, strings.Join(definitions, ", "))); err != nil {
+			return err
+		}
+	} else {
+		for _, definition := range definitions {
+			if _, err := Exec(c, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", quotedTable, definition)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range names {
+		columns[name] = true
+	}
+	return nil
+}
+
+func jsonImportValue(v interface{}) interface{} {
+	if n, ok := v.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		f, _ := n.Float64()
+		return f
+	}
+	return importValue(v)
+}
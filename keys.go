@@ -0,0 +1,301 @@
+package gosql
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+type keyedColumns struct {
+	dialect       Dialect
+	table         string
+	keys, columns []string
+	keyValues     []interface{}
+	values        []interface{}
+	softDelete    string
+}
+
+// SoftDeleteOption changes how Get, Update and Delete treat structs with a softdelete field (e.g. `db:"deleted_at,softdelete"`).
+// SelectQuery filters soft deleted rows based on its result type as well. Plain sql passed to Query or Paginate is never rewritten -
+// it has to filter soft deleted rows itself
+type SoftDeleteOption int
+
+// Unscoped makes Get return and Update modify soft deleted rows and Delete remove rows rather than setting their softdelete column
+const Unscoped SoftDeleteOption = 1
+
+func Get(c Connection, table string, v interface{}, options ...SoftDeleteOption) error {
+	kc, err := keyed(c, table, v)
+	if err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr {
+		return fmt.Errorf("cannot unmarshal into non-pointer %T", v)
+	}
+	xs := reflect.New(reflect.SliceOf(rv.Elem().Type()))
+	query := fmt.Sprintf("SELECT * FROM %s WHERE %s LIMIT 1", kc.table, kc.scopedWhere(0, options))
+	err = withRows(c, query, kc.keyValues, func(rows *resultRows) error {
+		rows.table = table
+		return unmarshal(rows, xs.Elem())
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", query, err)
+	} else if xs.Elem().Len() == 0 {
+		return sql.ErrNoRows
+	}
+	rv.Elem().Set(xs.Elem().Index(0))
+	return nil
+}
+
+func Update(c Connection, table string, v interface{}, options ...SoftDeleteOption) (sql.Result, error) {
+	if err := validate(v, columnMapperOf(c)); err != nil {
+		return nil, err
+	}
+	kc, err := keyed(c, table, v)
+	if err != nil {
+		return nil, err
+	}
+	sets := make([]string, len(kc.columns))
+	for i, column := range kc.columns {
+		sets[i] = column + " = " + kc.dialect.Placeholder(i)
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s", kc.table, strings.Join(sets, ", "), kc.scopedWhere(len(sets), options))
+	return Exec(c, query, append(kc.values, kc.keyValues...)...)
+}
+
+// Delete sets the softdelete column of v (if any) to the current time rather than deleting the row - see Unscoped
+func Delete(c Connection, table string, v interface{}, options ...SoftDeleteOption) (sql.Result, error) {
+	kc, err := keyed(c, table, v)
+	if err != nil {
+		return nil, err
+	}
+	if kc.softDelete != "" && !unscoped(options) {
+		query := fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s", kc.table, kc.softDelete, kc.dialect.Placeholder(0), kc.scopedWhere(1, options))
+		return Exec(c, query, append([]interface{}{time.Now().UTC()}, kc.keyValues...)...)
+	}
+	return Exec(c, fmt.Sprintf("DELETE FROM %s WHERE %s", kc.table, kc.where(0)), kc.keyValues...)
+}
+
+func Upsert(c Connection, table string, v interface{}) (sql.Result, error) {
+	if err := validate(v, columnMapperOf(c)); err != nil {
+		return nil, err
+	}
+	kc, err := keyed(c, table, v)
+	if err != nil {
+		return nil, err
+	}
+	columns, qs := append(append([]string{}, kc.keys...), kc.columns...), []string{}
+	for i := range columns {
+		qs = append(qs, kc.dialect.Placeholder(i))
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) %s",
+		kc.table, strings.Join(columns, ", "), strings.Join(qs, ", "), kc.dialect.Upsert(kc.keys, kc.columns))
+	return Exec(c, query, append(kc.keyValues, kc.values...)...)
+}
+
+func keyed(c Connection, table string, v interface{}) (*keyedColumns, error) {
+	d, rv := dialectOf(c), reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("unhandled type %T", v)
+	}
+	quotedTable, err := quoteDialectTableName(d, table)
+	if err != nil {
+		return nil, err
+	}
+	kc, mapper := &keyedColumns{dialect: d, table: quotedTable}, columnMapperOf(c)
+	for _, f := range columnFields(rv.Type(), mapper) {
+		name, options := mappedTag(mapper, f)
+		column, err := d.QuoteIdentifier(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := options["softdelete"]; ok {
+			kc.softDelete = column
+		}
+		if _, isPK := options["pk"]; isPK {
+			kc.keys, kc.keyValues = append(kc.keys, column), append(kc.keyValues, rv.FieldByIndex(f.Index).Interface())
+		} else if _, ok := options["encrypted"]; ok {
+			ad, err := encryptionContext(table, rv, f, mapper)
+			if err != nil {
+				return nil, err
+			}
+			value, err := encryptField(columnCipherOf(c), ad, f, rv.FieldByIndex(f.Index))
+			if err != nil {
+				return nil, err
+			}
+			kc.columns, kc.values = append(kc.columns, column), append(kc.values, value)
+		} else {
+			kc.columns, kc.values = append(kc.columns, column), append(kc.values, rv.FieldByIndex(f.Index).Interface())
+		}
+	}
+	if len(kc.keys) == 0 {
+		return nil, fmt.Errorf("%T has no pk fields", v)
+	}
+	return kc, nil
+}
+
+func (kc *keyedColumns) where(offset int) string {
+	conditions := make([]string, len(kc.keys))
+	for i, key := range kc.keys {
+		conditions[i] = key + " = " + kc.dialect.Placeholder(offset+i)
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// UpdateFromJSON updates the row identified by id - the primary key value, a []interface{} for composite keys,
+// or the rowid of tables without a primary key.
+func UpdateFromJSON(c Connection, table string, id interface{}, patch []byte, allowedColumns []string) (sql.Result, error) {
+	changes, d := map[string]interface{}{}, json.NewDecoder(bytes.NewReader(patch))
+	d.UseNumber()
+	if err := d.Decode(&changes); err != nil {
+		return nil, fmt.Errorf("invalid patch: %w", err)
+	} else if len(changes) == 0 {
+		return nil, errors.New("invalid patch: no changes")
+	}
+	columnTypes := []struct {
+		Name string `db:"name"`
+		Type string `db:"type"`
+	}{}
+	if err := Query(c, "SELECT name, type FROM pragma_table_info(?)", &columnTypes, table); err != nil {
+		return nil, err
+	}
+	types := map[string]string{}
+	for _, ct := range columnTypes {
+		types[ct.Name] = strings.ToUpper(ct.Type)
+	}
+	columns := make([]string, 0, len(changes))
+	for column := range changes {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	sets, values := []string{}, []interface{}{}
+	for _, column := range columns {
+		sqlType, exists := types[column]
+		if !exists || indexOf(allowedColumns, column) == -1 {
+			return nil, fmt.Errorf("invalid patch: column %s cannot be updated", column)
+		}
+		value, err := coerceJSONValue(changes[column], sqlType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid patch: column %s: %w", column, err)
+		}
+		quoted, err := quoteIdentifier(column)
+		if err != nil {
+			return nil, err
+		}
+		sets, values = append(sets, quoted+" = ?"), append(values, value)
+	}
+	quotedTable, err := quoteTableName(table)
+	if err != nil {
+		return nil, err
+	}
+	keys, _, err := primaryKey(c, table)
+	if err != nil {
+		return nil, err
+	}
+	ids, ok := id.([]interface{})
+	if !ok {
+		ids = []interface{}{id}
+	}
+	if len(keys) == 0 {
+		keys = []string{"rowid"}
+	}
+	if len(ids) != len(keys) {
+		return nil, fmt.Errorf("invalid id: %s has a %d column primary key", table, len(keys))
+	}
+	conditions := make([]string, len(keys))
+	for i, key := range keys {
+		conditions[i] = key + " = ?"
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s", quotedTable, strings.Join(sets, ", "), strings.Join(conditions, " AND "))
+	return Exec(c, query, append(values, ids...)...)
+}
+
+// primaryKey returns the quoted primary key columns of table in key order and whether table is WITHOUT ROWID.
+func primaryKey(c Connection, table string) ([]string, bool, error) {
+	columns, schemas := []string{}, []string{}
+	if err := Query(c, "SELECT name FROM pragma_table_info(?) WHERE pk > 0 ORDER BY pk", &columns, table); err != nil {
+		return nil, false, err
+	} else if err := Query(c, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", &schemas, table); err != nil {
+		return nil, false, err
+	}
+	for i, column := range columns {
+		quoted, err := quoteIdentifier(column)
+		if err != nil {
+			return nil, false, err
+		}
+		columns[i] = quoted
+	}
+	return columns, len(schemas) != 0 && strings.Contains(strings.ToUpper(schemas[0]), "WITHOUT ROWID"), nil
+}
+
+func coerceJSONValue(v interface{}, sqlType string) (interface{}, error) {
+	isInteger, isReal := strings.Contains(sqlType, "INT"), strings.Contains(sqlType, "REAL") || strings.Contains(sqlType, "FLOA") || strings.Contains(sqlType, "DOUB")
+	switch v := v.(type) {
+	case json.Number:
+		if isInteger {
+			return v.Int64()
+		} else if i, err := v.Int64(); err == nil && !isReal {
+			return i, nil
+		}
+		return v.Float64()
+	case bool:
+		if isInteger || isReal {
+			if v {
+				return 1, nil
+			}
+			return 0, nil
+		}
+		return v, nil
+	case string:
+		if isInteger || isReal {
+			return nil, fmt.Errorf("cannot store string in %s column", sqlType)
+		}
+		return v, nil
+	case map[string]interface{}, []interface{}:
+		bs, err := json.Marshal(v)
+		return string(bs), err
+	default:
+		return v, nil
+	}
+}
+
+func (kc *keyedColumns) scopedWhere(offset int, options []SoftDeleteOption) string {
+	if kc.softDelete == "" || unscoped(options) {
+		return kc.where(offset)
+	}
+	return kc.where(offset) + " AND " + kc.softDelete + " IS NULL"
+}
+
+// softDeleteColumn returns the quoted softdelete column of the struct (slice / map / pointer) type of result, if any
+func softDeleteColumn(c Connection, result interface{}) (string, error) {
+	t := reflect.TypeOf(result)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return "", nil
+	}
+	mapper := columnMapperOf(c)
+	for _, f := range columnFields(t, mapper) {
+		name, options := mappedTag(mapper, f)
+		if _, ok := options["softdelete"]; ok {
+			return dialectOf(c).QuoteIdentifier(name)
+		}
+	}
+	return "", nil
+}
+
+func unscoped(options []SoftDeleteOption) bool {
+	for _, option := range options {
+		if option == Unscoped {
+			return true
+		}
+	}
+	return false
+}
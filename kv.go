@@ -0,0 +1,76 @@
+package gosql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// KV stores json encoded values by key in a table that is created on first use
+type KV struct {
+	db    *DB
+	table string
+	once  sync.Once
+	err   error
+}
+
+func (db *DB) KV(table string) *KV { return &KV{db: db, table: table} }
+
+func (kv *KV) init() error {
+	kv.once.Do(func() {
+		table, err := quoteTableName(kv.table)
+		if err != nil {
+			kv.err = err
+			return
+		}
+		kv.table = table
+		q := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (key TEXT PRIMARY KEY, value TEXT NOT NULL, updated_at TIMESTAMP)", table)
+		_, kv.err = Exec(kv.db, q)
+	})
+	return kv.err
+}
+
+func (kv *KV) Set(key string, value interface{}) error {
+	if err := kv.init(); err != nil {
+		return err
+	}
+	bs, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	q := fmt.Sprintf(`INSERT INTO %s (key, value, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+	                  ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`, kv.table)
+	_, err = Exec(kv.db, q, key, string(bs))
+	return err
+}
+
+// Get unmarshals the value of key into value and returns sql.ErrNoRows if the key does not exist
+func (kv *KV) Get(key string, value interface{}) error {
+	if err := kv.init(); err != nil {
+		return err
+	}
+	values := []string{}
+	if err := Query(kv.db, fmt.Sprintf("SELECT value FROM %s WHERE key = ?", kv.table), &values, key); err != nil {
+		return err
+	} else if len(values) == 0 {
+		return sql.ErrNoRows
+	}
+	return json.Unmarshal([]byte(values[0]), value)
+}
+
+func (kv *KV) Delete(key string) error {
+	if err := kv.init(); err != nil {
+		return err
+	}
+	_, err := Exec(kv.db, fmt.Sprintf("DELETE FROM %s WHERE key = ?", kv.table), key)
+	return err
+}
+
+func (kv *KV) Keys() ([]string, error) {
+	if err := kv.init(); err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	return keys, Query(kv.db, fmt.Sprintf("SELECT key FROM %s ORDER BY key", kv.table), &keys)
+}
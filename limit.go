@@ -0,0 +1,111 @@
+package gosql
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Limiter struct {
+	Max     int
+	Reject  bool
+	Timeout time.Duration
+
+	once            sync.Once
+	slots           chan struct{}
+	waiting         int64
+	acquired        int64
+	rejected        int64
+	waitDuration    int64
+	maxWaitDuration int64
+}
+
+type LimiterStats struct {
+	InUse           int
+	Waiting         int
+	Acquired        int64
+	Rejected        int64
+	WaitDuration    time.Duration
+	MaxWaitDuration time.Duration
+}
+
+var ErrLimitExceeded = errors.New("concurrency limit exceeded")
+
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	if l == nil || l.Max <= 0 {
+		return func() {}, nil
+	}
+	l.init()
+	select {
+	case l.slots <- struct{}{}:
+		l.record(0, nil)
+		return l.release(), nil
+	default:
+		if l.Reject {
+			l.record(0, ErrLimitExceeded)
+			return nil, ErrLimitExceeded
+		}
+	}
+	start, timeout := time.Now(), (<-chan time.Time)(nil)
+	if l.Timeout > 0 {
+		t := time.NewTimer(l.Timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	atomic.AddInt64(&l.waiting, 1)
+	err := error(nil)
+	select {
+	case l.slots <- struct{}{}:
+	case <-timeout:
+		err = ErrLimitExceeded
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	atomic.AddInt64(&l.waiting, -1)
+	l.record(time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	return l.release(), nil
+}
+
+func (l *Limiter) Stats() LimiterStats {
+	if l == nil {
+		return LimiterStats{}
+	}
+	stats := LimiterStats{
+		Waiting:         int(atomic.LoadInt64(&l.waiting)),
+		Acquired:        atomic.LoadInt64(&l.acquired),
+		Rejected:        atomic.LoadInt64(&l.rejected),
+		WaitDuration:    time.Duration(atomic.LoadInt64(&l.waitDuration)),
+		MaxWaitDuration: time.Duration(atomic.LoadInt64(&l.maxWaitDuration)),
+	}
+	if l.Max > 0 {
+		l.init()
+		stats.InUse = len(l.slots)
+	}
+	return stats
+}
+
+func (l *Limiter) init() { l.once.Do(func() { l.slots = make(chan struct{}, l.Max) }) }
+
+func (l *Limiter) release() func() {
+	once := sync.Once{}
+	return func() { once.Do(func() { <-l.slots }) }
+}
+
+func (l *Limiter) record(wait time.Duration, err error) {
+	if err != nil {
+		atomic.AddInt64(&l.rejected, 1)
+	} else {
+		atomic.AddInt64(&l.acquired, 1)
+	}
+	atomic.AddInt64(&l.waitDuration, int64(wait))
+	for max := atomic.LoadInt64(&l.maxWaitDuration); int64(wait) > max; max = atomic.LoadInt64(&l.maxWaitDuration) {
+		if atomic.CompareAndSwapInt64(&l.maxWaitDuration, max, int64(wait)) {
+			break
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package gosql
+
+import (
+	"fmt"
+	"strings"
+)
+
+type LintWarning struct {
+	Line    int
+	Message string
+}
+
+var nonDeterministicFuncs = map[string]bool{
+	"RANDOM": true, "RANDOMBLOB": true, "CHANGES": true, "TOTAL_CHANGES": true, "LAST_INSERT_ROWID": true,
+}
+
+var fromClauseEnd = map[string]bool{
+	"WHERE": true, "GROUP": true, "HAVING": true, "ORDER": true, "LIMIT": true, "WINDOW": true, "UNION": true,
+	"EXCEPT": true, "INTERSECT": true, "JOIN": true, "ON": true, "USING": true, "RETURNING": true,
+}
+
+func (w LintWarning) String() string { return fmt.Sprintf("%d: %s", w.Line, w.Message) }
+
+func Lint(sql string) []LintWarning {
+	warnings, tokens, lines, line := []LintWarning{}, []token{}, []int{}, 1
+	for _, t := range tokenize(sql) {
+		if t.kind != "space" && t.kind != "comment" {
+			tokens, lines = append(tokens, t), append(lines, line)
+		}
+		line += strings.Count(t.text, "\n")
+	}
+	warn := func(i int, format string, args ...interface{}) {
+		warnings = append(warnings, LintWarning{lines[i], fmt.Sprintf(format, args...)})
+	}
+	depth, inFrom, inIndex := 0, map[int]bool{}, false
+	for i, t := range tokens {
+		upper, next := strings.ToUpper(t.text), ""
+		if i+1 < len(tokens) {
+			next = tokens[i+1].text
+		}
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			inFrom[depth] = false
+			depth--
+		case t.text == ";":
+			depth, inFrom, inIndex = 0, map[int]bool{}, false
+		case t.kind != "word":
+			if t.text == "," && inFrom[depth] {
+				warn(i, "implicit cross join - use an explicit JOIN ... ON")
+				inFrom[depth] = false
+			}
+		case upper == "SELECT" && (next == "*" || strings.EqualFold(next, "DISTINCT") && i+2 < len(tokens) && tokens[i+2].text == "*"):
+			warn(i, "SELECT * - list the columns explicitly")
+		case upper == "FROM":
+			inFrom[depth] = true
+		case fromClauseEnd[upper]:
+			inFrom[depth] = false
+		case upper == "LIKE" && (strings.HasPrefix(next, "'%") || strings.HasPrefix(next, "'_")):
+			warn(i, "LIKE with a leading wildcard cannot use an index")
+		case upper == "INDEX" && i > 0 && (strings.EqualFold(tokens[i-1].text, "CREATE") || strings.EqualFold(tokens[i-1].text, "UNIQUE")):
+			inIndex = true
+		case inIndex && nonDeterministicFuncs[upper] && next == "(":
+			warn(i, "non-deterministic function %s in index expression", t.text)
+		}
+		if inIndex && t.kind == "quoted" && strings.EqualFold(t.text, "'now'") {
+			warn(i, "non-deterministic 'now' in index expression")
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,70 @@
+package gosql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// LiveHandler holds a HandlerLimit slot for as long as the subscription lasts.
+func (db *DB) LiveHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	query, args := r.URL.Query().Get("query"), []interface{}{}
+	for _, arg := range r.URL.Query()["arg"] {
+		args = append(args, arg)
+	}
+	release, err := db.HandlerLimit.Acquire(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), handlerErrorStatus(r.Context(), err, http.StatusServiceUnavailable))
+		return
+	}
+	defer release()
+	conn, err := db.RODB.Conn(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer conn.Close()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	interval := db.ListenInterval
+	if interval <= 0 {
+		interval = defaultListenInterval
+	}
+	ticker, version, last := time.NewTicker(interval), int64(-1), []byte(nil)
+	defer ticker.Stop()
+	for {
+		current := int64(0)
+		if err := conn.QueryRowContext(r.Context(), "PRAGMA data_version").Scan(&current); err != nil {
+			return
+		}
+		if current != version {
+			version = current
+			results, event := []map[string]JSON{}, "results"
+			data := interface{}(&results)
+			if err := Query(ctxConn{r.Context(), conn}, query, &results, args...); err != nil {
+				event, data = "error", map[string]string{"error": err.Error()}
+			}
+			bs, err := json.Marshal(data)
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(bs, last) {
+				last = bs
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, bs)
+				flusher.Flush()
+			}
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package gosql
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type logWriter struct{ Logger }
+
+var defaultLogger Logger = log.New(os.Stderr, "", 0)
+
+func (db *DB) logger() Logger {
+	if db.Logger != nil {
+		return db.Logger
+	}
+	return defaultLogger
+}
+
+func (w logWriter) Write(bs []byte) (int, error) {
+	w.Printf("%s", strings.TrimSuffix(string(bs), "\n"))
+	return len(bs), nil
+}
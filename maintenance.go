@@ -0,0 +1,53 @@
+package gosql
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type Maintenance struct {
+	CheckpointInterval time.Duration
+	OptimizeInterval   time.Duration
+	VacuumInterval     time.Duration
+	VacuumPages        int
+	OnRun              func(task string, duration time.Duration, err error)
+}
+
+func (db *DB) StartMaintenance(m Maintenance) (stop func()) {
+	done, wg := make(chan struct{}), &sync.WaitGroup{}
+	tasks := map[string]struct {
+		interval time.Duration
+		query    string
+	}{
+		"checkpoint": {m.CheckpointInterval, "PRAGMA wal_checkpoint(TRUNCATE)"},
+		"optimize":   {m.OptimizeInterval, "PRAGMA optimize"},
+		"vacuum":     {m.VacuumInterval, fmt.Sprintf("PRAGMA incremental_vacuum(%d)", m.VacuumPages)},
+	}
+	for name, task := range tasks {
+		if task.interval <= 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(name, query string, ticker *time.Ticker) {
+			defer wg.Done()
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if db.DB.Stats().InUse != 0 {
+						continue
+					}
+					start := time.Now()
+					_, err := db.DB.Exec(query)
+					if m.OnRun != nil {
+						m.OnRun(name, time.Since(start), err)
+					}
+				}
+			}
+		}(name, task.query, time.NewTicker(task.interval))
+	}
+	return func() { close(done); wg.Wait() }
+}
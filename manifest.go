@@ -0,0 +1,116 @@
+package gosql
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+type Manifest struct {
+	GosqlVersion string          `json:"gosql_version"`
+	Tables       []ManifestTable `json:"tables"`
+	Migrations   []string        `json:"migrations"`
+}
+
+type ManifestTable struct {
+	Name    string           `db:"name" json:"name"`
+	SQL     string           `db:"sql" json:"sql"`
+	Columns []ManifestColumn `db:"-" json:"columns"`
+	Indexes []string         `db:"-" json:"indexes"`
+}
+
+type ManifestColumn struct {
+	Name    string  `db:"name" json:"name"`
+	Type    string  `db:"type" json:"type"`
+	NotNull int     `db:"notnull" json:"notnull"`
+	Default *string `db:"dflt_value" json:"default"`
+	PK      int     `db:"pk" json:"pk"`
+}
+
+func ReadManifest(c Connection) (*Manifest, error) {
+	m := &Manifest{GosqlVersion: gosqlVersion(), Tables: []ManifestTable{}, Migrations: []string{}}
+	query := "SELECT name, sql FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND substr(name, 1, 1) != '_' ORDER BY name"
+	if err := Query(c, query, &m.Tables); err != nil {
+		return nil, err
+	}
+	for i, t := range m.Tables {
+		m.Tables[i].Columns, m.Tables[i].Indexes = []ManifestColumn{}, []string{}
+		if err := Query(c, "SELECT name, type, \"notnull\", dflt_value, pk FROM pragma_table_info(?)", &m.Tables[i].Columns, t.Name); err != nil {
+			return nil, err
+		}
+		query := "SELECT sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL ORDER BY name"
+		if err := Query(c, query, &m.Tables[i].Indexes, t.Name); err != nil {
+			return nil, err
+		}
+	}
+	table := "_migrations"
+	if db, _ := hookedDB(c); db != nil {
+		table = db.migrationsTable()
+	}
+	migrationTables := []int{}
+	if err := Query(c, "SELECT count(*) FROM sqlite_master WHERE name = ?", &migrationTables, strings.Trim(table, `"`)); err != nil {
+		return nil, err
+	} else if migrationTables[0] != 0 {
+		if err := Query(c, "SELECT name FROM "+table+" ORDER BY rowid", &m.Migrations); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Manifest) Create(c Connection) error {
+	for _, t := range m.Tables {
+		if _, err := Exec(c, strings.Replace(t.SQL, "CREATE TABLE", "CREATE TABLE IF NOT EXISTS", 1)); err != nil {
+			return err
+		}
+		for _, index := range t.Indexes {
+			if _, err := Exec(c, strings.Replace(index, "INDEX", "INDEX IF NOT EXISTS", 1)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Manifest) Verify(c Connection) error {
+	current, err := ReadManifest(c)
+	if err != nil {
+		return err
+	}
+	tables := map[string]ManifestTable{}
+	for _, t := range current.Tables {
+		tables[t.Name] = t
+	}
+	for _, expected := range m.Tables {
+		actual, ok := tables[expected.Name]
+		if !ok {
+			return fmt.Errorf("table %s does not exist", expected.Name)
+		}
+		columns := map[string]ManifestColumn{}
+		for _, column := range actual.Columns {
+			columns[column.Name] = column
+		}
+		for _, column := range expected.Columns {
+			if actual, ok := columns[column.Name]; !ok {
+				return fmt.Errorf("table %s: column %s does not exist", expected.Name, column.Name)
+			} else if !strings.EqualFold(actual.Type, column.Type) {
+				return fmt.Errorf("table %s: column %s has type %s not %s", expected.Name, column.Name, actual.Type, column.Type)
+			}
+		}
+	}
+	return nil
+}
+
+func gosqlVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == "github.com/niklasfasching/gosql" {
+			return info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/niklasfasching/gosql" {
+				return dep.Version
+			}
+		}
+	}
+	return "(devel)"
+}
@@ -0,0 +1,192 @@
+package gosql
+
+import (
+	"expvar"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+type Metrics struct {
+	MaxFingerprints int
+	mutex           sync.Mutex
+	queries         int64
+	errors          int64
+	busyRetries     int64
+	fingerprints    map[string]*QueryMetrics
+	stats           []QueryStat
+}
+
+type QueryStat struct {
+	Fingerprint string
+	Count       int64
+	Errors      int64
+	Total       time.Duration
+	Max         time.Duration
+}
+
+type QueryMetrics struct {
+	Count   int64         `json:"count"`
+	Errors  int64         `json:"errors"`
+	Total   time.Duration `json:"total"`
+	Max     time.Duration `json:"max"`
+	Buckets []int64       `json:"buckets"`
+}
+
+var LatencyBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second,
+}
+
+func (m *Metrics) record(query string, d time.Duration, err error) {
+	fingerprint := Fingerprint(query)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.fingerprints == nil {
+		m.fingerprints = map[string]*QueryMetrics{}
+	}
+	max := m.MaxFingerprints
+	if max == 0 {
+		max = 1000
+	}
+	qm, ok := m.fingerprints[fingerprint]
+	if !ok && len(m.fingerprints) >= max {
+		fingerprint = "other"
+		qm, ok = m.fingerprints[fingerprint]
+	}
+	if !ok {
+		qm = &QueryMetrics{Buckets: make([]int64, len(LatencyBuckets)+1)}
+		m.fingerprints[fingerprint] = qm
+	}
+	m.queries++
+	m.stats = nil
+	qm.Count++
+	if err != nil {
+		m.errors++
+		qm.Errors++
+	}
+	qm.Total += d
+	if d > qm.Max {
+		qm.Max = d
+	}
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	qm.Buckets[i]++
+}
+
+func (m *Metrics) recordBusyRetry() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.busyRetries++
+}
+
+func (m *Metrics) Snapshot() (queries, errors, busyRetries int64, fingerprints map[string]QueryMetrics) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	fingerprints = map[string]QueryMetrics{}
+	for k, qm := range m.fingerprints {
+		fingerprints[k] = QueryMetrics{qm.Count, qm.Errors, qm.Total, qm.Max, append([]int64{}, qm.Buckets...)}
+	}
+	return m.queries, m.errors, m.busyRetries, fingerprints
+}
+
+func (db *DB) QueryStats() []QueryStat {
+	if db.Metrics == nil {
+		return nil
+	}
+	return db.Metrics.queryStats()
+}
+
+func (m *Metrics) queryStats() []QueryStat {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.stats == nil {
+		m.stats = make([]QueryStat, 0, len(m.fingerprints))
+		for k, qm := range m.fingerprints {
+			m.stats = append(m.stats, QueryStat{k, qm.Count, qm.Errors, qm.Total, qm.Max})
+		}
+		sort.Slice(m.stats, func(i, j int) bool {
+			if m.stats[i].Total != m.stats[j].Total {
+				return m.stats[i].Total > m.stats[j].Total
+			}
+			return m.stats[i].Fingerprint < m.stats[j].Fingerprint
+		})
+	}
+	return m.stats
+}
+
+func (db *DB) registerQueryStats(c driverConn) error {
+	if db.Metrics == nil {
+		return nil
+	}
+	// the view takes one snapshot per statement - connections run one statement at a time
+	snapshot := []QueryStat{}
+	stat := func(i int) QueryStat {
+		if i >= 0 && i < len(snapshot) {
+			return snapshot[i]
+		}
+		return QueryStat{}
+	}
+	funcs := map[string]interface{}{
+		"_query_stats_snapshot":    func() int { snapshot = db.Metrics.queryStats(); return len(snapshot) },
+		"_query_stats_fingerprint": func(i int) string { return stat(i).Fingerprint },
+		"_query_stats_calls":       func(i int) int64 { return stat(i).Count },
+		"_query_stats_errors":      func(i int) int64 { return stat(i).Errors },
+		"_query_stats_total_ms":    func(i int) float64 { return stat(i).Total.Seconds() * 1000 },
+		"_query_stats_max_ms":      func(i int) float64 { return stat(i).Max.Seconds() * 1000 },
+	}
+	for name, f := range funcs {
+		if err := c.RegisterFunc(name, f, false); err != nil {
+			return err
+		}
+	}
+	_, err := c.Exec(queryStatsView, nil)
+	return err
+}
+
+const queryStatsView = `CREATE TEMP VIEW IF NOT EXISTS _query_stats AS
+WITH RECURSIVE i(n, count) AS (SELECT 0, _query_stats_snapshot() UNION ALL SELECT n + 1, count FROM i WHERE n + 1 < count)
+SELECT _query_stats_fingerprint(n) AS fingerprint, _query_stats_calls(n) AS calls, _query_stats_errors(n) AS errors,
+       _query_stats_total_ms(n) AS total_ms, _query_stats_max_ms(n) AS max_ms
+FROM i WHERE n < count ORDER BY n`
+
+func (db *DB) MetricsVar() expvar.Var {
+	return expvar.Func(func() interface{} {
+		v := map[string]interface{}{"rw_pool": db.DB.Stats(), "ro_pool": db.RODB.Stats()}
+		if db.Metrics != nil {
+			queries, errors, busyRetries, fingerprints := db.Metrics.Snapshot()
+			v["queries"], v["errors"], v["busy_retries"], v["fingerprints"] = queries, errors, busyRetries, fingerprints
+		}
+		if wal, err := db.walInfo(walInfoMaxAge); err == nil && wal.Enabled {
+			v["wal"] = wal
+		}
+		return v
+	})
+}
+
+func Fingerprint(query string) string {
+	tokens, b := tokenize(query), &strings.Builder{}
+	for i := 0; i < len(tokens); i++ {
+		switch t := tokens[i]; {
+		case t.kind == "comment":
+		case t.kind == "space":
+			if s := b.String(); s != "" && s[len(s)-1] != ' ' {
+				b.WriteByte(' ')
+			}
+		case t.kind == "quoted" && t.text[0] == '\'':
+			b.WriteByte('?')
+		case t.kind == "word" && unicode.IsDigit(rune(t.text[0])):
+			if i+2 < len(tokens) && tokens[i+1].text == "." && unicode.IsDigit(rune(tokens[i+2].text[0])) {
+				i += 2
+			}
+			b.WriteByte('?')
+		default:
+			b.WriteString(t.text)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,131 @@
+package gosql
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CORSOptions struct {
+	Origins     []string
+	Methods     []string
+	Headers     []string
+	Credentials bool
+	MaxAge      time.Duration
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	bodyless bool
+	w        interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+func CORS(h http.Handler, o CORSOptions) http.Handler {
+	methods, headers := "GET, POST", "Content-Type, Authorization"
+	if len(o.Methods) != 0 {
+		methods = strings.Join(o.Methods, ", ")
+	}
+	if len(o.Headers) != 0 {
+		headers = strings.Join(o.Headers, ", ")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed, wildcard := o.allows(origin)
+		if origin == "" || !allowed {
+			h.ServeHTTP(w, r)
+			return
+		}
+		// origins only matched by "*" get a literal "*" and never credentials - echoing them back with
+		// credentials would let any site make authenticated requests
+		if wildcard {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if o.Credentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Next-Cursor")
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", methods)
+		w.Header().Set("Access-Control-Allow-Headers", headers)
+		if o.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(o.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (o CORSOptions) allows(origin string) (allowed, wildcard bool) {
+	for _, allowedOrigin := range o.Origins {
+		if allowedOrigin == origin {
+			return true, false
+		} else if allowedOrigin == "*" {
+			allowed, wildcard = true, true
+		}
+	}
+	return allowed, wildcard
+}
+
+func Compress(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		cw, encoding := &compressWriter{ResponseWriter: w}, acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		switch encoding {
+		case "gzip":
+			cw.w = gzip.NewWriter(w)
+		case "deflate":
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			cw.w = fw
+		default:
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Del("Content-Length")
+		h.ServeHTTP(cw, r)
+		if !cw.bodyless {
+			cw.w.Close()
+		}
+	})
+}
+
+func acceptedEncoding(header string) string {
+	for _, encoding := range []string{"gzip", "deflate"} {
+		for _, accepted := range strings.Split(header, ",") {
+			name := strings.TrimSpace(strings.SplitN(accepted, ";", 2)[0])
+			if name == encoding && !strings.HasSuffix(strings.ReplaceAll(accepted, " ", ""), ";q=0") {
+				return encoding
+			}
+		}
+	}
+	return ""
+}
+
+func (cw *compressWriter) Write(bs []byte) (int, error) { return cw.w.Write(bs) }
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.Header().Del("Content-Encoding")
+		cw.bodyless = true
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Flush() {
+	cw.w.Flush()
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
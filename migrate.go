@@ -0,0 +1,297 @@
+package gosql
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var downMigrationRegexp = regexp.MustCompile(`(?m)^--\s*\+down\s*$`)
+var noTransactionRegexp = regexp.MustCompile(`(?m)^--\s*\+notransaction\s*$`)
+
+type MigrationStatus struct {
+	Name       string     `db:"name"`
+	AppliedAt  *time.Time `db:"timestamp"`
+	DurationMS float64    `db:"duration_ms"`
+	Checksum   string     `db:"checksum"` // sha256 of sql migrations
+	Unknown    bool       `db:"-"`        // applied but no longer part of the loaded migrations
+	Modified   bool       `db:"-"`        // applied with a different checksum than the loaded migration
+}
+
+var migrationColumns = []string{"name STRING", "timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP", "duration_ms REAL", "checksum TEXT"}
+
+func (db *DB) migrate(migrations interface{}) error {
+	if err := db.LoadMigrations(migrations); err != nil {
+		return err
+	}
+	return db.Migrate()
+}
+
+// LoadMigrations sets the migrations used by Migrate, MigrateTo, Rollback and Migrations without applying them
+func (db *DB) LoadMigrations(migrations interface{}) error {
+	db.migrations = map[string]interface{}{}
+	switch m := migrations.(type) {
+	case nil:
+	case map[string]string:
+		for k, v := range m {
+			db.migrations[k] = v
+		}
+	case map[string]interface{}:
+		for k, v := range m {
+			switch v.(type) {
+			case string, func(Connection) error:
+				db.migrations[k] = v
+			default:
+				return fmt.Errorf("migration %s: unhandled type %T", k, v)
+			}
+		}
+	case fs.FS:
+		sqlMigrations, err := ReadMigrationsFS(m, "*.sql")
+		if err != nil {
+			return err
+		}
+		return db.LoadMigrations(sqlMigrations)
+	default:
+		return fmt.Errorf("unhandled migrations type %T", migrations)
+	}
+	return nil
+}
+
+func (db *DB) Migrate() error {
+	return db.applyMigrations(func(string) bool { return true })
+}
+
+// Migrations returns applied migrations in the order they were applied followed by pending migrations in the order they will be applied
+func (db *DB) Migrations() ([]MigrationStatus, error) {
+	if _, err := db.appliedMigrations(); err != nil {
+		return nil, err
+	}
+	ms, applied, pending := []MigrationStatus{}, map[string]bool{}, []string{}
+	query := "SELECT name, timestamp, coalesce(duration_ms, 0) AS duration_ms, coalesce(checksum, '') AS checksum FROM " + db.migrationsTable() + " ORDER BY rowid"
+	if err := Query(db, query, &ms); err != nil {
+		return nil, err
+	}
+	for i, m := range ms {
+		migration, ok := db.migrations[m.Name]
+		checksum := migrationChecksum(migration)
+		ms[i].Unknown, ms[i].Modified, applied[m.Name] = !ok, ok && m.Checksum != "" && checksum != "" && m.Checksum != checksum, true
+	}
+	for name := range db.migrations {
+		if !applied[name] {
+			pending = append(pending, name)
+		}
+	}
+	sort.Strings(pending)
+	for _, name := range pending {
+		ms = append(ms, MigrationStatus{Name: name})
+	}
+	return ms, nil
+}
+
+func (db *DB) MigrateTo(name string) error {
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	for i := len(applied) - 1; i >= 0; i-- {
+		if applied[i] > name {
+			if err := db.rollback(applied[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return db.applyMigrations(func(key string) bool { return key <= name })
+}
+
+func (db *DB) applyMigrations(include func(string) bool) error {
+	names, err := db.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	applied, keys := map[string]bool{}, []string{}
+	for _, name := range names {
+		applied[name] = true
+	}
+	for key := range db.migrations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if applied[key] || !include(key) {
+			continue
+		}
+		run, transaction := migrationFunc(db.migrations[key], true)
+		record := func(c Connection, d time.Duration) error {
+			query := "INSERT INTO " + db.migrationsTable() + " (name, duration_ms, checksum) VALUES (?, ?, ?)"
+			_, err := c.Exec(query, key, float64(d)/float64(time.Millisecond), migrationChecksum(db.migrations[key]))
+			return err
+		}
+		if err := db.execMigration(run, transaction, record); err != nil {
+			return fmt.Errorf("migration %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (db *DB) Rollback(n int) error {
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	for i := len(applied) - 1; i >= 0 && i >= len(applied)-n; i-- {
+		if err := db.rollback(applied[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) rollback(name string) error {
+	migration, ok := db.migrations[name].(string)
+	if !ok {
+		return fmt.Errorf("rollback %s: unknown or non-sql migration", name)
+	}
+	if _, down := splitMigration(migration); strings.TrimSpace(down) == "" {
+		return fmt.Errorf("rollback %s: no down migration", name)
+	}
+	run, transaction := migrationFunc(migration, false)
+	record := func(c Connection, _ time.Duration) error {
+		_, err := c.Exec("DELETE FROM "+db.migrationsTable()+" WHERE name = ?", name)
+		return err
+	}
+	if err := db.execMigration(run, transaction, record); err != nil {
+		return fmt.Errorf("rollback %s: %w", name, err)
+	}
+	return nil
+}
+
+func migrationFunc(migration interface{}, up bool) (func(Connection) error, bool) {
+	if f, ok := migration.(func(Connection) error); ok {
+		return f, true
+	}
+	query, down := splitMigration(migration.(string))
+	if !up {
+		query = down
+	}
+	return func(c Connection) error {
+		return ExecScript(c, query)
+	}, !noTransactionRegexp.MatchString(migration.(string))
+}
+
+func (db *DB) execMigration(run func(Connection) error, transaction bool, record func(Connection, time.Duration) error) error {
+	start := time.Now()
+	if !transaction {
+		if err := run(db); err != nil {
+			return err
+		}
+		return record(db, time.Since(start))
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := run(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := record(tx, time.Since(start)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrations tables created before duration_ms and checksum existed are upgraded in place
+func (db *DB) appliedMigrations() ([]string, error) {
+	table := db.migrationsTable()
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS " + table + " (" + strings.Join(migrationColumns, ", ") + ")"); err != nil {
+		return nil, err
+	}
+	columns, exists := []string{}, map[string]bool{}
+	if err := Query(db, "SELECT name FROM pragma_table_info(?)", &columns, strings.Trim(table, `"`)); err != nil {
+		return nil, err
+	}
+	for _, column := range columns {
+		exists[column] = true
+	}
+	for _, column := range migrationColumns {
+		if exists[strings.Fields(column)[0]] {
+			continue
+		} else if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column); err != nil {
+			return nil, err
+		}
+	}
+	names := []string{}
+	if err := Query(db, "SELECT name FROM "+table+" ORDER BY rowid", &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func (db *DB) migrationsTable() string {
+	if db.MigrationsTable == "" {
+		return "_migrations"
+	}
+	table, _ := quoteIdentifier(db.MigrationsTable)
+	return table
+}
+
+func migrationChecksum(migration interface{}) string {
+	if s, ok := migration.(string); ok {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+	}
+	return ""
+}
+
+func splitMigration(migration string) (string, string) {
+	if loc := downMigrationRegexp.FindStringIndex(migration); loc != nil {
+		return migration[:loc[0]], migration[loc[1]:]
+	}
+	return migration, ""
+}
+
+func ReadMigrations(directory string) (map[string]string, error) {
+	m, err := ReadMigrationsFS(os.DirFS(directory), "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	migrations := map[string]string{}
+	for name, migration := range m {
+		migrations[filepath.Join(directory, name)] = migration
+	}
+	return migrations, nil
+}
+
+func ReadMigrationsFS(fsys fs.FS, glob string) (map[string]string, error) {
+	m := map[string]string{}
+	sqlFiles, err := fs.Glob(fsys, glob)
+	if err != nil {
+		return nil, err
+	}
+	for _, sqlFile := range sqlFiles {
+		if strings.HasSuffix(sqlFile, ".down.sql") {
+			continue
+		}
+		bs, err := fs.ReadFile(fsys, sqlFile)
+		if err != nil {
+			return nil, err
+		}
+		m[sqlFile] = string(bs)
+		if strings.HasSuffix(sqlFile, ".up.sql") {
+			downFile := strings.TrimSuffix(sqlFile, ".up.sql") + ".down.sql"
+			if bs, err := fs.ReadFile(fsys, downFile); err == nil {
+				m[sqlFile] += "\n-- +down\n" + string(bs)
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+	return m, nil
+}
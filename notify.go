@@ -0,0 +1,103 @@
+package gosql
+
+import (
+	"context"
+	"time"
+)
+
+type Message struct {
+	ID        int64     `db:"id" json:"id"`
+	Channel   string    `db:"channel" json:"channel"`
+	Payload   string    `db:"payload" json:"payload"`
+	Timestamp time.Time `db:"timestamp" json:"timestamp"`
+}
+
+var defaultListenInterval = 100 * time.Millisecond
+
+func (db *DB) Notify(channel, payload string) error {
+	if err := db.createNotifyTable(); err != nil {
+		return err
+	}
+	// the newest row is kept so ids keep increasing - listeners only fetch ids above the last one they have seen
+	if _, err := Exec(db, "DELETE FROM _notify WHERE timestamp < datetime('now', '-1 hour') AND id < (SELECT max(id) FROM _notify)"); err != nil {
+		return err
+	}
+	_, err := Exec(db, "INSERT INTO _notify (channel, payload) VALUES (?, ?)", channel, payload)
+	return err
+}
+
+func (db *DB) Listen(ctx context.Context, channel string) (<-chan string, error) {
+	messages, err := db.ListenMessages(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+	payloads := make(chan string)
+	go func() {
+		defer close(payloads)
+		for m := range messages {
+			select {
+			case <-ctx.Done():
+			case payloads <- m.Payload:
+			}
+		}
+	}()
+	return payloads, nil
+}
+
+// ListenMessages is like Listen but also delivers id, channel and timestamp of notifications
+func (db *DB) ListenMessages(ctx context.Context, channel string) (<-chan Message, error) {
+	if err := db.createNotifyTable(); err != nil {
+		return nil, err
+	}
+	conn, err := db.RODB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c, last, version := ctxConn{ctx, conn}, []int64{}, int64(-1)
+	if err := Query(c, "SELECT coalesce(max(id), 0) FROM _notify", &last); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	interval := db.ListenInterval
+	if interval <= 0 {
+		interval = defaultListenInterval
+	}
+	messages, ticker := make(chan Message), time.NewTicker(interval)
+	go func() {
+		defer close(messages)
+		defer conn.Close()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			versions, notifications := []int64{}, []Message{}
+			if err := Query(c, "PRAGMA data_version", &versions); err != nil || versions[0] == version {
+				continue
+			}
+			version = versions[0]
+			query := "SELECT id, channel, payload, timestamp FROM _notify WHERE id > ? AND channel = ? ORDER BY id"
+			if err := Query(c, query, &notifications, last[0], channel); err != nil {
+				db.logger().Printf("WARNING: listening on %s: %s", channel, err)
+				continue
+			}
+			for _, n := range notifications {
+				select {
+				case <-ctx.Done():
+					return
+				case messages <- n:
+					last[0] = n.ID
+				}
+			}
+		}
+	}()
+	return messages, nil
+}
+
+func (db *DB) createNotifyTable() error {
+	_, err := Exec(db, `CREATE TABLE IF NOT EXISTS _notify (
+		id INTEGER PRIMARY KEY, channel TEXT, payload TEXT, timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+	return err
+}
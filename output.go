@@ -0,0 +1,229 @@
+package gosql
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type OutputOptions struct {
+	Mode     string
+	Null     string // defaults to NULL - and the empty string for csv
+	NoHeader bool
+	MaxRows  int // output stops after MaxRows rows; Output then returns an error if there were more
+}
+
+var OutputModes = []string{"table", "json", "ndjson", "csv", "markdown", "line"}
+
+func Table(w io.Writer, c Connection, query string, args ...interface{}) error {
+	return Output(w, c, OutputOptions{Mode: "table"}, query, args...)
+}
+
+// Output writes query results as an aligned table, a JSON array, NDJSON, CSV, a markdown table or one "column = value" line per column
+func Output(w io.Writer, c Connection, o OutputOptions, query string, args ...interface{}) error {
+	_, err := output(w, c, o, query, args...)
+	return err
+}
+
+func output(w io.Writer, c Connection, o OutputOptions, query string, args ...interface{}) (n int, err error) {
+	write, flush, err := outputWriter(w, o)
+	if err != nil {
+		return 0, err
+	}
+	err = withRows(c, query, args, func(rows *resultRows) error {
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		for i := 0; rows.Next(); i++ {
+			if o.MaxRows > 0 && i == o.MaxRows {
+				if err := flush(); err != nil {
+					return err
+				}
+				return errTooManyRows(o.MaxRows)
+			}
+			values := make([]interface{}, len(columns))
+			for i := range values {
+				values[i] = new(interface{})
+			}
+			if err := rows.Scan(values...); err != nil {
+				return err
+			}
+			for i, v := range values {
+				values[i] = *(v.(*interface{}))
+			}
+			if err := write(i, columns, values); err != nil {
+				return err
+			}
+			n++
+		}
+		return flush()
+	})
+	return n, err
+}
+
+func outputWriter(w io.Writer, o OutputOptions) (write func(i int, columns []string, values []interface{}) error, flush func() error, err error) {
+	null := o.Null
+	if null == "" && o.Mode != "csv" {
+		null = "NULL"
+	}
+	switch o.Mode {
+	case "table", "markdown":
+		rows, numeric := [][]string{}, []bool{}
+		return func(i int, columns []string, values []interface{}) error {
+			if i == 0 {
+				numeric = make([]bool, len(columns))
+				for i := range numeric {
+					numeric[i] = true
+				}
+				if !o.NoHeader {
+					rows = append(rows, append([]string{}, columns...), make([]string, len(columns)))
+				}
+			}
+			for i, v := range values {
+				switch v.(type) {
+				case nil, int64, float64:
+				default:
+					numeric[i] = false
+				}
+			}
+			rows = append(rows, outputStrings(values, null))
+			return nil
+		}, func() error { return writeAligned(w, rows, numeric, !o.NoHeader, o.Mode == "markdown") }, nil
+	case "json", "ndjson":
+		b, j, n := &bytes.Buffer{}, json.NewEncoder(w), 0
+		if o.Mode == "json" {
+			j = json.NewEncoder(b)
+		}
+		j.SetEscapeHTML(false)
+		return func(i int, columns []string, values []interface{}) error {
+				m := map[string]interface{}{}
+				for i, k := range columns {
+					if bs, ok := values[i].([]byte); ok {
+						m[k] = string(bs)
+					} else {
+						m[k] = values[i]
+					}
+				}
+				if err := j.Encode(m); err != nil || o.Mode == "ndjson" {
+					return err
+				}
+				prefix := ",\n"
+				if n++; n == 1 {
+					prefix = "[\n"
+				}
+				_, err := fmt.Fprintf(w, "%s%s", prefix, bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+				b.Reset()
+				return err
+			}, func() error {
+				if o.Mode == "ndjson" {
+					return nil
+				} else if n == 0 {
+					_, err := io.WriteString(w, "[]\n")
+					return err
+				}
+				_, err := io.WriteString(w, "\n]\n")
+				return err
+			}, nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		return func(i int, columns []string, values []interface{}) error {
+			if i == 0 && !o.NoHeader {
+				if err := cw.Write(columns); err != nil {
+					return err
+				}
+			}
+			return cw.Write(outputStrings(values, null))
+		}, func() error { cw.Flush(); return cw.Error() }, nil
+	case "line":
+		return func(i int, columns []string, values []interface{}) error {
+			width := 0
+			for _, column := range columns {
+				if len(column) > width {
+					width = len(column)
+				}
+			}
+			if i != 0 {
+				if _, err := fmt.Fprintln(w); err != nil {
+					return err
+				}
+			}
+			for i, s := range outputStrings(values, null) {
+				if _, err := fmt.Fprintf(w, "%*s = %s\n", width, columns[i], s); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, func() error { return nil }, nil
+	}
+	return nil, nil, fmt.Errorf("unknown output mode %q (%s)", o.Mode, strings.Join(OutputModes, ", "))
+}
+
+// numeric columns are right aligned. with header, rows[1] is replaced by the separator line
+func writeAligned(w io.Writer, rows [][]string, numeric []bool, header, markdown bool) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	escape, widths := strings.NewReplacer("|", `\|`, "\n", " "), make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if markdown {
+				row[i] = escape.Replace(cell)
+			}
+			if n := utf8.RuneCountInString(row[i]); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	if header {
+		for i := range widths {
+			if markdown && widths[i] < 3 {
+				widths[i] = 3
+			}
+			rows[1][i] = strings.Repeat("-", widths[i])
+			if markdown && numeric[i] {
+				rows[1][i] = rows[1][i][1:] + ":"
+			}
+		}
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			padding := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+			if numeric[i] {
+				row[i] = padding + cell
+			} else if markdown || i != len(row)-1 {
+				row[i] = cell + padding
+			}
+		}
+		line := strings.Join(row, "  ")
+		if markdown {
+			line = "| " + strings.Join(row, " | ") + " |"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func outputStrings(values []interface{}, null string) []string {
+	strs := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case nil:
+			strs[i] = null
+		case []byte:
+			strs[i] = string(v)
+		case time.Time:
+			strs[i] = v.Format(time.RFC3339Nano)
+		default:
+			strs[i] = fmt.Sprint(v)
+		}
+	}
+	return strs
+}
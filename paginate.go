@@ -0,0 +1,139 @@
+package gosql
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type Page struct {
+	Limit  int
+	Cursor string
+	Keys   []string
+}
+
+type cursor struct {
+	Offset int           `json:"offset,omitempty"`
+	Keys   []interface{} `json:"keys,omitempty"`
+}
+
+func Paginate(c Connection, queryString string, page Page, result interface{}, args ...interface{}) (string, error) {
+	next, err := paginate(c, queryString, page, result, args...)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", queryString, err)
+	}
+	return next, nil
+}
+
+func paginate(c Connection, query string, page Page, result interface{}, args ...interface{}) (string, error) {
+	xs := reflect.ValueOf(result)
+	if xs.Kind() != reflect.Ptr || xs.Type().Elem().Kind() != reflect.Slice {
+		return "", fmt.Errorf("cannot unmarshal query results into %T", result)
+	} else if page.Limit <= 0 {
+		return "", fmt.Errorf("invalid page limit %d", page.Limit)
+	}
+	current, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return "", err
+	}
+	query, args, err = pageQuery(query, page, current, args)
+	if err != nil {
+		return "", err
+	}
+	next := ""
+	err = withRows(c, query, args, func(rows *resultRows) error {
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		keyIndexes := make([]int, len(page.Keys))
+		for i, key := range page.Keys {
+			if keyIndexes[i] = indexOf(columns, key); keyIndexes[i] == -1 {
+				return fmt.Errorf("key column %q not in result", key)
+			}
+		}
+		decode, err := decoder(rows, xs.Type().Elem().Elem())
+		if err != nil {
+			return err
+		}
+		last := cursor{Offset: current.Offset + page.Limit}
+		for i := 0; rows.Next(); i++ {
+			if i == page.Limit {
+				bs, err := json.Marshal(last)
+				next = base64.RawURLEncoding.EncodeToString(bs)
+				return err
+			}
+			if len(keyIndexes) != 0 {
+				values := make([]interface{}, len(columns))
+				for i := range values {
+					values[i] = new(interface{})
+				}
+				if err := rows.Scan(values...); err != nil {
+					return err
+				}
+				last.Offset, last.Keys = 0, make([]interface{}, len(keyIndexes))
+				for i, j := range keyIndexes {
+					last.Keys[i] = *(values[j].(*interface{}))
+				}
+			}
+			x, err := decode()
+			if err != nil {
+				return err
+			}
+			xs.Elem().Set(reflect.Append(xs.Elem(), x))
+		}
+		return nil
+	})
+	return next, err
+}
+
+func pageQuery(query string, page Page, current cursor, args []interface{}) (string, []interface{}, error) {
+	query = fmt.Sprintf("SELECT * FROM (%s)", strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	if len(page.Keys) == 0 {
+		return query + " LIMIT ? OFFSET ?", append(args, page.Limit+1, current.Offset), nil
+	}
+	keys, placeholders := make([]string, len(page.Keys)), make([]string, len(page.Keys))
+	for i, key := range page.Keys {
+		quoted, err := quoteIdentifier(key)
+		if err != nil {
+			return "", nil, err
+		}
+		keys[i], placeholders[i] = quoted, "?"
+	}
+	if len(current.Keys) == len(keys) {
+		query += fmt.Sprintf(" WHERE (%s) > (%s)", strings.Join(keys, ", "), strings.Join(placeholders, ", "))
+		args = append(args, current.Keys...)
+	} else if len(current.Keys) != 0 {
+		return "", nil, fmt.Errorf("cursor does not match keys %v", page.Keys)
+	}
+	return query + fmt.Sprintf(" ORDER BY %s LIMIT ?", strings.Join(keys, ", ")), append(args, page.Limit+1), nil
+}
+
+func decodeCursor(s string) (cursor, error) {
+	c := cursor{}
+	if s == "" {
+		return c, nil
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor: %w", err)
+	}
+	d := json.NewDecoder(bytes.NewReader(bs))
+	d.UseNumber()
+	if err := d.Decode(&c); err != nil {
+		return c, fmt.Errorf("invalid cursor: %w", err)
+	}
+	for i, k := range c.Keys {
+		if n, ok := k.(json.Number); ok {
+			if x, err := n.Int64(); err == nil {
+				c.Keys[i] = x
+			} else if x, err := n.Float64(); err == nil {
+				c.Keys[i] = x
+			}
+		}
+	}
+	return c, nil
+}
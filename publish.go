@@ -0,0 +1,93 @@
+package gosql
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type schemaEntry struct {
+	Type    string            `db:"type" json:"type"`
+	Name    string            `db:"name" json:"name"`
+	Table   string            `db:"tbl_name" json:"table"`
+	SQL     *string           `db:"sql" json:"sql"`
+	Columns []map[string]JSON `db:"-" json:"columns,omitempty"`
+	Indexes []TableIndex      `db:"-" json:"indexes,omitempty"`
+	Rows    *int64            `db:"-" json:"rows,omitempty"`
+}
+
+type TableIndex struct {
+	Name    string   `db:"name" json:"name"`
+	Unique  int      `db:"unique" json:"unique"`
+	Columns []string `db:"-" json:"columns"`
+}
+
+func Publish(path string) (*DB, http.Handler, error) {
+	db := &DB{
+		DataSourceName: "file:" + path + "?mode=ro&immutable=1",
+		ReadOnly:       true,
+		ROLimit:        &Limiter{Max: 8, Timeout: 5 * time.Second},
+		Cache:          &QueryCache{MaxEntries: 1000},
+	}
+	if err := db.Open(nil); err != nil {
+		return nil, nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/schema", db.SchemaHandler)
+	mux.HandleFunc("/", db.Handler)
+	return db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		cw := &cacheHeaderWriter{ResponseWriter: w, header: http.Header{}}
+		cw.header.Set("Cache-Control", "public, max-age=86400, immutable")
+		mux.ServeHTTP(cw, r)
+	}), nil
+}
+
+func (db *DB) SchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	entries := []schemaEntry{}
+	err := Query(db.RODB, "SELECT type, name, tbl_name, sql FROM sqlite_master WHERE name NOT LIKE 'sqlite_%' AND substr(name, 1, 1) != '_' ORDER BY name", &entries)
+	for i := 0; err == nil && i < len(entries); i++ {
+		if entries[i].Type == "table" || entries[i].Type == "view" {
+			err = Query(db.RODB, "SELECT name, type, pk, \"notnull\", dflt_value AS \"default\" FROM pragma_table_info(?)", &entries[i].Columns, entries[i].Name)
+		}
+		if err == nil && entries[i].Type == "table" {
+			entries[i].Indexes, entries[i].Rows, err = schemaTableDetails(db.RODB, entries[i].Name)
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(entries)
+}
+
+func schemaTableDetails(c Connection, table string) ([]TableIndex, *int64, error) {
+	quoted, err := quoteIdentifier(table)
+	if err != nil {
+		return nil, nil, err
+	}
+	indexes, err := tableIndexes(c, table)
+	if err != nil {
+		return nil, nil, err
+	}
+	counts := []int64{}
+	if err := Query(c, "SELECT count(*) FROM "+quoted, &counts); err != nil {
+		return nil, nil, err
+	}
+	return indexes, &counts[0], nil
+}
+
+func tableIndexes(c Connection, table string) ([]TableIndex, error) {
+	indexes := []TableIndex{}
+	if err := Query(c, "SELECT name, \"unique\" FROM pragma_index_list(?) ORDER BY name", &indexes, table); err != nil {
+		return nil, err
+	}
+	for i := range indexes {
+		if err := Query(c, "SELECT name FROM pragma_index_info(?) ORDER BY seqno", &indexes[i].Columns, indexes[i].Name); err != nil {
+			return nil, err
+		}
+	}
+	return indexes, nil
+}
@@ -0,0 +1,45 @@
+package gosql
+
+import (
+	"container/list"
+	"regexp"
+	"sync"
+)
+
+type regexpCache struct {
+	max     int
+	mutex   sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type regexpCacheEntry struct {
+	pattern string
+	regexp  *regexp.Regexp
+}
+
+func (c *regexpCache) get(pattern string) (*regexp.Regexp, error) {
+	c.mutex.Lock()
+	if c.entries == nil {
+		c.order, c.entries = list.New(), map[string]*list.Element{}
+	}
+	if e, ok := c.entries[pattern]; ok {
+		c.order.MoveToFront(e)
+		c.mutex.Unlock()
+		return e.Value.(*regexpCacheEntry).regexp, nil
+	}
+	c.mutex.Unlock()
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.entries[pattern]; !ok {
+		c.entries[pattern] = c.order.PushFront(&regexpCacheEntry{pattern, r})
+		for c.order.Len() > c.max {
+			delete(c.entries, c.order.Remove(c.order.Back()).(*regexpCacheEntry).pattern)
+		}
+	}
+	return r, nil
+}
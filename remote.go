@@ -0,0 +1,220 @@
+package gosql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type Client struct {
+	URL        string
+	WriteURL   string
+	Header     http.Header
+	HTTPClient *http.Client
+	*sql.DB
+}
+
+type remoteConnector struct{ c *Client }
+type remoteConn struct{ c *Client }
+type remoteStmt struct {
+	c     *Client
+	query string
+}
+
+type remoteRows struct {
+	columns []string
+	rows    [][]interface{}
+	i       int
+}
+
+type remoteResult struct {
+	Affected int64 `json:"rows_affected"`
+	InsertID int64 `json:"last_insert_id"`
+}
+
+var errRemoteTx = errors.New("transactions are not supported by remote connections")
+
+func (c *Client) Open() error {
+	if c.DB != nil {
+		return errors.New("already open")
+	}
+	c.DB = sql.OpenDB(remoteConnector{c})
+	return nil
+}
+
+func (c *Client) post(ctx context.Context, u, query string, args []driver.NamedValue) (io.ReadCloser, error) {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("named args are not supported by remote connections: %s", arg.Name)
+		} else if _, ok := arg.Value.([]byte); ok {
+			return nil, fmt.Errorf("blob args are not supported by remote connections: arg %d", arg.Ordinal)
+		}
+		values[i] = arg.Value
+	}
+	bs, err := json.Marshal(map[string]interface{}{"query": query, "args": values})
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range c.Header {
+		r.Header[k] = vs
+	}
+	r.Header.Set("Content-Type", "application/json")
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	} else if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		body := map[string]string{}
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil || body["error"] == "" {
+			return nil, fmt.Errorf("remote: %s", res.Status)
+		}
+		return nil, fmt.Errorf("remote: %s", body["error"])
+	}
+	return res.Body, nil
+}
+
+func (c remoteConnector) Connect(context.Context) (driver.Conn, error) { return remoteConn{c.c}, nil }
+func (c remoteConnector) Driver() driver.Driver                        { return remoteDriver{} }
+
+type remoteDriver struct{}
+
+func (remoteDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("remote connections must be opened via Client.Open")
+}
+
+func (c remoteConn) Prepare(query string) (driver.Stmt, error) { return remoteStmt{c.c, query}, nil }
+func (c remoteConn) Close() error                              { return nil }
+func (c remoteConn) Begin() (driver.Tx, error)                 { return nil, errRemoteTx }
+
+func (c remoteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	u, err := url.Parse(c.c.URL)
+	if err != nil {
+		return nil, err
+	}
+	params := u.Query()
+	params.Set("format", "rows")
+	u.RawQuery = params.Encode()
+	body, err := c.c.post(ctx, u.String(), query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	result := struct {
+		Columns []string
+		Rows    [][]interface{}
+		Blobs   [][2]int
+		Error   string
+	}{}
+	d := json.NewDecoder(body)
+	d.UseNumber()
+	if err := d.Decode(&result); err != nil {
+		return nil, err
+	} else if result.Error != "" {
+		return nil, fmt.Errorf("remote: %s", result.Error)
+	}
+	for _, row := range result.Rows {
+		for i, v := range row {
+			if n, ok := v.(json.Number); ok {
+				row[i] = numberValue(n)
+			}
+		}
+	}
+	for _, p := range result.Blobs {
+		if p[0] < 0 || p[0] >= len(result.Rows) || p[1] < 0 || p[1] >= len(result.Rows[p[0]]) {
+			return nil, fmt.Errorf("remote: invalid blob position %v", p)
+		}
+		s, ok := result.Rows[p[0]][p[1]].(string)
+		if !ok {
+			return nil, fmt.Errorf("remote: invalid blob at %v: %T", p, result.Rows[p[0]][p[1]])
+		}
+		bs, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("remote: invalid blob at %v: %w", p, err)
+		}
+		result.Rows[p[0]][p[1]] = bs
+	}
+	return &remoteRows{columns: result.Columns, rows: result.Rows}, nil
+}
+
+func numberValue(n json.Number) interface{} {
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+func (c remoteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.c.WriteURL == "" {
+		return nil, errors.New("remote: no WriteURL configured")
+	}
+	body, err := c.c.post(ctx, c.c.WriteURL, query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	result := remoteResult{}
+	return result, json.NewDecoder(body).Decode(&result)
+}
+
+func (c remoteConn) CheckNamedValue(v *driver.NamedValue) error {
+	if t, ok := v.Value.(time.Time); ok {
+		v.Value = t.Format(time.RFC3339Nano)
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+func (s remoteStmt) Close() error  { return nil }
+func (s remoteStmt) NumInput() int { return -1 }
+
+func (s remoteStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return remoteConn{s.c}.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s remoteStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return remoteConn{s.c}.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	values := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		values[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return values
+}
+
+func (r *remoteRows) Columns() []string { return r.columns }
+func (r *remoteRows) Close() error      { return nil }
+
+func (r *remoteRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.rows) {
+		return io.EOF
+	}
+	for i, v := range r.rows[r.i] {
+		dest[i] = v
+	}
+	r.i++
+	return nil
+}
+
+func (r remoteResult) LastInsertId() (int64, error) { return r.InsertID, nil }
+func (r remoteResult) RowsAffected() (int64, error) { return r.Affected, nil }
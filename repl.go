@@ -0,0 +1,640 @@
+package gosql
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/peterh/liner"
+)
+
+type REPL struct {
+	DB       *DB
+	Reader   LineReader
+	Out      io.Writer
+	Prompt   func() string
+	Commands map[string]Command
+	Bail     bool
+	Echo     bool
+	Mode     string
+	Null     string
+	Timer    bool
+	MaxRows  int
+	Pager    string
+	Params   map[string]interface{}
+	names    []string
+	console  io.Writer
+	output   *os.File
+	reading  []string
+}
+
+type replSettings struct {
+	Mode    *string `json:"mode"`
+	Null    *string `json:"null"`
+	Timer   *bool   `json:"timer"`
+	MaxRows *int    `json:"max_rows"`
+	Pager   *string `json:"pager"`
+}
+
+type LineReader interface {
+	Prompt(prompt string) (string, error)
+	AppendHistory(item string)
+}
+
+type suggestionReader interface {
+	PromptWithSuggestion(prompt, text string, pos int) (string, error)
+}
+
+type Command struct {
+	Help string
+	Run  func(r *REPL, args []string) error
+}
+
+var errQuit = errors.New("quit")
+
+var defaultCommands = map[string]Command{
+	".format": {"pretty print SQL", func(r *REPL, args []string) error {
+		_, err := fmt.Fprintln(r.Out, Format(strings.Join(args, " ")))
+		return err
+	}},
+	".lint": {"show lint warnings for SQL", func(r *REPL, args []string) error {
+		for _, w := range Lint(strings.Join(args, " ")) {
+			fmt.Fprintln(r.Out, w.Message)
+		}
+		return nil
+	}},
+	".mode": {"show or set the output mode (" + strings.Join(OutputModes, ", ") + ")", func(r *REPL, args []string) error {
+		if len(args) == 0 {
+			_, err := fmt.Fprintln(r.Out, r.mode())
+			return err
+		} else if len(args) != 1 {
+			return errors.New("usage: .mode [MODE]")
+		} else if _, _, err := outputWriter(r.Out, OutputOptions{Mode: args[0]}); err != nil {
+			return err
+		}
+		r.Mode = args[0]
+		return nil
+	}},
+	".nullvalue": {"show NULL as TEXT", func(r *REPL, args []string) error {
+		if len(args) != 1 {
+			return errors.New("usage: .nullvalue TEXT")
+		}
+		r.Null = args[0]
+		return nil
+	}},
+	".output": {"write results to FILE, or back to the console without FILE", func(r *REPL, args []string) error {
+		if len(args) > 1 {
+			return errors.New("usage: .output [FILE]")
+		}
+		if err := r.closeOutput(); err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return nil
+		}
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		r.console, r.Out, r.output = r.Out, f, f
+		return nil
+	}},
+	".limit": {"show at most N rows per statement (0 shows all)", func(r *REPL, args []string) error {
+		if len(args) == 0 {
+			_, err := fmt.Fprintln(r.Out, r.MaxRows)
+			return err
+		}
+		n, err := strconv.Atoi(strings.Join(args, " "))
+		if err != nil || n < 0 {
+			return errors.New("usage: .limit [N]")
+		}
+		r.MaxRows = n
+		return nil
+	}},
+	".pager": {"pipe statement output through COMMAND (off disables paging)", func(r *REPL, args []string) error {
+		if len(args) == 0 {
+			return errors.New("usage: .pager COMMAND|off")
+		} else if r.Pager = strings.Join(args, " "); r.Pager == "off" {
+			r.Pager = ""
+		}
+		return nil
+	}},
+	".set": {"bind :NAME to VALUE in statements, list parameters without arguments", func(r *REPL, args []string) error {
+		if len(args) == 0 {
+			names := []string{}
+			for name := range r.Params {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if _, err := fmt.Fprintf(r.Out, ":%s = %s\n", name, sqlLiteral(r.Params[name])); err != nil {
+					return err
+				}
+			}
+			return nil
+		} else if len(args) == 1 {
+			return errors.New("usage: .set [NAME VALUE]")
+		}
+		if r.Params == nil {
+			r.Params = map[string]interface{}{}
+		}
+		r.Params[strings.TrimPrefix(args[0], ":")] = parseParam(strings.Join(args[1:], " "))
+		return nil
+	}},
+	".unset": {"remove parameter NAME", func(r *REPL, args []string) error {
+		if len(args) != 1 {
+			return errors.New("usage: .unset NAME")
+		}
+		delete(r.Params, strings.TrimPrefix(args[0], ":"))
+		return nil
+	}},
+	".timer": {"show the run time of statements (on|off)", func(r *REPL, args []string) error {
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			return errors.New("usage: .timer on|off")
+		}
+		r.Timer = args[0] == "on"
+		return nil
+	}},
+	".quit": {"exit the repl", func(r *REPL, args []string) error { return errQuit }},
+	".databases": {"list attached databases", func(r *REPL, args []string) error {
+		return r.list("SELECT name, file FROM pragma_database_list")
+	}},
+	".indexes": {"list indexes, optionally only those of TABLE", func(r *REPL, args []string) error {
+		if len(args) > 1 {
+			return errors.New("usage: .indexes [TABLE]")
+		}
+		return r.list(`SELECT name FROM sqlite_master WHERE type = 'index' AND (? = '' OR tbl_name = ?) ORDER BY name`,
+			strings.Join(args, ""), strings.Join(args, ""))
+	}},
+	".dump": {"write the schema and data of the database as sql statements", func(r *REPL, args []string) error {
+		if len(args) != 0 {
+			return errors.New("usage: .dump")
+		}
+		return r.DB.Dump(r.Out)
+	}},
+	".restore": {"execute a .dump FILE in a single transaction", func(r *REPL, args []string) error {
+		if len(args) != 1 {
+			return errors.New("usage: .restore FILE")
+		}
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r.names = nil
+		return r.DB.RestoreDump(f)
+	}},
+	".schema": {"show the CREATE statements of all tables or just TABLE", func(r *REPL, args []string) error {
+		if len(args) > 1 {
+			return errors.New("usage: .schema [TABLE]")
+		}
+		return schemaDump(r.Out, r.DB, strings.Join(args, ""))
+	}},
+	".tables": {"list tables and views, optionally only those matching the LIKE PATTERN", func(r *REPL, args []string) error {
+		if len(args) > 1 {
+			return errors.New("usage: .tables [PATTERN]")
+		}
+		pattern := "%"
+		if len(args) == 1 {
+			pattern = args[0]
+		}
+		return r.list(`SELECT name FROM sqlite_master WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%' AND name LIKE ? ORDER BY name`, pattern)
+	}},
+}
+
+func init() {
+	defaultCommands[".read"] = Command{"execute the SQL statements and dot commands in FILE", func(r *REPL, args []string) error {
+		if len(args) != 1 {
+			return errors.New("usage: .read FILE")
+		}
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		for _, p := range r.reading {
+			if p == path {
+				return fmt.Errorf(".read: %s is already being read", args[0])
+			}
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r.reading = append(r.reading, path)
+		defer func() { r.reading = r.reading[:len(r.reading)-1] }()
+		return r.RunBatch(f)
+	}}
+	defaultCommands[".help"] = Command{"show available commands", func(r *REPL, args []string) error {
+		commands, names := r.commands(), []string{}
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(r.Out, "%s\t%s\n", name, commands[name].Help)
+		}
+		return nil
+	}}
+}
+
+func (db *DB) REPL() error {
+	l := liner.NewLiner()
+	defer l.Close()
+	l.SetCtrlCAborts(true)
+	r := &REPL{DB: db, Reader: l, Out: os.Stdout, MaxRows: 1000}
+	if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		if r.Pager = os.Getenv("PAGER"); r.Pager == "" {
+			r.Pager = "less -FRSX"
+		}
+	}
+	l.SetWordCompleter(r.Complete)
+	dir, key := filepath.Join(os.Getenv("HOME"), ".gosql"), replKey(db.DataSourceName)
+	historyFile, settingsFile := filepath.Join(dir, key+".history"), filepath.Join(dir, key+".json")
+	initialHistoryFile := historyFile
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		// first run since history is kept per database - start with the previous global history
+		initialHistoryFile = filepath.Join(os.Getenv("HOME"), ".gosql_history")
+	}
+	if f, err := os.Open(initialHistoryFile); err == nil {
+		l.ReadHistory(f)
+		f.Close()
+	}
+	if bs, err := os.ReadFile(settingsFile); err == nil {
+		if err := json.Unmarshal(bs, &replSettings{&r.Mode, &r.Null, &r.Timer, &r.MaxRows, &r.Pager}); err != nil {
+			db.logger().Printf("WARNING: ignoring %s: %s", settingsFile, err)
+		}
+	}
+	defer func() {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return
+		}
+		if f, err := os.Create(historyFile); err == nil {
+			l.WriteHistory(f)
+			f.Close()
+		}
+		if bs, err := json.Marshal(replSettings{&r.Mode, &r.Null, &r.Timer, &r.MaxRows, &r.Pager}); err == nil {
+			os.WriteFile(settingsFile, bs, 0600)
+		}
+	}()
+	return r.Run()
+}
+
+// history and settings are kept per database: NAME-HASH where HASH identifies the absolute path
+func replKey(dataSourceName string) string {
+	path := strings.SplitN(strings.TrimPrefix(dataSourceName, "file:"), "?", 2)[0]
+	if abs, err := filepath.Abs(path); err == nil && path != ":memory:" && path != "" {
+		path = abs
+	}
+	sum := sha256.Sum256([]byte(path))
+	name := strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, filepath.Base(path))
+	return fmt.Sprintf("%s-%x", name, sum[:4])
+}
+
+// Ctrl-C while in the middle of a statement puts the statement so far back into a single editable line;
+// Ctrl-C again discards it
+func (r *REPL) Run() error {
+	defer r.closeOutput()
+	statement, edit := "", ""
+	for {
+		line, err := "", error(nil)
+		if sr, ok := r.Reader.(suggestionReader); ok && edit != "" {
+			line, err = sr.PromptWithSuggestion(r.prompt(), edit, -1)
+		} else if strings.TrimSpace(statement) != "" {
+			line, err = r.Reader.Prompt(r.continuationPrompt())
+		} else {
+			line, err = r.Reader.Prompt(r.prompt())
+		}
+		edit = ""
+		if err == liner.ErrPromptAborted {
+			edit, statement = strings.TrimSpace(statement), ""
+			continue
+		} else if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if strings.TrimSpace(statement) == "" && strings.HasPrefix(strings.TrimSpace(line), ".") {
+			r.Reader.AppendHistory(line)
+			if err := r.Eval(line); err == errQuit {
+				return nil
+			} else if err != nil {
+				r.DB.logger().Printf("ERROR: %s", err)
+			}
+			continue
+		}
+		statements, rest := splitStatements(strings.TrimSpace(statement + " " + line))
+		for _, statement := range statements {
+			r.Reader.AppendHistory(statement)
+			if err := r.evalInterruptible(statement); err != nil {
+				r.DB.logger().Printf("ERROR: %s", err)
+			}
+		}
+		statement = rest
+	}
+}
+
+func (r *REPL) RunBatch(in io.Reader) error {
+	if len(r.reading) == 0 {
+		defer r.closeOutput()
+	}
+	scanner, statement, succeeded, failed := bufio.NewScanner(in), "", 0, 0
+	eval := func(input string) bool {
+		if r.Echo {
+			fmt.Fprintln(r.Out, input)
+		}
+		if err := r.Eval(input); err == errQuit {
+			return false
+		} else if err != nil {
+			failed++
+			if strings.HasPrefix(input, ".") {
+				r.DB.logger().Printf("ERROR: %s", err)
+			} else {
+				r.DB.logger().Printf("ERROR: %s\n%s", err, Format(input))
+			}
+			return !r.Bail
+		}
+		succeeded++
+		return true
+	}
+	stop := false
+	for !stop && scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(statement) == "" && strings.HasPrefix(strings.TrimSpace(line), ".") {
+			stop = !eval(line)
+			continue
+		}
+		statements, rest := splitStatements(statement + line + "\n")
+		for i := 0; i < len(statements) && !stop; i++ {
+			stop = !eval(statements[i])
+		}
+		statement = rest
+	}
+	if !stop && strings.TrimSpace(statement) != "" {
+		eval(strings.TrimSpace(statement))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	r.DB.logger().Printf("%d statements succeeded, %d failed", succeeded, failed)
+	if failed != 0 {
+		return fmt.Errorf("%d statements failed", failed)
+	}
+	return nil
+}
+
+// closeOutput closes the file of .output (if any) and switches back to the console
+func (r *REPL) closeOutput() error {
+	if r.output == nil {
+		return nil
+	}
+	f := r.output
+	r.Out, r.output = r.console, nil
+	return f.Close()
+}
+
+func (r *REPL) Eval(input string) error {
+	return r.EvalContext(context.Background(), input)
+}
+
+// Ctrl-C cancels the running statement (sqlite3_interrupt via ctx) rather than just its output
+func (r *REPL) evalInterruptible(input string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := r.EvalContext(ctx, input)
+	if ctx.Err() != nil {
+		return errors.New("interrupted")
+	}
+	return err
+}
+
+func (r *REPL) EvalContext(ctx context.Context, input string) error {
+	if input = strings.TrimSpace(input); strings.HasPrefix(input, ".") {
+		fields := strings.Fields(input)
+		command, ok := r.commands()[fields[0]]
+		if !ok {
+			return fmt.Errorf("unknown command %s (see .help)", fields[0])
+		}
+		return command.Run(r, fields[1:])
+	}
+	if fields := strings.Fields(strings.ToUpper(input)); len(fields) != 0 && (fields[0] == "CREATE" || fields[0] == "DROP" || fields[0] == "ALTER") {
+		r.names = nil
+	}
+	args, err := r.params(input)
+	if err != nil {
+		return err
+	}
+	start, out, wait := time.Now(), r.Out, func() error { return nil }
+	if r.Pager != "" && r.output == nil {
+		pager, err := startPager(r.Pager, r.Out)
+		if err != nil {
+			return err
+		}
+		out, wait = pager, pager.Close
+	}
+	err = Output(out, ctxConn{ctx, r.DB}, OutputOptions{Mode: r.mode(), Null: r.Null, MaxRows: r.MaxRows}, input, args...)
+	if err := wait(); err != nil {
+		r.DB.logger().Printf("WARNING: pager: %s", err)
+	}
+	console := r.Out
+	if r.output != nil {
+		console = r.console
+	}
+	if errors.As(err, new(errTooManyRows)) {
+		fmt.Fprintf(console, "(showing the first %d rows - see .limit)\n", r.MaxRows)
+		err = nil
+	}
+	if r.Timer {
+		fmt.Fprintf(console, "Run Time: %s\n", time.Since(start).Round(time.Microsecond))
+	}
+	return err
+}
+
+type pager struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func startPager(command string, out io.Writer) (*pager, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout, cmd.Stderr = out, os.Stderr
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &pager{w, cmd}, nil
+}
+
+// the pager may quit before reading everything (q in less) - the resulting write errors are not interesting
+func (p *pager) Write(bs []byte) (int, error) {
+	if n, err := p.WriteCloser.Write(bs); err == nil {
+		return n, nil
+	}
+	return len(bs), nil
+}
+
+func (p *pager) Close() error {
+	p.WriteCloser.Close()
+	return p.cmd.Wait()
+}
+
+// completes dot commands, keywords, functions, tables, columns and TABLE.COLUMN - keywords follow the case of the input.
+// pos is in runes (liner.WordCompleter)
+func (r *REPL) Complete(line string, pos int) (head string, completions []string, tail string) {
+	rs, start := []rune(line), pos
+	for start > 0 && (rs[start-1] == '_' || rs[start-1] == '.' || unicode.IsLetter(rs[start-1]) || unicode.IsDigit(rs[start-1])) {
+		start--
+	}
+	head, word, tail := string(rs[:start]), string(rs[start:pos]), string(rs[pos:])
+	candidates := []string{}
+	if strings.TrimSpace(head) == "" && strings.HasPrefix(word, ".") {
+		for name := range r.commands() {
+			candidates = append(candidates, name)
+		}
+	} else if i := strings.LastIndexByte(word, '.'); i != -1 {
+		columns := []string{}
+		Query(r.DB, "SELECT name FROM pragma_table_info(?)", &columns, word[:i])
+		for _, column := range columns {
+			candidates = append(candidates, word[:i+1]+column)
+		}
+	} else {
+		candidates = r.completionNames()
+	}
+	isLower := strings.ToLower(word) == word
+	for _, c := range candidates {
+		if strings.HasPrefix(strings.ToLower(c), strings.ToLower(word)) {
+			if isLower && keywords[c] {
+				c = strings.ToLower(c)
+			}
+			completions = append(completions, c)
+		}
+	}
+	sort.Strings(completions)
+	return head, completions, tail
+}
+
+func (r *REPL) completionNames() []string {
+	if r.names != nil {
+		return r.names
+	}
+	names, seen := []string{}, map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for k := range keywords {
+		add(k)
+	}
+	r.DB.funcsMutex.RLock()
+	for name := range r.DB.Funcs {
+		add(name)
+	}
+	r.DB.funcsMutex.RUnlock()
+	columns := []struct {
+		Table  string `db:"tbl"`
+		Column string `db:"col"`
+	}{}
+	query := `SELECT m.name AS tbl, c.name AS col FROM sqlite_master m, pragma_table_info(m.name) c
+	          WHERE m.type IN ('table', 'view') AND m.name NOT LIKE 'sqlite_%'`
+	if err := Query(r.DB, query, &columns); err == nil {
+		for _, c := range columns {
+			add(c.Table)
+			add(c.Column)
+		}
+	}
+	r.names = names
+	return names
+}
+
+func (r *REPL) prompt() string {
+	if r.Prompt != nil {
+		return r.Prompt()
+	}
+	return "> "
+}
+
+// :name parameters are bound as sql.Named args - referencing a parameter that was not .set is an error
+func (r *REPL) params(input string) ([]interface{}, error) {
+	args, seen := []interface{}{}, map[string]bool{}
+	for _, t := range tokenize(input) {
+		if t.kind != "word" || len(t.text) < 2 || t.text[0] != ':' || seen[t.text] {
+			continue
+		}
+		name := t.text[1:]
+		v, ok := r.Params[name]
+		if !ok {
+			return nil, fmt.Errorf("parameter %s is not set (see .set)", t.text)
+		}
+		args, seen[t.text] = append(args, sql.Named(name, v)), true
+	}
+	return args, nil
+}
+
+// 'quoted' values are always strings, NULL is nil and numbers are numbers
+func parseParam(s string) interface{} {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	} else if strings.EqualFold(s, "NULL") {
+		return nil
+	} else if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	} else if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+func (r *REPL) continuationPrompt() string {
+	prompt := "...> "
+	if n := utf8.RuneCountInString(r.prompt()); n > len(prompt) {
+		prompt = strings.Repeat(" ", n-len(prompt)) + prompt
+	}
+	return prompt
+}
+
+func (r *REPL) list(query string, args ...interface{}) error {
+	return Output(r.Out, r.DB, OutputOptions{Mode: "table", NoHeader: true}, query, args...)
+}
+
+func (r *REPL) mode() string {
+	if r.Mode == "" {
+		return "table"
+	}
+	return r.Mode
+}
+
+func (r *REPL) commands() map[string]Command {
+	commands := map[string]Command{}
+	for name, command := range defaultCommands {
+		commands[name] = command
+	}
+	for name, command := range r.Commands {
+		commands[name] = command
+	}
+	return commands
+}
@@ -0,0 +1,191 @@
+package gosql
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ReplicaDestination interface {
+	Put(name string, r io.Reader) error
+	Get(name string) (io.ReadCloser, error)
+	List() ([]string, error)
+	Delete(name string) error
+}
+
+type DirDestination string
+
+var defaultReplicateInterval = time.Minute
+
+type Replication struct {
+	Destination ReplicaDestination
+	Interval    time.Duration // defaults to a minute
+	Retain      int
+	OnSnapshot  func(name string, err error)
+}
+
+func (d DirDestination) Put(name string, r io.Reader) error {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(string(d), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(string(d), name))
+}
+
+func (d DirDestination) Get(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), name))
+}
+
+func (d DirDestination) List() ([]string, error) {
+	return filepath.Glob(filepath.Join(string(d), "snapshot-*.sqlite"))
+}
+
+func (d DirDestination) Delete(name string) error { return os.Remove(filepath.Join(string(d), name)) }
+
+func (db *DB) Replicate(r Replication) (stop func(), err error) {
+	conn, err := db.RODB.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if r.Interval <= 0 {
+		r.Interval = defaultReplicateInterval
+	}
+	done, stopped, version := make(chan struct{}), make(chan struct{}), int64(-1)
+	ticker := time.NewTicker(r.Interval)
+	go func() {
+		defer close(stopped)
+		defer conn.Close()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			current := int64(0)
+			if err := conn.QueryRowContext(context.Background(), "PRAGMA data_version").Scan(&current); err == nil && current == version {
+				continue
+			}
+			name, err := db.snapshot(r)
+			if err == nil {
+				version = current
+			}
+			if r.OnSnapshot != nil {
+				r.OnSnapshot(name, err)
+			}
+		}
+	}()
+	return func() { close(done); <-stopped }, nil
+}
+
+func (db *DB) snapshot(r Replication) (string, error) {
+	dir, err := ioutil.TempDir("", "gosql-snapshot-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	path, name := filepath.Join(dir, "snapshot.sqlite"), "snapshot-"+time.Now().UTC().Format("20060102T150405.000000000Z")+".sqlite"
+	if err := db.BackupTo(path, nil); err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := r.Destination.Put(name, f); err != nil {
+		return "", err
+	}
+	if r.Retain > 0 {
+		names, err := snapshotNames(r.Destination)
+		if err != nil {
+			return name, err
+		}
+		for len(names) > r.Retain {
+			if err := r.Destination.Delete(names[0]); err != nil {
+				return name, err
+			}
+			names = names[1:]
+		}
+	}
+	return name, nil
+}
+
+func RestoreLatest(d ReplicaDestination, path string) (string, error) {
+	names, err := snapshotNames(d)
+	if err != nil {
+		return "", err
+	} else if len(names) == 0 {
+		return "", errors.New("no snapshots found")
+	}
+	name := names[len(names)-1]
+	r, err := d.Get(name)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	tmp := path + ".restore"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	} else if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := checkSnapshot(tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return name, os.Rename(tmp, path)
+}
+
+func snapshotNames(d ReplicaDestination) ([]string, error) {
+	paths, err := d.List()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, p := range paths {
+		if name := filepath.Base(p); strings.HasPrefix(name, "snapshot-") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func checkSnapshot(path string) error {
+	db, err := openFile("file:" + path + "?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	result := ""
+	if err := db.QueryRow("PRAGMA quick_check").Scan(&result); err != nil {
+		return err
+	} else if result != "ok" {
+		return errors.New("snapshot failed quick_check: " + result)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package gosql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// restTable rows are addressed by their primary key - /table/a/b for composite keys - or by rowid if there is none.
+type restTable struct {
+	name, quoted string
+	keys         []string
+	withoutRowID bool
+	columns      map[string]string
+}
+
+func (db *DB) RESTHandler(tables ...string) http.Handler {
+	allowed := map[string]bool{}
+	for _, table := range tables {
+		allowed[table] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		parts := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 2)
+		if !allowed[parts[0]] {
+			writeHandlerError(w, http.StatusNotFound, fmt.Errorf("unknown table %s", parts[0]))
+			return
+		}
+		t, err := loadRESTTable(db.RODB, parts[0])
+		if errors.Is(err, errNotFound) {
+			writeHandlerError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			writeHandlerError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if r.Method != http.MethodGet && (db.WriteAuth == nil || !db.WriteAuth(r)) {
+			writeHandlerError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		status, result, err := http.StatusOK, interface{}(nil), error(nil)
+		switch {
+		case r.Method == http.MethodGet && len(parts) == 1:
+			result, err = t.list(db.RODB, r)
+		case r.Method == http.MethodGet:
+			result, err = t.get(db.RODB, parts[1])
+		case r.Method == http.MethodPost && len(parts) == 1:
+			status = http.StatusCreated
+			result, err = t.create(db, r)
+		case r.Method == http.MethodPatch && len(parts) == 2:
+			result, err = t.update(db, parts[1], r)
+		case r.Method == http.MethodDelete && len(parts) == 2:
+			status, err = http.StatusNoContent, t.delete(db, parts[1])
+		default:
+			writeHandlerError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		if errors.Is(err, errNotFound) {
+			writeHandlerError(w, http.StatusNotFound, err)
+		} else if err != nil {
+			writeHandlerError(w, http.StatusBadRequest, err)
+		} else {
+			w.WriteHeader(status)
+			if status != http.StatusNoContent {
+				json.NewEncoder(w).Encode(result)
+			}
+		}
+	})
+}
+
+var errNotFound = errors.New("not found")
+
+func loadRESTTable(c Connection, name string) (*restTable, error) {
+	quoted, err := quoteIdentifier(name)
+	if err != nil {
+		return nil, err
+	}
+	infos := []struct {
+		Name string `db:"name"`
+		Type string `db:"type"`
+	}{}
+	if err := Query(c, "SELECT name, type FROM pragma_table_info(?)", &infos, name); err != nil {
+		return nil, err
+	} else if len(infos) == 0 {
+		return nil, fmt.Errorf("%s: %w", name, errNotFound)
+	}
+	t := &restTable{name: name, quoted: quoted, columns: map[string]string{}}
+	for _, info := range infos {
+		t.columns[info.Name] = info.Type
+	}
+	if t.keys, t.withoutRowID, err = primaryKey(c, name); err != nil {
+		return nil, err
+	} else if len(t.keys) == 0 {
+		t.keys = []string{"rowid"}
+	}
+	return t, nil
+}
+
+func (t *restTable) list(c Connection, r *http.Request) ([]map[string]JSON, error) {
+	wheres, args, orders, params := []string{}, []interface{}{}, []string{}, r.URL.Query()
+	names := []string{}
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch name {
+		case "sort", "limit", "offset":
+			continue
+		}
+		column, err := t.column(name)
+		if err != nil {
+			return nil, err
+		}
+		wheres, args = append(wheres, column+" = ?"), append(args, params.Get(name))
+	}
+	for _, name := range params["sort"] {
+		direction := "ASC"
+		if strings.HasPrefix(name, "-") {
+			name, direction = name[1:], "DESC"
+		}
+		column, err := t.column(name)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, column+" "+direction)
+	}
+	query := "SELECT * FROM " + t.quoted
+	if len(wheres) != 0 {
+		query += " WHERE " + strings.Join(wheres, " AND ")
+	}
+	if len(orders) != 0 {
+		query += " ORDER BY " + strings.Join(orders, ", ")
+	}
+	limit, offset := -1, 0
+	for name, v := range map[string]*int{"limit": &limit, "offset": &offset} {
+		if s := params.Get(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*v = n
+		}
+	}
+	results := []map[string]JSON{}
+	err := Query(c, query+" LIMIT ? OFFSET ?", &results, append(args, limit, offset)...)
+	return results, err
+}
+
+func (t *restTable) get(c Connection, id string) (map[string]JSON, error) {
+	where, args, err := t.where(id)
+	if err != nil {
+		return nil, err
+	}
+	return t.find(c, id, where, args...)
+}
+
+func (t *restTable) find(c Connection, id, where string, args ...interface{}) (map[string]JSON, error) {
+	results := []map[string]JSON{}
+	if err := Query(c, fmt.Sprintf("SELECT * FROM %s WHERE %s", t.quoted, where), &results, args...); err != nil {
+		return nil, err
+	} else if len(results) == 0 {
+		return nil, fmt.Errorf("%s %s: %w", t.name, id, errNotFound)
+	}
+	return results[0], nil
+}
+
+func (t *restTable) create(db *DB, r *http.Request) (map[string]JSON, error) {
+	columns, args, err := t.body(r)
+	if err != nil {
+		return nil, err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", t.quoted, strings.Join(columns, ", "), placeholders)
+	if len(columns) == 0 {
+		query = fmt.Sprintf("INSERT INTO %s DEFAULT VALUES", t.quoted)
+	}
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", query, err)
+	}
+	if id, ok := t.updatedID(nil, columns, args); ok {
+		return t.get(db, id)
+	} else if t.withoutRowID {
+		return nil, fmt.Errorf("%s: cannot look up the created row without its primary key %s", t.name, strings.Join(t.keys, ", "))
+	}
+	rowID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return t.find(db, strconv.FormatInt(rowID, 10), "rowid = ?", rowID)
+}
+
+func (t *restTable) update(db *DB, id string, r *http.Request) (map[string]JSON, error) {
+	columns, args, err := t.body(r)
+	if err != nil {
+		return nil, err
+	} else if len(columns) == 0 {
+		return nil, errors.New("invalid request body: no columns to update")
+	}
+	where, keyArgs, err := t.where(id)
+	if err != nil {
+		return nil, err
+	}
+	sets := make([]string, len(columns))
+	for i, column := range columns {
+		sets[i] = column + " = ?"
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s", t.quoted, strings.Join(sets, ", "), where)
+	result, err := db.Exec(query, append(args, keyArgs...)...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", query, err)
+	} else if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, fmt.Errorf("%s %s: %w", t.name, id, errNotFound)
+	}
+	if updatedID, ok := t.updatedID(keyArgs, columns, args); ok {
+		id = updatedID
+	}
+	return t.get(db, id)
+}
+
+func (t *restTable) delete(db *DB, id string) error {
+	where, args, err := t.where(id)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", t.quoted, where)
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", query, err)
+	} else if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%s %s: %w", t.name, id, errNotFound)
+	}
+	return nil
+}
+
+func (t *restTable) body(r *http.Request) ([]string, []interface{}, error) {
+	body := map[string]json.RawMessage{}
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	names := []string{}
+	for name := range body {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	columns, args := []string{}, []interface{}{}
+	for _, name := range names {
+		column, err := t.column(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		arg, err := decodeJSONArg(body[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid request body: %s: %w", name, err)
+		}
+		columns, args = append(columns, column), append(args, arg)
+	}
+	return columns, args, nil
+}
+
+func (t *restTable) column(name string) (string, error) {
+	if _, ok := t.columns[name]; !ok {
+		return "", fmt.Errorf("unknown column %s", name)
+	}
+	return quoteIdentifier(name)
+}
+
+func (t *restTable) where(id string) (string, []interface{}, error) {
+	parts := strings.Split(id, "/")
+	if len(parts) != len(t.keys) {
+		return "", nil, fmt.Errorf("%s %s: %w", t.name, id, errNotFound)
+	}
+	conditions, args := make([]string, len(t.keys)), make([]interface{}, len(t.keys))
+	for i, key := range t.keys {
+		conditions[i], args[i] = key+" = ?", parts[i]
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// updatedID returns the id of a row with the key values keyArgs (nil for new rows) after setting columns to args.
+func (t *restTable) updatedID(keyArgs []interface{}, columns []string, args []interface{}) (string, bool) {
+	values := map[string]interface{}{}
+	for i, column := range columns {
+		values[column] = args[i]
+	}
+	parts, changed := make([]string, len(t.keys)), false
+	for i, key := range t.keys {
+		if v, ok := values[key]; ok {
+			parts[i], changed = fmt.Sprint(v), true
+		} else if keyArgs == nil {
+			return "", false
+		} else {
+			parts[i] = fmt.Sprint(keyArgs[i])
+		}
+	}
+	return strings.Join(parts, "/"), changed
+}
@@ -0,0 +1,123 @@
+package gosql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// roConn runs everything on the read-only pool, with the hooks (history, metrics, tracing, limits) of DB for queries
+type roConn struct{ *DB }
+
+// RO returns a Connection that sends queries to RODB - writes fail with the read-only authorizer
+func (db *DB) RO() Connection { return roConn{db} }
+
+func (db *DB) QueryRO(query string, result interface{}, args ...interface{}) error {
+	return Query(roConn{db}, query, result, args...)
+}
+
+func (c roConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.RODB.Query(query, args...)
+}
+
+func (c roConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return c.RODB.QueryContext(ctx, query, args...)
+}
+
+func (c roConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.RODB.Exec(query, args...)
+}
+
+func (c roConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return c.RODB.ExecContext(ctx, query, args...)
+}
+
+// QueryReadOnly runs query on a connection of the read-write pool with the read-only authorizer of RODB -
+// for code paths that must never write, independent of the pool they use
+func QueryReadOnly(db *DB, query string, result interface{}, args ...interface{}) error {
+	ctx := context.Background()
+	release, err := db.acquire(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer release()
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT _gosql_read_only(1)"); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT _gosql_read_only(0)"); err != nil {
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+	}()
+	return Query(pinnedConn{ctxConn{ctx, conn}, db, false}, query, result, args...)
+}
+
+// Snapshot runs fn in a read transaction on a connection of the read-only pool - all queries of fn see
+// the same state of the database, even while writers commit concurrently (requires WAL mode)
+func (db *DB) Snapshot(fn func(Connection) error) (err error) {
+	ctx := context.Background()
+	release, err := db.acquire(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+	conn, err := db.RODB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// the read-only authorizer denies BEGIN and COMMIT - the first read starts the snapshot of the deferred transaction
+	if err := execUnauthorized(conn, db, "BEGIN DEFERRED", "SELECT count(*) FROM sqlite_master"); err != nil {
+		return err
+	}
+	defer func() {
+		if endErr := execUnauthorized(conn, db, "COMMIT"); endErr != nil {
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+			if err == nil {
+				err = endErr
+			}
+		}
+	}()
+	return fn(pinnedConn{ctxConn{ctx, conn}, db, false})
+}
+
+func execUnauthorized(conn *sql.Conn, db *DB, queries ...string) error {
+	return conn.Raw(func(c interface{}) error {
+		dc := c.(driverConn)
+		dc.RegisterAuthorizer(nil)
+		defer dc.RegisterAuthorizer(db.authorizeReadOnly)
+		for _, query := range queries {
+			if _, err := dc.Exec(query, nil); err != nil {
+				return fmt.Errorf("%s: %w", query, err)
+			}
+		}
+		return nil
+	})
+}
+
+// the authorizer only runs while statements are prepared - toggling it per connection is cheap
+func (db *DB) registerReadOnlySwitch(c driverConn) error {
+	readOnly := false
+	c.RegisterAuthorizer(func(op int, arg1, arg2, arg3 string) int {
+		if !readOnly {
+			return authOK
+		}
+		return db.authorizeReadOnly(op, arg1, arg2, arg3)
+	})
+	return c.RegisterFunc("_gosql_read_only", func(enabled bool) bool { readOnly = enabled; return enabled }, false)
+}
+
+func isReadOnlyConn(c Connection) bool {
+	if cc, ok := c.(ctxConn); ok {
+		_, ok := cc.contextConn.(roConn)
+		return ok
+	}
+	_, ok := c.(roConn)
+	return ok
+}
@@ -0,0 +1,324 @@
+package gosql
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+func (db *DB) CreateTable(table string, v interface{}) error {
+	query, err := createTableQuery(table, v, db.ColumnMapper)
+	if err != nil {
+		return err
+	}
+	_, err = Exec(db, query)
+	return err
+}
+
+func createTableQuery(table string, v interface{}, mapper func(string) string) (string, error) {
+	t, err := structType(v)
+	if err != nil {
+		return "", err
+	}
+	table, err = quoteTableName(table)
+	if err != nil {
+		return "", err
+	}
+	fields, pks, withoutRowID := columnFields(t, mapper), []string{}, false
+	for _, f := range fields {
+		name, options := mappedTag(mapper, f)
+		if _, ok := options["withoutrowid"]; ok {
+			withoutRowID = true
+		}
+		if _, isPK := options["pk"]; isPK {
+			quoted, err := quoteIdentifier(name)
+			if err != nil {
+				return "", err
+			}
+			pks = append(pks, quoted)
+		}
+	}
+	columns := []string{}
+	for _, f := range fields {
+		column, err := columnDefinition(f, len(pks) == 1, true, mapper)
+		if err != nil {
+			return "", err
+		}
+		columns = append(columns, column)
+	}
+	if len(pks) > 1 {
+		columns = append(columns, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(pks, ", ")))
+	}
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(columns, ", "))
+	if withoutRowID {
+		if len(pks) == 0 {
+			return "", fmt.Errorf("%s: WITHOUT ROWID table requires a primary key", table)
+		}
+		query += " WITHOUT ROWID"
+	}
+	return query, nil
+}
+
+func (db *DB) AutoMigrate(table string, v interface{}, dryRun bool) ([]string, error) {
+	queries, err := autoMigrateQueries(db, table, v)
+	if err != nil || dryRun {
+		return queries, err
+	}
+	for _, query := range queries {
+		if _, err := Exec(db, query); err != nil {
+			return nil, err
+		}
+	}
+	return queries, nil
+}
+
+func autoMigrateQueries(c Connection, table string, v interface{}) ([]string, error) {
+	t, err := structType(v)
+	if err != nil {
+		return nil, err
+	}
+	existingColumns, existingIndexes := []string{}, []string{}
+	if err := Query(c, "SELECT name FROM pragma_table_info(?)", &existingColumns, table); err != nil {
+		return nil, err
+	}
+	if err := Query(c, "SELECT name FROM pragma_index_list(?)", &existingIndexes, table); err != nil {
+		return nil, err
+	}
+	queries, indexQueries, columns, indexes := []string{}, []string{}, map[string]bool{}, map[string]bool{}
+	mapper := columnMapperOf(c)
+	for _, column := range existingColumns {
+		columns[column] = true
+	}
+	for _, index := range existingIndexes {
+		indexes[index] = true
+	}
+	if len(existingColumns) == 0 {
+		query, err := createTableQuery(table, v, mapper)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, query)
+	}
+	quotedTable, err := quoteTableName(table)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range columnFields(t, mapper) {
+		name, options := mappedTag(mapper, f)
+		if len(existingColumns) != 0 && !columns[name] {
+			if err := checkAddColumn(table, name, f, options); err != nil {
+				return nil, err
+			}
+			column, err := columnDefinition(f, false, false, mapper)
+			if err != nil {
+				return nil, err
+			}
+			queries = append(queries, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", quotedTable, column))
+			if _, ok := options["unique"]; ok {
+				query, err := createIndexQuery(table, name, true)
+				if err != nil {
+					return nil, err
+				}
+				indexQueries = append(indexQueries, query)
+			}
+		}
+		if _, ok := options["index"]; ok && !indexes[indexName(table, name)] {
+			query, err := createIndexQuery(table, name, false)
+			if err != nil {
+				return nil, err
+			}
+			indexQueries = append(indexQueries, query)
+		}
+	}
+	return append(queries, indexQueries...), nil
+}
+
+// checkAddColumn rejects columns sqlite cannot ADD to an existing table - adding them requires rebuilding the table,
+// which is left to a hand-written migration. UNIQUE columns are added as a plain column plus a unique index instead
+func checkAddColumn(table, column string, f reflect.StructField, options map[string]string) error {
+	d, hasDefault := f.Tag.Lookup("default")
+	d = strings.ToUpper(strings.TrimSpace(d))
+	if _, ok := options["pk"]; ok {
+		return fmt.Errorf("%s.%s: cannot add PRIMARY KEY column to existing table - migrate it by hand", table, column)
+	} else if _, ok := options["notnull"]; ok && (!hasDefault || d == "NULL") {
+		return fmt.Errorf("%s.%s: cannot add NOT NULL column without default to existing table - migrate it by hand", table, column)
+	} else if strings.HasPrefix(d, "CURRENT_") || strings.HasPrefix(d, "(") {
+		return fmt.Errorf("%s.%s: cannot add column with non-constant DEFAULT %s to existing table - migrate it by hand", table, column, d)
+	}
+	return nil
+}
+
+func createIndexQuery(table, column string, unique bool) (string, error) {
+	quotedTable, err := quoteTableName(table)
+	if err != nil {
+		return "", err
+	}
+	quotedColumn, err := quoteIdentifier(column)
+	if err != nil {
+		return "", err
+	}
+	name, kind := indexName(table, column), "INDEX"
+	if unique {
+		name, kind = table+"_"+column+"_key", "UNIQUE INDEX"
+	}
+	quotedIndex, err := quoteIdentifier(name)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("CREATE %s IF NOT EXISTS %s ON %s (%s)", kind, quotedIndex, quotedTable, quotedColumn), nil
+}
+
+func indexName(table, column string) string {
+	return table + "_" + column + "_idx"
+}
+
+func structType(v interface{}) (reflect.Type, error) {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot derive table from %T", v)
+	}
+	return t, nil
+}
+
+func columnFields(t reflect.Type, mapper func(string) string) []reflect.StructField {
+	fields := []reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.PkgPath == "" {
+			if name, _ := mappedTag(mapper, f); name != "-" {
+				fields = append(fields, f)
+			}
+		}
+	}
+	return fields
+}
+
+func columnDefinition(f reflect.StructField, inlinePK, inlineUnique bool, mapper func(string) string) (string, error) {
+	name, options := mappedTag(mapper, f)
+	name, err := quoteIdentifier(name)
+	if err != nil {
+		return "", err
+	}
+	sqlType, ok := options["type"]
+	if !ok {
+		sqlType = columnType(f.Type)
+	}
+	parts := []string{name, sqlType}
+	if _, ok := options["pk"]; ok && inlinePK {
+		parts = append(parts, "PRIMARY KEY")
+	}
+	if _, ok := options["notnull"]; ok {
+		parts = append(parts, "NOT NULL")
+	}
+	if _, ok := options["unique"]; ok && inlineUnique {
+		parts = append(parts, "UNIQUE")
+	}
+	if d, ok := f.Tag.Lookup("default"); ok {
+		parts = append(parts, "DEFAULT "+d)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+func columnType(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return "TIMESTAMP"
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return "BLOB"
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "INTEGER"
+	case reflect.Float32, reflect.Float64:
+		return "REAL"
+	default:
+		return "TEXT"
+	}
+}
+
+func (db *DB) SchemaDump(w io.Writer) error {
+	return SchemaDump(w, db.RODB)
+}
+
+func SchemaDump(w io.Writer, c Connection) error {
+	return schemaDump(w, c, "")
+}
+
+func schemaDump(w io.Writer, c Connection, table string) error {
+	objects, err := masterObjects(c, table, true)
+	if err != nil {
+		return err
+	}
+	shadows := shadowTables(objects)
+	for _, o := range objects {
+		if shadows[o.Name] {
+			continue
+		} else if _, err := fmt.Fprintf(w, "%s;\n", o.SQL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isVirtualTable(sql string) bool {
+	return strings.HasPrefix(strings.ToUpper(sql), "CREATE VIRTUAL TABLE")
+}
+
+var shadowTableSuffixes = map[string][]string{
+	"fts3":  {"_content", "_segments", "_segdir", "_docsize", "_stat"},
+	"fts4":  {"_content", "_segments", "_segdir", "_docsize", "_stat"},
+	"fts5":  {"_data", "_idx", "_content", "_docsize", "_config"},
+	"rtree": {"_node", "_rowid", "_parent"},
+}
+
+// shadowTables returns the tables that store the data of virtual tables (e.g. x_content and x_idx of fts5 table x).
+// sqlite (as of 3.34) does not expose which tables are shadow tables - they are recognized by the known suffixes of their module
+func shadowTables(objects []dumpObject) map[string]bool {
+	tables, m := map[string]bool{}, map[string]bool{}
+	for _, o := range objects {
+		if o.Type == "table" && !isVirtualTable(o.SQL) {
+			tables[o.Name] = true
+		}
+	}
+	for _, vt := range objects {
+		if vt.Type != "table" || !isVirtualTable(vt.SQL) {
+			continue
+		}
+		for _, suffix := range shadowTableSuffixes[virtualTableModule(vt.SQL)] {
+			if tables[vt.Name+suffix] {
+				m[vt.Name+suffix] = true
+			}
+		}
+	}
+	return m
+}
+
+func virtualTableModule(sql string) string {
+	tokens := significantTokens(sql)
+	for i, t := range tokens {
+		if strings.EqualFold(t.text, "USING") && i+1 < len(tokens) {
+			return strings.ToLower(unquoteToken(tokens[i+1]))
+		}
+	}
+	return ""
+}
+
+// masterObjects returns the objects of sqlite_master (of all or just one table) - tables first, then indexes, views and triggers.
+// Within each type they are ordered by name or, for scripts that have to recreate them, in order of creation
+func masterObjects(c Connection, table string, byName bool) ([]dumpObject, error) {
+	objects, order := []dumpObject{}, "rowid"
+	if byName {
+		order = "name"
+	}
+	query := `SELECT type, name, sql FROM sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' AND (? = '' OR tbl_name = ?)
+	          ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'index' THEN 1 WHEN 'view' THEN 2 ELSE 3 END, ` + order
+	return objects, Query(c, query, &objects, table, table)
+}
@@ -0,0 +1,96 @@
+package gosql
+
+import (
+	"fmt"
+	"strings"
+)
+
+type ScriptError struct {
+	Statement int
+	Line      int
+	Query     string
+	Err       error
+}
+
+type scriptStatement struct {
+	query string
+	line  int
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("statement %d (line %d): %s: %s", e.Statement, e.Line, e.Query, e.Err)
+}
+
+func (e *ScriptError) Unwrap() error { return e.Err }
+
+func ExecScript(c Connection, script string) error {
+	statements, rest := splitScript(script)
+	if line := lineOf(rest); line != 0 {
+		line += strings.Count(script[:len(script)-len(rest)], "\n")
+		statements = append(statements, scriptStatement{strings.TrimSpace(rest), line})
+	}
+	for i, s := range statements {
+		if _, err := c.Exec(s.query); err != nil {
+			return &ScriptError{i + 1, s.line, s.query, err}
+		}
+	}
+	return nil
+}
+
+func splitStatements(s string) ([]string, string) {
+	statements, rest := splitScript(s)
+	queries := make([]string, len(statements))
+	for i, statement := range statements {
+		queries[i] = statement.query
+	}
+	return queries, rest
+}
+
+func splitScript(s string) ([]scriptStatement, string) {
+	statements, words, start, offset, line, depth := []scriptStatement{}, []string{}, 0, 0, 1, 0
+	for _, t := range tokenize(s) {
+		offset += len(t.text)
+		if t.kind == "space" || t.kind == "comment" {
+			continue
+		}
+		if t.kind == "word" && len(words) < 3 {
+			words = append(words, strings.ToUpper(t.text))
+		}
+		if t.kind == "word" && isCreateTrigger(words) {
+			switch strings.ToUpper(t.text) {
+			case "BEGIN", "CASE":
+				depth++
+			case "END":
+				depth--
+			}
+		}
+		if t.text == ";" && depth <= 0 {
+			query := s[start:offset]
+			statements = append(statements, scriptStatement{strings.TrimSpace(query), line + lineOf(query) - 1})
+			start, line, words, depth = offset, line+strings.Count(query, "\n"), nil, 0
+		}
+	}
+	return statements, s[start:]
+}
+
+func isCreateTrigger(words []string) bool {
+	switch {
+	case len(words) < 2 || words[0] != "CREATE":
+		return false
+	case words[1] == "TRIGGER":
+		return true
+	default:
+		return len(words) == 3 && (words[1] == "TEMP" || words[1] == "TEMPORARY") && words[2] == "TRIGGER"
+	}
+}
+
+func lineOf(s string) int {
+	line := 1
+	for _, t := range tokenize(s) {
+		if t.kind != "space" && t.kind != "comment" {
+			return line
+		}
+		line += strings.Count(t.text, "\n")
+	}
+	return 0
+}
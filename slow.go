@@ -0,0 +1,50 @@
+package gosql
+
+import (
+	"strings"
+	"time"
+)
+
+type SlowQuery struct {
+	Query    string
+	Args     []interface{}
+	Duration time.Duration
+	Rows     int
+	Err      error
+	Plan     []string
+}
+
+type planStep struct {
+	ID     int    `db:"id"`
+	Parent int    `db:"parent"`
+	Detail string `db:"detail"`
+}
+
+func (db *DB) logSlowQuery(q SlowQuery) {
+	plan, err := QueryPlan(db.DB, q.Query, q.Args...)
+	if err != nil {
+		plan = []string{"error: " + err.Error()}
+	}
+	q.Plan = plan
+	if db.OnSlowQuery != nil {
+		db.OnSlowQuery(q)
+		return
+	}
+	db.logger().Printf("SLOW QUERY (%s, %d rows): %s\n%s", q.Duration, q.Rows, q.Query, strings.Join(q.Plan, "\n"))
+}
+
+func QueryPlan(c Connection, query string, args ...interface{}) ([]string, error) {
+	steps, depths, plan := []planStep{}, map[int]int{}, []string{}
+	if err := Query(c, "EXPLAIN QUERY PLAN "+query, &steps, args...); err != nil {
+		return nil, err
+	}
+	for _, step := range steps {
+		depth := 0
+		if d, ok := depths[step.Parent]; ok {
+			depth = d + 1
+		}
+		depths[step.ID] = depth
+		plan = append(plan, strings.Repeat("  ", depth)+step.Detail)
+	}
+	return plan, nil
+}
@@ -0,0 +1,69 @@
+package gosql
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// TableFunc is a row source usable as `SELECT * FROM name(args...)`. next returns nil, nil after the last row.
+// Table funcs are virtual tables and require the sqlite_vtable build tag.
+type TableFunc struct {
+	Columns []string
+	Args    []string
+	Rows    func(args ...interface{}) (next func() ([]interface{}, error), err error)
+}
+
+var defaultTableFuncs = map[string]TableFunc{
+	"generate_series": {[]string{"value"}, []string{"start", "stop", "step"}, generateSeries},
+	"regexp_matches":  {[]string{"match", "start", "end", "groups"}, []string{"input", "pattern"}, regexpMatches},
+}
+
+func generateSeries(args ...interface{}) (func() ([]interface{}, error), error) {
+	start, ok1 := args[0].(int64)
+	stop, ok2 := args[1].(int64)
+	step, ok3 := args[2].(int64)
+	if args[2] == nil {
+		step, ok3 = 1, true
+	}
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("generate_series: start, stop and step must be integers")
+	} else if step == 0 {
+		return nil, fmt.Errorf("generate_series: step must not be 0")
+	}
+	return func() ([]interface{}, error) {
+		if (step > 0 && start > stop) || (step < 0 && start < stop) {
+			return nil, nil
+		}
+		start += step
+		return []interface{}{start - step}, nil
+	}, nil
+}
+
+func regexpMatches(args ...interface{}) (func() ([]interface{}, error), error) {
+	input, ok1 := args[0].(string)
+	pattern, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("regexp_matches: input and pattern must be strings")
+	}
+	r, err := regexpExtractRegexps.get(pattern)
+	if err != nil {
+		return nil, err
+	}
+	matches := r.FindAllStringSubmatchIndex(input, -1)
+	return func() ([]interface{}, error) {
+		if len(matches) == 0 {
+			return nil, nil
+		}
+		m, groups := matches[0], []interface{}{}
+		matches = matches[1:]
+		for i := 2; i < len(m); i += 2 {
+			if m[i] == -1 {
+				groups = append(groups, nil)
+			} else {
+				groups = append(groups, input[m[i]:m[i+1]])
+			}
+		}
+		bs, err := json.Marshal(groups)
+		return []interface{}{input[m[0]:m[1]], int64(m[0]), int64(m[1]), string(bs)}, err
+	}, nil
+}
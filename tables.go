@@ -0,0 +1,82 @@
+package gosql
+
+import (
+	"strconv"
+	"strings"
+)
+
+type TableInfo struct {
+	Name          string           `db:"name" json:"name"`
+	Columns       []ManifestColumn `db:"-" json:"columns"`
+	Indexes       []TableIndex     `db:"-" json:"indexes"`
+	Rows          int64            `db:"-" json:"rows"`
+	RowsEstimated bool             `db:"-" json:"rows_estimated"`
+	Size          *int64           `db:"-" json:"size"` // bytes of table and indexes - nil if sqlite is built without dbstat
+}
+
+// Tables returns the user tables (no sqlite_ or _ prefix) with columns, indexes, row count and size.
+// Row counts are taken from sqlite_stat1 (see ANALYZE) if available and counted otherwise
+func (db *DB) Tables() ([]TableInfo, error) {
+	c, tables := db.RO(), []TableInfo{}
+	query := "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND substr(name, 1, 1) != '_' ORDER BY name"
+	if err := Query(c, query, &tables); err != nil {
+		return nil, err
+	}
+	hasStats, hasDBStat := []int{}, true
+	if err := Query(c, "SELECT count(*) FROM sqlite_master WHERE name = 'sqlite_stat1'", &hasStats); err != nil {
+		return nil, err
+	}
+	for i, t := range tables {
+		if err := Query(c, "SELECT name, type, \"notnull\", dflt_value, pk FROM pragma_table_info(?)", &tables[i].Columns, t.Name); err != nil {
+			return nil, err
+		}
+		indexes, err := tableIndexes(c, t.Name)
+		if err != nil {
+			return nil, err
+		}
+		tables[i].Indexes = indexes
+		if tables[i].Rows, tables[i].RowsEstimated, err = tableRows(c, t.Name, hasStats[0] != 0); err != nil {
+			return nil, err
+		}
+		if hasDBStat {
+			sizes := []int64{}
+			query := "SELECT coalesce(sum(pgsize), 0) FROM dbstat WHERE name IN (SELECT name FROM sqlite_master WHERE tbl_name = ?)"
+			if err := Query(c, query, &sizes, t.Name); err != nil && strings.Contains(err.Error(), "no such table: dbstat") {
+				hasDBStat = false
+			} else if err != nil {
+				return nil, err
+			} else {
+				tables[i].Size = &sizes[0]
+			}
+		}
+	}
+	return tables, nil
+}
+
+func tableRows(c Connection, table string, hasStats bool) (int64, bool, error) {
+	if hasStats {
+		stats := []string{}
+		if err := Query(c, "SELECT stat FROM sqlite_stat1 WHERE tbl = ?", &stats, table); err != nil {
+			return 0, false, err
+		} else if len(stats) != 0 {
+			n := int64(0)
+			for _, stat := range stats {
+				if fields := strings.Fields(stat); len(fields) != 0 {
+					if x, _ := strconv.ParseInt(fields[0], 10, 64); x > n {
+						n = x
+					}
+				}
+			}
+			return n, true, nil
+		}
+	}
+	quoted, err := quoteIdentifier(table)
+	if err != nil {
+		return 0, false, err
+	}
+	counts := []int64{}
+	if err := Query(c, "SELECT count(*) FROM "+quoted, &counts); err != nil {
+		return 0, false, err
+	}
+	return counts[0], false, nil
+}
@@ -0,0 +1,194 @@
+package gosql
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Tenants opens and caches one DB per tenant. Unused DBs are closed after IdleTimeout and when more than MaxOpen are open
+type Tenants struct {
+	DSN         string      // fmt template of the DataSourceName, e.g. data/%s.sqlite
+	Migrations  interface{} // applied whenever a tenant DB is opened
+	Funcs       map[string]interface{}
+	Configure   func(tenant string, db *DB) // optional, called before a tenant DB is opened
+	MaxOpen     int
+	IdleTimeout time.Duration
+
+	mutex  sync.Mutex
+	dbs    map[string]*tenantDB
+	done   chan struct{}
+	closed bool
+}
+
+// tenantDB is added to dbs before it is opened - ready is closed once DB or err is set
+type tenantDB struct {
+	*DB
+	refs     int
+	lastUsed time.Time
+	ready    chan struct{}
+	err      error
+}
+
+var ErrTenantsClosed = errors.New("tenants are closed")
+
+// tenant ids end up in the DSN - anything but these characters could escape the directory or add DSN options (?)
+var tenantRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Acquire returns the DB of tenant - release must be called once it is no longer used.
+// Migrations run without holding the lock - other tenants can be acquired meanwhile
+func (t *Tenants) Acquire(tenant string) (db *DB, release func(), err error) {
+	if !tenantRegexp.MatchString(tenant) {
+		return nil, nil, fmt.Errorf("invalid tenant %q", tenant)
+	}
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		return nil, nil, ErrTenantsClosed
+	} else if t.dbs == nil {
+		t.dbs, t.done = map[string]*tenantDB{}, make(chan struct{})
+		if t.IdleTimeout > 0 {
+			go t.evictIdle(t.done)
+		}
+	}
+	tdb, ok := t.dbs[tenant]
+	if !ok {
+		if t.MaxOpen > 0 && len(t.dbs) >= t.MaxOpen && !t.evictLRU() {
+			t.mutex.Unlock()
+			return nil, nil, ErrLimitExceeded
+		}
+		tdb = &tenantDB{ready: make(chan struct{})}
+		t.dbs[tenant] = tdb
+	}
+	tdb.refs++
+	t.mutex.Unlock()
+	once := sync.Once{}
+	release = func() {
+		once.Do(func() {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			tdb.refs, tdb.lastUsed = tdb.refs-1, time.Now()
+		})
+	}
+	if !ok {
+		t.open(tenant, tdb)
+	}
+	if <-tdb.ready; tdb.err != nil {
+		release()
+		return nil, nil, tdb.err
+	}
+	return tdb.DB, release, nil
+}
+
+func (t *Tenants) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	if t.done != nil {
+		close(t.done)
+	}
+	var err error
+	for tenant, tdb := range t.dbs {
+		if closeErr := tdb.close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("%s: %w", tenant, closeErr)
+		}
+		delete(t.dbs, tenant)
+	}
+	return err
+}
+
+// Open returns the tenants that currently have an open DB
+func (t *Tenants) Open() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	tenants := []string{}
+	for tenant := range t.dbs {
+		tenants = append(tenants, tenant)
+	}
+	return tenants
+}
+
+func (t *Tenants) open(tenant string, tdb *tenantDB) {
+	db := &DB{DataSourceName: fmt.Sprintf(t.DSN, tenant), Funcs: map[string]interface{}{}}
+	for k, v := range t.Funcs {
+		db.Funcs[k] = v
+	}
+	if t.Configure != nil {
+		t.Configure(tenant, db)
+	}
+	err := db.Open(t.Migrations)
+	if err != nil {
+		err = fmt.Errorf("tenant %s: %w", tenant, err)
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err == nil && t.closed {
+		err = ErrTenantsClosed
+	}
+	if err != nil {
+		if db.DB != nil {
+			db.DB.Close()
+			db.RODB.Close()
+		}
+		if t.dbs[tenant] == tdb {
+			delete(t.dbs, tenant)
+		}
+		tdb.err = err
+	} else {
+		tdb.DB, tdb.lastUsed = db, time.Now()
+	}
+	close(tdb.ready)
+}
+
+// evictLRU closes the least recently used DB that is not in use
+func (t *Tenants) evictLRU() bool {
+	lru := ""
+	for tenant, tdb := range t.dbs {
+		if tdb.refs == 0 && (lru == "" || tdb.lastUsed.Before(t.dbs[lru].lastUsed)) {
+			lru = tenant
+		}
+	}
+	if lru == "" {
+		return false
+	}
+	t.dbs[lru].close()
+	delete(t.dbs, lru)
+	return true
+}
+
+func (t *Tenants) evictIdle(done chan struct{}) {
+	ticker := time.NewTicker(t.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			t.mutex.Lock()
+			for tenant, tdb := range t.dbs {
+				if tdb.refs == 0 && time.Since(tdb.lastUsed) > t.IdleTimeout {
+					tdb.close()
+					delete(t.dbs, tenant)
+				}
+			}
+			t.mutex.Unlock()
+		}
+	}
+}
+
+// DB is nil while the tenant is still being opened - open closes it if Tenants were closed meanwhile
+func (tdb *tenantDB) close() error {
+	if tdb.DB == nil {
+		return nil
+	}
+	if err := tdb.DB.DB.Close(); err != nil {
+		tdb.RODB.Close()
+		return err
+	}
+	return tdb.RODB.Close()
+}
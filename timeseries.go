@@ -0,0 +1,108 @@
+package gosql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value *float64  `json:"value"`
+}
+
+var downsampleAggregates = map[string]bool{"avg": true, "sum": true, "total": true, "min": true, "max": true, "count": true}
+
+const maxDownsamplePoints = 100000
+
+// Downsample aggregates the (time, value) rows returned by query into buckets of interval in [from, to).
+// Buckets without rows are returned with a nil Value. All rows of query are read - filter by time in query
+func Downsample(c Connection, interval time.Duration, from, to time.Time, aggregate, query string, args ...interface{}) ([]Point, error) {
+	if !downsampleAggregates[aggregate] {
+		return nil, fmt.Errorf("downsample: unsupported aggregate %q", aggregate)
+	} else if interval <= 0 {
+		return nil, fmt.Errorf("downsample: invalid interval %s", interval)
+	} else if n := to.Sub(from) / interval; n > maxDownsamplePoints {
+		return nil, fmt.Errorf("downsample: %d points exceed the maximum of %d", n, maxDownsamplePoints)
+	}
+	columns := []string{}
+	err := withRows(c, "SELECT * FROM ("+query+") LIMIT 0", args, func(rows *resultRows) (err error) {
+		columns, err = rows.Columns()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	} else if len(columns) < 2 {
+		return nil, fmt.Errorf("downsample: query must return time and value columns, got %d", len(columns))
+	}
+	downsample, err := downsampleQuery(aggregate, query, columns[0], columns[1])
+	if err != nil {
+		return nil, err
+	}
+	rows := []struct {
+		Bucket string   `db:"bucket"`
+		Value  *float64 `db:"value"`
+	}{}
+	if err := Query(c, downsample, &rows, append(append([]interface{}{}, args...), interval.String())...); err != nil {
+		return nil, err
+	}
+	buckets := map[int64]*float64{}
+	for _, r := range rows {
+		t, err := parseTimeValue("downsample", r.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		buckets[t.Unix()] = r.Value
+	}
+	points := []Point{}
+	for t := from.UTC().Truncate(interval); t.Before(to); t = t.Add(interval) {
+		points = append(points, Point{t, buckets[t.Unix()]})
+	}
+	return points, nil
+}
+
+// the first two columns of query are used as time and value - further columns are ignored
+func downsampleQuery(aggregate, query, timeColumn, valueColumn string) (string, error) {
+	t, err := quoteIdentifier(timeColumn)
+	if err != nil {
+		return "", err
+	}
+	v, err := quoteIdentifier(valueColumn)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`WITH _q AS (%s), _tv AS (SELECT %s AS t, %s AS v FROM _q)
+	                    SELECT time_bucket(?, t) AS bucket, %s(v) AS value FROM _tv WHERE t IS NOT NULL GROUP BY bucket`, query, t, v, aggregate), nil
+}
+
+// time_bucket truncates ts (unix seconds or a timestamp) to a multiple of interval (a go duration or Nd / Nw).
+// The start of the bucket is returned as RFC3339 in UTC
+func timeBucket(interval string, ts interface{}) (string, error) {
+	d, err := parseInterval(interval)
+	if err != nil {
+		return "", err
+	}
+	t, err := parseTimeValue("time_bucket", ts)
+	if err != nil {
+		return "", err
+	}
+	return t.Truncate(d).Format(time.RFC3339), nil
+}
+
+func parseInterval(s string) (time.Duration, error) {
+	d, err := time.Duration(0), error(nil)
+	if n, suffix := strings.TrimRight(s, "dw"), strings.TrimLeft(s, "0123456789"); len(suffix) == 1 && n != s {
+		x, convErr := strconv.Atoi(n)
+		d, err = time.Duration(x)*24*time.Hour, convErr
+		if suffix == "w" {
+			d *= 7
+		}
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("time_bucket: invalid interval %q", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,33 @@
+package gosql
+
+import (
+	"context"
+	"database/sql"
+)
+
+type Tracer interface {
+	Start(ctx context.Context, operation, query string) (context.Context, Span)
+}
+
+type Span interface {
+	End(rows int, err error)
+}
+
+type nopSpan struct{}
+
+func (nopSpan) End(int, error) {}
+
+func (db *DB) startSpan(ctx context.Context, operation, query string) (context.Context, Span) {
+	if db.Tracer == nil {
+		return ctx, nopSpan{}
+	}
+	return db.Tracer.Start(ctx, operation, query)
+}
+
+func rowsAffected(result sql.Result) int {
+	if result == nil {
+		return 0
+	}
+	n, _ := result.RowsAffected()
+	return int(n)
+}
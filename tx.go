@@ -0,0 +1,124 @@
+package gosql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type TxOptions struct {
+	Immediate  bool
+	Retries    int
+	RetryDelay time.Duration
+}
+
+type contextConn interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type ctxConn struct {
+	ctx context.Context
+	contextConn
+}
+
+func (c ctxConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.QueryContext(c.ctx, query, args...)
+}
+
+func (c ctxConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.ExecContext(c.ctx, query, args...)
+}
+
+// pinnedConn is a connection of the pools of db held by Transact, Batch, Snapshot or QueryReadOnly. The hooks of db
+// (history, metrics, tracing, column cipher and mapper) apply to its statements - the limiter is acquired once for
+// the whole connection instead. History is recorded on the connection itself inside transactions: a second
+// connection would have to wait for the lock of the transaction
+type pinnedConn struct {
+	ctxConn
+	db *DB
+	tx bool
+}
+
+func (c pinnedConn) Exec(query string, args ...interface{}) (result sql.Result, err error) {
+	defer c.db.recordHistory(c.historyConn(), query, args, time.Now(), &err)
+	defer c.db.observeExec(query, args, time.Now(), &result, &err)
+	ctx, span := c.db.startSpan(c.ctx, "exec", query)
+	defer func() { span.End(rowsAffected(result), err) }()
+	args, err = convertArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return c.ExecContext(ctx, query, args...)
+}
+
+// pinnedOf returns the pinnedConn of c - Batch statements run on the pinned connection of their transaction
+func pinnedOf(c Connection) (pinnedConn, bool) {
+	if b, ok := c.(*Batch); ok {
+		c = b.Connection
+	}
+	pc, ok := c.(pinnedConn)
+	return pc, ok
+}
+
+func (c pinnedConn) historyConn() Connection {
+	if c.tx {
+		return c.ctxConn
+	}
+	return c.db.DB
+}
+
+func (db *DB) Transact(ctx context.Context, opts TxOptions, fn func(Connection) error) error {
+	for attempt := 0; ; attempt++ {
+		err := db.transact(ctx, opts, func(pc pinnedConn, _ *sql.Conn) error { return fn(pc) })
+		if err == nil || !IsBusy(err) || attempt >= opts.Retries {
+			return err
+		} else if db.Metrics != nil {
+			db.Metrics.recordBusyRetry()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(opts.RetryDelay * time.Duration(attempt+1)):
+		}
+	}
+}
+
+func (db *DB) transact(ctx context.Context, opts TxOptions, fn func(pinnedConn, *sql.Conn) error) (err error) {
+	ctx, span := db.startSpan(ctx, "transaction", "")
+	defer func() { span.End(0, err) }()
+	release, err := db.acquire(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer release()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	begin := "BEGIN DEFERRED"
+	if opts.Immediate {
+		begin = "BEGIN IMMEDIATE"
+	}
+	if _, err := conn.ExecContext(ctx, begin); err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+			panic(r)
+		} else if err != nil {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+		}
+	}()
+	if err := fn(pinnedConn{ctxConn{ctx, conn}, db, true}, conn); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "COMMIT")
+	return err
+}
+
+func IsBusy(err error) bool {
+	return isBusyError(err)
+}
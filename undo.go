@@ -0,0 +1,159 @@
+package gosql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+type undoState struct {
+	step      int64
+	replaying bool
+}
+
+func (db *DB) Undo() (bool, error) { return db.replayUndoLog("undo", "redo") }
+
+func (db *DB) Redo() (bool, error) { return db.replayUndoLog("redo", "undo") }
+
+func (db *DB) replayUndoLog(from, to string) (bool, error) {
+	if len(db.UndoTables) == 0 {
+		return false, fmt.Errorf("undo is not enabled")
+	}
+	replayed := false
+	err := db.Transact(context.Background(), TxOptions{Immediate: true}, func(c Connection) error {
+		steps, queries := []int64{}, []string{}
+		if err := Query(c, "SELECT step FROM _undolog WHERE kind = ? ORDER BY seq DESC LIMIT 1", &steps, from); err != nil {
+			return err
+		} else if len(steps) == 0 {
+			return nil
+		}
+		q := "SELECT sql FROM _undolog WHERE kind = ? AND step = ? ORDER BY seq DESC"
+		if err := Query(c, q, &queries, from, steps[0]); err != nil {
+			return err
+		}
+		if _, err := Exec(c, "DELETE FROM _undolog WHERE kind = ? AND step = ?", from, steps[0]); err != nil {
+			return err
+		}
+		if _, err := Exec(c, "SELECT _undo_replay(1)"); err != nil {
+			return err
+		}
+		for _, query := range queries {
+			if _, err := Exec(c, query); err != nil {
+				return err
+			}
+		}
+		if _, err := Exec(c, "UPDATE _undolog SET kind = ? WHERE kind = 'undo' AND step = _undo_step()", to); err != nil {
+			return err
+		}
+		replayed = true
+		return nil
+	})
+	return replayed && err == nil, err
+}
+
+// the undo funcs are registered even without UndoTables - the triggers stay in the database file and would
+// otherwise break writes to their tables
+func (db *DB) registerUndoFuncs(c driverConn) error {
+	state := &undoState{}
+	reset := func() { state.step, state.replaying = 0, false }
+	c.RegisterCommitHook(func() int { reset(); return 0 })
+	c.RegisterRollbackHook(reset)
+	step := func() int64 {
+		if state.step == 0 {
+			state.step = atomic.AddInt64(&db.undoStep, 1)
+		}
+		return state.step
+	}
+	replay := func(replaying bool) bool {
+		state.replaying = replaying
+		return replaying
+	}
+	if err := c.RegisterFunc("_undo_step", step, false); err != nil {
+		return err
+	} else if err := c.RegisterFunc("_undo_replay", replay, false); err != nil {
+		return err
+	}
+	return c.RegisterFunc("_undo_replaying", func() bool { return state.replaying }, false)
+}
+
+func (db *DB) createUndoTriggers() error {
+	if len(db.UndoTables) == 0 {
+		return nil
+	}
+	q := `CREATE TABLE IF NOT EXISTS _undolog (seq INTEGER PRIMARY KEY, step INTEGER, kind TEXT DEFAULT 'undo', sql TEXT);
+	      CREATE INDEX IF NOT EXISTS _undolog_step_idx ON _undolog (step)`
+	if _, err := db.DB.Exec(q); err != nil {
+		return err
+	}
+	steps := []int64{}
+	if err := Query(db.DB, "SELECT coalesce(max(step), 0) FROM _undolog", &steps); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&db.undoStep, steps[0])
+	for _, table := range db.UndoTables {
+		columns, schemas := []string{}, []string{}
+		if err := Query(db.DB, "SELECT name FROM pragma_table_info(?)", &columns, table); err != nil {
+			return err
+		} else if len(columns) == 0 {
+			return fmt.Errorf("undo: no such table %s", table)
+		} else if err := Query(db.DB, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", &schemas, table); err != nil {
+			return err
+		} else if len(schemas) != 0 && strings.Contains(strings.ToUpper(schemas[0]), "WITHOUT ROWID") {
+			return fmt.Errorf("undo: table %s is WITHOUT ROWID - undo log entries are keyed by rowid", table)
+		}
+		queries, err := undoTriggerQueries(table, columns, db.UndoLimit)
+		if err != nil {
+			return err
+		}
+		for _, query := range queries {
+			if _, err := db.DB.Exec(query); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func undoTriggerQueries(table string, columns []string, limit int) ([]string, error) {
+	t, err := quoteIdentifier(table)
+	if err != nil {
+		return nil, err
+	}
+	quotedColumns, sets, values := make([]string, len(columns)), make([]string, len(columns)), make([]string, len(columns))
+	for i, column := range columns {
+		c, err := quoteIdentifier(column)
+		if err != nil {
+			return nil, err
+		}
+		quotedColumns[i] = c
+		sets[i] = fmt.Sprintf("%s = ' || quote(old.%s) || '", sqlString(c), c)
+		values[i] = fmt.Sprintf("' || quote(old.%s) || '", c)
+	}
+	log := func(sql string) string {
+		s := fmt.Sprintf("INSERT INTO _undolog (step, sql) VALUES (_undo_step(), %s);\n", sql)
+		s += "DELETE FROM _undolog WHERE kind = 'redo' AND NOT _undo_replaying();\n"
+		if limit > 0 {
+			s += fmt.Sprintf("DELETE FROM _undolog WHERE step <= _undo_step() - %d;\n", limit)
+		}
+		return s
+	}
+	name := func(op string) string {
+		quoted, _ := quoteIdentifier("_undo_" + table + "_" + op)
+		return quoted
+	}
+	return []string{
+		"DROP TRIGGER IF EXISTS " + name("insert"),
+		"DROP TRIGGER IF EXISTS " + name("update"),
+		"DROP TRIGGER IF EXISTS " + name("delete"),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER INSERT ON %s BEGIN\n%sEND", name("insert"), t,
+			log(fmt.Sprintf("'DELETE FROM %s WHERE rowid = ' || new.rowid", sqlString(t)))),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER UPDATE ON %s BEGIN\n%sEND", name("update"), t,
+			log(fmt.Sprintf("'UPDATE %s SET %s WHERE rowid = ' || old.rowid", sqlString(t), strings.Join(sets, ", ")))),
+		fmt.Sprintf("CREATE TRIGGER %s BEFORE DELETE ON %s BEGIN\n%sEND", name("delete"), t,
+			log(fmt.Sprintf("'INSERT INTO %s (rowid, %s) VALUES (' || old.rowid || ', %s)'", sqlString(t),
+				sqlString(strings.Join(quotedColumns, ", ")), strings.Join(values, ", ")))),
+	}, nil
+}
+
+func sqlString(s string) string { return strings.ReplaceAll(s, "'", "''") }
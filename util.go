@@ -1,18 +1,22 @@
 package gosql
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"math"
 	"os"
-	"path/filepath"
 	"reflect"
-	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 type Connection interface {
@@ -22,112 +26,312 @@ type Connection interface {
 
 type JSON struct{ Value interface{} }
 
-type PureFunc interface{}
+type pureFunc struct{ f interface{} }
+
+// PureFunc marks f as deterministic so SQLite can use it in indexes and factor out repeated calls.
+func PureFunc(f interface{}) interface{} { return pureFunc{f} }
 
 var defaultFuncs = map[string]interface{}{
-	"json_includes":  PureFunc(jsonIncludes),
-	"regexp_extract": PureFunc(regexpExtract),
-	"geo_haversine":  PureFunc(haversine),
-	"geo_offset_lat": PureFunc(offsetLat),
-	"geo_offset_lng": PureFunc(offsetLng),
+	"json_includes":       PureFunc(jsonIncludes),
+	"json_includes_all":   PureFunc(jsonIncludesAll),
+	"json_merge":          PureFunc(jsonMerge),
+	"json_pick":           PureFunc(jsonPick),
+	"json_omit":           PureFunc(jsonOmit),
+	"json_flatten":        PureFunc(jsonFlatten),
+	"json_typeof_deep":    PureFunc(jsonTypeofDeep),
+	"json_is_valid":       PureFunc(jsonIsValid),
+	"regexp_extract":      PureFunc(regexpExtract),
+	"geo_haversine":       PureFunc(haversine),
+	"geo_offset_lat":      PureFunc(offsetLat),
+	"geo_offset_lng":      PureFunc(offsetLng),
+	"geo_bbox":            PureFunc(geoBBox),
+	"geo_contains":        PureFunc(geoContains),
+	"geohash":             PureFunc(geohash),
+	"geohash_decode":      PureFunc(geohashDecode),
+	"url_host":            PureFunc(urlHost),
+	"url_path":            PureFunc(urlPath),
+	"url_query_param":     PureFunc(urlQueryParam),
+	"slugify":             PureFunc(slugify),
+	"md5":                 PureFunc(md5Hex),
+	"sha256":              PureFunc(sha256Hex),
+	"crc32":               PureFunc(crc32Checksum),
+	"uuid4":               uuid4,
+	"ulid":                ulid,
+	"levenshtein":         PureFunc(levenshtein),
+	"damerau_levenshtein": PureFunc(damerauLevenshtein),
+	"trigram_similarity":  PureFunc(trigramSimilarity),
+	"strptime_go":         PureFunc(strptimeGo),
+	"strftime_go":         PureFunc(strftimeGo),
+	"duration_parse":      PureFunc(durationParse),
+	"humanize":            PureFunc(humanize),
+	"time_bucket":         PureFunc(timeBucket),
+	"median":              PureFunc(func() *medianAggregator { return &medianAggregator{} }),
+	"percentile":          PureFunc(func() *percentileAggregator { return &percentileAggregator{} }),
+	"variance":            PureFunc(func() *varianceAggregator { return &varianceAggregator{} }),
+	"stddev":              PureFunc(func() *stddevAggregator { return &stddevAggregator{} }),
 }
 
-var regexpExtractRegexps = map[string]*regexp.Regexp{}
+var regexpExtractRegexps = &regexpCache{max: 256}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+var jsonMapType = reflect.TypeOf(map[string]JSON{})
+
+type PrintOptions struct {
+	Indent     string
+	EscapeHTML bool
+	Debug      io.Writer
+	Output     OutputOptions   // without an output mode rows are printed as JSON objects
+	Encoder    *EncoderOptions // defaults to DB.Encoder
+}
 
 func Print(db *DB, debug bool, query string, args ...interface{}) error {
-	start := time.Now()
+	o := PrintOptions{Indent: "  "}
 	if debug {
-		if err := printQuery(os.Stderr, db, "explain query plan "+query, args...); err != nil {
+		o.Debug = logWriter{db.logger()}
+	}
+	return Fprint(os.Stdout, db, o, query, args...)
+}
+
+// with Debug set, the query plan, index advice, duration and number of rows are written to it as a single JSON object (see Explanation)
+func Fprint(w io.Writer, c Connection, o PrintOptions, query string, args ...interface{}) error {
+	var e *Explanation
+	if o.Debug != nil {
+		db, _ := hookedDB(c)
+		explanation, err := explainPlan(db, c, query, args...)
+		if err != nil {
 			return err
 		}
+		e = explanation
+	}
+	start, n, err := time.Now(), 0, error(nil)
+	if o.Output.Mode != "" {
+		n, err = output(w, c, o.Output, query, args...)
+	} else {
+		n, err = fprintJSON(w, c, o, query, args...)
 	}
-	if err := printQuery(os.Stdout, db, query, args...); err != nil {
+	if err != nil || e == nil {
 		return err
 	}
-	if debug {
-		fmt.Fprintf(os.Stderr, `{"time": %q}`+"\n", time.Since(start))
+	e.Duration, e.Rows = time.Since(start), n
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
 	}
-	return nil
+	_, err = fmt.Fprintf(o.Debug, "%s\n", bs)
+	return err
 }
 
-func printQuery(w io.Writer, db *DB, query string, args ...interface{}) error {
+func fprintJSON(w io.Writer, c Connection, o PrintOptions, query string, args ...interface{}) (n int, err error) {
 	j := json.NewEncoder(w)
-	j.SetIndent("", "  ")
-	j.SetEscapeHTML(false)
-	rows, err := db.Query(query, args...)
-	if err != nil {
-		return err
-	}
-	columns, err := rows.Columns()
-	if err != nil {
-		return err
+	j.SetIndent("", o.Indent)
+	j.SetEscapeHTML(o.EscapeHTML)
+	if db, _ := hookedDB(c); o.Encoder == nil && db != nil {
+		o.Encoder = db.Encoder
 	}
-	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		for i := range values {
-			values[i] = new(interface{})
-		}
-		if err := rows.Scan(values...); err != nil {
+	err = withRows(c, query, args, func(rows *resultRows) error {
+		columns, err := rows.Columns()
+		if err != nil {
 			return err
 		}
-		m := map[string]interface{}{}
-		for i, k := range columns {
-			m[k] = values[i]
-		}
-
-		if err := j.Encode(m); err != nil {
-			return err
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			for i := range values {
+				values[i] = new(interface{})
+			}
+			if err := rows.Scan(values...); err != nil {
+				return err
+			}
+			m := map[string]interface{}{}
+			for i, k := range columns {
+				m[k] = values[i]
+			}
+			if o.Encoder != nil {
+				for i := range values {
+					values[i] = *values[i].(*interface{})
+				}
+				m = o.Encoder.object(rawRow{columns, values})
+			}
+			if err := j.Encode(m); err != nil {
+				return err
+			}
+			n++
 		}
-	}
-	return rows.Err()
+		return nil
+	})
+	return n, err
 }
 
 func Query(c Connection, queryString string, result interface{}, args ...interface{}) error {
 	if err := query(c, queryString, result, args...); err != nil {
-		return fmt.Errorf("%s: %s", queryString, err)
+		return fmt.Errorf("%s: %w", queryString, err)
 	}
 	return nil
 }
 
 func Exec(c Connection, queryString string, args ...interface{}) (sql.Result, error) {
+	args, err := convertArgs(args)
+	if err != nil {
+		return nil, err
+	}
 	result, err := c.Exec(queryString, args...)
 	if err != nil {
-		err = fmt.Errorf("%s: %s", queryString, err)
+		err = fmt.Errorf("%s: %w", queryString, err)
 	}
 	return result, err
 }
 
+// JSON can't implement driver.Valuer as that would clash with its Value field - args are converted here (and by pooledConn.CheckNamedValue) instead
+func convertArgs(args []interface{}) ([]interface{}, error) {
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		v, err := convertArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		converted[i] = v
+	}
+	return converted, nil
+}
+
+// slices, maps and structs are bound as JSON text - just like Insert stores them. Byte slices (e.g. net.IP) are blobs.
+// json_includes_all(tags, ?) checks all elements of a bound slice
+func convertArg(arg interface{}) (interface{}, error) {
+	switch x := arg.(type) {
+	case JSON:
+		return x.driverValue()
+	case sql.NamedArg:
+		v, err := convertArg(x.Value)
+		x.Value = v
+		return x, err
+	case nil, []byte, time.Time, driver.Valuer:
+		return arg, nil
+	}
+	v := reflect.ValueOf(arg)
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Bytes(), nil
+		} else if v.IsNil() {
+			return nil, nil
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+	case reflect.Array, reflect.Struct:
+	default:
+		return arg, nil
+	}
+	bs, err := json.Marshal(arg)
+	if err != nil {
+		return nil, err
+	}
+	return string(bs), nil
+}
+
 func Insert(c Connection, table string, v interface{}, or string) (sql.Result, error) {
-	rv, ks, qs, vs := reflect.ValueOf(v), []string{}, []string{}, []interface{}{}
+	d, rv, ks, qs, vs := dialectOf(c), reflect.ValueOf(v), []string{}, []string{}, []interface{}{}
+	if rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Struct {
+		rv = rv.Elem()
+	}
+	var hooks interface{}
 	add := func(k string, v interface{}) {
 		ks = append(ks, k)
-		qs = append(qs, "?")
+		qs = append(qs, d.Placeholder(len(qs)))
 		vs = append(vs, v)
 	}
 	switch rv.Kind() {
 	case reflect.Map:
-		m := rv.MapRange()
-		for m.Next() {
-			switch v := m.Value().Elem(); v.Kind() {
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			switch v := rv.MapIndex(k).Elem(); v.Kind() {
 			case reflect.Map, reflect.Struct, reflect.Slice:
 				bs, err := json.Marshal(v.Interface())
 				if err != nil {
 					return nil, err
 				}
-				add(m.Key().String(), string(bs))
+				add(k.String(), string(bs))
+			case reflect.Invalid:
+				add(k.String(), nil)
 			default:
-				add(m.Key().String(), v.Interface())
+				add(k.String(), v.Interface())
 			}
 		}
 	case reflect.Struct:
+		if !rv.CanAddr() {
+			ptr := reflect.New(rv.Type())
+			ptr.Elem().Set(rv)
+			rv = ptr.Elem()
+		}
+		hooks = rv.Addr().Interface()
+		if h, ok := hooks.(BeforeInserter); ok {
+			if err := h.BeforeInsert(); err != nil {
+				return nil, err
+			}
+		}
+		mapper := columnMapperOf(c)
+		if err := validate(hooks, mapper); err != nil {
+			return nil, err
+		}
 		for i, rt := 0, rv.Type(); i < rv.NumField(); i++ {
-			add(rt.Field(i).Name, rv.Field(i).Interface())
+			name, options := mappedTag(mapper, rt.Field(i))
+			if _, omitEmpty := options["omitempty"]; name == "-" || (omitEmpty && rv.Field(i).IsZero()) {
+				continue
+			} else if _, ok := options["encrypted"]; ok {
+				ad, err := encryptionContext(table, rv, rt.Field(i), mapper)
+				if err != nil {
+					return nil, err
+				}
+				v, err := encryptField(columnCipherOf(c), ad, rt.Field(i), rv.Field(i))
+				if err != nil {
+					return nil, err
+				}
+				add(name, v)
+				continue
+			}
+			add(name, rv.Field(i).Interface())
 		}
 	default:
 		return nil, fmt.Errorf("unhandled type %T", v)
 	}
+	table, err := quoteDialectTableName(d, table)
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range ks {
+		if ks[i], err = d.QuoteIdentifier(k); err != nil {
+			return nil, err
+		}
+	}
 	query := fmt.Sprintf("INSERT %s INTO %s (%s) VALUES (%s)", or, table, strings.Join(ks, ", "), strings.Join(qs, ", "))
-	return c.Exec(query, vs...)
+	result, err := c.Exec(query, vs...)
+	if h, ok := hooks.(AfterInserter); ok && err == nil {
+		err = h.AfterInsert(result)
+	}
+	return result, err
+}
+
+func quoteIdentifier(s string) (string, error) {
+	if s == "" || strings.ContainsRune(s, 0) || !utf8.ValidString(s) {
+		return "", fmt.Errorf("invalid identifier %q", s)
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`, nil
+}
+
+func quoteTableName(s string) (string, error) {
+	parts := strings.SplitN(s, ".", 2)
+	for i, part := range parts {
+		quoted, err := quoteIdentifier(part)
+		if err != nil {
+			return "", fmt.Errorf("invalid table name %q", s)
+		}
+		parts[i] = quoted
+	}
+	return strings.Join(parts, "."), nil
 }
 
 func query(c Connection, query string, result interface{}, args ...interface{}) error {
@@ -135,99 +339,627 @@ func query(c Connection, query string, result interface{}, args ...interface{})
 	if xs.Kind() != reflect.Ptr || xs.Type().Elem().Kind() != reflect.Slice {
 		return fmt.Errorf("cannot unmarshal query results into %t (%v)", result, result)
 	}
+	return withRows(c, query, args, func(rows *resultRows) error {
+		return unmarshal(rows, xs.Elem())
+	})
+}
+
+// QueryScalars scans the single result row of query into dsts - positionally, one pointer per column, or into a single
+// struct / map. Returns ErrNoRows for empty results and fails for more than one row.
+//
+//	QueryScalars(c, "SELECT count(*), max(ts) FROM events", nil, &count, &max)
+func QueryScalars(c Connection, queryString string, args []interface{}, dsts ...interface{}) error {
+	if err := queryScalars(c, queryString, args, dsts...); err != nil {
+		return fmt.Errorf("%s: %w", queryString, err)
+	}
+	return nil
+}
+
+// isCompositeDst reports whether a single row is decoded into t column by column - sql.Scanner implementations
+// (e.g. sql.NullInt64) and time.Time are scalars
+func isCompositeDst(t reflect.Type) bool {
+	if reflect.PtrTo(t).Implements(scannerType) || t == reflect.TypeOf(time.Time{}) {
+		return false
+	}
+	return t.Kind() == reflect.Struct || t.Kind() == reflect.Map
+}
+
+func queryScalars(c Connection, query string, args []interface{}, dsts ...interface{}) error {
+	if len(dsts) == 0 {
+		return errors.New("no values to scan into")
+	}
+	for _, dst := range dsts {
+		if v := reflect.ValueOf(dst); v.Kind() != reflect.Ptr || v.IsNil() {
+			return fmt.Errorf("cannot scan into %T: expected pointer", dst)
+		}
+	}
+	return withRows(c, query, args, func(rows *resultRows) error {
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		if !rows.Next() {
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			return ErrNoRows
+		}
+		if t := reflect.TypeOf(dsts[0]).Elem(); len(dsts) == 1 && isCompositeDst(t) {
+			decode, err := decoder(rows, t)
+			if err != nil {
+				return err
+			}
+			x, err := decode()
+			if err != nil {
+				return err
+			}
+			reflect.ValueOf(dsts[0]).Elem().Set(x)
+		} else if len(dsts) != len(columns) {
+			return fmt.Errorf("cannot scan %d columns into %d values", len(columns), len(dsts))
+		} else if err := scan(rows, dsts); err != nil {
+			return err
+		}
+		if rows.Next() {
+			return errors.New("expected a single row")
+		}
+		return rows.Err()
+	})
+}
+
+func QueryMap(c Connection, queryString string, result interface{}, args ...interface{}) error {
+	if err := queryMap(c, queryString, result, args...); err != nil {
+		return fmt.Errorf("%s: %w", queryString, err)
+	}
+	return nil
+}
+
+func queryMap(c Connection, query string, result interface{}, args ...interface{}) error {
+	m := reflect.ValueOf(result)
+	if m.Kind() != reflect.Ptr || m.Type().Elem().Kind() != reflect.Map {
+		return fmt.Errorf("cannot unmarshal query results into %T", result)
+	}
+	m, t := m.Elem(), m.Type().Elem()
+	return withRows(c, query, args, func(rows *resultRows) error {
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		} else if len(columns) != 2 {
+			return fmt.Errorf("expected 2 columns (key, value), got %d", len(columns))
+		}
+		if m.IsNil() {
+			m.Set(reflect.MakeMap(t))
+		}
+		for rows.Next() {
+			k, v := reflect.New(t.Key()), reflect.New(t.Elem())
+			if err := scan(rows, []interface{}{k.Interface(), v.Interface()}); err != nil {
+				return err
+			}
+			m.SetMapIndex(k.Elem(), v.Elem())
+		}
+		return nil
+	})
+}
+
+func QueryGrouped(c Connection, queryString, keyColumn string, result interface{}, args ...interface{}) error {
+	if err := queryGrouped(c, queryString, keyColumn, result, args...); err != nil {
+		return fmt.Errorf("%s: %w", queryString, err)
+	}
+	return nil
+}
+
+func queryGrouped(c Connection, query, keyColumn string, result interface{}, args ...interface{}) error {
+	m := reflect.ValueOf(result)
+	if m.Kind() != reflect.Ptr || m.Type().Elem().Kind() != reflect.Map || m.Type().Elem().Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("cannot group query results into %T", result)
+	}
+	m, t := m.Elem(), m.Type().Elem()
+	return withRows(c, query, args, func(rows *resultRows) error {
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		keyIndex := indexOf(columns, keyColumn)
+		if keyIndex == -1 {
+			return fmt.Errorf("key column %q not in result", keyColumn)
+		}
+		decode, err := decoder(rows, t.Elem().Elem())
+		if err != nil {
+			return err
+		}
+		if m.IsNil() {
+			m.Set(reflect.MakeMap(t))
+		}
+		for rows.Next() {
+			k, values := reflect.New(t.Key()), make([]interface{}, len(columns))
+			for i := range values {
+				values[i] = new(interface{})
+			}
+			values[keyIndex] = k.Interface()
+			if err := scan(rows, values); err != nil {
+				return err
+			}
+			x, err := decode()
+			if err != nil {
+				return err
+			}
+			xs := m.MapIndex(k.Elem())
+			if !xs.IsValid() {
+				xs = reflect.MakeSlice(t.Elem(), 0, 1)
+			}
+			m.SetMapIndex(k.Elem(), reflect.Append(xs, x))
+		}
+		return nil
+	})
+}
+
+func Each(c Connection, queryString string, f interface{}, args ...interface{}) error {
+	if err := each(c, queryString, f, args...); err != nil {
+		return fmt.Errorf("%s: %w", queryString, err)
+	}
+	return nil
+}
+
+func each(c Connection, query string, f interface{}, args ...interface{}) error {
+	fv := reflect.ValueOf(f)
+	if t := fv.Type(); fv.Kind() != reflect.Func || t.NumIn() != 1 || t.NumOut() != 1 || t.Out(0) != errorType {
+		return fmt.Errorf("cannot call %T for each row: expected func(T) error", f)
+	}
+	return withRows(c, query, args, func(rows *resultRows) error {
+		decode, err := decoder(rows, fv.Type().In(0))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			x, err := decode()
+			if err != nil {
+				return err
+			}
+			if err, _ := fv.Call([]reflect.Value{x})[0].Interface().(error); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// QueryChan decodes the rows of query into ch (a chan T) from a new goroutine and closes it afterwards - so rows can be
+// processed concurrently while scanning continues. The returned channel receives the error (if any) once ch is closed.
+// Canceling ctx stops the query.
+func QueryChan(ctx context.Context, c Connection, queryString string, ch interface{}, args ...interface{}) <-chan error {
+	errs, chv := make(chan error, 1), reflect.ValueOf(ch)
+	if chv.Kind() != reflect.Chan || chv.Type().ChanDir()&reflect.SendDir == 0 {
+		errs <- fmt.Errorf("cannot send rows to %T: expected chan T", ch)
+		close(errs)
+		return errs
+	}
+	if pc, ok := c.(pinnedConn); ok {
+		pc.ctx, c = ctx, pc
+	} else if cc, ok := c.(ctxConn); ok {
+		c = ctxConn{ctx, cc.contextConn}
+	} else if cc, ok := c.(contextConn); ok {
+		c = ctxConn{ctx, cc}
+	}
+	go func() {
+		defer close(errs)
+		defer chv.Close()
+		if err := queryChan(ctx, c, queryString, chv, args...); err != nil {
+			errs <- fmt.Errorf("%s: %w", queryString, err)
+		}
+	}()
+	return errs
+}
+
+func queryChan(ctx context.Context, c Connection, query string, ch reflect.Value, args ...interface{}) error {
+	return withRows(c, query, args, func(rows *resultRows) error {
+		decode, err := decoder(rows, ch.Type().Elem())
+		if err != nil {
+			return err
+		}
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+			{Dir: reflect.SelectSend, Chan: ch},
+		}
+		for rows.Next() {
+			x, err := decode()
+			if err != nil {
+				return err
+			}
+			cases[1].Send = x
+			if i, _, _ := reflect.Select(cases); i == 0 {
+				return ctx.Err()
+			}
+		}
+		return rows.Err()
+	})
+}
+
+// Preload binds the keys of parents to the first IN (?) of the query - args are bound to the other placeholders in order.
+func Preload(c Connection, parents interface{}, field, queryString string, args ...interface{}) error {
+	if err := preload(c, parents, field, queryString, args...); err != nil {
+		return fmt.Errorf("%s: %w", queryString, err)
+	}
+	return nil
+}
+
+func preload(c Connection, parents interface{}, field, query string, args ...interface{}) error {
+	xs := reflect.Indirect(reflect.ValueOf(parents))
+	if xs.Kind() != reflect.Slice {
+		return fmt.Errorf("cannot preload into %T", parents)
+	}
+	t := xs.Type().Elem()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	f, ok := t.FieldByName(field)
+	if t.Kind() != reflect.Struct || !ok || f.Type.Kind() != reflect.Slice {
+		return fmt.Errorf("%s has no slice field %s", t, field)
+	}
+	kv := strings.SplitN(f.Tag.Get("preload"), "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf(`field %s needs a preload:"child_column=parent_column" tag`, field)
+	}
+	childColumn, parentColumn := kv[0], kv[1]
+	if xs.Len() == 0 {
+		return nil
+	}
+	mapper := columnMapperOf(c)
+	parentKey := func(i int) reflect.Value { return fieldByColumn(reflect.Indirect(xs.Index(i)), parentColumn, mapper) }
+	if !parentKey(0).IsValid() {
+		return fmt.Errorf("%s has no field for column %s", t, parentColumn)
+	}
+	keys, placeholders, seen := []interface{}{}, []string{}, map[interface{}]bool{}
+	for i := 0; i < xs.Len(); i++ {
+		if k := parentKey(i).Interface(); !seen[k] {
+			seen[k] = true
+			keys, placeholders = append(keys, k), append(placeholders, "?")
+		}
+	}
+	// the keys replace the first (?) - args of the placeholders before it are bound before them
+	tokens, before, found, b := tokenize(query), 0, false, strings.Builder{}
+	for i := 0; i < len(tokens); i++ {
+		if !found && i+2 < len(tokens) && tokens[i].text == "(" && tokens[i+1].text == "?" && tokens[i+2].text == ")" {
+			b.WriteString("(" + strings.Join(placeholders, ", ") + ")")
+			found, i = true, i+2
+			continue
+		} else if !found && tokens[i].text == "?" {
+			before++
+		}
+		b.WriteString(tokens[i].text)
+	}
+	if !found {
+		return errors.New("query needs an IN (?) placeholder for the preload keys")
+	} else if before > len(args) {
+		return fmt.Errorf("query has %d placeholders before IN (?) but only %d args", before, len(args))
+	}
+	args = append(append(append([]interface{}{}, args[:before]...), keys...), args[before:]...)
+	m := reflect.New(reflect.MapOf(parentKey(0).Type(), f.Type))
+	if err := queryGrouped(c, b.String(), childColumn, m.Interface(), args...); err != nil {
+		return err
+	}
+	for i := 0; i < xs.Len(); i++ {
+		if children := m.Elem().MapIndex(parentKey(i)); children.IsValid() {
+			reflect.Indirect(xs.Index(i)).FieldByIndex(f.Index).Set(children)
+		}
+	}
+	return nil
+}
+
+func withRows(c Connection, query string, args []interface{}, f func(*resultRows) error) (err error) {
+	db, ctx := hookedDB(c)
+	pinned, isPinned := pinnedOf(c)
+	if db != nil && !isPinned {
+		release, err := db.acquire(ctx, isReadOnlyConn(c))
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	r := &resultRows{}
+	if db != nil {
+		historyConn := Connection(db.DB)
+		if isPinned {
+			historyConn = pinned.historyConn()
+		}
+		defer db.recordHistory(historyConn, query, args, time.Now(), &err)
+		defer db.observeQuery(query, args, time.Now(), &r.count, &err)
+		_, span := db.startSpan(ctx, "query", query)
+		defer func() { span.End(r.count, err) }()
+	}
+	args, err = convertArgs(args)
+	if err != nil {
+		return err
+	}
 	rows, err := c.Query(query, args...)
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
-	if err := unmarshal(rows, xs.Elem()); err != nil {
+	r.Rows = rows
+	if db != nil {
+		r.strict, r.cipher, r.mapper = db.StrictScan, db.columnCipher, db.ColumnMapper
+		if db.WarnCoercions {
+			r.warn = func(message string) { db.logger().Printf("WARNING: %s: %s", query, message) }
+		}
+	}
+	if err := f(r); err != nil {
 		return err
 	}
 	return rows.Err()
 }
 
-func unmarshal(rows *sql.Rows, xs reflect.Value) error {
-	t, isPtr := xs.Type().Elem(), false
-	switch t.Kind() {
-	case reflect.Ptr:
+// ctxConn{ctx, db} runs queries with ctx but keeps the hooks (history, metrics, tracing, limits) of db
+func hookedDB(c Connection) (*DB, context.Context) {
+	conn, ctx := interface{}(c), context.Background()
+	if pc, ok := pinnedOf(c); ok {
+		return pc.db, pc.ctx
+	} else if c, ok := c.(ctxConn); ok {
+		conn, ctx = c.contextConn, c.ctx
+	}
+	switch c := conn.(type) {
+	case *DB:
+		return c, ctx
+	case roConn:
+		return c.DB, ctx
+	}
+	return nil, ctx
+}
+
+func unmarshal(rows *resultRows, xs reflect.Value) error {
+	decode, err := decoder(rows, xs.Type().Elem())
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		x, err := decode()
+		if err != nil {
+			return err
+		}
+		xs.Set(reflect.Append(xs, x))
+	}
+	return nil
+}
+
+func decoder(rows *resultRows, t reflect.Type) (func() (reflect.Value, error), error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if t.Kind() == reflect.Ptr && t.Implements(rowMapperType) {
+		return rowMapperDecoder(rows, columns, t.Elem(), true), nil
+	} else if reflect.PtrTo(t).Implements(rowMapperType) {
+		return rowMapperDecoder(rows, columns, t, false), nil
+	}
+	isPtr := false
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
 		t, isPtr = t.Elem(), true
-		fallthrough
+	}
+	switch t.Kind() {
 	case reflect.Struct:
-		return unmarshalStruct(rows, xs, t, isPtr)
+		if rows.strict {
+			if err := checkStructColumns(columns, t, rows.mapper); err != nil {
+				return nil, err
+			}
+		}
+		return structDecoder(rows, columns, t, isPtr), nil
 	case reflect.Interface:
-		t = reflect.TypeOf(map[string]interface{}{})
-		fallthrough
+		return mapDecoder(rows, columns, reflect.TypeOf(map[string]interface{}{}))
 	case reflect.Map:
-		return unmarshalMap(rows, xs, t, isPtr)
+		if t == jsonMapType {
+			return jsonMapDecoder(rows, columns), nil
+		}
+		return mapDecoder(rows, columns, t)
 	default:
-		for rows.Next() {
+		return func() (reflect.Value, error) {
 			x := reflect.New(t)
-			if err := scan(rows, []interface{}{x.Interface()}); err != nil {
-				return err
-			}
-			if !isPtr {
-				x = x.Elem()
-			}
-			xs.Set(reflect.Append(xs, x))
-		}
+			err := scan(rows, []interface{}{x.Interface()})
+			return x.Elem(), err
+		}, nil
 	}
-	return nil
 }
 
-func unmarshalStruct(rows *sql.Rows, xs reflect.Value, t reflect.Type, isPtr bool) error {
-	columns, err := rows.Columns()
-	if err != nil {
-		return err
+func structDecoder(rows *resultRows, columns []string, t reflect.Type, isPtr bool) func() (reflect.Value, error) {
+	plan, afterScan := structPlan(t, columns, rows.mapper), reflect.PtrTo(t).Implements(afterScannerType)
+	encrypted, raw := make([]bool, len(plan)), make([]bool, len(plan))
+	for i, field := range plan {
+		if field != -1 {
+			encrypted[i] = isEncrypted(t.Field(field))
+			raw[i] = isRawType(t.Field(field).Type)
+		}
 	}
-	for rows.Next() {
+	return func() (reflect.Value, error) {
 		x := reflect.New(t).Elem()
-		values := []interface{}{}
-		for _, column := range columns {
-			field := x.FieldByName(column) // TODO: use tags / case conversion for struct -> sql field mapping
-			if field.IsValid() {
-				values = append(values, field.Addr().Interface())
+		values := make([]interface{}, len(columns))
+		for i, field := range plan {
+			if field != -1 && encrypted[i] {
+				values[i] = new([]byte)
+			} else if field != -1 && raw[i] {
+				values[i] = &rawValue{}
+			} else if field != -1 {
+				values[i] = x.Field(field).Addr().Interface()
 			} else {
-				values = append(values, new(interface{}))
+				values[i] = new(interface{})
 			}
 		}
-		if err = scan(rows, values); err != nil {
-			return err
+		if err := scan(rows, values); err != nil {
+			return reflect.Value{}, err
+		}
+		for i, field := range plan {
+			if encrypted[i] {
+				ad, err := encryptionContext(rows.table, x, t.Field(field), rows.mapper)
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				if err := decryptField(rows.cipher, ad, x.Field(field), *values[i].(*[]byte)); err != nil {
+					return reflect.Value{}, fmt.Errorf("%s: %w", columns[i], err)
+				}
+			} else if raw[i] {
+				if err := values[i].(*rawValue).assign(x.Field(field)); err != nil {
+					return reflect.Value{}, fmt.Errorf("%s: %w", columns[i], err)
+				}
+			}
+		}
+		if afterScan {
+			if err := x.Addr().Interface().(AfterScanner).AfterScan(); err != nil {
+				return reflect.Value{}, err
+			}
 		}
 		if isPtr {
 			x = x.Addr()
 		}
-		xs.Set(reflect.Append(xs, x))
+		return x, nil
+	}
+}
+
+func checkStructColumns(columns []string, t reflect.Type, mapper func(string) string) error {
+	x := reflect.New(t).Elem()
+	for _, column := range columns {
+		if !fieldByColumn(x, column, mapper).IsValid() {
+			return fmt.Errorf("column %s has no matching field in %s", column, t)
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := t.Field(i).Tag.Lookup("db"); (!ok && mapper == nil) || t.Field(i).PkgPath != "" {
+			continue
+		} else if name, _ := mappedTag(mapper, t.Field(i)); name != "-" && indexOf(columns, name) == -1 {
+			return fmt.Errorf("field %s.%s (%s) is missing from result columns", t, t.Field(i).Name, name)
+		}
 	}
 	return nil
 }
 
-func unmarshalMap(rows *sql.Rows, xs reflect.Value, t reflect.Type, isPtr bool) error {
-	columns, err := rows.Columns()
-	if err != nil {
-		return err
+func fieldByColumn(x reflect.Value, column string, mapper func(string) string) reflect.Value {
+	if i := fieldIndex(x.Type(), column, mapper); i != -1 {
+		return x.Field(i)
 	}
-	mt := reflect.MapOf(reflect.TypeOf(""), t.Elem())
-	values := []interface{}{}
-	for range columns {
-		values = append(values, reflect.New(t.Elem()).Interface())
+	return reflect.Value{}
+}
+
+func fieldIndex(t reflect.Type, column string, mapper func(string) string) int {
+	for i := 0; i < t.NumField(); i++ {
+		if name, _ := mappedTag(mapper, t.Field(i)); name == column {
+			return i
+		}
 	}
-	for rows.Next() {
-		if err = scan(rows, values); err != nil {
-			return err
+	return -1
+}
+
+type structPlanKey struct {
+	t       reflect.Type
+	columns string
+}
+
+var structPlans sync.Map
+
+// structPlan returns the index of the field of t for each column (-1 for columns without field); cached per type and column set.
+// Plans with a column mapper are not cached - funcs can't be part of the key
+func structPlan(t reflect.Type, columns []string, mapper func(string) string) []int {
+	key := structPlanKey{t, strings.Join(columns, "\x00")}
+	if plan, ok := structPlans.Load(key); ok && mapper == nil {
+		return plan.([]int)
+	}
+	plan := make([]int, len(columns))
+	for i, column := range columns {
+		plan[i] = fieldIndex(t, column, mapper)
+	}
+	if mapper == nil {
+		structPlans.Store(key, plan)
+	}
+	return plan
+}
+
+func parseTag(f reflect.StructField) (string, map[string]string) {
+	parts, options := strings.Split(f.Tag.Get("db"), ","), map[string]string{}
+	for _, option := range parts[1:] {
+		kv := strings.SplitN(option, "=", 2)
+		options[kv[0]] = kv[len(kv)-1]
+	}
+	if parts[0] == "" {
+		return f.Name, options
+	}
+	return parts[0], options
+}
+
+// mappedTag is parseTag with the names of fields without db tag name mapped by DB.ColumnMapper
+func mappedTag(mapper func(string) string, f reflect.StructField) (string, map[string]string) {
+	name, options := parseTag(f)
+	if mapper != nil && strings.SplitN(f.Tag.Get("db"), ",", 2)[0] == "" {
+		name = mapper(name)
+	}
+	return name, options
+}
+
+func columnMapperOf(c Connection) func(string) string {
+	if db, _ := hookedDB(c); db != nil {
+		return db.ColumnMapper
+	}
+	return nil
+}
+
+func mapDecoder(rows *resultRows, columns []string, t reflect.Type) (func() (reflect.Value, error), error) {
+	if t.Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("cannot unmarshal rows into %s: column keys must be strings", t)
+	}
+	return func() (reflect.Value, error) {
+		values := []interface{}{}
+		for range columns {
+			values = append(values, reflect.New(t.Elem()).Interface())
+		}
+		if err := scan(rows, values); err != nil {
+			return reflect.Value{}, err
 		}
-		x := reflect.MakeMapWithSize(mt, len(columns))
+		x := reflect.MakeMapWithSize(t, len(columns))
 		for i, column := range columns {
-			x.SetMapIndex(reflect.ValueOf(column), reflect.ValueOf(values[i]).Elem())
+			x.SetMapIndex(reflect.ValueOf(column).Convert(t.Key()), reflect.ValueOf(values[i]).Elem())
 		}
-		if isPtr {
-			x = x.Addr()
+		return x, nil
+	}, nil
+}
+
+// the handler hot path: same values as mapDecoder + convert without the reflection and the json round trip per cell
+func jsonMapDecoder(rows *resultRows, columns []string) func() (reflect.Value, error) {
+	return func() (reflect.Value, error) {
+		tmp := make([]interface{}, len(columns))
+		for i := range tmp {
+			tmp[i] = new(interface{})
 		}
-		xs.Set(reflect.Append(xs, x))
+		if err := rows.Scan(tmp...); err != nil {
+			return reflect.Value{}, err
+		}
+		m := make(map[string]JSON, len(columns))
+		for i, column := range columns {
+			j, v := JSON{}, *tmp[i].(*interface{})
+			if rows.warn != nil {
+				rows.checkCoercion(i, v, &j)
+			}
+			if x, ok := jsonValue(v); ok {
+				j.Value = x
+			} else if err := convert(tmp[i], &j); err != nil {
+				return reflect.Value{}, err
+			}
+			m[column] = j
+		}
+		return reflect.ValueOf(m), nil
 	}
-	return nil
 }
 
-func scan(rows *sql.Rows, values []interface{}) error {
+// jsonValue returns what json.Unmarshal(json.Marshal(v)) would for the values returned by the driver - if that's cheap to do
+func jsonValue(v interface{}) (interface{}, bool) {
+	switch v := v.(type) {
+	case nil, bool:
+		return v, true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+	case string:
+		return v, utf8.ValidString(v)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), true
+	}
+	return nil, false
+}
+
+func scan(rows *resultRows, values []interface{}) error {
 	tmp := make([]interface{}, len(values))
 	for i := range values {
 		tmp[i] = new(interface{})
@@ -236,6 +968,18 @@ func scan(rows *sql.Rows, values []interface{}) error {
 		return err
 	}
 	for i := range values {
+		if rows.warn != nil {
+			rows.checkCoercion(i, *tmp[i].(*interface{}), values[i])
+		}
+		if r, ok := values[i].(*rawValue); ok {
+			r.v = *tmp[i].(*interface{})
+			continue
+		} else if s, ok := values[i].(sql.Scanner); ok {
+			if err := s.Scan(*tmp[i].(*interface{})); err != nil {
+				return err
+			}
+			continue
+		}
 		if err := convert(tmp[i], values[i]); err != nil {
 			return err
 		}
@@ -244,6 +988,37 @@ func scan(rows *sql.Rows, values []interface{}) error {
 	return nil
 }
 
+// rawValue receives the driver value of a column as is - for json.RawMessage and interface{} fields
+type rawValue struct{ v interface{} }
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+func isRawType(t reflect.Type) bool {
+	return t == rawMessageType || (t.Kind() == reflect.Interface && t.NumMethod() == 0)
+}
+
+func (r *rawValue) assign(v reflect.Value) error {
+	if r.v == nil {
+		return nil
+	} else if v.Type() != rawMessageType {
+		v.Set(reflect.ValueOf(r.v))
+		return nil
+	}
+	switch x := r.v.(type) {
+	case []byte:
+		v.SetBytes(x)
+	case string:
+		v.SetBytes([]byte(x))
+	default:
+		bs, err := json.Marshal(x)
+		if err != nil {
+			return err
+		}
+		v.SetBytes(bs)
+	}
+	return nil
+}
+
 func convert(src, dst interface{}) error {
 	bs, err := json.Marshal(src)
 	if err != nil {
@@ -274,28 +1049,78 @@ func (j *JSON) UnmarshalJSON(b []byte) error {
 	return json.Unmarshal(b, &j.Value)
 }
 
-func isJSONObjectString(s string) bool {
-	return len(s) >= 2 && s[0] == '{' && s[len(s)-1] == '}'
+func (j JSON) driverValue() (driver.Value, error) {
+	if j.Value == nil {
+		return nil, nil
+	}
+	bs, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+	return string(bs), nil
 }
 
-func isJSONArrayString(s string) bool {
-	return len(s) >= 2 && s[0] == '[' && s[len(s)-1] == ']'
+func (j *JSON) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return j.Scan(string(src))
+	case string:
+		if err := json.Unmarshal([]byte(src), &j.Value); err != nil {
+			j.Value = src
+		}
+	default:
+		j.Value = src
+	}
+	return nil
 }
 
-func ReadMigrations(directory string) (map[string]string, error) {
-	m := map[string]string{}
-	sqlFiles, err := filepath.Glob(filepath.Join(directory, "*.sql"))
-	if err != nil {
-		return nil, err
+func indexOf(xs []string, x string) int {
+	for i := range xs {
+		if xs[i] == x {
+			return i
+		}
 	}
-	for _, sqlFile := range sqlFiles {
-		bs, err := ioutil.ReadFile(sqlFile)
-		if err != nil {
-			return nil, err
+	return -1
+}
+
+func isReadQuery(query string) bool {
+	switch statementVerb(query) {
+	case "SELECT", "EXPLAIN", "VALUES":
+		return true
+	case "PRAGMA":
+		return !strings.Contains(query, "=")
+	}
+	return false
+}
+
+// statementVerb returns the first keyword of query - or, for WITH, the keyword of the statement following the
+// common table expressions (WITH ... INSERT is a write)
+func statementVerb(query string) string {
+	tokens, depth := significantTokens(query), 0
+	if len(tokens) == 0 {
+		return ""
+	} else if verb := strings.ToUpper(tokens[0].text); verb != "WITH" {
+		return verb
+	}
+	for _, t := range tokens[1:] {
+		switch text := strings.ToUpper(t.text); {
+		case text == "(":
+			depth++
+		case text == ")":
+			depth--
+		case depth == 0 && (text == "SELECT" || text == "VALUES" || text == "INSERT" || text == "REPLACE" || text == "UPDATE" || text == "DELETE"):
+			return text
 		}
-		m[sqlFile] = string(bs)
 	}
-	return m, nil
+	return "WITH"
+}
+
+func isJSONObjectString(s string) bool {
+	return len(s) >= 2 && s[0] == '{' && s[len(s)-1] == '}'
+}
+
+func isJSONArrayString(s string) bool {
+	return len(s) >= 2 && s[0] == '[' && s[len(s)-1] == ']'
 }
 
 func jsonIncludes(s string, vs ...interface{}) (bool, error) {
@@ -312,14 +1137,18 @@ func jsonIncludes(s string, vs ...interface{}) (bool, error) {
 	return len(m) == 0, nil
 }
 
+func jsonIncludesAll(s, array string) (bool, error) {
+	vs := []interface{}{}
+	if err := json.Unmarshal([]byte(array), &vs); err != nil {
+		return false, err
+	}
+	return jsonIncludes(s, vs...)
+}
+
 func regexpExtract(input, regexpString string, i int) (string, error) {
-	r, err := regexpExtractRegexps[regexpString], error(nil)
-	if r == nil {
-		r, err = regexp.Compile(regexpString)
-		if err != nil {
-			return "", err
-		}
-		regexpExtractRegexps[regexpString] = r
+	r, err := regexpExtractRegexps.get(regexpString)
+	if err != nil {
+		return "", err
 	}
 	if m := r.FindStringSubmatch(input); len(m) > i {
 		return m[i], nil
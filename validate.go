@@ -0,0 +1,93 @@
+package gosql
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator is implemented by values that check themselves before Insert, Update and Upsert
+type Validator interface {
+	Validate() error
+}
+
+type FieldError struct {
+	Field   string
+	Column  string
+	Message string
+}
+
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, len(e))
+	for i, fe := range e {
+		messages[i] = fe.Column + ": " + fe.Message
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// validate checks the db tag constraints notnull (no nil pointers, maps, slices or interfaces),
+// required (no zero values) and maxlen=N (max runes of strings) of struct fields and calls Validate if implemented
+func validate(v interface{}, mapper func(string) string) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	errs := ValidationError{}
+	for _, f := range columnFields(rv.Type(), mapper) {
+		column, options := mappedTag(mapper, f)
+		fv := rv.FieldByIndex(f.Index)
+		fail := func(format string, args ...interface{}) {
+			errs = append(errs, FieldError{f.Name, column, fmt.Sprintf(format, args...)})
+		}
+		if _, ok := options["notnull"]; ok && isNil(fv) {
+			fail("must not be null")
+		} else if _, ok := options["required"]; ok && fv.IsZero() {
+			fail("is required")
+		}
+		if s, ok := options["maxlen"]; ok {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("%s: invalid maxlen %q", f.Name, s)
+			}
+			if fv = reflect.Indirect(fv); fv.Kind() == reflect.String && utf8.RuneCountInString(fv.String()) > n {
+				fail("must be at most %d characters", n)
+			}
+		}
+	}
+	if err := callValidate(v, rv); err != nil {
+		if fieldErrs := (ValidationError{}); errors.As(err, &fieldErrs) {
+			errs = append(errs, fieldErrs...)
+		} else {
+			return err
+		}
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+func callValidate(v interface{}, rv reflect.Value) error {
+	if validator, ok := v.(Validator); ok {
+		return validator.Validate()
+	}
+	ptr := reflect.New(rv.Type())
+	ptr.Elem().Set(rv)
+	if validator, ok := ptr.Interface().(Validator); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,260 @@
+//go:build sqlite_vtable
+// +build sqlite_vtable
+
+package gosql
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+type csvModule struct{}
+
+type csvVTab struct {
+	path    string
+	columns int
+}
+
+type csvCursor struct {
+	path   string
+	f      *os.File
+	r      *csv.Reader
+	record []string
+	rowid  int64
+	eof    bool
+}
+
+type tableFuncModule struct{ TableFunc }
+
+type tableFuncVTab struct{ TableFunc }
+
+type tableFuncCursor struct {
+	f     TableFunc
+	next  func() ([]interface{}, error)
+	row   []interface{}
+	rowid int64
+}
+
+func (db *DB) registerModules(c driverConn) error {
+	sc, ok := sqliteConn(c)
+	if !ok {
+		return nil
+	}
+	if err := sc.CreateModule("csv", csvModule{}); err != nil {
+		return err
+	}
+	for name, f := range db.TableFuncs {
+		if err := sc.CreateModule(name, tableFuncModule{f}); err != nil {
+			return fmt.Errorf("table func %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (m csvModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Connect(c, args)
+}
+
+func (csvModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	if len(args) != 4 {
+		return nil, fmt.Errorf("usage: CREATE VIRTUAL TABLE name USING csv('path')")
+	}
+	path := strings.TrimSpace(args[3])
+	if len(path) >= 2 && (path[0] == '\'' || path[0] == '"') && path[len(path)-1] == path[0] {
+		path = strings.ReplaceAll(path[1:len(path)-1], path[:1]+path[:1], path[:1])
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	header, err := csv.NewReader(f).Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	columns := make([]string, len(header))
+	for i, name := range header {
+		if columns[i], err = quoteIdentifier(name); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := c.DeclareVTab(fmt.Sprintf("CREATE TABLE x (%s)", strings.Join(columns, ", "))); err != nil {
+		return nil, err
+	}
+	return &csvVTab{path, len(columns)}, nil
+}
+
+func (csvModule) DestroyModule() {}
+
+func (t *csvVTab) BestIndex(cs []sqlite3.InfoConstraint, _ []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	return &sqlite3.IndexResult{Used: make([]bool, len(cs)), EstimatedCost: 1000000}, nil
+}
+
+func (t *csvVTab) Disconnect() error { return nil }
+func (t *csvVTab) Destroy() error    { return nil }
+
+func (t *csvVTab) Open() (sqlite3.VTabCursor, error) { return &csvCursor{path: t.path}, nil }
+
+func (c *csvCursor) Filter(int, string, []interface{}) error {
+	if c.f != nil {
+		c.f.Close()
+	}
+	f, err := os.Open(c.path)
+	if err != nil {
+		return err
+	}
+	c.f, c.r, c.rowid, c.eof = f, csv.NewReader(f), 0, false
+	c.r.FieldsPerRecord, c.r.ReuseRecord = -1, true
+	if _, err := c.r.Read(); err != nil && err != io.EOF {
+		return err
+	}
+	return c.Next()
+}
+
+func (c *csvCursor) Next() error {
+	record, err := c.r.Read()
+	if err == io.EOF {
+		c.eof = true
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("%s: %w", c.path, err)
+	}
+	c.record = record
+	c.rowid++
+	return nil
+}
+
+func (c *csvCursor) EOF() bool             { return c.eof }
+func (c *csvCursor) Rowid() (int64, error) { return c.rowid, nil }
+
+func (c *csvCursor) Column(ctx *sqlite3.SQLiteContext, i int) error {
+	if i < len(c.record) {
+		ctx.ResultText(c.record[i])
+	} else {
+		ctx.ResultNull()
+	}
+	return nil
+}
+
+func (c *csvCursor) Close() error {
+	if c.f == nil {
+		return nil
+	}
+	return c.f.Close()
+}
+
+func (m tableFuncModule) EponymousOnlyModule() {}
+func (m tableFuncModule) DestroyModule()       {}
+
+func (m tableFuncModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Connect(c, args)
+}
+
+func (m tableFuncModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	if len(m.Args) > 7 {
+		return nil, fmt.Errorf("table funcs support at most 7 args")
+	}
+	columns := []string{}
+	for _, column := range m.Columns {
+		quoted, err := quoteIdentifier(column)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, quoted)
+	}
+	for _, arg := range m.Args {
+		quoted, err := quoteIdentifier(arg)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, quoted+" HIDDEN")
+	}
+	if err := c.DeclareVTab(fmt.Sprintf("CREATE TABLE x (%s)", strings.Join(columns, ", "))); err != nil {
+		return nil, err
+	}
+	return &tableFuncVTab{m.TableFunc}, nil
+}
+
+// equality constraints on the hidden arg columns are passed to Filter in constraint order.
+// idxNum records which arg each one is, 4 bits per constraint (idxStr is freed too early by the driver)
+func (t *tableFuncVTab) BestIndex(cs []sqlite3.InfoConstraint, _ []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	used, idxNum, n, seen := make([]bool, len(cs)), 0, 0, map[int]bool{}
+	for i, c := range cs {
+		arg := c.Column - len(t.Columns)
+		if arg < 0 || c.Op != sqlite3.OpEQ || seen[arg] {
+			continue
+		} else if !c.Usable {
+			return &sqlite3.IndexResult{Used: make([]bool, len(cs)), EstimatedCost: math.MaxFloat64}, nil
+		}
+		used[i], seen[arg] = true, true
+		idxNum |= (arg + 1) << (4 * n)
+		n++
+	}
+	return &sqlite3.IndexResult{Used: used, IdxNum: idxNum, EstimatedCost: 1000}, nil
+}
+
+func (t *tableFuncVTab) Disconnect() error { return nil }
+func (t *tableFuncVTab) Destroy() error    { return nil }
+
+func (t *tableFuncVTab) Open() (sqlite3.VTabCursor, error) {
+	return &tableFuncCursor{f: t.TableFunc}, nil
+}
+
+func (c *tableFuncCursor) Filter(idxNum int, _ string, vals []interface{}) error {
+	args := make([]interface{}, len(c.f.Args))
+	for i := range vals {
+		if bs, ok := vals[i].([]byte); ok && bs == nil {
+			vals[i] = nil
+		}
+		args[(idxNum>>(4*i))&15-1] = vals[i]
+	}
+	next, err := c.f.Rows(args...)
+	if err != nil {
+		return err
+	}
+	c.next, c.rowid = next, 0
+	return c.Next()
+}
+
+func (c *tableFuncCursor) Next() error {
+	row, err := c.next()
+	c.row = row
+	c.rowid++
+	return err
+}
+
+func (c *tableFuncCursor) EOF() bool             { return c.row == nil }
+func (c *tableFuncCursor) Rowid() (int64, error) { return c.rowid, nil }
+func (c *tableFuncCursor) Close() error          { return nil }
+
+func (c *tableFuncCursor) Column(ctx *sqlite3.SQLiteContext, i int) error {
+	if i >= len(c.row) {
+		ctx.ResultNull()
+		return nil
+	}
+	switch v := c.row[i].(type) {
+	case nil:
+		ctx.ResultNull()
+	case int64:
+		ctx.ResultInt64(v)
+	case int:
+		ctx.ResultInt64(int64(v))
+	case float64:
+		ctx.ResultDouble(v)
+	case bool:
+		ctx.ResultBool(v)
+	case []byte:
+		ctx.ResultBlob(v)
+	case string:
+		ctx.ResultText(v)
+	default:
+		ctx.ResultText(fmt.Sprint(v))
+	}
+	return nil
+}
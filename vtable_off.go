@@ -0,0 +1,15 @@
+//go:build !sqlite_vtable
+// +build !sqlite_vtable
+
+package gosql
+
+import "fmt"
+
+func (db *DB) registerModules(c driverConn) error {
+	for name := range db.TableFuncs {
+		if _, ok := defaultTableFuncs[name]; !ok {
+			return fmt.Errorf("table func %s: requires the sqlite_vtable build tag", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package gosql
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type CheckpointMode string
+
+const (
+	CheckpointPassive  CheckpointMode = "PASSIVE"
+	CheckpointFull     CheckpointMode = "FULL"
+	CheckpointRestart  CheckpointMode = "RESTART"
+	CheckpointTruncate CheckpointMode = "TRUNCATE"
+)
+
+// Checkpoint is the result of PRAGMA wal_checkpoint. Busy is set if the checkpoint could not complete
+// because of readers or writers - LogFrames and CheckpointedFrames are -1 outside of WAL mode.
+type Checkpoint struct {
+	Mode               CheckpointMode
+	Busy               bool
+	LogFrames          int
+	CheckpointedFrames int
+	Duration           time.Duration
+	Time               time.Time
+}
+
+type WALInfo struct {
+	Enabled         bool  // journal_mode=wal
+	Size            int64 // bytes of the -wal file. TRUNCATE checkpoints reset it to 0 - otherwise it's reused but keeps its size
+	Frames          int   // frames the -wal file has room for
+	PageSize        int
+	AutoCheckpoint  int // pages - see PRAGMA wal_autocheckpoint
+	Checkpoints     int // number of checkpoints run via Checkpoint - the driver does not report automatic checkpoints
+	BusyCheckpoints int
+	LastCheckpoint  *Checkpoint
+}
+
+type walStats struct {
+	mutex       sync.Mutex
+	checkpoints int
+	busy        int
+	last        *Checkpoint
+	info        *WALInfo
+	infoTime    time.Time
+}
+
+// MetricsVar reports WALInfo at most every walInfoMaxAge - it queries the read-write pool and stats the wal file
+var walInfoMaxAge = 10 * time.Second
+
+func (db *DB) Checkpoint(mode CheckpointMode) (Checkpoint, error) {
+	switch mode {
+	case CheckpointPassive, CheckpointFull, CheckpointRestart, CheckpointTruncate:
+	default:
+		return Checkpoint{}, fmt.Errorf("invalid checkpoint mode %q", mode)
+	}
+	c, busy, start := Checkpoint{Mode: mode, Time: time.Now()}, 0, time.Now()
+	query := fmt.Sprintf("PRAGMA wal_checkpoint(%s)", mode)
+	if err := db.DB.QueryRow(query).Scan(&busy, &c.LogFrames, &c.CheckpointedFrames); err != nil {
+		return Checkpoint{}, fmt.Errorf("%s: %w", query, err)
+	}
+	c.Busy, c.Duration = busy != 0, time.Since(start)
+	db.wal.mutex.Lock()
+	defer db.wal.mutex.Unlock()
+	db.wal.checkpoints, db.wal.last = db.wal.checkpoints+1, &c
+	if c.Busy {
+		db.wal.busy++
+	}
+	return c, nil
+}
+
+func (db *DB) WALInfo() (WALInfo, error) {
+	return db.walInfo(0)
+}
+
+func (db *DB) walInfo(maxAge time.Duration) (WALInfo, error) {
+	db.wal.mutex.Lock()
+	cached, cachedTime := db.wal.info, db.wal.infoTime
+	db.wal.mutex.Unlock()
+	if cached != nil && time.Since(cachedTime) < maxAge {
+		return db.walStats(*cached), nil
+	}
+	info, err := db.readWALInfo()
+	if err != nil {
+		return info, err
+	}
+	db.wal.mutex.Lock()
+	db.wal.info, db.wal.infoTime = &info, time.Now()
+	db.wal.mutex.Unlock()
+	return db.walStats(info), nil
+}
+
+func (db *DB) readWALInfo() (WALInfo, error) {
+	info, journalMode := WALInfo{}, ""
+	if err := db.DB.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		return info, err
+	} else if err := db.DB.QueryRow("PRAGMA page_size").Scan(&info.PageSize); err != nil {
+		return info, err
+	} else if err := db.DB.QueryRow("PRAGMA wal_autocheckpoint").Scan(&info.AutoCheckpoint); err != nil {
+		return info, err
+	}
+	info.Enabled = journalMode == "wal"
+	if path := db.path(); info.Enabled && path != "" {
+		if fi, err := os.Stat(path + "-wal"); err == nil {
+			info.Size = fi.Size()
+		} else if !os.IsNotExist(err) {
+			return info, err
+		}
+	}
+	// the wal file consists of a 32 byte header and frames of a 24 byte header followed by a page
+	if info.Size > 32 {
+		info.Frames = int((info.Size - 32) / int64(info.PageSize+24))
+	}
+	return info, nil
+}
+
+func (db *DB) walStats(info WALInfo) WALInfo {
+	db.wal.mutex.Lock()
+	defer db.wal.mutex.Unlock()
+	info.Checkpoints, info.BusyCheckpoints = db.wal.checkpoints, db.wal.busy
+	if db.wal.last != nil {
+		last := *db.wal.last
+		info.LastCheckpoint = &last
+	}
+	return info
+}
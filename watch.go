@@ -0,0 +1,84 @@
+package gosql
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+func (db *DB) Watch(interval time.Duration, onReopen func(error)) (stop func(), err error) {
+	path := db.path()
+	if path == "" {
+		return nil, errors.New("cannot watch in-memory database")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	done, ticker := make(chan struct{}), time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				current, err := os.Stat(path)
+				if err != nil || os.SameFile(info, current) {
+					continue
+				}
+				info, err = current, db.reopen()
+				if onReopen != nil {
+					onReopen(err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }, nil
+}
+
+// WatchChanges calls f once and then whenever PRAGMA data_version reports a change made by another connection -
+// until ctx is done or f fails
+func (db *DB) WatchChanges(ctx context.Context, interval time.Duration, f func() error) error {
+	v, last, ticker := &dataVersion{}, int64(-1), time.NewTicker(interval)
+	defer ticker.Stop()
+	defer v.close()
+	for {
+		version, err := v.get(db.RODB)
+		if err != nil {
+			return err
+		} else if version != last {
+			if err := f(); err != nil {
+				return err
+			}
+			last = version
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// reopen connects to the replaced file - connections to the old one are closed once they are returned to the pools
+func (db *DB) reopen() error {
+	if db.Cache != nil {
+		db.Cache.Close()
+	}
+	db.handlerVersion.close()
+	return db.recyclePools()
+}
+
+func (db *DB) path() string {
+	path := strings.TrimPrefix(db.DataSourceName, "file:")
+	if i := strings.IndexByte(path, '?'); i != -1 {
+		path = path[:i]
+	}
+	if path == ":memory:" || strings.Contains(db.DataSourceName, "mode=memory") {
+		return ""
+	}
+	return path
+}